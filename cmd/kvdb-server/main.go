@@ -0,0 +1,142 @@
+// Command kvdb-server serves a kvcache over the Redis protocol, and
+// optionally the memcached protocol and an HTTP/JSON API.
+//
+//	kvdb-server -addr :6379 -max-memory 1073741824 -eviction tinylfu -wal /var/lib/kvdb/kvdb.wal
+//	kvdb-server -addr :6379 -memcached :11211 -http :8080
+//
+// With -wal every write is logged and replayed at startup. With -snapshot
+// the cache is also loaded from the snapshot at startup and saved to it on
+// shutdown.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/HueCodes/Fast-Cache/httpapi"
+	"github.com/HueCodes/Fast-Cache/kvcache"
+	"github.com/HueCodes/Fast-Cache/memcached"
+	"github.com/HueCodes/Fast-Cache/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":6379", "TCP address to listen on")
+	memcachedAddr := flag.String("memcached", "", "TCP address to serve the memcached protocol on, empty to disable")
+	httpAddr := flag.String("http", "", "TCP address to serve the HTTP/JSON API on, empty to disable")
+	shards := flag.Int("shards", kvcache.DefaultNumShards, "number of cache shards (a power of two)")
+	maxEntries := flag.Int("max-entries", 0, "maximum number of keys, 0 for unlimited")
+	maxMemory := flag.Int64("max-memory", 0, "maximum total size of values in bytes, 0 for unlimited")
+	eviction := flag.String("eviction", "lru", "eviction policy: lru, lfu, fifo, random, arc or tinylfu")
+	walPath := flag.String("wal", "", "write-ahead log file, empty to disable")
+	walSync := flag.String("wal-sync", "always", "write-ahead log fsync policy: always, periodic or never")
+	snapshotPath := flag.String("snapshot", "", "snapshot file loaded at startup and saved on shutdown")
+	flag.Parse()
+
+	kind, err := parseEviction(*eviction)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := kvcache.Config{
+		NumShards:   *shards,
+		MaxCapacity: *maxEntries,
+		MaxCost:     *maxMemory,
+		Eviction:    kind,
+	}
+
+	cache, err := openCache(cfg, *walPath, *walSync, *snapshotPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := server.New(cache)
+	mc := memcached.New(cache)
+	hs := &http.Server{Addr: *httpAddr, Handler: httpapi.NewHandler(cache)}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		hs.Close()
+		mc.Close()
+		srv.Close()
+	}()
+
+	if *memcachedAddr != "" {
+		go func() {
+			log.Printf("kvdb-server serving memcached on %s", *memcachedAddr)
+			err := mc.ListenAndServe(*memcachedAddr)
+			if !errors.Is(err, memcached.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if *httpAddr != "" {
+		go func() {
+			log.Printf("kvdb-server serving HTTP on %s", *httpAddr)
+			err := hs.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	log.Printf("kvdb-server listening on %s", *addr)
+	err = srv.ListenAndServe(*addr)
+	if !errors.Is(err, server.ErrServerClosed) {
+		log.Print(err)
+	}
+
+	if *snapshotPath != "" {
+		if err := cache.SaveSnapshotFile(*snapshotPath); err != nil {
+			log.Printf("saving snapshot: %v", err)
+		}
+	}
+	if err := cache.Close(); err != nil {
+		log.Printf("closing cache: %v", err)
+	}
+}
+
+// openCache creates the cache, recovering it from the log or snapshot
+func openCache(cfg kvcache.Config, walPath, walSync, snapshotPath string) (*kvcache.KVCache, error) {
+	if walPath == "" {
+		cache := kvcache.NewKVCacheWithConfig(cfg)
+		if snapshotPath != "" {
+			err := cache.LoadSnapshotFile(snapshotPath)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				cache.Close()
+				return nil, err
+			}
+		}
+		return cache, nil
+	}
+
+	sync, ok := map[string]kvcache.WALSyncPolicy{
+		"always":   kvcache.WALSyncAlways,
+		"periodic": kvcache.WALSyncPeriodic,
+		"never":    kvcache.WALSyncNever,
+	}[walSync]
+	if !ok {
+		return nil, fmt.Errorf("unknown -wal-sync policy %q", walSync)
+	}
+	return kvcache.OpenKVCache(cfg, kvcache.WALOptions{
+		Path:         walPath,
+		SnapshotPath: snapshotPath,
+		Sync:         sync,
+	})
+}
+
+// parseEviction maps a policy name to its EvictionKind
+func parseEviction(name string) (kvcache.EvictionKind, error) {
+	for k := kvcache.EvictionLRU; k <= kvcache.EvictionTinyLFU; k++ {
+		if k.String() == name {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown eviction policy %q", name)
+}
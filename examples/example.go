@@ -4,11 +4,11 @@ import (
 	"fmt"
 	"time"
 
-	"kv-db-go/kvcache"
+	"github.com/HueCodes/Fast-Cache/kvcache"
 )
 
 func main() {
-	fmt.Println("=== KV-DB-GO High-Performance Cache Demo ===\n")
+	fmt.Print("=== KV-DB-GO High-Performance Cache Demo ===\n\n")
 
 	// Example 1: Basic usage
 	fmt.Println("1. Basic Cache Operations")
@@ -27,7 +27,7 @@ func main() {
 	fmt.Println("2. Custom TTL per Entry")
 	cache.Set("session:abc", "active", 30*time.Second)
 	cache.Set("config:app", "settings", 24*time.Hour)
-	fmt.Println("   Session expires in 30s, config in 24h\n")
+	fmt.Print("   Session expires in 30s, config in 24h\n\n")
 
 	// Example 3: Batch operations
 	fmt.Println("3. Batch Operations")
@@ -111,7 +111,7 @@ func main() {
 	time.Sleep(150 * time.Millisecond)
 
 	if _, ok := expCache.Get("temp:data"); !ok {
-		fmt.Println("   ✓ Data expired after TTL\n")
+		fmt.Print("   ✓ Data expired after TTL\n\n")
 	}
 
 	fmt.Println("=== Performance Characteristics ===")
@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Blob is a value stored with a content type that has no Go
+// representation here, such as image/png. It is served back with the same
+// Content-Type.
+type Blob struct {
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"`
+}
+
+// Content types used for values without an explicit one
+const (
+	contentText   = "text/plain; charset=utf-8"
+	contentJSON   = "application/json"
+	contentBinary = "application/octet-stream"
+)
+
+// decodeValue converts a request body to the value stored in the cache:
+// text as a string, JSON as the decoded value (numbers as json.Number),
+// octet streams as []byte and anything else as a Blob. A missing content
+// type is treated as text.
+func decodeValue(contentType string, body []byte) (interface{}, error) {
+	if contentType == "" {
+		return string(body), nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Type: %w", err)
+	}
+	switch {
+	case mediaType == "text/plain":
+		return string(body), nil
+	case mediaType == contentJSON || strings.HasSuffix(mediaType, "+json"):
+		return decodeJSON(body)
+	case mediaType == contentBinary:
+		return body, nil
+	default:
+		return Blob{ContentType: contentType, Data: body}, nil
+	}
+}
+
+// decodeJSON decodes exactly one JSON value
+func decodeJSON(body []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("invalid JSON: trailing data after value")
+	}
+	return v, nil
+}
+
+// encodeValue renders a cached value as a response body and content type.
+// Values with a Bytes method, such as memcached.Item, are served as octet
+// streams. Other values stored in-process that are not strings, byte
+// slices or Blobs are rendered as JSON.
+func encodeValue(v interface{}) ([]byte, string, error) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), contentText, nil
+	case []byte:
+		return v, contentBinary, nil
+	case Blob:
+		return v.Data, v.ContentType, nil
+	case interface{ Bytes() []byte }:
+		return v.Bytes(), contentBinary, nil
+	default:
+		body, err := json.Marshal(v)
+		return body, contentJSON, err
+	}
+}
+
+// etag formats an entry version as a strong entity tag. Versions change
+// on every write, so a tag identifies one stored value.
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// matchETag reports whether an If-Match or If-None-Match header value
+// lists tag, or is "*" and the key exists. Weak tags compare by opaque
+// value.
+func matchETag(header, tag string, exists bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		switch {
+		case candidate == "*":
+			return exists
+		case exists && strings.TrimPrefix(candidate, "W/") == tag:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// TestDecodeValue tests content-type aware storage
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        interface{}
+	}{
+		{"", "plain", "plain"},
+		{"text/plain; charset=utf-8", "text", "text"},
+		{"application/json", `{"n": 12345678901234567890}`, map[string]interface{}{"n": json.Number("12345678901234567890")}},
+		{"application/problem+json", `"s"`, "s"},
+		{"application/octet-stream", "\x00\x01", []byte{0, 1}},
+		{"image/png", "PNG", Blob{ContentType: "image/png", Data: []byte("PNG")}},
+	}
+	for _, tt := range tests {
+		got, err := decodeValue(tt.contentType, []byte(tt.body))
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeValue(%q, %q) = %#v, %v; want %#v", tt.contentType, tt.body, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []struct{ contentType, body string }{
+		{"application/json", `{"unterminated"`},
+		{"application/json", `1 2`},
+		{"text/plain; charset", "x"},
+	} {
+		if _, err := decodeValue(bad.contentType, []byte(bad.body)); err == nil {
+			t.Errorf("decodeValue(%q, %q) should fail", bad.contentType, bad.body)
+		}
+	}
+}
+
+// TestEncodeValue tests rendering cached values
+func TestEncodeValue(t *testing.T) {
+	tests := []struct {
+		value       interface{}
+		body        string
+		contentType string
+	}{
+		{"s", "s", contentText},
+		{[]byte("b"), "b", contentBinary},
+		{Blob{ContentType: "image/gif", Data: []byte("GIF")}, "GIF", "image/gif"},
+		{map[string]int{"a": 1}, `{"a":1}`, contentJSON},
+		{bytes.NewBufferString("buf"), "buf", contentBinary},
+	}
+	for _, tt := range tests {
+		body, contentType, err := encodeValue(tt.value)
+		if err != nil || string(body) != tt.body || contentType != tt.contentType {
+			t.Errorf("encodeValue(%#v) = %q, %q, %v", tt.value, body, contentType, err)
+		}
+	}
+	if _, _, err := encodeValue(make(chan int)); err == nil {
+		t.Error("Expected an error encoding a channel")
+	}
+}
+
+// TestMatchETag tests If-Match and If-None-Match list parsing
+func TestMatchETag(t *testing.T) {
+	tag := etag(17)
+	if tag != `"17"` {
+		t.Errorf("Unexpected ETag %s", tag)
+	}
+	tests := []struct {
+		header string
+		exists bool
+		want   bool
+	}{
+		{tag, true, true},
+		{`"other", ` + tag, true, true},
+		{"W/" + tag, true, true},
+		{`"other"`, true, false},
+		{tag, false, false},
+		{"*", true, true},
+		{"*", false, false},
+	}
+	for _, tt := range tests {
+		if got := matchETag(tt.header, tag, tt.exists); got != tt.want {
+			t.Errorf("matchETag(%q, exists=%v) = %v", tt.header, tt.exists, got)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+// Package httpapi exposes a kvcache.KVCache as an HTTP/JSON API that can
+// be mounted in any net/http server:
+//
+//	GET    /keys/{key}       read a value (HEAD for headers only)
+//	PUT    /keys/{key}       write a value
+//	DELETE /keys/{key}       delete a value
+//	GET    /keys?prefix=p    stream matching keys as a JSON array
+//	DELETE /keys             clear the cache
+//	POST   /mget             read several values
+//	POST   /mset             write several values
+//	GET    /stats            cache statistics
+//
+// Values keep their content type (see Blob). Reads and writes return the
+// entry's version as its ETag, and writes honour If-Match and
+// If-None-Match atomically using CompareAndSwap. A TTL is given with the
+// X-TTL header or ttl query parameter, as a Go duration ("90s") or in whole
+// seconds.
+//
+// To serve the API under a path prefix, wrap it with http.StripPrefix.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// DefaultMaxBodySize limits request bodies when Handler.MaxBodySize is 0
+const DefaultMaxBodySize = 32 * 1024 * 1024
+
+// ttlHeader carries a TTL on writes and the remaining TTL on reads
+const ttlHeader = "X-TTL"
+
+// Handler serves the API for one cache
+type Handler struct {
+	// MaxBodySize limits request bodies in bytes (DefaultMaxBodySize if 0)
+	MaxBodySize int64
+
+	cache *kvcache.KVCache
+}
+
+// NewHandler creates a handler for cache. The cache is owned by the caller.
+func NewHandler(cache *kvcache.KVCache) *Handler {
+	return &Handler{cache: cache}
+}
+
+// ServeHTTP routes a request
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/keys/") && len(path) > len("/keys/"):
+		key := path[len("/keys/"):]
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.getKey(w, r, key)
+		case http.MethodPut:
+			h.putKey(w, r, key)
+		case http.MethodDelete:
+			h.deleteKey(w, r, key)
+		default:
+			methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+		}
+	case path == "/keys" || path == "/keys/":
+		switch r.Method {
+		case http.MethodGet:
+			h.listKeys(w, r)
+		case http.MethodDelete:
+			h.cache.Clear()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			methodNotAllowed(w, "GET, DELETE")
+		}
+	case path == "/mget":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		h.mget(w, r)
+	case path == "/mset":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		h.mset(w, r)
+	case path == "/stats":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		h.stats(w)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// errorResponse is the body of every error response
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", contentJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// parseTTL reads a TTL from the X-TTL header or the ttl query parameter.
+// It returns 0 if neither is set.
+func parseTTL(r *http.Request) (time.Duration, error) {
+	s := r.Header.Get(ttlHeader)
+	if s == "" {
+		s = r.URL.Query().Get("ttl")
+	}
+	return parseDuration(s)
+}
+
+// parseDuration accepts a Go duration or whole seconds; "" is 0
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 && n <= int64(time.Duration(1<<63-1)/time.Second) {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errors.New("invalid TTL " + strconv.Quote(s))
+	}
+	return d, nil
+}
+
+// ttlArgs converts a parsed TTL to the optional argument taken by the
+// cache's write methods (none means the cache default)
+func ttlArgs(ttl time.Duration) []time.Duration {
+	if ttl > 0 {
+		return []time.Duration{ttl}
+	}
+	return nil
+}
+
+func (h *Handler) getKey(w http.ResponseWriter, r *http.Request, key string) {
+	value, version, ttl, ok := h.cache.GetWithVersionAndTTL(key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	body, contentType, err := encodeValue(value)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "value cannot be encoded: "+err.Error())
+		return
+	}
+
+	header := w.Header()
+	tag := etag(version)
+	header.Set("ETag", tag)
+	if ttl > 0 {
+		// Round up so a live key never reports 0
+		header.Set(ttlHeader, strconv.FormatInt(int64((ttl+time.Second-1)/time.Second), 10))
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && matchETag(inm, tag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+// readBody reads a request body up to the size limit
+func (h *Handler) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	limit := h.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		} else {
+			writeError(w, http.StatusBadRequest, "reading request body: "+err.Error())
+		}
+		return nil, false
+	}
+	return body, true
+}
+
+// preconditionsHold evaluates If-Match and If-None-Match against a key's
+// current version (0 if missing)
+func preconditionsHold(r *http.Request, version uint64, exists bool) bool {
+	tag := etag(version)
+	if im := r.Header.Get("If-Match"); im != "" && !matchETag(im, tag, exists) {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && matchETag(inm, tag, exists) {
+		return false
+	}
+	return true
+}
+
+// putKey stores the request body, replying 201 for a new key, 204 for a
+// replaced one and 507 for a value costing more than Config.MaxCost. The write is a CompareAndSwap against the version the
+// preconditions were checked on, retried if another writer got in first.
+func (h *Handler) putKey(w http.ResponseWriter, r *http.Request, key string) {
+	ttl, err := parseTTL(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body, ok := h.readBody(w, r)
+	if !ok {
+		return
+	}
+	value, err := decodeValue(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for {
+		_, version, exists := h.cache.GetWithVersion(key)
+		if !preconditionsHold(r, version, exists) {
+			writeError(w, http.StatusPreconditionFailed, "precondition failed")
+			return
+		}
+		newVersion, err := h.cache.CompareAndSwap(key, version, value, ttlArgs(ttl)...)
+		if errors.Is(err, kvcache.ErrVersionConflict) {
+			continue
+		}
+		if newVersion == 0 {
+			// Over the cache's cost budget: not stored, and the old value
+			// is gone
+			writeError(w, http.StatusInsufficientStorage, "value exceeds the cache's cost budget")
+			return
+		}
+		w.Header().Set("ETag", etag(newVersion))
+		if exists {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+		return
+	}
+}
+
+// deleteKey deletes a key, with the same precondition handling as putKey
+func (h *Handler) deleteKey(w http.ResponseWriter, r *http.Request, key string) {
+	for {
+		_, version, exists := h.cache.GetWithVersion(key)
+		switch {
+		case !preconditionsHold(r, version, exists):
+			writeError(w, http.StatusPreconditionFailed, "precondition failed")
+			return
+		case !exists:
+			writeError(w, http.StatusNotFound, "key not found")
+			return
+		}
+		if err := h.cache.CompareAndDelete(key, version); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+}
+
+// listKeys streams the keys starting with the prefix query parameter as a
+// JSON array, flushing as it goes. The optional limit parameter caps the
+// number of keys. Keys are in no particular order.
+func (h *Handler) listKeys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	limit := -1
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit "+strconv.Quote(s))
+			return
+		}
+		limit = n
+	}
+
+	w.Header().Set("Content-Type", contentJSON)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	io.WriteString(w, "[")
+	count := 0
+	var err error
+	h.cache.Range(func(key string, _ interface{}) bool {
+		if limit >= 0 && count >= limit {
+			return false
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return true
+		}
+		b, _ := json.Marshal(key)
+		if count > 0 {
+			_, err = io.WriteString(w, ",")
+		}
+		if err == nil {
+			_, err = w.Write(b)
+		}
+		count++
+		if flusher != nil && count%256 == 0 {
+			flusher.Flush()
+		}
+		// Stop once the client has gone away
+		return err == nil && r.Context().Err() == nil
+	})
+	io.WriteString(w, "]\n")
+}
+
+// mgetRequest is the body of POST /mget
+type mgetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// mgetResponse maps each found key to its value; missing keys are omitted
+type mgetResponse struct {
+	Values map[string]interface{} `json:"values"`
+}
+
+func (h *Handler) mget(w http.ResponseWriter, r *http.Request) {
+	var req mgetRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, mgetResponse{h.cache.GetMulti(req.Keys)})
+}
+
+// msetRequest is the body of POST /mset. Values are stored as decoded from
+// JSON. TTL is optional, as a Go duration or whole seconds.
+type msetRequest struct {
+	Entries map[string]json.RawMessage `json:"entries"`
+	TTL     string                     `json:"ttl"`
+}
+
+func (h *Handler) mset(w http.ResponseWriter, r *http.Request) {
+	var req msetRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	ttl, err := parseDuration(req.TTL)
+	if err == nil && ttl == 0 {
+		ttl, err = parseTTL(r)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	values := make(map[string]interface{}, len(req.Entries))
+	for key, raw := range req.Entries {
+		value, err := decodeJSON(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "entry "+strconv.Quote(key)+": "+err.Error())
+			return
+		}
+		values[key] = value
+	}
+	for key, value := range values {
+		h.cache.Set(key, value, ttlArgs(ttl)...)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeRequest decodes a JSON request body into v, writing 400 on failure
+func (h *Handler) decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, ok := h.readBody(w, r)
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// statsResponse is the body of GET /stats
+type statsResponse struct {
+	Hits       uint64  `json:"hits"`
+	Misses     uint64  `json:"misses"`
+	Evictions  uint64  `json:"evictions"`
+	Rejections uint64  `json:"rejections"`
+	Size       uint64  `json:"size"`
+	Cost       int64   `json:"cost"`
+	MaxCost    int64   `json:"maxCost"`
+	HitRate    float64 `json:"hitRate"`
+}
+
+func (h *Handler) stats(w http.ResponseWriter) {
+	s := h.cache.Stats()
+	writeJSON(w, http.StatusOK, statsResponse{
+		Hits:       s.Hits,
+		Misses:     s.Misses,
+		Evictions:  s.Evictions,
+		Rejections: s.Rejections,
+		Size:       s.Size,
+		Cost:       s.Cost,
+		MaxCost:    s.MaxCost,
+		HitRate:    s.HitRate(),
+	})
+}
@@ -0,0 +1,277 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// newTestHandler serves a fresh cache
+func newTestHandler(t *testing.T) (*Handler, *kvcache.KVCache) {
+	t.Helper()
+	cache := kvcache.NewKVCacheWithConfig(kvcache.Config{NumShards: 16})
+	t.Cleanup(func() { cache.Close() })
+	return NewHandler(cache), cache
+}
+
+// do runs one request against h
+func do(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// TestKeyLifecycle tests PUT, GET, HEAD and DELETE on a single key
+func TestKeyLifecycle(t *testing.T) {
+	h, cache := newTestHandler(t)
+
+	if w := do(h, "GET", "/keys/k", ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"error"`) {
+		t.Errorf("Expected 404 with an error body, got %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "PUT", "/keys/k", "hello", "Content-Type", "text/plain"); w.Code != http.StatusCreated || w.Header().Get("ETag") == "" {
+		t.Errorf("Expected 201 with an ETag, got %d", w.Code)
+	}
+	if v, _ := cache.Get("k"); v != "hello" {
+		t.Errorf("Expected text stored as a string, got %#v", v)
+	}
+
+	w := do(h, "GET", "/keys/k", "")
+	if w.Code != http.StatusOK || w.Body.String() != "hello" || w.Header().Get("Content-Type") != contentText {
+		t.Errorf("Unexpected GET: %d %q %q", w.Code, w.Body, w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get(ttlHeader) != "" {
+		t.Error("Key without a TTL should not report one")
+	}
+	if w := do(h, "HEAD", "/keys/k", ""); w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "5" {
+		t.Errorf("Unexpected HEAD: %d %q", w.Code, w.Body)
+	}
+
+	if w := do(h, "PUT", "/keys/k", `{"a":[1,2]}`, "Content-Type", "application/json"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 replacing a key, got %d", w.Code)
+	}
+	if w := do(h, "GET", "/keys/k", ""); w.Body.String() != `{"a":[1,2]}` || w.Header().Get("Content-Type") != contentJSON {
+		t.Errorf("Unexpected JSON GET: %q", w.Body)
+	}
+	if w := do(h, "PUT", "/keys/k", `{bad`, "Content-Type", "application/json"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid JSON, got %d", w.Code)
+	}
+
+	do(h, "PUT", "/keys/img", "GIF89a", "Content-Type", "image/gif")
+	if w := do(h, "GET", "/keys/img", ""); w.Body.String() != "GIF89a" || w.Header().Get("Content-Type") != "image/gif" {
+		t.Errorf("Unexpected blob GET: %q %q", w.Body, w.Header().Get("Content-Type"))
+	}
+
+	do(h, "PUT", "/keys/a%2Fb", "slash")
+	if v, _ := cache.Get("a/b"); v != "slash" {
+		t.Errorf("Expected escaped slash in key, got %#v", v)
+	}
+
+	if w := do(h, "DELETE", "/keys/k", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 deleting, got %d", w.Code)
+	}
+	if w := do(h, "DELETE", "/keys/k", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting a missing key, got %d", w.Code)
+	}
+	if w := do(h, "POST", "/keys/k", ""); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") == "" {
+		t.Errorf("Expected 405 with Allow, got %d", w.Code)
+	}
+	if w := do(h, "GET", "/nope", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown path, got %d", w.Code)
+	}
+}
+
+// TestTTL tests the X-TTL header and ttl query parameter
+func TestTTL(t *testing.T) {
+	h, cache := newTestHandler(t)
+
+	do(h, "PUT", "/keys/header", "v", ttlHeader, "90")
+	do(h, "PUT", "/keys/query?ttl=1m30s", "v")
+	for _, key := range []string{"header", "query"} {
+		if _, ttl, _ := cache.GetWithTTL(key); ttl <= 89*time.Second || ttl > 90*time.Second {
+			t.Errorf("Expected %s to have a 90s TTL, got %v", key, ttl)
+		}
+		if w := do(h, "GET", "/keys/"+key, ""); w.Header().Get(ttlHeader) != "90" {
+			t.Errorf("Expected %s to report X-TTL 90, got %q", key, w.Header().Get(ttlHeader))
+		}
+	}
+	for _, bad := range []string{"-5s", "soon"} {
+		if w := do(h, "PUT", "/keys/k", "v", ttlHeader, bad); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for TTL %q, got %d", bad, w.Code)
+		}
+	}
+}
+
+// TestPutOverBudget tests a value costing more than the whole budget
+func TestPutOverBudget(t *testing.T) {
+	cache := kvcache.NewKVCacheWithConfig(kvcache.Config{NumShards: 16, MaxCost: 10})
+	defer cache.Close()
+	h := NewHandler(cache)
+
+	if w := do(h, "PUT", "/keys/k", "small"); w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", w.Code)
+	}
+	w := do(h, "PUT", "/keys/k", "far too large a value")
+	if w.Code != http.StatusInsufficientStorage || w.Header().Get("ETag") != "" {
+		t.Errorf("Expected 507 without an ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	if _, ok := cache.Get("k"); ok {
+		t.Error("Expected the old value to be removed, as with SetWithCost")
+	}
+}
+
+// TestConditionalRequests tests ETag, If-Match and If-None-Match
+func TestConditionalRequests(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	if w := do(h, "PUT", "/keys/k", "v1", "If-Match", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected If-Match * on a missing key to fail, got %d", w.Code)
+	}
+	w := do(h, "PUT", "/keys/k", "v1", "If-None-Match", "*")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected create-only PUT to succeed, got %d", w.Code)
+	}
+	tag := w.Header().Get("ETag")
+	if got := do(h, "GET", "/keys/k", "").Header().Get("ETag"); got != tag {
+		t.Errorf("PUT and GET ETags differ: %s vs %s", tag, got)
+	}
+	if w := do(h, "PUT", "/keys/k", "v1", "If-None-Match", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected create-only PUT on an existing key to fail, got %d", w.Code)
+	}
+	if w := do(h, "GET", "/keys/k", "", "If-None-Match", tag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected 304, got %d", w.Code)
+	}
+
+	w = do(h, "PUT", "/keys/k", "v2", "If-Match", tag)
+	if w.Code != http.StatusNoContent || w.Header().Get("ETag") == tag {
+		t.Fatalf("Expected If-Match PUT to succeed with a new ETag, got %d", w.Code)
+	}
+	if w := do(h, "PUT", "/keys/k", "v3", "If-Match", tag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected stale If-Match to fail, got %d", w.Code)
+	}
+	if w := do(h, "DELETE", "/keys/k", "", "If-Match", tag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected stale If-Match DELETE to fail, got %d", w.Code)
+	}
+}
+
+// TestConditionalRace tests that concurrent If-Match updates never lose a write
+func TestConditionalRace(t *testing.T) {
+	h, cache := newTestHandler(t)
+	do(h, "PUT", "/keys/n", "0")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for done := 0; done < 25; {
+				w := do(h, "GET", "/keys/n", "")
+				n, _ := strconv.Atoi(w.Body.String())
+				put := do(h, "PUT", "/keys/n", strconv.Itoa(n+1), "If-Match", w.Header().Get("ETag"))
+				if put.Code == http.StatusNoContent {
+					done++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := cache.Get("n"); v != "200" {
+		t.Errorf("Expected 200 increments, got %v", v)
+	}
+}
+
+// TestBatchAndStats tests /mget, /mset, /stats and DELETE /keys
+func TestBatchAndStats(t *testing.T) {
+	h, cache := newTestHandler(t)
+
+	w := do(h, "POST", "/mset", `{"entries": {"a": "x", "b": {"n": 1}}, "ttl": "1h"}`)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Unexpected mset: %d %s", w.Code, w.Body)
+	}
+	if v, ttl, _ := cache.GetWithTTL("a"); v != "x" || ttl == 0 {
+		t.Errorf("Expected a stored as a string with a TTL, got %#v %v", v, ttl)
+	}
+	if w := do(h, "POST", "/mset", `{"entries": {"a": "x"}, "ttl": "never"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad TTL, got %d", w.Code)
+	}
+
+	w = do(h, "POST", "/mget", `{"keys": ["a", "b", "missing"]}`)
+	var got mgetResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Unexpected mget: %d %s", w.Code, w.Body)
+	}
+	if len(got.Values) != 2 || got.Values["a"] != "x" {
+		t.Errorf("Unexpected mget values %v", got.Values)
+	}
+	if w := do(h, "GET", "/mget", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+
+	var stats map[string]interface{}
+	w = do(h, "GET", "/stats", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["size"] != 2.0 || stats["hits"] != 3.0 || stats["hitRate"] == nil {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+
+	if w := do(h, "DELETE", "/keys", ""); w.Code != http.StatusNoContent || cache.Size() != 0 {
+		t.Errorf("Expected DELETE /keys to clear, got %d with %d keys", w.Code, cache.Size())
+	}
+}
+
+// TestListKeys tests streaming key listings
+func TestListKeys(t *testing.T) {
+	h, cache := newTestHandler(t)
+	for i := 0; i < 600; i++ {
+		cache.Set("user:"+strconv.Itoa(i), i)
+	}
+	cache.Set("session:1", 1)
+
+	list := func(target string) []string {
+		t.Helper()
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+		resp, err := http.Get(srv.URL + target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		var keys []string
+		if err := json.Unmarshal(body, &keys); err != nil {
+			t.Fatalf("%s: invalid listing %q: %v", target, body, err)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	if keys := list("/keys"); len(keys) != 601 {
+		t.Errorf("Expected 601 keys, got %d", len(keys))
+	}
+	if keys := list("/keys?prefix=session:"); len(keys) != 1 || keys[0] != "session:1" {
+		t.Errorf("Unexpected prefix listing %v", keys)
+	}
+	if keys := list("/keys?prefix=user:&limit=10"); len(keys) != 10 || !strings.HasPrefix(keys[0], "user:") {
+		t.Errorf("Expected 10 user keys, got %v", keys)
+	}
+	if keys := list("/keys?prefix=none"); len(keys) != 0 {
+		t.Errorf("Expected an empty listing, got %v", keys)
+	}
+	if w := do(h, "GET", "/keys?limit=-1", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad limit, got %d", w.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package kvcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec encodes keys and values for persistence. Encode and Decode are
+// given pointers to the key or value, as with encoding/gob and
+// encoding/json, so interface{} values round-trip with their dynamic type
+// where the encoding supports it.
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+// GobCodec is the default Codec, using encoding/gob. Strings and byte
+// slices are stored raw. Concrete types held in interface{} values (such as
+// KVCache values) must be registered with gob.Register, except for gob's
+// predeclared basic types.
+type GobCodec struct{}
+
+// Encode implements Codec
+func (GobCodec) Encode(v interface{}) ([]byte, error) {
+	switch p := v.(type) {
+	case *string:
+		return []byte(*p), nil
+	case *[]byte:
+		return *p, nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements Codec
+func (GobCodec) Decode(data []byte, v interface{}) error {
+	switch p := v.(type) {
+	case *string:
+		*p = string(data)
+		return nil
+	case *[]byte:
+		*p = append([]byte(nil), data...)
+		return nil
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// JSONCodec is a Codec using encoding/json. Numbers held in interface{}
+// values decode as float64.
+type JSONCodec struct{}
+
+// Encode implements Codec
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode implements Codec
+func (JSONCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,50 @@
+package kvcache
+
+import (
+	"testing"
+)
+
+// TestCodecRoundTrip tests the built-in codecs on common types
+func TestCodecRoundTrip(t *testing.T) {
+	for name, codec := range map[string]Codec{"gob": GobCodec{}, "json": JSONCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			s := "hello"
+			data, err := codec.Encode(&s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotS string
+			if err := codec.Decode(data, &gotS); err != nil || gotS != s {
+				t.Errorf("string round trip = %q, %v", gotS, err)
+			}
+
+			u := snapshotUser{"Cy", 9}
+			data, err = codec.Encode(&u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotU snapshotUser
+			if err := codec.Decode(data, &gotU); err != nil || gotU != u {
+				t.Errorf("struct round trip = %+v, %v", gotU, err)
+			}
+		})
+	}
+}
+
+// TestGobCodecRawBytes tests the raw fast paths and that decoding copies
+func TestGobCodecRawBytes(t *testing.T) {
+	b := []byte("raw")
+	data, _ := GobCodec{}.Encode(&b)
+	if string(data) != "raw" {
+		t.Errorf("Expected raw bytes, got %q", data)
+	}
+
+	var got []byte
+	if err := (GobCodec{}).Decode(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	data[0] = 'X'
+	if string(got) != "raw" {
+		t.Error("Decoded slice should not alias the input")
+	}
+}
@@ -0,0 +1,108 @@
+package kvcache
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Op tells Compute what to do with the value its callback returns
+type Op int
+
+const (
+	// OpKeep leaves the entry as it was (or missing)
+	OpKeep Op = iota
+	// OpSet stores the returned value
+	OpSet
+	// OpDelete removes the entry
+	OpDelete
+)
+
+// String returns the operation's name
+func (op Op) String() string {
+	switch op {
+	case OpKeep:
+		return "keep"
+	case OpSet:
+		return "set"
+	case OpDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("Op(%d)", int(op))
+	}
+}
+
+// Compute atomically updates key: fn receives the current value (the zero
+// value and false if missing or expired) and returns a new value and what
+// to do with it. The result is key's value afterwards and whether it is
+// present.
+//
+// A stored value takes ttl if given. Otherwise an existing entry keeps its
+// expiration and a new one gets the default TTL.
+//
+// fn runs while holding the lock of key's shard, which also guards many
+// other keys. It must be quick and must not call any method of this cache,
+// for any key, or it may deadlock. If fn panics the lock is released, the
+// entry is left unchanged and the panic propagates to the caller.
+func (c *Cache[K, V]) Compute(key K, fn func(old V, exists bool) (V, Op), ttl ...time.Duration) (V, bool) {
+	value, present := c.compute(key, fn, ttl)
+	c.shed()
+	return value, present
+}
+
+// ComputeIfAbsent calls fn only if key is missing, storing its result if
+// it returns OpSet. It returns key's value afterwards and whether it is
+// present. fn has the same restrictions as in Compute.
+func (c *Cache[K, V]) ComputeIfAbsent(key K, fn func() (V, Op), ttl ...time.Duration) (V, bool) {
+	return c.Compute(key, func(old V, exists bool) (V, Op) {
+		if exists {
+			return old, OpKeep
+		}
+		return fn()
+	}, ttl...)
+}
+
+// ComputeIfPresent calls fn only if key is present, then stores or deletes
+// as it says. It returns key's value afterwards and whether it is present.
+// fn has the same restrictions as in Compute.
+func (c *Cache[K, V]) ComputeIfPresent(key K, fn func(old V) (V, Op), ttl ...time.Duration) (V, bool) {
+	return c.Compute(key, func(old V, exists bool) (V, Op) {
+		if !exists {
+			return old, OpKeep
+		}
+		return fn(old)
+	}, ttl...)
+}
+
+// compute runs fn under the shard lock, which is released by a deferred
+// unlock so a panicking fn cannot leave it held. Nothing is written until
+// fn returns.
+func (c *Cache[K, V]) compute(key K, fn func(old V, exists bool) (V, Op), ttl []time.Duration) (value V, present bool) {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	defer c.unlock(shard)
+
+	var old V
+	var expiration int64
+	entry := c.liveLocked(shard, key)
+	if entry != nil {
+		old, expiration = c.export(entry.Value), atomic.LoadInt64(&entry.Expiration)
+	}
+
+	value, op := fn(old, entry != nil)
+	switch op {
+	case OpSet:
+		if len(ttl) > 0 || entry == nil {
+			expiration = c.expiration(c.now(), ttl)
+		}
+		return value, c.setLocked(shard, key, value, expiration, c.valueCost(value))
+	case OpDelete:
+		if entry != nil {
+			c.removeLocked(shard, entry, RemovalDeleted)
+		}
+		var zero V
+		return zero, false
+	default:
+		return old, entry != nil
+	}
+}
@@ -0,0 +1,158 @@
+package kvcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCompute tests the keep, set and delete operations
+func TestCompute(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, []string](Config{NumShards: 4, DefaultTTL: time.Hour, Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+
+	appendItem := func(item string) func([]string, bool) ([]string, Op) {
+		return func(old []string, exists bool) ([]string, Op) {
+			return append(old, item), OpSet
+		}
+	}
+
+	if v, ok := cache.Compute("list", appendItem("a"), time.Minute); !ok || len(v) != 1 {
+		t.Errorf("Expected [a], got %v, %v", v, ok)
+	}
+	clock.Advance(10 * time.Second)
+	cache.Compute("list", appendItem("b"))
+	if v, ttl, _ := cache.GetWithTTL("list"); len(v) != 2 || v[1] != "b" || ttl != 50*time.Second {
+		t.Errorf("Expected [a b] keeping its TTL, got %v, %v", v, ttl)
+	}
+	cache.Compute("list", appendItem("c"), 5*time.Minute)
+	if _, ttl, _ := cache.GetWithTTL("list"); ttl != 5*time.Minute {
+		t.Errorf("Expected an explicit TTL to replace the old one, got %v", ttl)
+	}
+	cache.Compute("new", appendItem("x"))
+	if _, ttl, _ := cache.GetWithTTL("new"); ttl != time.Hour {
+		t.Errorf("Expected a new entry to get the default TTL, got %v", ttl)
+	}
+
+	v, ok := cache.Compute("list", func(old []string, exists bool) ([]string, Op) {
+		return nil, OpKeep
+	})
+	if !ok || len(v) != 3 {
+		t.Errorf("OpKeep should return the current value, got %v, %v", v, ok)
+	}
+	if v, ok := cache.Compute("list", func(old []string, exists bool) ([]string, Op) {
+		return nil, OpDelete
+	}); ok || v != nil {
+		t.Errorf("OpDelete should report the key missing, got %v, %v", v, ok)
+	}
+	if _, ok := cache.Get("list"); ok {
+		t.Error("Expected the key to be deleted")
+	}
+	if _, ok := cache.Compute("missing", func(old []string, exists bool) ([]string, Op) {
+		if exists {
+			t.Error("Missing key reported as existing")
+		}
+		return []string{"ignored"}, OpKeep
+	}); ok {
+		t.Error("OpKeep on a missing key should leave it missing")
+	}
+}
+
+// TestOpString tests operation names
+func TestOpString(t *testing.T) {
+	if s := OpDelete.String(); s != "delete" {
+		t.Errorf("Expected delete, got %s", s)
+	}
+	if s := Op(42).String(); s != "Op(42)" {
+		t.Errorf("Expected Op(42), got %s", s)
+	}
+}
+
+// TestComputeIfAbsentAndPresent tests the conditional variants
+func TestComputeIfAbsentAndPresent(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 4})
+	defer cache.Close()
+
+	calls := 0
+	build := func() (int, Op) {
+		calls++
+		return 10, OpSet
+	}
+	if v, ok := cache.ComputeIfAbsent("k", build); !ok || v != 10 {
+		t.Errorf("Expected 10 stored, got %d, %v", v, ok)
+	}
+	if v, _ := cache.ComputeIfAbsent("k", build); v != 10 || calls != 1 {
+		t.Errorf("Expected fn to run once, ran %d times", calls)
+	}
+
+	double := func(old int) (int, Op) { return old * 2, OpSet }
+	if _, ok := cache.ComputeIfPresent("missing", double); ok {
+		t.Error("ComputeIfPresent should not create a key")
+	}
+	if v, ok := cache.ComputeIfPresent("k", double); !ok || v != 20 {
+		t.Errorf("Expected 20, got %d, %v", v, ok)
+	}
+	cache.ComputeIfPresent("k", func(int) (int, Op) { return 0, OpDelete })
+	if _, ok := cache.Get("k"); ok {
+		t.Error("Expected ComputeIfPresent to delete")
+	}
+}
+
+// TestComputePanic tests that a panicking callback releases the lock and
+// leaves the entry unchanged
+func TestComputePanic(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 1})
+	defer cache.Close()
+	cache.Set("k", 1)
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("Expected the panic to propagate, got %v", r)
+			}
+		}()
+		cache.Compute("k", func(old int, exists bool) (int, Op) {
+			panic("boom")
+		})
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		cache.Set("other", 2) // Same shard; would block if the lock leaked
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shard lock still held after the callback panicked")
+	}
+	if v, _ := cache.Get("k"); v != 1 {
+		t.Errorf("Expected the entry unchanged, got %d", v)
+	}
+}
+
+// TestComputeConcurrent tests that concurrent updates are not lost
+func TestComputeConcurrent(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 4})
+	defer cache.Close()
+
+	const goroutines, increments = 8, 1000
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				cache.Compute("counter", func(old int, _ bool) (int, Op) {
+					return old + 1, OpSet
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := cache.Get("counter"); v != goroutines*increments {
+		t.Errorf("Expected %d, got %d", goroutines*increments, v)
+	}
+}
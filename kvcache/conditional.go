@@ -0,0 +1,160 @@
+package kvcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// The conditional writes below check and write a key under one acquisition
+// of its shard lock, so they are atomic with respect to every other
+// operation on the key. Values whose cost alone exceeds Config.MaxCost are
+// not stored, as with SetWithCost. Those that return the current value count
+// as lookups in the hit and miss metrics.
+
+// liveLocked returns key's entry, or nil if it is missing. An expired
+// entry is removed and counts as missing.
+// Must be called with shard.mutex held.
+func (c *Cache[K, V]) liveLocked(shard *shard[K, V], key K) *CacheEntry[K, V] {
+	entry, result := c.readLocked(shard, key)
+	if result == lookupExpired {
+		c.removeExpiredLocked(shard, key)
+	}
+	return entry
+}
+
+// SetNX stores value only if key is missing, and reports whether it did
+func (c *Cache[K, V]) SetNX(key K, value V, ttl ...time.Duration) bool {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	stored := false
+	if c.liveLocked(shard, key) == nil {
+		stored = c.setLocked(shard, key, value, c.expiration(c.now(), ttl), c.valueCost(value))
+	}
+	c.unlock(shard)
+
+	c.shed()
+	return stored
+}
+
+// SetXX stores value only if key is present, and reports whether it did
+func (c *Cache[K, V]) SetXX(key K, value V, ttl ...time.Duration) bool {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	stored := false
+	if c.liveLocked(shard, key) != nil {
+		stored = c.setLocked(shard, key, value, c.expiration(c.now(), ttl), c.valueCost(value))
+	}
+	c.unlock(shard)
+
+	c.shed()
+	return stored
+}
+
+// GetOrSet returns key's value if present (loaded is true). Otherwise it
+// stores value and returns it (loaded is false). If value is refused for
+// exceeding Config.MaxCost it returns the zero value and false, so actual
+// is only ever a value that is or was in the cache.
+func (c *Cache[K, V]) GetOrSet(key K, value V, ttl ...time.Duration) (actual V, loaded bool) {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	if entry := c.liveLocked(shard, key); entry != nil {
+		actual = c.export(entry.Value)
+		c.unlock(shard)
+		c.recordAccess(shard, key)
+		return actual, c.recordLookup(lookupHit)
+	}
+	if c.setLocked(shard, key, value, c.expiration(c.now(), ttl), c.valueCost(value)) {
+		actual = value
+	}
+	c.unlock(shard)
+
+	c.shed()
+	return actual, c.recordLookup(lookupMiss)
+}
+
+// GetAndSet stores value with the given or default TTL and returns the
+// previous value, if there was one
+func (c *Cache[K, V]) GetAndSet(key K, value V, ttl ...time.Duration) (old V, loaded bool) {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	if entry := c.liveLocked(shard, key); entry != nil {
+		old, loaded = c.export(entry.Value), true
+	}
+	c.setLocked(shard, key, value, c.expiration(c.now(), ttl), c.valueCost(value))
+	c.unlock(shard)
+
+	c.shed()
+	c.recordLookup(lookupResultOf(loaded))
+	return old, loaded
+}
+
+// Swap stores value and returns the previous value, if there was one.
+// Unlike GetAndSet, a present key keeps its expiration; a missing key gets
+// the default TTL.
+func (c *Cache[K, V]) Swap(key K, value V) (old V, loaded bool) {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	var expiration int64
+	if entry := c.liveLocked(shard, key); entry != nil {
+		old, loaded = c.export(entry.Value), true
+		expiration = atomic.LoadInt64(&entry.Expiration)
+	} else {
+		expiration = c.expiration(c.now(), nil)
+	}
+	c.setLocked(shard, key, value, expiration, c.valueCost(value))
+	c.unlock(shard)
+
+	c.shed()
+	c.recordLookup(lookupResultOf(loaded))
+	return old, loaded
+}
+
+// GetAndDelete removes key and returns its value, if it was present
+func (c *Cache[K, V]) GetAndDelete(key K) (value V, loaded bool) {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	if entry := c.liveLocked(shard, key); entry != nil {
+		value, loaded = c.export(entry.Value), true
+		c.removeLocked(shard, entry, RemovalDeleted)
+	}
+	c.unlock(shard)
+
+	c.recordLookup(lookupResultOf(loaded))
+	return value, loaded
+}
+
+// Expire gives key a new TTL, keeping its value, and reports whether key
+// was present. NoExpiration makes the entry permanent.
+func (c *Cache[K, V]) Expire(key K, ttl time.Duration) bool {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	defer c.unlock(shard)
+	entry := c.liveLocked(shard, key)
+	if entry == nil {
+		return false
+	}
+	atomic.StoreInt64(&entry.Expiration, c.expiration(c.now(), []time.Duration{ttl}))
+	c.updatedLocked(shard, entry, entry.cost)
+	return true
+}
+
+// Persist removes key's expiration and reports whether it had one
+func (c *Cache[K, V]) Persist(key K) bool {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	defer c.unlock(shard)
+	entry := c.liveLocked(shard, key)
+	if entry == nil || atomic.LoadInt64(&entry.Expiration) == 0 {
+		return false
+	}
+	atomic.StoreInt64(&entry.Expiration, 0)
+	c.updatedLocked(shard, entry, entry.cost)
+	return true
+}
+
+func lookupResultOf(hit bool) lookupResult {
+	if hit {
+		return lookupHit
+	}
+	return lookupMiss
+}
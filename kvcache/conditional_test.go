@@ -0,0 +1,204 @@
+package kvcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSetNXAndSetXX tests writes conditional on presence
+func TestSetNXAndSetXX(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, int](Config{NumShards: 4, Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+
+	if cache.SetXX("k", 1) {
+		t.Error("SetXX should not store a missing key")
+	}
+	if !cache.SetNX("k", 1, time.Minute) {
+		t.Error("SetNX should store a missing key")
+	}
+	if cache.SetNX("k", 2) {
+		t.Error("SetNX should not overwrite")
+	}
+	if !cache.SetXX("k", 3) {
+		t.Error("SetXX should overwrite a present key")
+	}
+	if v, ttl, _ := cache.GetWithTTL("k"); v != 3 || ttl != 0 {
+		t.Errorf("Expected 3 with no TTL, got %d, %v", v, ttl)
+	}
+
+	// Expired entries count as missing
+	cache.Set("e", 1, time.Second)
+	clock.Advance(2 * time.Second)
+	if cache.SetXX("e", 2) || !cache.SetNX("e", 3) {
+		t.Error("Expected an expired key to be treated as missing")
+	}
+}
+
+// TestGetOrSet tests loading or storing a value
+func TestGetOrSet(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 4})
+	defer cache.Close()
+
+	if v, loaded := cache.GetOrSet("k", 1); loaded || v != 1 {
+		t.Errorf("Expected 1 stored, got %d, %v", v, loaded)
+	}
+	if v, loaded := cache.GetOrSet("k", 2); !loaded || v != 1 {
+		t.Errorf("Expected 1 loaded, got %d, %v", v, loaded)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %d and %d", stats.Hits, stats.Misses)
+	}
+}
+
+// TestGetOrSetOversized tests that a value over the cost budget is not
+// reported as stored
+func TestGetOrSetOversized(t *testing.T) {
+	cache := NewWithConfig[string, string](Config{MaxCost: 10, CleanupInterval: -1})
+	defer cache.Close()
+
+	if v, loaded := cache.GetOrSet("k", "more than ten bytes"); loaded || v != "" {
+		t.Errorf("Expected the zero value for a refused value, got %q, %v", v, loaded)
+	}
+	if _, ok := cache.Get("k"); ok {
+		t.Error("Expected the oversized value not to be stored")
+	}
+	if v, loaded := cache.GetOrSet("k", "small"); loaded || v != "small" {
+		t.Errorf("Expected small to be stored, got %q, %v", v, loaded)
+	}
+}
+
+// TestGetAndSetAndSwap tests replacing a value and its TTL handling
+func TestGetAndSetAndSwap(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, int](Config{NumShards: 4, DefaultTTL: time.Hour, Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+
+	if _, loaded := cache.GetAndSet("k", 1, time.Minute); loaded {
+		t.Error("GetAndSet should report a missing key")
+	}
+	if old, loaded := cache.GetAndSet("k", 2); !loaded || old != 1 {
+		t.Errorf("Expected old value 1, got %d, %v", old, loaded)
+	}
+	if _, ttl, _ := cache.GetWithTTL("k"); ttl != time.Hour {
+		t.Errorf("GetAndSet should apply the default TTL, got %v", ttl)
+	}
+
+	cache.Set("s", 1, time.Minute)
+	if old, loaded := cache.Swap("s", 2); !loaded || old != 1 {
+		t.Errorf("Expected old value 1, got %d, %v", old, loaded)
+	}
+	if v, ttl, _ := cache.GetWithTTL("s"); v != 2 || ttl != time.Minute {
+		t.Errorf("Swap should keep the TTL, got %d, %v", v, ttl)
+	}
+	if _, loaded := cache.Swap("new", 1); loaded {
+		t.Error("Swap should report a missing key")
+	}
+	if _, ttl, _ := cache.GetWithTTL("new"); ttl != time.Hour {
+		t.Errorf("Swap of a missing key should apply the default TTL, got %v", ttl)
+	}
+}
+
+// TestGetAndDelete tests removing and returning a value
+func TestGetAndDelete(t *testing.T) {
+	cache := NewWithConfig[string, []int](Config{NumShards: 4})
+	defer cache.Close()
+
+	cache.Set("k", []int{1, 2})
+	if v, loaded := cache.GetAndDelete("k"); !loaded || len(v) != 2 {
+		t.Errorf("Expected [1 2], got %v, %v", v, loaded)
+	}
+	if _, loaded := cache.GetAndDelete("k"); loaded {
+		t.Error("Expected the key to be gone")
+	}
+	if cache.Size() != 0 {
+		t.Errorf("Expected empty cache, got %d", cache.Size())
+	}
+}
+
+// TestConditionalConcurrent tests that each primitive is atomic under
+// contention: exactly one SetNX and one GetAndDelete win per round, and
+// TestExpireAndPersist tests changing a key's TTL in place
+func TestExpireAndPersist(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, int](Config{DefaultTTL: time.Minute, Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+	cache.Set("k", 1)
+	_, version, _ := cache.GetWithVersion("k")
+
+	if cache.Expire("missing", time.Hour) || cache.Persist("missing") {
+		t.Error("Expected Expire and Persist to miss")
+	}
+	if !cache.Expire("k", time.Hour) {
+		t.Fatal("Expected Expire to find k")
+	}
+	if v, ttl, _ := cache.GetWithTTL("k"); v != 1 || ttl != time.Hour {
+		t.Errorf("Expected 1 with an hour left, got %d, %v", v, ttl)
+	}
+	if _, v2, _ := cache.GetWithVersion("k"); v2 == version {
+		t.Error("Expected Expire to change the version")
+	}
+
+	if !cache.Persist("k") || cache.Persist("k") {
+		t.Error("Expected Persist to succeed once")
+	}
+	clock.Advance(2 * time.Hour)
+	if _, ttl, ok := cache.GetWithTTL("k"); !ok || ttl != 0 {
+		t.Errorf("Expected k to be permanent, got %v, %v", ttl, ok)
+	}
+
+	cache.Expire("k", NoExpiration)
+	cache.Expire("k", 0) // The default TTL
+	clock.Advance(2 * time.Minute)
+	if cache.Contains("k") {
+		t.Error("Expected k to expire with the default TTL")
+	}
+}
+
+// GetOrSet callers all agree on the stored value
+func TestConditionalConcurrent(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 4, MaxCapacity: 1000})
+	defer cache.Close()
+
+	const goroutines, rounds = 8, 200
+	for round := 0; round < rounds; round++ {
+		var setWins, deleteWins atomic.Int32
+		results := make([]int, goroutines)
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				if cache.SetNX("nx", g) {
+					setWins.Add(1)
+				}
+				results[g], _ = cache.GetOrSet("gos", g)
+				cache.Swap("swap", g)
+			}(g)
+		}
+		wg.Wait()
+
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, ok := cache.GetAndDelete("nx"); ok {
+					deleteWins.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if setWins.Load() != 1 || deleteWins.Load() != 1 {
+			t.Fatalf("Round %d: %d SetNX and %d GetAndDelete winners, expected 1 each", round, setWins.Load(), deleteWins.Load())
+		}
+		for _, r := range results {
+			if r != results[0] {
+				t.Fatalf("Round %d: GetOrSet callers disagree: %v", round, results)
+			}
+		}
+		cache.Delete("gos")
+	}
+}
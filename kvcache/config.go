@@ -0,0 +1,156 @@
+package kvcache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Default configuration values
+const (
+	DefaultNumShards       = 256
+	DefaultCleanupInterval = time.Minute
+)
+
+// ErrInvalidConfig is returned by Config.Validate for unusable settings
+var ErrInvalidConfig = errors.New("kvcache: invalid config")
+
+// Clock supplies the current time to the cache.
+// Tests can substitute a fake clock to control expiration.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the default Clock backed by time.Now
+type SystemClock struct{}
+
+// Now returns the current wall clock time
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// Config holds the tunables for a cache created with NewWithConfig.
+// The zero value is usable and matches New(0): no expiration, no capacity
+// limit, 256 shards and a one-minute cleanup interval.
+type Config struct {
+	// DefaultTTL applies to entries set without a TTL or with a TTL of 0.
+	// Zero means such entries never expire. A negative TTL, such as
+	// NoExpiration, overrides it with no expiry.
+	DefaultTTL time.Duration
+
+	// MaxCapacityPerShard limits the number of entries in each shard.
+	// Zero means unlimited.
+	MaxCapacityPerShard int
+
+	// MaxCapacity limits the total number of entries in the cache. The limit
+	// is divided across shards and takes precedence over MaxCapacityPerShard.
+	// Each shard holds at least one entry, so use fewer shards for very
+	// small limits. Zero means MaxCapacityPerShard is used instead.
+	MaxCapacity int
+
+	// NumShards is the number of independent shards. It must be a power of
+	// two so a key's shard can be selected by masking its hash.
+	// Zero means DefaultNumShards.
+	NumShards int
+
+	// GracePeriod keeps entries for this long after they expire so
+	// GetStale, and a LoadingCache, can still serve them flagged as stale.
+	// Other reads treat them as missing; they are removed once the grace
+	// period ends. Zero removes entries as soon as they expire.
+	GracePeriod time.Duration
+
+	// CleanupInterval controls how often expired entries are swept.
+	// Zero means DefaultCleanupInterval; a negative value disables the
+	// background cleanup goroutine (expired entries are still removed lazily).
+	CleanupInterval time.Duration
+
+	// MaxCost limits the total cost of all entries, for example their size
+	// in bytes. When a write takes the cache over budget, entries are
+	// evicted across shards until it fits again. A single value costing
+	// more than MaxCost is not stored. Zero means unlimited.
+	MaxCost int64
+
+	// Cost returns the cost of a value stored with Set. Nil means DefaultCost.
+	// Set only computes costs when MaxCost or Cost is set; SetWithCost
+	// always charges the cost it is given.
+	Cost func(value interface{}) int64
+
+	// InitialShardSize is a size hint for each shard's map.
+	InitialShardSize int
+
+	// Eviction selects the built-in policy used when a shard is full.
+	// The zero value is EvictionLRU.
+	Eviction EvictionKind
+
+	// RemovalQueueSize moves OnRemoval listeners to a background goroutine
+	// fed by a queue of this many removals. A writer that finds the queue
+	// full calls the listeners itself, so listeners then run concurrently
+	// and out of order. Close delivers everything queued before returning.
+	// Zero calls listeners on the goroutine that removed the entry.
+	RemovalQueueSize int
+
+	// Clock supplies the current time. Nil means SystemClock.
+	Clock Clock
+
+	// Codec encodes keys and values for snapshots. Nil means GobCodec.
+	Codec Codec
+}
+
+// Validate reports whether the configuration can be used to build a cache
+func (cfg Config) Validate() error {
+	if cfg.DefaultTTL < 0 {
+		return fmt.Errorf("%w: DefaultTTL must not be negative", ErrInvalidConfig)
+	}
+	if cfg.GracePeriod < 0 {
+		return fmt.Errorf("%w: GracePeriod must not be negative", ErrInvalidConfig)
+	}
+	if cfg.MaxCapacityPerShard < 0 || cfg.MaxCapacity < 0 || cfg.MaxCost < 0 {
+		return fmt.Errorf("%w: capacity must not be negative", ErrInvalidConfig)
+	}
+	if cfg.NumShards < 0 || cfg.NumShards&(cfg.NumShards-1) != 0 {
+		return fmt.Errorf("%w: NumShards must be a power of two, got %d", ErrInvalidConfig, cfg.NumShards)
+	}
+	if cfg.InitialShardSize < 0 {
+		return fmt.Errorf("%w: InitialShardSize must not be negative", ErrInvalidConfig)
+	}
+	if cfg.RemovalQueueSize < 0 {
+		return fmt.Errorf("%w: RemovalQueueSize must not be negative", ErrInvalidConfig)
+	}
+	if cfg.Eviction < EvictionLRU || cfg.Eviction > EvictionTinyLFU {
+		return fmt.Errorf("%w: unknown eviction policy %v", ErrInvalidConfig, cfg.Eviction)
+	}
+	return nil
+}
+
+// withDefaults fills in zero-valued settings
+func (cfg Config) withDefaults() Config {
+	if cfg.NumShards == 0 {
+		cfg.NumShards = DefaultNumShards
+	}
+	if cfg.CleanupInterval == 0 {
+		cfg.CleanupInterval = DefaultCleanupInterval
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = SystemClock{}
+	}
+	if cfg.Codec == nil {
+		cfg.Codec = GobCodec{}
+	}
+	return cfg
+}
+
+// shardCapacity returns the entry limit for shard i, 0 meaning unlimited.
+// A total MaxCapacity is split so the shard limits add up to exactly it.
+func (cfg Config) shardCapacity(i int) int {
+	if cfg.MaxCapacity == 0 {
+		return cfg.MaxCapacityPerShard
+	}
+	capacity := cfg.MaxCapacity / cfg.NumShards
+	if i < cfg.MaxCapacity%cfg.NumShards {
+		capacity++
+	}
+	if capacity == 0 {
+		// More shards than total capacity; every shard still holds one
+		// entry rather than letting 0 mean unlimited.
+		capacity = 1
+	}
+	return capacity
+}
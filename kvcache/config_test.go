@@ -0,0 +1,203 @@
+package kvcache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced Clock for deterministic expiration tests
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	f.mu.Unlock()
+}
+
+// TestConfigValidate tests rejection of unusable settings
+func TestConfigValidate(t *testing.T) {
+	valid := []Config{
+		{},
+		{NumShards: 1},
+		{NumShards: 1024, MaxCapacity: 10},
+		{CleanupInterval: -1},
+	}
+	for _, cfg := range valid {
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", cfg, err)
+		}
+	}
+
+	invalid := []Config{
+		{NumShards: 3},
+		{NumShards: 100},
+		{NumShards: -4},
+		{DefaultTTL: -time.Second},
+		{GracePeriod: -time.Second},
+		{MaxCapacity: -1},
+		{MaxCapacityPerShard: -1},
+		{InitialShardSize: -1},
+		{RemovalQueueSize: -1},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.Validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected %+v to be invalid, got %v", cfg, err)
+		}
+	}
+}
+
+// TestNewWithConfigPanicsOnInvalidConfig tests constructor validation
+func TestNewWithConfigPanicsOnInvalidConfig(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic for non power-of-two shard count")
+		}
+	}()
+	NewWithConfig[string, int](Config{NumShards: 12})
+}
+
+// TestConfigShardCount tests custom shard counts and masking
+func TestConfigShardCount(t *testing.T) {
+	cache := NewWithConfig[int, int](Config{NumShards: 16})
+	defer cache.Close()
+
+	if len(cache.shards) != 16 {
+		t.Fatalf("Expected 16 shards, got %d", len(cache.shards))
+	}
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i)
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := cache.Get(i); !ok || v != i {
+			t.Fatalf("Key %d: got %d, %v", i, v, ok)
+		}
+	}
+}
+
+// TestConfigTotalCapacity tests dividing a global limit across shards
+func TestConfigTotalCapacity(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{
+		NumShards:           8,
+		MaxCapacity:         100,
+		MaxCapacityPerShard: 1000, // ignored in favour of MaxCapacity
+	})
+	defer cache.Close()
+
+	total := 0
+	for _, s := range cache.shards {
+		total += s.capacity
+	}
+	if total != 100 {
+		t.Errorf("Expected shard capacities to sum to 100, got %d", total)
+	}
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), i)
+	}
+	if size := cache.Size(); size > 100 {
+		t.Errorf("Expected at most 100 entries, got %d", size)
+	}
+	if cache.Stats().Evictions == 0 {
+		t.Error("Expected evictions once the total capacity is reached")
+	}
+}
+
+// TestConfigClock tests expiration driven by a pluggable clock
+func TestConfigClock(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, string](Config{
+		DefaultTTL:      time.Minute,
+		CleanupInterval: -1,
+		Clock:           clock,
+	})
+	defer cache.Close()
+	if cache.Clock() != clock {
+		t.Error("Clock should return the configured clock")
+	}
+
+	cache.Set("key", "value")
+	clock.Advance(59 * time.Second)
+	if _, ok := cache.Get("key"); !ok {
+		t.Error("Key should not expire before its TTL")
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, ok := cache.Get("key"); ok {
+		t.Error("Key should expire after its TTL")
+	}
+}
+
+// TestConfigZeroTTL tests that a zero default TTL never expires entries
+func TestConfigZeroTTL(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, string](Config{Clock: clock})
+	defer cache.Close()
+
+	cache.Set("forever", "value")
+	cache.Set("short", "value", time.Second)
+	clock.Advance(24 * time.Hour)
+
+	if _, ok := cache.Get("forever"); !ok {
+		t.Error("Entry without TTL should never expire")
+	}
+	if _, ok := cache.Get("short"); ok {
+		t.Error("Entry with explicit TTL should expire")
+	}
+}
+
+// TestConfigCleanupInterval tests the background sweep interval
+func TestConfigCleanupInterval(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, string](Config{
+		CleanupInterval: 10 * time.Millisecond,
+		Clock:           clock,
+	})
+	defer cache.Close()
+
+	cache.Set("key", "value", time.Second)
+	clock.Advance(2 * time.Second)
+
+	deadline := time.Now().Add(time.Second)
+	for cache.Size() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if cache.Size() != 0 {
+		t.Error("Expected cleanup goroutine to remove the expired entry")
+	}
+}
+
+// TestConfigCleanupDisabled tests that a negative interval disables the sweep
+func TestConfigCleanupDisabled(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, string](Config{
+		CleanupInterval: -1,
+		Clock:           clock,
+	})
+
+	cache.Set("key", "value", time.Second)
+	clock.Advance(2 * time.Second)
+	time.Sleep(20 * time.Millisecond)
+
+	if cache.Size() != 1 {
+		t.Error("Expired entry should remain until accessed when cleanup is disabled")
+	}
+	if err := cache.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package kvcache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Backoff bounds used while polling a contended shard lock
+const (
+	minLockBackoff = time.Microsecond
+	maxLockBackoff = time.Millisecond
+)
+
+// acquire calls try until it succeeds or ctx is done.
+// A goroutine blocked in sync.RWMutex.Lock cannot be interrupted, so
+// contended locks are polled with exponential backoff instead. Because
+// TryLock never queues, a writer can be starved by a steady stream of
+// readers; the context deadline bounds how long it keeps trying.
+func acquire(ctx context.Context, try func() bool) error {
+	if try() {
+		return nil
+	}
+	backoff := minLockBackoff
+	for {
+		if err := ctx.Err(); err != nil {
+			return canceled(err)
+		}
+		time.Sleep(backoff)
+		if try() {
+			return nil
+		}
+		if backoff < maxLockBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+// checkContext returns ErrClosed or ErrCanceled before an operation starts
+func (c *Cache[K, V]) checkContext(ctx context.Context) error {
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return canceled(err)
+	}
+	return nil
+}
+
+// GetWithContext retrieves a value by key, waiting on the shard lock no
+// longer than ctx allows. It returns ErrNotFound for missing or expired keys.
+func (c *Cache[K, V]) GetWithContext(ctx context.Context, key K) (V, error) {
+	var zero V
+	if err := c.checkContext(ctx); err != nil {
+		return zero, err
+	}
+
+	shard := c.getShard(key)
+	if err := acquire(ctx, shard.mutex.TryRLock); err != nil {
+		return zero, err
+	}
+	var value V
+	entry, result := c.readLocked(shard, key)
+	if result == lookupHit {
+		value = c.export(entry.Value)
+	}
+	shard.mutex.RUnlock()
+
+	switch result {
+	case lookupHit:
+		c.recordAccess(shard, key)
+	case lookupExpired:
+		// Lazy deletion is best effort here; don't wait for the write lock
+		if shard.mutex.TryLock() {
+			c.removeExpiredLocked(shard, key)
+			c.unlock(shard)
+		}
+	}
+	if !c.recordLookup(result) {
+		return zero, ErrNotFound
+	}
+	return value, nil
+}
+
+// SetWithContext adds or updates a key-value pair with optional custom TTL,
+// waiting on the shard lock no longer than ctx allows
+func (c *Cache[K, V]) SetWithContext(ctx context.Context, key K, value V, ttl ...time.Duration) error {
+	if err := c.checkContext(ctx); err != nil {
+		return err
+	}
+
+	cost := c.valueCost(value)
+	shard := c.getShard(key)
+	if err := acquire(ctx, shard.mutex.TryLock); err != nil {
+		return err
+	}
+	c.setLocked(shard, key, value, c.expiration(c.now(), ttl), cost)
+	c.unlock(shard)
+
+	c.shed()
+	return nil
+}
+
+// DeleteWithContext removes a key-value pair, waiting on the shard lock no
+// longer than ctx allows. It returns ErrNotFound if the key was not present.
+func (c *Cache[K, V]) DeleteWithContext(ctx context.Context, key K) error {
+	if err := c.checkContext(ctx); err != nil {
+		return err
+	}
+
+	shard := c.getShard(key)
+	if err := acquire(ctx, shard.mutex.TryLock); err != nil {
+		return err
+	}
+	defer c.unlock(shard)
+
+	if !c.deleteLocked(shard, key) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetMultiWithContext retrieves multiple values by keys. If ctx is canceled
+// part-way, the values found so far are returned with a *BatchError.
+func (c *Cache[K, V]) GetMultiWithContext(ctx context.Context, keys []K) (map[K]V, error) {
+	result := make(map[K]V, len(keys))
+	for i, key := range keys {
+		value, err := c.GetWithContext(ctx, key)
+		switch {
+		case err == nil:
+			result[key] = value
+		case !errors.Is(err, ErrNotFound):
+			return result, &BatchError{Completed: i, Total: len(keys), Err: err}
+		}
+	}
+	return result, nil
+}
+
+// SetMultiWithContext sets multiple key-value pairs. If ctx is canceled
+// part-way, the pairs already written stay in the cache and a *BatchError
+// reports how many were applied. Map iteration order is unspecified, so
+// callers that need every pair should retry the whole batch.
+func (c *Cache[K, V]) SetMultiWithContext(ctx context.Context, entries map[K]V, ttl ...time.Duration) error {
+	done := 0
+	for key, value := range entries {
+		if err := c.SetWithContext(ctx, key, value, ttl...); err != nil {
+			return &BatchError{Completed: done, Total: len(entries), Err: err}
+		}
+		done++
+	}
+	return nil
+}
+
+// ClearWithContext removes all entries shard by shard. If ctx is canceled
+// part-way, the shards already cleared stay empty and a *BatchError reports
+// how many shards were processed.
+func (c *Cache[K, V]) ClearWithContext(ctx context.Context) error {
+	for i, shard := range c.shards {
+		err := c.checkContext(ctx)
+		if err == nil {
+			err = acquire(ctx, shard.mutex.TryLock)
+		}
+		if err != nil {
+			return &BatchError{Completed: i, Total: len(c.shards), Err: err}
+		}
+		c.clearLocked(shard)
+		c.unlock(shard)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package kvcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestContextBasicOperations tests the context-aware happy path
+func TestContextBasicOperations(t *testing.T) {
+	cache := New[string, int](5 * time.Minute)
+	defer cache.Close()
+	ctx := context.Background()
+
+	if err := cache.SetWithContext(ctx, "key", 1); err != nil {
+		t.Fatalf("SetWithContext failed: %v", err)
+	}
+	val, err := cache.GetWithContext(ctx, "key")
+	if err != nil || val != 1 {
+		t.Errorf("Expected 1, got %v (%v)", val, err)
+	}
+
+	if _, err := cache.GetWithContext(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := cache.DeleteWithContext(ctx, "key"); err != nil {
+		t.Errorf("DeleteWithContext failed: %v", err)
+	}
+	if err := cache.DeleteWithContext(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting missing key, got %v", err)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %+v", stats)
+	}
+}
+
+// TestContextExpiredKey tests that expired keys report ErrNotFound
+func TestContextExpiredKey(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, int](Config{Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+
+	cache.Set("key", 1, time.Second)
+	clock.Advance(2 * time.Second)
+
+	if _, err := cache.GetWithContext(context.Background(), "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if cache.Size() != 0 {
+		t.Error("Expired entry should be removed lazily")
+	}
+}
+
+// TestContextDeadlineWhileLocked tests giving up on a held shard lock
+func TestContextDeadlineWhileLocked(t *testing.T) {
+	cache := New[string, int](5 * time.Minute)
+	defer cache.Close()
+	cache.Set("key", 1)
+
+	shard := cache.getShard("key")
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := cache.GetWithContext(ctx, "key")
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected ErrCanceled wrapping DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetWithContext waited %v past its deadline", elapsed)
+	}
+
+	if err := cache.SetWithContext(ctx, "key", 2); !errors.Is(err, ErrCanceled) {
+		t.Errorf("Expected ErrCanceled from Set, got %v", err)
+	}
+	if err := cache.DeleteWithContext(ctx, "key"); !errors.Is(err, ErrCanceled) {
+		t.Errorf("Expected ErrCanceled from Delete, got %v", err)
+	}
+}
+
+// TestContextLockReleased tests waiting for a lock that is released in time
+func TestContextLockReleased(t *testing.T) {
+	cache := New[string, int](5 * time.Minute)
+	defer cache.Close()
+
+	shard := cache.getShard("key")
+	shard.mutex.Lock()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		shard.mutex.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := cache.SetWithContext(ctx, "key", 1); err != nil {
+		t.Errorf("Expected Set to succeed once the lock is released, got %v", err)
+	}
+}
+
+// TestContextClosed tests ErrClosed after Close
+func TestContextClosed(t *testing.T) {
+	cache := New[string, int](5 * time.Minute)
+	cache.Close()
+	cache.Close() // Close is idempotent
+
+	ctx := context.Background()
+	if err := cache.SetWithContext(ctx, "key", 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+	if _, err := cache.GetWithContext(ctx, "key"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+// TestContextBatchPartial tests batch operations stopping part-way
+func TestContextBatchPartial(t *testing.T) {
+	cache := New[string, int](5 * time.Minute)
+	defer cache.Close()
+
+	keys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		cache.Set(key, i)
+	}
+
+	// Hold the lock of the shard owning key50 so the batch stalls there
+	shard := cache.getShard("key50")
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	stop := 0
+	for i, key := range keys {
+		if cache.getShard(key) == shard {
+			stop = i
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	results, err := cache.GetMultiWithContext(ctx, keys)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("Expected *BatchError, got %v", err)
+	}
+	if batchErr.Completed != stop || batchErr.Total != len(keys) {
+		t.Errorf("Expected to stop at %d of %d, got %+v", stop, len(keys), batchErr)
+	}
+	if len(results) != stop {
+		t.Errorf("Expected %d partial results, got %d", stop, len(results))
+	}
+	if !errors.Is(err, ErrCanceled) {
+		t.Errorf("Expected BatchError to wrap ErrCanceled, got %v", err)
+	}
+
+	err = cache.ClearWithContext(ctx)
+	if !errors.As(err, &batchErr) || batchErr.Total != len(cache.shards) {
+		t.Fatalf("Expected *BatchError from Clear, got %v", err)
+	}
+}
+
+// TestContextSetMultiCanceled tests SetMulti with an already canceled context
+func TestContextSetMultiCanceled(t *testing.T) {
+	cache := New[string, int](5 * time.Minute)
+	defer cache.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := cache.SetMultiWithContext(ctx, map[string]int{"a": 1, "b": 2})
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Completed != 0 || batchErr.Total != 2 {
+		t.Errorf("Expected BatchError with nothing applied, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error to wrap context.Canceled, got %v", err)
+	}
+	if cache.Size() != 0 {
+		t.Error("No entries should be written with a canceled context")
+	}
+
+	if err := cache.SetMultiWithContext(context.Background(), map[string]int{"a": 1, "b": 2}); err != nil {
+		t.Errorf("SetMultiWithContext failed: %v", err)
+	}
+	if err := cache.ClearWithContext(context.Background()); err != nil || cache.Size() != 0 {
+		t.Errorf("ClearWithContext failed: %v", err)
+	}
+}
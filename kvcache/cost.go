@@ -0,0 +1,109 @@
+package kvcache
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+// ErrOverBudget is returned by writes that report errors, such as Incr,
+// when the new value's cost alone exceeds Config.MaxCost. The value is not
+// stored and, as with SetWithCost, the previous value is evicted.
+var ErrOverBudget = errors.New("kvcache: value exceeds the cost budget")
+
+// DefaultCost estimates the size of a value in bytes. A value with a
+// Cost() int64 method costs what it returns. Strings and byte slices cost
+// their length; other slices, arrays and maps cost their length times the
+// size of their elements; anything else costs the size of its type. Memory
+// referenced through pointers is not counted.
+func DefaultCost(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case interface{ Cost() int64 }:
+		return v.Cost()
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case []string:
+		var n int64
+		for _, s := range v {
+			n += int64(len(s))
+		}
+		return n
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		return int64(rv.Len()) * int64(rv.Type().Elem().Size())
+	case reflect.Map:
+		t := rv.Type()
+		return int64(rv.Len()) * int64(t.Key().Size()+t.Elem().Size())
+	default:
+		return int64(rv.Type().Size())
+	}
+}
+
+// valueCost adapts a Config.Cost function to the cache's value type
+func valueCost[V any](cost func(interface{}) int64) func(V) int64 {
+	if cost == nil {
+		cost = DefaultCost
+	}
+	return func(v V) int64 { return max(cost(v), 0) }
+}
+
+// valueCost returns the cost Set charges for a value, 0 if costs are not
+// tracked
+func (c *Cache[K, V]) valueCost(value V) int64 {
+	if c.costOf == nil {
+		return 0
+	}
+	return c.costOf(value)
+}
+
+// fits reports whether a value of the given cost can be stored at all
+func (c *Cache[K, V]) fits(cost int64) bool {
+	return c.maxCost == 0 || cost <= c.maxCost
+}
+
+// SetWithCost adds or updates a key-value pair with an explicit cost and
+// optional custom TTL, then evicts entries while the cache is over its cost
+// budget. It returns false if the cost alone exceeds Config.MaxCost; the
+// value is then not stored and any previous value for key is removed.
+// Negative costs count as zero.
+func (c *Cache[K, V]) SetWithCost(key K, value V, cost int64, ttl ...time.Duration) bool {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	stored := c.setLocked(shard, key, value, c.expiration(c.now(), ttl), max(cost, 0))
+	c.unlock(shard)
+
+	c.shed()
+	return stored
+}
+
+// shed evicts entries until the total cost is within budget, taking one
+// victim from each shard in turn so no single shard pays for the others.
+// Only one shard lock is held at a time. Concurrent writers may briefly
+// overshoot the budget before their own shed catches up.
+func (c *Cache[K, V]) shed() {
+	if c.maxCost == 0 {
+		return
+	}
+	// Stop after a full round without a victim, e.g. when every shard is
+	// empty but a concurrent writer has not settled the counter yet
+	for idle := 0; idle < c.numShards && c.cost.Load() > c.maxCost; {
+		s := c.shards[c.shedCursor.Add(1)&c.shardMask]
+		s.mutex.Lock()
+		c.drainAccessesLocked(s)
+		evicted := c.cost.Load() > c.maxCost && c.evictOldest(s)
+		c.unlock(s)
+
+		if evicted {
+			idle = 0
+		} else {
+			idle++
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package kvcache
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sized is a value that reports its own cost
+type sized int64
+
+func (s sized) Cost() int64 { return int64(s) }
+
+// TestDefaultCost tests the built-in size estimator
+func TestDefaultCost(t *testing.T) {
+	type pair struct{ a, b int64 }
+	tests := []struct {
+		value interface{}
+		want  int64
+	}{
+		{nil, 0},
+		{"hello", 5},
+		{[]byte("abc"), 3},
+		{[]string{"ab", "cde"}, 5},
+		{int64(1), 8},
+		{true, 1},
+		{pair{}, 16},
+		{[]int32{1, 2, 3}, 12},
+		{[4]uint16{}, 8},
+		{map[int64]int64{1: 1, 2: 2}, 32},
+		{sized(1000), 1000},
+	}
+	for _, tc := range tests {
+		if got := DefaultCost(tc.value); got != tc.want {
+			t.Errorf("DefaultCost(%#v) = %d, want %d", tc.value, got, tc.want)
+		}
+	}
+}
+
+// TestCostBudgetEvicts tests that writes over budget evict across shards
+func TestCostBudgetEvicts(t *testing.T) {
+	cache := NewWithConfig[int, string](Config{
+		NumShards:       4,
+		MaxCost:         100,
+		CleanupInterval: -1,
+	})
+	defer cache.Close()
+
+	for i := 0; i < 50; i++ {
+		if !cache.SetWithCost(i, "v", 10) {
+			t.Fatalf("SetWithCost(%d) rejected", i)
+		}
+		if cost := cache.Stats().Cost; cost > 100 {
+			t.Fatalf("Cost %d over budget after %d writes", cost, i+1)
+		}
+	}
+
+	stats := cache.Stats()
+	if stats.Cost != 100 || stats.MaxCost != 100 {
+		t.Errorf("Expected cost 100/100, got %d/%d", stats.Cost, stats.MaxCost)
+	}
+	if stats.Size != 10 || stats.Evictions != 40 {
+		t.Errorf("Expected 10 entries and 40 evictions, got %d and %d", stats.Size, stats.Evictions)
+	}
+	if _, ok := cache.Get(49); !ok {
+		t.Error("The latest write should not be evicted")
+	}
+}
+
+// TestCostEvictsByPolicy tests that shedding follows the eviction policy
+func TestCostEvictsByPolicy(t *testing.T) {
+	cache := NewWithConfig[int, int](Config{
+		NumShards:       1,
+		MaxCost:         30,
+		CleanupInterval: -1,
+	})
+	defer cache.Close()
+
+	cache.SetWithCost(1, 1, 10)
+	cache.SetWithCost(2, 2, 10)
+	cache.SetWithCost(3, 3, 10)
+	cache.Get(1)
+
+	// Needs two victims: 2 then 3
+	cache.SetWithCost(4, 4, 20)
+	for k, want := range map[int]bool{1: true, 2: false, 3: false, 4: true} {
+		if _, ok := cache.Get(k); ok != want {
+			t.Errorf("Key %d present = %v, want %v", k, ok, want)
+		}
+	}
+}
+
+// TestCostOversizedValue tests that a value over the whole budget is refused
+func TestCostOversizedValue(t *testing.T) {
+	cache := NewWithConfig[string, string](Config{MaxCost: 10, CleanupInterval: -1})
+	defer cache.Close()
+
+	cache.Set("a", "small")
+	cache.Set("b", "tiny")
+	if cache.SetWithCost("a", "huge", 11) {
+		t.Error("Expected oversized value to be refused")
+	}
+	if _, ok := cache.Get("a"); ok {
+		t.Error("The previous value should be removed, not left stale")
+	}
+	if _, ok := cache.Get("b"); !ok {
+		t.Error("Other entries should not be evicted for a refused value")
+	}
+
+	// Set uses the cost function and refuses the same way
+	cache.Set("c", "more than ten bytes")
+	if _, ok := cache.Get("c"); ok {
+		t.Error("Expected oversized Set to be dropped")
+	}
+	if cost := cache.Stats().Cost; cost != 4 {
+		t.Errorf("Expected cost 4, got %d", cost)
+	}
+}
+
+// TestCostAccounting tests that every removal path releases cost
+func TestCostAccounting(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, []byte](Config{
+		NumShards:       2,
+		MaxCost:         1 << 20,
+		CleanupInterval: -1,
+		Clock:           clock,
+	})
+	defer cache.Close()
+
+	cache.Set("a", make([]byte, 100))
+	cache.Set("b", make([]byte, 50))
+	if cost := cache.Stats().Cost; cost != 150 {
+		t.Fatalf("Expected cost 150, got %d", cost)
+	}
+
+	cache.Set("a", make([]byte, 10)) // Overwrite adjusts by the difference
+	if cost := cache.Stats().Cost; cost != 60 {
+		t.Errorf("Expected cost 60 after overwrite, got %d", cost)
+	}
+
+	cache.Delete("b")
+	if cost := cache.Stats().Cost; cost != 10 {
+		t.Errorf("Expected cost 10 after delete, got %d", cost)
+	}
+
+	cache.Set("c", make([]byte, 5), time.Second)
+	clock.Advance(2 * time.Second)
+	cache.Get("c")
+	if cost := cache.Stats().Cost; cost != 10 {
+		t.Errorf("Expected cost 10 after expiry, got %d", cost)
+	}
+
+	cache.Set("d", make([]byte, 5))
+	cache.Clear()
+	if cost := cache.Stats().Cost; cost != 0 {
+		t.Errorf("Expected cost 0 after clear, got %d", cost)
+	}
+}
+
+// TestCostFunction tests a custom Config.Cost and untracked caches
+func TestCostFunction(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{
+		MaxCost: 3,
+		Cost:    func(interface{}) int64 { return 1 },
+	})
+	defer cache.Close()
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		cache.Set(k, make([]byte, 1000))
+	}
+	if stats := cache.Stats(); stats.Size != 3 || stats.Cost != 3 {
+		t.Errorf("Expected 3 entries costing 3, got %d costing %d", stats.Size, stats.Cost)
+	}
+
+	plain := New[string, string](0)
+	defer plain.Close()
+	plain.Set("a", "hello")
+	if cost := plain.Stats().Cost; cost != 0 {
+		t.Errorf("Costs should not be computed without a budget, got %d", cost)
+	}
+	plain.SetWithCost("b", "x", 7)
+	if cost := plain.Stats().Cost; cost != 7 {
+		t.Errorf("Explicit costs should always be tracked, got %d", cost)
+	}
+
+	if err := (Config{MaxCost: -1}).Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected negative MaxCost to be invalid, got %v", err)
+	}
+}
+
+// TestCostWithCapacity tests entry and cost limits applied together
+func TestCostWithCapacity(t *testing.T) {
+	for _, kind := range allPolicies {
+		t.Run(kind.String(), func(t *testing.T) {
+			cache := NewWithConfig[int, int](Config{
+				NumShards:           4,
+				MaxCapacityPerShard: 8,
+				MaxCost:             200,
+				CleanupInterval:     -1,
+				Eviction:            kind,
+			})
+			defer cache.Close()
+
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < 5000; i++ {
+				k := rng.Intn(100)
+				if _, ok := cache.Get(k); !ok {
+					cache.SetWithCost(k, k, int64(1+k%20))
+				}
+			}
+			stats := cache.Stats()
+			if stats.Cost > 200 || stats.Size > 32 {
+				t.Errorf("Limits exceeded: cost %d, size %d", stats.Cost, stats.Size)
+			}
+			checkCost(t, cache)
+		})
+	}
+}
+
+// TestCostConcurrent tests the shared budget under concurrent writers
+func TestCostConcurrent(t *testing.T) {
+	cache := NewWithConfig[int, int](Config{
+		NumShards:       8,
+		MaxCost:         500,
+		CleanupInterval: -1,
+	})
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < 5000; i++ {
+				k := rng.Intn(1000)
+				switch rng.Intn(3) {
+				case 0:
+					cache.SetWithCost(k, k, int64(rng.Intn(50)))
+				case 1:
+					cache.Get(k)
+				default:
+					cache.Delete(k)
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	if cost := cache.Stats().Cost; cost > 500 {
+		t.Errorf("Cost %d over budget once writers settled", cost)
+	}
+	checkCost(t, cache)
+}
+
+// checkCost verifies the total cost matches the entries actually cached
+func checkCost(t *testing.T, cache *Cache[int, int]) {
+	t.Helper()
+	var sum int64
+	for _, shard := range cache.shards {
+		shard.mutex.RLock()
+		for _, e := range shard.store {
+			sum += e.cost
+		}
+		shard.mutex.RUnlock()
+	}
+	if cost := cache.Stats().Cost; cost != sum {
+		t.Errorf("Stats report cost %d, entries add up to %d", cost, sum)
+	}
+}
@@ -0,0 +1,294 @@
+package kvcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Counters are ordinary values updated atomically under the shard lock.
+// Incr works on any Go integer type and IncrFloat on any integer or float
+// type, keeping the value's type, so callers can still type-assert what
+// they stored. Strings and byte slices holding a decimal number (as written
+// by the Redis server) are accepted too and stay strings or byte slices.
+// Missing keys start from 0 and are stored as int64 or float64.
+
+// ErrNotNumeric is matched by a *NotNumericError
+var ErrNotNumeric = errors.New("kvcache: value is not numeric")
+
+// ErrOverflow is returned when an increment would overflow the counter's
+// type, or make a float counter infinite or NaN
+var ErrOverflow = errors.New("kvcache: increment would overflow")
+
+// NotNumericError reports a counter operation on a value that is not a
+// number of the kind it needs: Incr and Decr need an integer, IncrFloat
+// any number
+type NotNumericError struct {
+	Key   string
+	Value interface{}
+}
+
+// Error implements the error interface
+func (e *NotNumericError) Error() string {
+	return fmt.Sprintf("kvcache: value of key %q is not numeric: %T", e.Key, e.Value)
+}
+
+// Unwrap returns ErrNotNumeric
+func (e *NotNumericError) Unwrap() error {
+	return ErrNotNumeric
+}
+
+// CounterOptions controls the TTL of counters updated by IncrWithOptions
+// and IncrFloatWithOptions
+type CounterOptions struct {
+	TTL      time.Duration // TTL of a counter created by the call, 0 for DefaultTTL
+	ResetTTL bool          // Also give an existing counter TTL instead of keeping its expiration
+}
+
+// Incr atomically adds delta to key's integer value and returns the
+// result. A missing key is created with value delta and the given TTL
+// (the default TTL if none); an existing key keeps its expiration.
+func (c *KVCache) Incr(key string, delta int64, ttl ...time.Duration) (int64, error) {
+	return c.IncrWithOptions(key, delta, counterOptions(ttl))
+}
+
+// Decr atomically subtracts delta from key's integer value, as Incr
+func (c *KVCache) Decr(key string, delta int64, ttl ...time.Duration) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, ErrOverflow
+	}
+	return c.IncrWithOptions(key, -delta, counterOptions(ttl))
+}
+
+// IncrFloat atomically adds delta to key's numeric value and returns the
+// result, as Incr
+func (c *KVCache) IncrFloat(key string, delta float64, ttl ...time.Duration) (float64, error) {
+	return c.IncrFloatWithOptions(key, delta, counterOptions(ttl))
+}
+
+// IncrWithOptions is Incr with control over the counter's TTL
+func (c *KVCache) IncrWithOptions(key string, delta int64, opts CounterOptions) (int64, error) {
+	var result int64
+	err := c.updateCounter(key, opts, func(old interface{}) (interface{}, error) {
+		if old == nil {
+			result = delta
+			return delta, nil
+		}
+		var value interface{}
+		var err error
+		value, result, err = addInt(old, delta)
+		if errors.Is(err, ErrNotNumeric) {
+			err = &NotNumericError{Key: key, Value: old}
+		}
+		return value, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// IncrFloatWithOptions is IncrFloat with control over the counter's TTL
+func (c *KVCache) IncrFloatWithOptions(key string, delta float64, opts CounterOptions) (float64, error) {
+	if math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return 0, ErrOverflow
+	}
+	var result float64
+	err := c.updateCounter(key, opts, func(old interface{}) (interface{}, error) {
+		if old == nil {
+			result = delta
+			return delta, nil
+		}
+		var value interface{}
+		var err error
+		value, result, err = addFloat(old, delta)
+		if errors.Is(err, ErrNotNumeric) {
+			err = &NotNumericError{Key: key, Value: old}
+		}
+		return value, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+func counterOptions(ttl []time.Duration) CounterOptions {
+	if len(ttl) > 0 {
+		return CounterOptions{TTL: ttl[0]}
+	}
+	return CounterOptions{}
+}
+
+// updateCounter stores add's result for key's current value (nil if
+// missing). With a Store, a key missing from the cache is loaded first and
+// the result is written through or queued like Set.
+func (c *KVCache) updateCounter(key string, opts CounterOptions, add func(old interface{}) (interface{}, error)) error {
+	if c.backend == nil {
+		_, err := c.addLocked(key, opts, add)
+		return err
+	}
+
+	var err error
+	if storeErr := c.update(context.Background(), key, func() (interface{}, bool, bool) {
+		var value interface{}
+		value, err = c.addLocked(key, opts, add)
+		return value, false, err == nil
+	}); storeErr != nil {
+		return storeErr
+	}
+	return err
+}
+
+// addLocked applies add to key under its shard lock and returns the value
+// stored
+func (c *KVCache) addLocked(key string, opts CounterOptions, add func(old interface{}) (interface{}, error)) (interface{}, error) {
+	defer c.shed()
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	defer c.unlock(shard)
+
+	var old interface{}
+	var expiration int64
+	entry := c.liveLocked(shard, key)
+	if entry != nil {
+		old, expiration = c.export(entry.Value), atomic.LoadInt64(&entry.Expiration)
+		if old == nil {
+			return nil, &NotNumericError{Key: key}
+		}
+	}
+	value, err := add(old)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || opts.ResetTTL {
+		expiration = c.expiration(c.now(), []time.Duration{opts.TTL})
+	}
+	if !c.setLocked(shard, key, value, expiration, c.valueCost(value)) {
+		return nil, ErrOverBudget
+	}
+	return value, nil
+}
+
+// addInt adds delta to an integer value of any type, returning the new
+// value in the same type and as an int64
+func addInt(old interface{}, delta int64) (interface{}, int64, error) {
+	var n int64
+	var err error
+	rv := reflect.ValueOf(old)
+	switch v := old.(type) {
+	case string:
+		n, err = strconv.ParseInt(v, 10, 64)
+	case []byte:
+		n, err = strconv.ParseInt(string(v), 10, 64)
+	default:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = rv.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			// Results must also fit the int64 returned
+			if rv.Uint() > math.MaxInt64 {
+				return nil, 0, ErrOverflow
+			}
+			n = int64(rv.Uint())
+		default:
+			return nil, 0, ErrNotNumeric
+		}
+	}
+	if err != nil {
+		return nil, 0, ErrNotNumeric
+	}
+
+	if addOverflows(n, delta) {
+		return nil, 0, ErrOverflow
+	}
+	n += delta
+	switch old.(type) {
+	case string:
+		return strconv.FormatInt(n, 10), n, nil
+	case []byte:
+		return strconv.AppendInt(nil, n, 10), n, nil
+	}
+	result := reflect.New(rv.Type()).Elem()
+	if result.CanInt() {
+		if result.OverflowInt(n) {
+			return nil, 0, ErrOverflow
+		}
+		result.SetInt(n)
+	} else {
+		if n < 0 || result.OverflowUint(uint64(n)) {
+			return nil, 0, ErrOverflow
+		}
+		result.SetUint(uint64(n))
+	}
+	return result.Interface(), n, nil
+}
+
+// addOverflows reports whether n+delta overflows an int64
+func addOverflows(n, delta int64) bool {
+	return (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta)
+}
+
+// addFloat adds delta to a numeric value of any type. Floats, strings and
+// byte slices keep their type; integers become float64, since the result
+// may not be whole.
+func addFloat(old interface{}, delta float64) (interface{}, float64, error) {
+	var n float64
+	var err error
+	rv := reflect.ValueOf(old)
+	switch v := old.(type) {
+	case string:
+		n, err = parseFloatCounter(v)
+	case []byte:
+		n, err = parseFloatCounter(string(v))
+	default:
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			n = rv.Float()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			n = float64(rv.Uint())
+		default:
+			err = ErrNotNumeric
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n += delta
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return nil, 0, ErrOverflow
+	}
+	switch old.(type) {
+	case string:
+		return strconv.FormatFloat(n, 'f', -1, 64), n, nil
+	case []byte:
+		return strconv.AppendFloat(nil, n, 'f', -1, 64), n, nil
+	}
+	if kind := rv.Kind(); kind == reflect.Float32 || kind == reflect.Float64 {
+		if rv.OverflowFloat(n) {
+			return nil, 0, ErrOverflow
+		}
+		result := reflect.New(rv.Type()).Elem()
+		result.SetFloat(n)
+		return result.Interface(), result.Float(), nil
+	}
+	return n, n, nil
+}
+
+// parseFloatCounter parses a decimal string counter, rejecting the
+// infinities and NaN ParseFloat also accepts
+func parseFloatCounter(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, ErrNotNumeric
+	}
+	return f, nil
+}
@@ -0,0 +1,234 @@
+package kvcache
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestIncr tests integer counters
+func TestIncr(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+
+	if n, err := cache.Incr("n", 5); n != 5 || err != nil {
+		t.Errorf("Expected 5 on a miss, got %d, %v", n, err)
+	}
+	if n, err := cache.Decr("n", 7); n != -2 || err != nil {
+		t.Errorf("Expected -2, got %d, %v", n, err)
+	}
+	if v, _ := cache.Get("n"); v != int64(-2) {
+		t.Errorf("Expected int64(-2) stored, got %#v", v)
+	}
+
+	// Existing values keep their type
+	tests := []struct {
+		value interface{}
+		delta int64
+		want  interface{}
+	}{
+		{int(1), 1, int(2)},
+		{int8(126), 1, int8(127)},
+		{uint16(5), -5, uint16(0)},
+		{"41", 1, "42"},
+		{[]byte("-1"), 1, "0"},
+	}
+	for _, tt := range tests {
+		cache.Set("k", tt.value)
+		if _, err := cache.Incr("k", tt.delta); err != nil {
+			t.Errorf("%#v: unexpected error %v", tt.value, err)
+			continue
+		}
+		v, _ := cache.Get("k")
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		if v != tt.want {
+			t.Errorf("%#v + %d: expected %#v, got %#v", tt.value, tt.delta, tt.want, v)
+		}
+	}
+}
+
+// TestIncrErrors tests overflow and non-numeric values
+func TestIncrErrors(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+
+	overflows := []struct {
+		value interface{}
+		delta int64
+	}{
+		{int64(math.MaxInt64), 1},
+		{int64(math.MinInt64), -1},
+		{int8(127), 1},
+		{uint8(0), -1},
+		{uint64(math.MaxUint64), 0},
+		{"9223372036854775807", 1},
+	}
+	for _, tt := range overflows {
+		cache.Set("k", tt.value)
+		if _, err := cache.Incr("k", tt.delta); !errors.Is(err, ErrOverflow) {
+			t.Errorf("%#v + %d: expected ErrOverflow, got %v", tt.value, tt.delta, err)
+		}
+		if v, _ := cache.Get("k"); v != tt.value {
+			t.Errorf("Expected %#v to be unchanged, got %#v", tt.value, v)
+		}
+	}
+	if _, err := cache.Decr("k", math.MinInt64); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected ErrOverflow, got %v", err)
+	}
+
+	for _, value := range []interface{}{"abc", 1.5, struct{}{}, nil} {
+		cache.Set("k", value)
+		_, err := cache.Incr("k", 1)
+		var nerr *NotNumericError
+		if !errors.As(err, &nerr) || !errors.Is(err, ErrNotNumeric) || nerr.Key != "k" {
+			t.Errorf("%#v: expected a *NotNumericError, got %v", value, err)
+		}
+	}
+}
+
+// TestIncrOverBudget tests that an increment whose result is refused for
+// its cost reports it
+func TestIncrOverBudget(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{MaxCost: 5, CleanupInterval: -1})
+	defer cache.Close()
+
+	cache.Set("i", "1234")
+	if n, err := cache.Incr("i", 100000); !errors.Is(err, ErrOverBudget) || n != 0 {
+		t.Errorf("Expected ErrOverBudget, got %d, %v", n, err)
+	}
+	cache.Set("f", "1.5")
+	if f, err := cache.IncrFloat("f", 1000); !errors.Is(err, ErrOverBudget) || f != 0 {
+		t.Errorf("Expected ErrOverBudget, got %v, %v", f, err)
+	}
+	if cache.Contains("i") || cache.Contains("f") {
+		t.Error("Expected the refused counters to be evicted")
+	}
+}
+
+// TestIncrFloat tests float counters
+func TestIncrFloat(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+
+	if f, err := cache.IncrFloat("f", 1.5); f != 1.5 || err != nil {
+		t.Errorf("Expected 1.5 on a miss, got %v, %v", f, err)
+	}
+	if f, _ := cache.IncrFloat("f", 0.25); f != 1.75 {
+		t.Errorf("Expected 1.75, got %v", f)
+	}
+
+	tests := []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{float32(1), float32(1.5)},
+		{int(1), float64(1.5)},
+		{"1", "1.5"},
+	}
+	for _, tt := range tests {
+		cache.Set("k", tt.value)
+		if _, err := cache.IncrFloat("k", 0.5); err != nil {
+			t.Errorf("%#v: unexpected error %v", tt.value, err)
+		}
+		if v, _ := cache.Get("k"); v != tt.want {
+			t.Errorf("%#v: expected %#v, got %#v", tt.value, tt.want, v)
+		}
+	}
+
+	cache.Set("k", math.MaxFloat64)
+	if _, err := cache.IncrFloat("k", math.MaxFloat64); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected ErrOverflow, got %v", err)
+	}
+	cache.Set("k", float32(math.MaxFloat32))
+	if _, err := cache.IncrFloat("k", math.MaxFloat32); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected ErrOverflow for float32, got %v", err)
+	}
+	if _, err := cache.IncrFloat("k", math.NaN()); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected ErrOverflow for NaN, got %v", err)
+	}
+	cache.Set("k", "inf")
+	if _, err := cache.IncrFloat("k", 1); !errors.Is(err, ErrNotNumeric) {
+		t.Errorf("Expected ErrNotNumeric, got %v", err)
+	}
+}
+
+// TestIncrTTL tests the TTL of new and existing counters
+func TestIncrTTL(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewKVCacheWithConfig(Config{DefaultTTL: time.Minute, Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+
+	cache.Incr("default", 1)
+	if _, ttl, _ := cache.GetWithTTL("default"); ttl != time.Minute {
+		t.Errorf("Expected the default TTL, got %v", ttl)
+	}
+
+	cache.Incr("window", 1, time.Second)
+	clock.Advance(600 * time.Millisecond)
+	cache.Incr("window", 1, time.Second)
+	if _, ttl, _ := cache.GetWithTTL("window"); ttl != 400*time.Millisecond {
+		t.Errorf("Expected the TTL to be kept, got %v", ttl)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if n, _ := cache.Incr("window", 1, time.Second); n != 1 {
+		t.Errorf("Expected the expired counter to restart, got %d", n)
+	}
+
+	clock.Advance(600 * time.Millisecond)
+	cache.IncrWithOptions("window", 1, CounterOptions{TTL: time.Second, ResetTTL: true})
+	if _, ttl, _ := cache.GetWithTTL("window"); ttl != time.Second {
+		t.Errorf("Expected ResetTTL to reset the TTL, got %v", ttl)
+	}
+}
+
+// TestIncrConcurrent tests that no increments are lost
+func TestIncrConcurrent(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				cache.Incr("n", 1)
+				cache.IncrFloat("f", 0.5)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := cache.Get("n"); v != int64(8000) {
+		t.Errorf("Expected 8000, got %v", v)
+	}
+	if v, _ := cache.Get("f"); v != 4000.0 {
+		t.Errorf("Expected 4000, got %v", v)
+	}
+}
+
+// TestIncrStore tests counters backed by a Store
+func TestIncrStore(t *testing.T) {
+	store := newMapStore()
+	store.data["n"] = int64(10)
+	cache := NewKVCacheWithStore(Config{CleanupInterval: -1}, store, StoreOptions{})
+	defer cache.Close()
+
+	if n, err := cache.Incr("n", 1); n != 11 || err != nil {
+		t.Errorf("Expected the stored value to be loaded, got %d, %v", n, err)
+	}
+	if v := store.data["n"]; v != int64(11) {
+		t.Errorf("Expected the result to be written through, got %v", v)
+	}
+
+	store.fail = 1
+	if _, err := cache.Incr("n", 1); !errors.Is(err, errStoreDown) {
+		t.Errorf("Expected the store error, got %v", err)
+	}
+	if n, _ := cache.Incr("n", 1); n != 12 {
+		t.Errorf("Expected the failed increment to be dropped, got %d", n)
+	}
+}
@@ -0,0 +1,48 @@
+package kvcache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the context-aware operations
+var (
+	// ErrNotFound is returned when a key is missing or expired
+	ErrNotFound = errors.New("kvcache: key not found")
+
+	// ErrClosed is returned when the cache has been closed
+	ErrClosed = errors.New("kvcache: cache closed")
+
+	// ErrCanceled is returned when the context is canceled or its deadline
+	// passes before the operation completes. The context's own error is
+	// wrapped as well, so errors.Is(err, context.DeadlineExceeded) works.
+	ErrCanceled = errors.New("kvcache: operation canceled")
+)
+
+// canceled wraps a context error as ErrCanceled
+func canceled(ctxErr error) error {
+	return fmt.Errorf("%w: %w", ErrCanceled, ctxErr)
+}
+
+// BatchError reports a batch operation that stopped part-way because its
+// context was canceled. Results for the completed part are still applied
+// (or returned, for reads).
+type BatchError struct {
+	Completed int   // Items processed before stopping (shards for Clear)
+	Total     int   // Items in the whole batch
+	Err       error // Cause, usually wrapping ErrCanceled
+}
+
+// Error implements the error interface
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("kvcache: batch stopped after %d of %d: %v", e.Completed, e.Total, e.Err)
+}
+
+// Unwrap returns the underlying cause
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ErrInvalidSnapshot is returned when loading a snapshot or log that is
+// truncated, fails its checksum, or has an unsupported format version
+var ErrInvalidSnapshot = errors.New("kvcache: invalid snapshot")
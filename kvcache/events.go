@@ -0,0 +1,272 @@
+package kvcache
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType is the kind of change an Event reports
+type EventType int
+
+// Event types
+const (
+	EventSet    EventType = iota // Key written
+	EventDelete                  // Key deleted
+	EventExpire                  // Key expired
+	EventEvict                   // Key evicted
+	EventClear                   // Key removed by Clear
+)
+
+// String returns the event type's name
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	case EventClear:
+		return "clear"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event is a change to one key, delivered by Subscribe
+type Event struct {
+	Type  EventType
+	Key   string
+	Value interface{} // New value for EventSet, removed value otherwise; nil unless EventFilter.Values
+	Time  time.Time   // When the change was made, from Config.Clock
+}
+
+// OverflowPolicy says what happens when a subscriber's buffer is full
+type OverflowPolicy int
+
+// Overflow policies
+const (
+	OverflowDrop       OverflowPolicy = iota // Discard the event (default)
+	OverflowBlock                            // Wait for room, stalling the writer
+	OverflowDisconnect                       // Cancel the subscription, closing its channel
+)
+
+// DefaultEventBuffer is the subscriber buffer used when EventFilter.Buffer
+// is 0
+const DefaultEventBuffer = 256
+
+// EventFilter selects the events a subscriber receives and how they are
+// buffered
+type EventFilter struct {
+	Prefix  string      // Only keys with this prefix
+	Pattern string      // Only keys matching this Redis-style glob, "" for all
+	Types   []EventType // Only these types, all if empty
+	Values  bool        // Include values in events
+
+	Buffer   int            // Channel capacity, 0 for DefaultEventBuffer
+	Overflow OverflowPolicy // What to do when the channel is full
+}
+
+// Subscribe returns a channel of events for changes matching filter, and a
+// function that cancels the subscription and closes the channel. Replacing
+// a value is reported as a single EventSet; Clear reports an EventClear per
+// key. Events for a key arrive in the order the changes were made. With the
+// default OverflowDrop a subscriber that falls behind misses events rather
+// than stalling writers; with OverflowBlock it stalls writers to every key
+// in the shard, so it must not write to the cache itself. Close cancels
+// every subscription.
+func (c *KVCache) Subscribe(filter EventFilter) (<-chan Event, func()) {
+	if filter.Buffer <= 0 {
+		filter.Buffer = DefaultEventBuffer
+	}
+	s := &subscriber{
+		mailbox: newMailbox[Event](filter.Buffer, filter.Overflow),
+		filter:  filter,
+	}
+	for _, t := range filter.Types {
+		s.types |= 1 << t
+	}
+	if !c.events.add(c.Cache, s) {
+		// Closed: the channel is closed at once
+		s.close()
+		return s.ch, func() {}
+	}
+	return s.ch, func() { c.events.remove(c.Cache, s) }
+}
+
+// eventHub fans cache changes out to a KVCache's subscribers
+type eventHub struct {
+	mu     sync.Mutex
+	subs   []*subscriber
+	closed bool // Set by closeAll; no subscribers are added after it
+	// Copy of subs read by publish without locking
+	snapshot atomic.Pointer[[]*subscriber]
+}
+
+// add registers s, watching the cache from the first subscriber on. It
+// reports false if the hub is closed.
+func (h *eventHub) add(c *Cache[string, interface{}], s *subscriber) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.set(append(h.subs[:len(h.subs):len(h.subs)], s))
+	if len(h.subs) == 1 {
+		c.watch(func(ch change[string, interface{}]) { h.publish(c, ch) })
+	}
+	return true
+}
+
+// remove cancels s, and stops watching the cache once no subscribers remain
+func (h *eventHub) remove(c *Cache[string, interface{}], s *subscriber) {
+	h.mu.Lock()
+	subs := make([]*subscriber, 0, len(h.subs))
+	for _, sub := range h.subs {
+		if sub != s {
+			subs = append(subs, sub)
+		}
+	}
+	h.set(subs)
+	if len(subs) == 0 {
+		c.watch(nil)
+	}
+	h.mu.Unlock()
+	s.close()
+}
+
+// closeAll cancels every subscription
+func (h *eventHub) closeAll(c *Cache[string, interface{}]) {
+	h.mu.Lock()
+	subs := h.subs
+	h.closed = true
+	h.set(nil)
+	c.watch(nil)
+	h.mu.Unlock()
+	for _, s := range subs {
+		s.close()
+	}
+}
+
+// set replaces the subscriber list. Must be called with h.mu held.
+func (h *eventHub) set(subs []*subscriber) {
+	h.subs = subs
+	h.snapshot.Store(&subs)
+}
+
+// publish delivers a change to the matching subscribers
+func (h *eventHub) publish(c *Cache[string, interface{}], ch change[string, interface{}]) {
+	if ch.reason == RemovalReplaced && !ch.set {
+		return // Reported by the EventSet that follows
+	}
+	ev := Event{Type: eventType(ch), Key: ch.key, Time: time.Unix(0, ch.at)}
+	subs := h.snapshot.Load()
+	if subs == nil {
+		return
+	}
+	for _, s := range *subs {
+		if !s.matches(ev) {
+			continue
+		}
+		ev := ev
+		if s.filter.Values {
+			ev.Value = ch.value
+		}
+		if !s.send(ev) {
+			h.remove(c, s)
+		}
+	}
+}
+
+// eventType maps a change to its event type
+func eventType(ch change[string, interface{}]) EventType {
+	if ch.set {
+		return EventSet
+	}
+	switch ch.reason {
+	case RemovalExpired:
+		return EventExpire
+	case RemovalEvicted:
+		return EventEvict
+	case RemovalCleared:
+		return EventClear
+	default:
+		return EventDelete
+	}
+}
+
+// subscriber is one Subscribe call
+type subscriber struct {
+	mailbox[Event]
+	filter EventFilter
+	types  uint // Bit per accepted EventType, 0 for all
+}
+
+// matches reports whether ev passes the subscriber's filter
+func (s *subscriber) matches(ev Event) bool {
+	if s.types != 0 && s.types&(1<<ev.Type) == 0 {
+		return false
+	}
+	if !strings.HasPrefix(ev.Key, s.filter.Prefix) {
+		return false
+	}
+	return s.filter.Pattern == "" || matchGlob(s.filter.Pattern, ev.Key)
+}
+
+// mailbox is a bounded channel with an overflow policy, shared by event
+// and pub/sub subscribers
+type mailbox[T any] struct {
+	ch       chan T
+	overflow OverflowPolicy
+
+	// done is closed first so blocked senders give up; then mu is taken
+	// to close ch once no sender is using it
+	done      chan struct{}
+	mu        sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+}
+
+func newMailbox[T any](buffer int, overflow OverflowPolicy) mailbox[T] {
+	return mailbox[T]{ch: make(chan T, buffer), overflow: overflow, done: make(chan struct{})}
+}
+
+// send delivers v according to the overflow policy. It reports false if
+// the receiver should be disconnected.
+func (m *mailbox[T]) send(v T) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return true
+	}
+	switch m.overflow {
+	case OverflowBlock:
+		select {
+		case m.ch <- v:
+		case <-m.done:
+		}
+	default:
+		select {
+		case m.ch <- v:
+		default:
+			return m.overflow != OverflowDisconnect
+		}
+	}
+	return true
+}
+
+// close closes the channel once no send is in progress
+func (m *mailbox[T]) close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.mu.Lock()
+		m.closed = true
+		close(m.ch)
+		m.mu.Unlock()
+	})
+}
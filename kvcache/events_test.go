@@ -0,0 +1,288 @@
+package kvcache
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drain returns the events buffered on ch, formatted as "type key=value"
+func drain(ch <-chan Event) []string {
+	var got []string
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return got
+			}
+			got = append(got, fmt.Sprintf("%v %s=%v", ev.Type, ev.Key, ev.Value))
+		default:
+			return got
+		}
+	}
+}
+
+// TestSubscribe tests the event reported for each kind of change
+func TestSubscribe(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewKVCacheWithConfig(Config{NumShards: 1, MaxCapacity: 2, Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+	events, cancel := cache.Subscribe(EventFilter{Values: true})
+	defer cancel()
+
+	cache.Set("a", 1)
+	cache.Set("a", 2)
+	cache.Delete("a")
+	cache.Set("e", 1, time.Second)
+	clock.Advance(2 * time.Second)
+	cache.Get("e")
+	cache.Set("b", 1)
+	cache.Set("c", 1)
+	cache.Set("d", 1)
+	cache.Clear()
+
+	want := []string{
+		"set a=1", "set a=2", "delete a=2",
+		"set e=1", "expire e=1",
+		"set b=1", "set c=1", "evict b=1", "set d=1",
+		"clear c=1", "clear d=1",
+	}
+	got := drain(events)
+	// Clear walks the shard map, so its order is not fixed
+	if len(got) == len(want) && got[9] == "clear d=1" {
+		got[9], got[10] = got[10], got[9]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestSubscribeRefusedWrite tests that an overwrite refused for exceeding
+// the cost budget reports the old value's eviction, since no EventSet follows
+func TestSubscribeRefusedWrite(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{MaxCost: 10, CleanupInterval: -1})
+	defer cache.Close()
+	events, cancel := cache.Subscribe(EventFilter{Values: true})
+	defer cancel()
+
+	cache.SetWithCost("a", 1, 5)
+	if cache.SetWithCost("a", 2, 20) {
+		t.Fatal("Expected the over-budget write to be refused")
+	}
+	want := []string{"set a=1", "evict a=1"}
+	if got := drain(events); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestSubscribeEventTime tests that events carry the cache clock's time
+func TestSubscribeEventTime(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewKVCacheWithConfig(Config{Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+	events, cancel := cache.Subscribe(EventFilter{})
+	defer cancel()
+
+	cache.Set("a", 1)
+	ev := <-events
+	if !ev.Time.Equal(clock.Now()) {
+		t.Errorf("Expected time %v, got %v", clock.Now(), ev.Time)
+	}
+	if ev.Value != nil {
+		t.Errorf("Expected no value without Values, got %v", ev.Value)
+	}
+}
+
+// TestSubscribeFilter tests filtering by prefix, pattern and type
+func TestSubscribeFilter(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+	byPrefix, cancel1 := cache.Subscribe(EventFilter{Prefix: "user:"})
+	defer cancel1()
+	byPattern, cancel2 := cache.Subscribe(EventFilter{Pattern: "*:[0-9]"})
+	defer cancel2()
+	byType, cancel3 := cache.Subscribe(EventFilter{Types: []EventType{EventDelete}})
+	defer cancel3()
+
+	cache.Set("user:1", 1)
+	cache.Set("order:a", 1)
+	cache.Set("order:2", 1)
+	cache.Delete("order:a")
+
+	if got, want := drain(byPrefix), []string{"set user:1=<nil>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Prefix: expected %v, got %v", want, got)
+	}
+	if got, want := drain(byPattern), []string{"set user:1=<nil>", "set order:2=<nil>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Pattern: expected %v, got %v", want, got)
+	}
+	if got, want := drain(byType), []string{"delete order:a=<nil>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Types: expected %v, got %v", want, got)
+	}
+}
+
+// TestSubscribeOverflow tests each overflow policy with a full buffer
+func TestSubscribeOverflow(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+
+	t.Run("drop", func(t *testing.T) {
+		events, cancel := cache.Subscribe(EventFilter{Prefix: "drop:", Buffer: 2})
+		defer cancel()
+		for i := 0; i < 5; i++ {
+			cache.Set(fmt.Sprintf("drop:%d", i), i)
+		}
+		if got, want := drain(events), []string{"set drop:0=<nil>", "set drop:1=<nil>"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		events, cancel := cache.Subscribe(EventFilter{Prefix: "disc:", Buffer: 1, Overflow: OverflowDisconnect})
+		defer cancel()
+		cache.Set("disc:0", 0)
+		cache.Set("disc:1", 1)
+		<-events
+		if _, ok := <-events; ok {
+			t.Error("Expected the channel to be closed")
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		events, cancel := cache.Subscribe(EventFilter{Prefix: "block:", Buffer: 1, Overflow: OverflowBlock})
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 3; i++ {
+				cache.Set(fmt.Sprintf("block:%d", i), i)
+			}
+		}()
+		for i := 0; i < 3; i++ {
+			if ev := <-events; ev.Key != fmt.Sprintf("block:%d", i) {
+				t.Errorf("Expected block:%d, got %s", i, ev.Key)
+			}
+		}
+		<-done
+	})
+
+	t.Run("cancel unblocks writer", func(t *testing.T) {
+		_, cancel := cache.Subscribe(EventFilter{Prefix: "stuck:", Buffer: 1, Overflow: OverflowBlock})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			cache.Set("stuck:0", 0)
+			cache.Set("stuck:1", 1)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		<-done
+	})
+}
+
+// TestSubscribeCancel tests that cancel and Close close subscriptions
+func TestSubscribeCancel(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	events, cancel := cache.Subscribe(EventFilter{})
+	cancel()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("Expected cancel to close the channel")
+	}
+	cache.Set("a", 1)
+	if cache.watcher.Load() != nil {
+		t.Error("Expected no watcher without subscribers")
+	}
+
+	events, _ = cache.Subscribe(EventFilter{})
+	cache.Close()
+	if _, ok := <-events; ok {
+		t.Error("Expected Close to close the channel")
+	}
+	events, _ = cache.Subscribe(EventFilter{})
+	if _, ok := <-events; ok {
+		t.Error("Expected a closed channel after Close")
+	}
+}
+
+// TestSubscribeOrder tests that concurrent writes to a key are reported in
+// the order they were made
+func TestSubscribeOrder(t *testing.T) {
+	for _, queue := range []int{0, 64} {
+		cache := NewKVCacheWithConfig(Config{NumShards: 1, RemovalQueueSize: queue, CleanupInterval: -1})
+		events, _ := cache.Subscribe(EventFilter{Values: true, Buffer: 1 << 16})
+
+		// Each increment stores a larger value than the one before
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					cache.Incr("k", 1)
+				}
+			}()
+		}
+		wg.Wait()
+		cache.Close()
+
+		var last int64
+		for ev := range events {
+			n := ev.Value.(int64)
+			if n <= last {
+				t.Errorf("Queue %d: event %d arrived after %d", queue, n, last)
+				break
+			}
+			last = n
+		}
+		if last != 8000 {
+			t.Errorf("Queue %d: expected the last event to be 8000, got %d", queue, last)
+		}
+	}
+}
+
+// TestSubscribeCloseRace tests that a Subscribe racing Close still gets
+// its channel closed
+func TestSubscribeCloseRace(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		cache := NewKVCacheWithConfig(Config{NumShards: 1, CleanupInterval: -1})
+		go cache.Close()
+		events, _ := cache.Subscribe(EventFilter{})
+		cache.Close()
+		select {
+		case <-events:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the channel to be closed by Close")
+		}
+	}
+}
+
+// TestSubscribeAsync tests events on a cache with a removal queue
+func TestSubscribeAsync(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{RemovalQueueSize: 4, CleanupInterval: -1})
+	events, cancel := cache.Subscribe(EventFilter{})
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		cache.Set("k", i)
+	}
+	cache.Delete("k")
+	var got []EventType
+	for len(got) < 11 {
+		got = append(got, (<-events).Type)
+	}
+	if got[10] != EventDelete {
+		t.Errorf("Expected the delete last, got %v", got)
+	}
+	cache.Close()
+}
+
+// TestEventTypeString tests event type names
+func TestEventTypeString(t *testing.T) {
+	if s := EventExpire.String(); s != "expire" {
+		t.Errorf("Expected expire, got %s", s)
+	}
+	if s := EventType(42).String(); s != "EventType(42)" {
+		t.Errorf("Expected EventType(42), got %s", s)
+	}
+}
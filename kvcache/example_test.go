@@ -1,6 +1,9 @@
 package kvcache_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -46,6 +49,27 @@ func ExampleKVCache_Get() {
 	// Output: Hello, World!
 }
 
+func ExampleCache_CompareAndSwap() {
+	cache := kvcache.New[string, int](5 * time.Minute)
+	defer cache.Close()
+
+	cache.Set("counter", 41)
+
+	// Retry the read-modify-write until no other writer got in between
+	for {
+		n, version, _ := cache.GetWithVersion("counter")
+		if _, err := cache.CompareAndSwap("counter", version, n+1); err == nil {
+			break
+		} else if !errors.Is(err, kvcache.ErrVersionConflict) {
+			panic(err)
+		}
+	}
+
+	n, _ := cache.Get("counter")
+	fmt.Println(n)
+	// Output: 42
+}
+
 func ExampleKVCache_Stats() {
 	cache := kvcache.NewKVCache(5 * time.Minute)
 	defer cache.Close()
@@ -134,3 +158,72 @@ func ExampleCacheStats_HitRate() {
 	fmt.Printf("Hit rate: %.0f%%\n", stats.HitRate())
 	// Output: Hit rate: 80%
 }
+
+func ExampleNew() {
+	cache := kvcache.New[string, int](5 * time.Minute)
+	defer cache.Close()
+
+	cache.Set("counter", 42)
+	value, exists := cache.Get("counter")
+	if exists {
+		fmt.Println(value + 1)
+	}
+	// Output: 43
+}
+
+func ExampleNewWithConfig() {
+	cache := kvcache.NewWithConfig[string, int](kvcache.Config{
+		DefaultTTL:      10 * time.Minute,
+		MaxCapacity:     1000,
+		NumShards:       16,
+		CleanupInterval: 30 * time.Second,
+	})
+	defer cache.Close()
+
+	for i := 0; i < 5000; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), i)
+	}
+
+	fmt.Printf("Size within limit: %t\n", cache.Size() <= 1000)
+	// Output: Size within limit: true
+}
+
+func ExampleCache_GetWithContext() {
+	cache := kvcache.New[string, string](5 * time.Minute)
+	defer cache.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	cache.Set("key", "value")
+	if value, err := cache.GetWithContext(ctx, "key"); err == nil {
+		fmt.Println(value)
+	}
+	if _, err := cache.GetWithContext(ctx, "missing"); errors.Is(err, kvcache.ErrNotFound) {
+		fmt.Println("not found")
+	}
+	// Output: value
+	// not found
+}
+
+func ExampleCache_SaveSnapshot() {
+	cache := kvcache.New[string, int](time.Hour)
+	defer cache.Close()
+	cache.Set("answer", 42)
+
+	var buf bytes.Buffer
+	if err := cache.SaveSnapshot(&buf); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	restored := kvcache.New[string, int](time.Hour)
+	defer restored.Close()
+	if err := restored.LoadSnapshot(&buf); err != nil {
+		fmt.Println(err)
+		return
+	}
+	value, _ := restored.Get("answer")
+	fmt.Println(value)
+	// Output: 42
+}
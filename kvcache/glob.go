@@ -0,0 +1,101 @@
+package kvcache
+
+// matchGlob reports whether s matches a Redis-style glob pattern: * matches
+// any sequence, ? any single byte, [abc], [a-z] and [^abc] a byte class,
+// and \ escapes the next byte. An unterminated [ is matched literally.
+func matchGlob(pattern, s string) bool {
+	px, sx := 0, 0
+	// Where to resume after the last *, if the match so far fails
+	starPx, starSx := -1, -1
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				starPx, starSx = px, sx
+				px++
+				continue
+			case '?':
+				if sx < len(s) {
+					px++
+					sx++
+					continue
+				}
+			case '[':
+				if sx >= len(s) {
+					break
+				}
+				if matched, width := matchClass(pattern[px:], s[sx]); width > 0 {
+					if matched {
+						px += width
+						sx++
+						continue
+					}
+					break
+				}
+				if s[sx] == '[' {
+					px++
+					sx++
+					continue
+				}
+			case '\\':
+				literal, width := c, 1
+				if px+1 < len(pattern) {
+					literal, width = pattern[px+1], 2
+				}
+				if sx < len(s) && s[sx] == literal {
+					px += width
+					sx++
+					continue
+				}
+			default:
+				if sx < len(s) && s[sx] == c {
+					px++
+					sx++
+					continue
+				}
+			}
+		}
+		// Let the last * absorb one more byte and retry
+		if starPx >= 0 && starSx < len(s) {
+			starSx++
+			px, sx = starPx+1, starSx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// matchClass matches c against the class at the start of p, which begins
+// with [. It returns the class's length in the pattern, or 0 if the class
+// is unterminated. A ] right after [ or [^ is a literal member.
+func matchClass(p string, c byte) (matched bool, width int) {
+	i := 1
+	negate := i < len(p) && p[i] == '^'
+	if negate {
+		i++
+	}
+	for first := true; i < len(p); first = false {
+		if p[i] == ']' && !first {
+			return matched != negate, i + 1
+		}
+		lo := p[i]
+		if lo == '\\' && i+1 < len(p) {
+			i++
+			lo = p[i]
+		}
+		hi := lo
+		if i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']' {
+			hi = p[i+2]
+			i += 2
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+		i++
+	}
+	return false, 0
+}
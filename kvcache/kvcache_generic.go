@@ -0,0 +1,862 @@
+package kvcache
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache is a type-safe generic cache for Go 1.18+
+// It provides high-performance concurrent access with TTL support.
+//
+// Example usage:
+//
+//	cache := kvcache.New[string, int](5 * time.Minute)
+//	cache.Set("counter", 42)
+//	val, ok := cache.Get("counter")
+type Cache[K comparable, V any] struct {
+	shards    []*shard[K, V]
+	numShards int
+	shardMask uint32
+	hash      func(K) uint32
+	stable    bool // hash is the same in every process, see clearLocked
+	ttl       time.Duration
+	grace     int64 // Config.GracePeriod in nanoseconds, see stale.go
+	clock     Clock
+	codec     Codec
+	entryPool sync.Pool
+	newPolicy PolicyFactory[K, V]
+
+	// trackAccess is set when shards are bounded and reads must feed the
+	// eviction policy; unbounded caches skip the access buffer entirely.
+	trackAccess bool
+
+	// Cost budget shared by all shards, see cost.go
+	costOf     func(V) int64 // Nil when costs are not tracked
+	maxCost    int64         // 0 = unlimited
+	cost       atomic.Int64
+	shedCursor atomic.Uint32
+
+	// Last entry version handed out, see version.go
+	versions atomic.Uint64
+
+	// Write-ahead log, nil unless opened with Open
+	wal *writeAheadLog
+
+	// Copy values changed in place (KVCache lists) for snapshots and for
+	// callers, nil if V cannot hold them; see snapshotLocked and export
+	cloneValue  func(V) V
+	exportValue func(V) V
+
+	// Removal listeners and the event watcher, see removal.go
+	listeners     atomic.Pointer[[]func(K, V, RemovalReason)]
+	listenersMu   sync.Mutex
+	watcher       atomic.Pointer[func(change[K, V])]
+	changes       chan change[K, V] // Delivery queue, nil if synchronous
+	changesMu     sync.RWMutex      // Held for reading while sending to changes
+	changesClosed bool
+	changesDone   chan struct{}
+
+	// Shutdown coordination
+	done   chan struct{}
+	wg     sync.WaitGroup
+	closed atomic.Bool
+
+	// Metrics
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	evictions  atomic.Uint64
+	rejections atomic.Uint64
+}
+
+// CacheEntry represents a single key-value pair with expiration.
+// The unexported fields are bookkeeping for the built-in eviction policies.
+type CacheEntry[K comparable, V any] struct {
+	Value      V
+	Expiration int64 // UnixNano timestamp for expiration (use atomic operations)
+
+	key   K                 // Needed to remove the map entry on eviction
+	prev  *CacheEntry[K, V] // Towards most recently used
+	next  *CacheEntry[K, V] // Towards least recently used
+	freq  uint32            // LFU access count
+	index int32             // Slice/heap position, or which list the entry is in
+	tick  uint64            // LFU recency tiebreak
+	cost  int64             // Share of the cost budget
+
+	version   uint64 // Bumped on every write, see version.go
+	refreshAt int64  // When a LoadingCache reloads the value, 0 = never
+}
+
+// Key returns the entry's key
+func (e *CacheEntry[K, V]) Key() K {
+	return e.key
+}
+
+type shard[K comparable, V any] struct {
+	store    map[K]*CacheEntry[K, V]
+	mutex    sync.RWMutex
+	index    int // Position in Cache.shards
+	size     int // Track size to avoid map iterations
+	capacity int // Max entries in this shard, 0 = unlimited
+	policy   EvictionPolicy[K, V]
+
+	// Changes made under mutex, delivered by Cache.unlock holding delivery
+	changes  []change[K, V]
+	delivery sync.Mutex
+
+	// Promotions recorded by readers, applied under mutex
+	accessMu      sync.Mutex
+	accesses      []K
+	spareAccesses []K
+}
+
+// New creates a new generic cache with the specified default TTL
+func New[K comparable, V any](defaultTTL time.Duration) *Cache[K, V] {
+	return NewWithCapacity[K, V](defaultTTL, 0)
+}
+
+// NewWithCapacity creates a generic cache with TTL and max capacity per shard
+func NewWithCapacity[K comparable, V any](defaultTTL time.Duration, maxCapacityPerShard int) *Cache[K, V] {
+	return NewWithConfig[K, V](Config{
+		DefaultTTL:          defaultTTL,
+		MaxCapacityPerShard: maxCapacityPerShard,
+	})
+}
+
+// NewWithConfig creates a generic cache from a Config.
+// It panics if the configuration is invalid; use Config.Validate to check
+// user-supplied settings beforehand.
+func NewWithConfig[K comparable, V any](cfg Config) *Cache[K, V] {
+	return NewWithPolicy[K, V](cfg, nil)
+}
+
+// NewWithPolicy creates a generic cache whose shards use a custom eviction
+// policy built by newPolicy. A nil factory selects cfg.Eviction.
+// It panics if the configuration is invalid.
+func NewWithPolicy[K comparable, V any](cfg Config, newPolicy PolicyFactory[K, V]) *Cache[K, V] {
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+	cfg = cfg.withDefaults()
+
+	cache := newCache(cfg, newPolicy)
+	cache.start(cfg)
+	return cache
+}
+
+// newCache builds a cache from a validated config with defaults applied,
+// without starting any background goroutines
+func newCache[K comparable, V any](cfg Config, newPolicy PolicyFactory[K, V]) *Cache[K, V] {
+	cache := &Cache[K, V]{
+		shards:      make([]*shard[K, V], cfg.NumShards),
+		numShards:   cfg.NumShards,
+		shardMask:   uint32(cfg.NumShards - 1),
+		hash:        newHasher[K](),
+		stable:      stableHash[K](),
+		ttl:         cfg.DefaultTTL,
+		grace:       int64(cfg.GracePeriod),
+		clock:       cfg.Clock,
+		codec:       cfg.Codec,
+		cloneValue:  listMapper[V]((*listValue).clone),
+		exportValue: listMapper[V]((*listValue).elements),
+		newPolicy:   newPolicy,
+		done:        make(chan struct{}),
+		entryPool: sync.Pool{
+			New: func() interface{} {
+				return &CacheEntry[K, V]{}
+			},
+		},
+	}
+	if cache.newPolicy == nil {
+		cache.newPolicy = builtinPolicy(cfg.Eviction, cache)
+	}
+	if cfg.MaxCost > 0 || cfg.Cost != nil {
+		cache.maxCost = cfg.MaxCost
+		cache.costOf = valueCost[V](cfg.Cost)
+		// Shedding over budget consults the policies
+		cache.trackAccess = cfg.MaxCost > 0
+	}
+	for i := range cache.shards {
+		capacity := cfg.shardCapacity(i)
+		cache.shards[i] = &shard[K, V]{
+			store:    make(map[K]*CacheEntry[K, V], cfg.InitialShardSize),
+			index:    i,
+			capacity: capacity,
+			policy:   cache.newPolicy(capacity),
+		}
+		if capacity > 0 {
+			cache.trackAccess = true
+		}
+	}
+	return cache
+}
+
+// start launches the background goroutines
+func (c *Cache[K, V]) start(cfg Config) {
+	if cfg.RemovalQueueSize > 0 {
+		c.changes = make(chan change[K, V], cfg.RemovalQueueSize)
+		c.changesDone = make(chan struct{})
+		go c.deliverChanges()
+	}
+	if cfg.CleanupInterval > 0 {
+		c.wg.Add(1)
+		go c.cleanup(cfg.CleanupInterval)
+	}
+}
+
+// getShard returns the shard for a given key
+func (c *Cache[K, V]) getShard(key K) *shard[K, V] {
+	return c.shards[c.hash(key)&c.shardMask]
+}
+
+// now returns the current time from the configured clock in UnixNano
+func (c *Cache[K, V]) now() int64 {
+	return c.clock.Now().UnixNano()
+}
+
+// Clock returns the clock the cache takes the time from, so code storing
+// timestamps in the cache can agree with its TTLs
+func (c *Cache[K, V]) Clock() Clock {
+	return c.clock
+}
+
+// NoExpiration as a TTL stores an entry that never expires, whatever the
+// default TTL. Any negative TTL does the same; a TTL of 0 means the default.
+const NoExpiration time.Duration = -1
+
+// expiration computes the absolute expiration for an optional custom TTL.
+// It returns 0 (never expires) for NoExpiration, or when neither a TTL nor
+// a default TTL is set.
+func (c *Cache[K, V]) expiration(now int64, ttl []time.Duration) int64 {
+	d := c.ttl
+	if len(ttl) > 0 && ttl[0] != 0 {
+		d = ttl[0]
+	}
+	if d <= 0 {
+		return 0
+	}
+	return now + int64(d)
+}
+
+// releaseEntry clears an entry and returns it to the pool.
+// The key and value are zeroed so pooled entries do not pin user data.
+func (c *Cache[K, V]) releaseEntry(entry *CacheEntry[K, V]) {
+	*entry = CacheEntry[K, V]{}
+	c.entryPool.Put(entry)
+}
+
+// Set adds or updates a key-value pair with optional custom TTL: none or
+// 0 means Config.DefaultTTL, and a negative TTL such as NoExpiration means
+// the entry never expires. With a cost budget configured, the value's cost
+// comes from Config.Cost.
+func (c *Cache[K, V]) Set(key K, value V, ttl ...time.Duration) {
+	c.SetWithCost(key, value, c.valueCost(value), ttl...)
+}
+
+// setLocked stores a value with an absolute expiration and cost, evicting if
+// the shard is full. Overwrites are reported to the policy as an access.
+// It reports false, and removes any previous value for key, if the cost
+// alone exceeds the cache's budget; the caller should then shed.
+// Must be called with shard.mutex held.
+func (c *Cache[K, V]) setLocked(shard *shard[K, V], key K, value V, expiration, cost int64) bool {
+	if !c.fits(cost) {
+		if entry, exists := shard.store[key]; exists {
+			// The write was refused, taking the old value with it
+			reason := c.replacedReason(entry)
+			if reason == RemovalReplaced {
+				reason = RemovalEvicted
+			}
+			c.removeLocked(shard, entry, reason)
+		}
+		return false
+	}
+
+	// Report earlier reads first so the policy sees accesses in order
+	if c.trackAccess {
+		c.drainAccessesLocked(shard)
+	}
+
+	if entry, exists := shard.store[key]; exists {
+		if c.listeners.Load() != nil || c.watcher.Load() != nil {
+			c.recordRemovalLocked(shard, key, entry.Value, c.replacedReason(entry))
+		}
+		c.recordSetLocked(shard, key, value)
+		entry.Value = value
+		atomic.StoreInt64(&entry.Expiration, expiration)
+		c.cost.Add(cost - entry.cost)
+		entry.cost = cost
+		entry.version = c.versions.Add(1)
+		entry.refreshAt = 0
+		shard.policy.OnAccess(entry)
+		if c.wal != nil {
+			c.logSet(shard, key, value, expiration, cost)
+		}
+		return true
+	}
+
+	// Check if we need to evict before adding
+	if shard.capacity > 0 && shard.size >= shard.capacity {
+		c.evictOldest(shard)
+	}
+
+	// Get entry from pool or create new
+	entry := c.entryPool.Get().(*CacheEntry[K, V])
+	entry.key = key
+	entry.Value = value
+	atomic.StoreInt64(&entry.Expiration, expiration)
+	entry.cost = cost
+	entry.version = c.versions.Add(1)
+	c.cost.Add(cost)
+
+	shard.store[key] = entry
+	shard.size++
+	c.recordSetLocked(shard, key, value)
+	shard.policy.OnInsert(entry)
+	if c.wal != nil {
+		c.logSet(shard, key, value, expiration, cost)
+	}
+	return true
+}
+
+// updatedLocked records a change made in place to entry's value, which
+// now costs cost: like an overwrite by setLocked, it bumps the version,
+// reports an access to the policy and logs the write, but reports no
+// removal. It reports false, removing the entry, if the cost alone exceeds
+// the cache's budget. Must be called with shard.mutex held.
+func (c *Cache[K, V]) updatedLocked(shard *shard[K, V], entry *CacheEntry[K, V], cost int64) bool {
+	if !c.changedLocked(shard, entry, cost) {
+		return false
+	}
+	if c.wal != nil {
+		c.logSet(shard, entry.key, entry.Value, atomic.LoadInt64(&entry.Expiration), cost)
+	}
+	return true
+}
+
+// changedLocked is updatedLocked for callers that log the change
+// themselves. Must be called with shard.mutex held.
+func (c *Cache[K, V]) changedLocked(shard *shard[K, V], entry *CacheEntry[K, V], cost int64) bool {
+	if !c.fits(cost) {
+		c.removeLocked(shard, entry, RemovalEvicted)
+		return false
+	}
+	if c.trackAccess {
+		c.drainAccessesLocked(shard)
+	}
+	c.recordSetLocked(shard, entry.key, entry.Value)
+	c.cost.Add(cost - entry.cost)
+	entry.cost = cost
+	entry.version = c.versions.Add(1)
+	shard.policy.OnAccess(entry)
+	return true
+}
+
+// Get retrieves a value by key, returning the zero value if not found or expired
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	value, _, ok := c.get(key)
+	return value, ok
+}
+
+// GetWithTTL retrieves a value by key along with its remaining time to
+// live. A TTL of 0 means the entry never expires.
+func (c *Cache[K, V]) GetWithTTL(key K) (V, time.Duration, bool) {
+	value, meta, ok := c.get(key)
+	return value, c.remaining(meta), ok
+}
+
+// remaining returns the TTL left for an entry read by get, 0 if it never
+// expires or was missing
+func (c *Cache[K, V]) remaining(meta entryMeta) time.Duration {
+	if meta.expiration == 0 {
+		return 0
+	}
+	// Still live, so at least a nanosecond remains
+	return max(time.Duration(meta.expiration-c.now()), 1)
+}
+
+// Contains reports whether key is present, without counting a lookup in
+// the metrics or as a use of the entry by the eviction policy
+func (c *Cache[K, V]) Contains(key K) bool {
+	shard := c.getShard(key)
+	shard.mutex.RLock()
+	_, result := c.readLocked(shard, key)
+	shard.mutex.RUnlock()
+	return result == lookupHit
+}
+
+// entryMeta is the bookkeeping read along with a value by get
+type entryMeta struct {
+	expiration int64
+	version    uint64
+	refreshAt  int64
+}
+
+// metaOf reads an entry's bookkeeping. Must be called with the shard lock
+// held.
+func metaOf[K comparable, V any](entry *CacheEntry[K, V]) entryMeta {
+	return entryMeta{
+		expiration: atomic.LoadInt64(&entry.Expiration),
+		version:    entry.version,
+		refreshAt:  entry.refreshAt,
+	}
+}
+
+// peek is get without counting a lookup or recording an access
+func (c *Cache[K, V]) peek(key K) (value V, meta entryMeta, ok bool) {
+	shard := c.getShard(key)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+	entry, result := c.readLocked(shard, key)
+	if result != lookupHit {
+		return value, meta, false
+	}
+	return c.export(entry.Value), metaOf(entry), true
+}
+
+// get looks up a live value with its bookkeeping, recording the access
+func (c *Cache[K, V]) get(key K) (V, entryMeta, bool) {
+	value, meta, ok := c.lookup(key)
+	return c.export(value), meta, ok
+}
+
+// lookup is get returning the stored value itself, for the list operations
+func (c *Cache[K, V]) lookup(key K) (value V, meta entryMeta, ok bool) {
+	shard := c.getShard(key)
+	shard.mutex.RLock()
+	entry, result := c.readLocked(shard, key)
+	if result == lookupHit {
+		value, meta = entry.Value, metaOf(entry)
+	}
+	shard.mutex.RUnlock()
+
+	switch result {
+	case lookupHit:
+		c.recordAccess(shard, key)
+	case lookupExpired:
+		// Entry expired - need write lock for deletion
+		shard.mutex.Lock()
+		c.removeExpiredLocked(shard, key)
+		c.unlock(shard)
+	}
+	return value, meta, c.recordLookup(result)
+}
+
+// lookupResult is the outcome of reading a key under the shard lock
+type lookupResult int
+
+const (
+	lookupHit lookupResult = iota
+	lookupMiss
+	lookupExpired
+)
+
+// readLocked looks up a live entry, returning nil unless the result is a
+// hit. Must be called with shard.mutex held for reading or writing, and the
+// entry must not be used after it is released; expired entries are reported
+// but not removed, and the caller is responsible for recording the access
+// for LRU.
+func (c *Cache[K, V]) readLocked(shard *shard[K, V], key K) (*CacheEntry[K, V], lookupResult) {
+	entry, exists := shard.store[key]
+	if !exists {
+		return nil, lookupMiss
+	}
+
+	// Fast path: atomic expiration check without the write lock
+	expiration := atomic.LoadInt64(&entry.Expiration)
+	if expiration > 0 && c.now() > expiration {
+		return nil, lookupExpired
+	}
+	return entry, lookupHit
+}
+
+// recordLookup updates hit/miss metrics and reports whether it was a hit
+func (c *Cache[K, V]) recordLookup(result lookupResult) bool {
+	if result == lookupHit {
+		c.hits.Add(1)
+		return true
+	}
+	c.misses.Add(1)
+	return false
+}
+
+// removeExpiredLocked deletes key if it is still expired and past its
+// grace period. The entry is re-fetched because it may have changed while
+// the lock was released. Must be called with shard.mutex held.
+func (c *Cache[K, V]) removeExpiredLocked(shard *shard[K, V], key K) {
+	entry, exists := shard.store[key]
+	if !exists {
+		return
+	}
+	if c.pastGrace(atomic.LoadInt64(&entry.Expiration), c.now()) {
+		c.removeLocked(shard, entry, RemovalExpired)
+	}
+}
+
+// Delete removes a key-value pair
+func (c *Cache[K, V]) Delete(key K) {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	defer c.unlock(shard)
+
+	c.deleteLocked(shard, key)
+}
+
+// deleteLocked removes key and reports whether it was present.
+// Must be called with shard.mutex held.
+func (c *Cache[K, V]) deleteLocked(shard *shard[K, V], key K) bool {
+	entry, exists := shard.store[key]
+	if !exists {
+		return false
+	}
+	c.removeLocked(shard, entry, RemovalDeleted)
+	return true
+}
+
+// removeLocked unlinks an entry from its shard and recycles it.
+// Must be called with shard.mutex held.
+func (c *Cache[K, V]) removeLocked(shard *shard[K, V], entry *CacheEntry[K, V], reason RemovalReason) {
+	c.recordRemovalLocked(shard, entry.key, entry.Value, reason)
+	delete(shard.store, entry.key)
+	shard.size--
+	c.cost.Add(-entry.cost)
+	shard.policy.OnRemove(entry)
+	if c.wal != nil {
+		c.logRemove(shard, entry.key, reason)
+	}
+	c.releaseEntry(entry)
+}
+
+// evictOldest removes the entry chosen by the shard's eviction policy and
+// reports whether there was one. Buffered reads must already be reported so
+// the choice is exact. Must be called with shard.mutex held.
+func (c *Cache[K, V]) evictOldest(s *shard[K, V]) bool {
+	victim := s.policy.Victim()
+	if victim == nil {
+		return false
+	}
+	c.removeLocked(s, victim, RemovalEvicted)
+	c.evictions.Add(1)
+	return true
+}
+
+// Close stops the background goroutines and releases resources. For a
+// cache opened with Open, the log is synced and closed and the first log
+// error, if any, is returned.
+// After calling Close, the cache should not be used.
+// Calling Close more than once is safe.
+func (c *Cache[K, V]) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(c.done)
+	c.wg.Wait()
+	c.closeChanges()
+	if c.wal != nil {
+		return c.wal.close()
+	}
+	return nil
+}
+
+// cleanup periodically removes expired entries with minimal blocking
+func (c *Cache[K, V]) cleanup(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := c.now()
+
+			for _, shard := range c.shards {
+				// Collect expired keys with read lock first
+				shard.mutex.RLock()
+				expiredKeys := make([]K, 0, 16)
+
+				for key, entry := range shard.store {
+					if c.pastGrace(atomic.LoadInt64(&entry.Expiration), now) {
+						expiredKeys = append(expiredKeys, key)
+					}
+				}
+				shard.mutex.RUnlock()
+
+				// Delete expired entries with write lock (if any found)
+				if len(expiredKeys) > 0 {
+					shard.mutex.Lock()
+					for _, key := range expiredKeys {
+						// Double-check expiration after acquiring lock
+						if entry, exists := shard.store[key]; exists {
+							if c.pastGrace(atomic.LoadInt64(&entry.Expiration), now) {
+								c.removeLocked(shard, entry, RemovalExpired)
+							}
+						}
+					}
+					c.unlock(shard)
+				}
+			}
+
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Size returns the number of entries in the cache
+func (c *Cache[K, V]) Size() int {
+	total := 0
+	for _, shard := range c.shards {
+		shard.mutex.RLock()
+		total += shard.size
+		shard.mutex.RUnlock()
+	}
+	return total
+}
+
+// Stats returns cache statistics
+func (c *Cache[K, V]) Stats() CacheStats {
+	return CacheStats{
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+		Evictions:  c.evictions.Load(),
+		Rejections: c.rejections.Load(),
+		Size:       uint64(c.Size()),
+		Cost:       c.cost.Load(),
+		MaxCost:    c.maxCost,
+	}
+}
+
+// SetMulti sets multiple key-value pairs in a single operation
+func (c *Cache[K, V]) SetMulti(entries map[K]V, ttl ...time.Duration) {
+	for key, value := range entries {
+		c.Set(key, value, ttl...)
+	}
+}
+
+// GetMulti retrieves multiple values by keys
+func (c *Cache[K, V]) GetMulti(keys []K) map[K]V {
+	result := make(map[K]V, len(keys))
+	for _, key := range keys {
+		if value, ok := c.Get(key); ok {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// Range calls f for each live entry until f returns false. Each shard's
+// entries are copied under a read lock and f runs after it is released, so
+// f may use the cache. Entries written concurrently may or may not be seen,
+// and reads through Range do not count as accesses.
+func (c *Cache[K, V]) Range(f func(key K, value V) bool) {
+	type pair struct {
+		key   K
+		value V
+	}
+	var pairs []pair
+	for _, shard := range c.shards {
+		now := c.now()
+		shard.mutex.RLock()
+		for key, entry := range shard.store {
+			exp := atomic.LoadInt64(&entry.Expiration)
+			if exp > 0 && now > exp {
+				continue
+			}
+			pairs = append(pairs, pair{key, c.export(entry.Value)})
+		}
+		shard.mutex.RUnlock()
+
+		for i := range pairs {
+			if !f(pairs[i].key, pairs[i].value) {
+				return
+			}
+			pairs[i] = pair{} // Don't pin values
+		}
+		pairs = pairs[:0]
+	}
+}
+
+// Clear removes all entries from the cache
+func (c *Cache[K, V]) Clear() {
+	for _, shard := range c.shards {
+		shard.mutex.Lock()
+		c.clearLocked(shard)
+		c.unlock(shard)
+	}
+}
+
+// clearLocked removes every entry from a shard.
+// Must be called with shard.mutex held.
+func (c *Cache[K, V]) clearLocked(shard *shard[K, V]) {
+	// A clear record names the shard by index, which only selects the
+	// same keys on replay if the hash is stable; otherwise log each key
+	logKeys := c.wal != nil && !c.stable
+	for key, entry := range shard.store {
+		if logKeys {
+			c.logRemove(shard, key, RemovalDeleted)
+		}
+		c.recordRemovalLocked(shard, key, entry.Value, RemovalCleared)
+		delete(shard.store, key)
+		c.cost.Add(-entry.cost)
+		c.releaseEntry(entry)
+	}
+	shard.size = 0
+	shard.policy = c.newPolicy(shard.capacity)
+	if c.wal != nil && c.stable {
+		c.logClear(shard.index)
+	}
+}
+
+// FNV-1a parameters used for string keys
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// newHasher picks a shard hash function for the key type.
+// Strings and integers are hashed without allocation; any other comparable
+// type is walked with reflection, see hashValue.
+func newHasher[K comparable]() func(K) uint32 {
+	return func(key K) uint32 {
+		switch k := any(key).(type) {
+		case string:
+			return fnv32a(k)
+		case int:
+			return mix64(uint64(k))
+		case int8:
+			return mix64(uint64(k))
+		case int16:
+			return mix64(uint64(k))
+		case int32:
+			return mix64(uint64(k))
+		case int64:
+			return mix64(uint64(k))
+		case uint:
+			return mix64(uint64(k))
+		case uint8:
+			return mix64(uint64(k))
+		case uint16:
+			return mix64(uint64(k))
+		case uint32:
+			return mix64(uint64(k))
+		case uint64:
+			return mix64(k)
+		case uintptr:
+			return mix64(uint64(k))
+		case float32:
+			if k == 0 {
+				return mix64(0) // +0 and -0 are equal keys
+			}
+			return mix64(uint64(math.Float32bits(k)))
+		case float64:
+			if k == 0 {
+				return mix64(0)
+			}
+			return mix64(math.Float64bits(k))
+		case bool:
+			if k {
+				return mix64(1)
+			}
+			return mix64(0)
+		default:
+			var h maphash.Hash
+			h.SetSeed(hashSeed)
+			hashValue(&h, reflect.ValueOf(key))
+			return uint32(h.Sum64())
+		}
+	}
+}
+
+// stableHash reports whether newHasher hashes every K without hashSeed
+func stableHash[K comparable]() bool {
+	var zero K
+	switch any(zero).(type) {
+	case string, int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, uintptr, float32, float64, bool:
+		return true
+	}
+	return false
+}
+
+// hashSeed seeds hashValue. It differs between processes, so hashes of
+// walked keys must not be persisted (see clearLocked).
+var hashSeed = maphash.MakeSeed()
+
+// hashValue writes v to h so that values equal under == hash equally:
+// pointers, channels and interfaces by identity and dynamic value, floats
+// with -0 as +0, structs by their non-blank fields
+func hashValue(h *maphash.Hash, v reflect.Value) {
+	var buf [8]byte
+	putUint := func(n uint64) {
+		binary.LittleEndian.PutUint64(buf[:], n)
+		h.Write(buf[:])
+	}
+	putFloat := func(f float64) {
+		if f == 0 {
+			f = 0 // +0 and -0 are equal keys
+		}
+		putUint(math.Float64bits(f))
+	}
+
+	switch v.Kind() {
+	case reflect.Invalid:
+		h.WriteByte(0) // Nil interface
+	case reflect.String:
+		h.WriteString(v.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		putUint(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		putUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		putFloat(v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		putFloat(real(v.Complex()))
+		putFloat(imag(v.Complex()))
+	case reflect.Bool:
+		if v.Bool() {
+			h.WriteByte(1)
+		} else {
+			h.WriteByte(0)
+		}
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		putUint(uint64(v.Pointer()))
+	case reflect.Interface:
+		hashValue(h, v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			hashValue(h, v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).Name != "_" {
+				hashValue(h, v.Field(i))
+			}
+		}
+	}
+}
+
+// fnv32a hashes a string with FNV-1a without allocating
+func fnv32a(s string) uint32 {
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= fnvPrime32
+	}
+	return h
+}
+
+// mix64 folds a 64-bit integer into a well-distributed 32-bit hash
+// (the finalizer from MurmurHash3)
+func mix64(k uint64) uint32 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return uint32(k)
+}
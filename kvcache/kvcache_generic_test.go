@@ -0,0 +1,395 @@
+package kvcache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestGenericBasicOperations tests core functionality of the generic API
+func TestGenericBasicOperations(t *testing.T) {
+	cache := New[string, int](5 * time.Minute)
+	defer cache.Close()
+
+	cache.Set("counter", 42)
+	val, ok := cache.Get("counter")
+	if !ok || val != 42 {
+		t.Errorf("Expected 42, got %v", val)
+	}
+
+	// Missing keys return the zero value
+	val, ok = cache.Get("missing")
+	if ok || val != 0 {
+		t.Errorf("Expected zero value miss, got %v, %v", val, ok)
+	}
+
+	cache.Delete("counter")
+	if _, ok := cache.Get("counter"); ok {
+		t.Error("Key should be deleted")
+	}
+}
+
+// TestGenericKeyTypes tests sharding on non-string comparable keys
+func TestGenericKeyTypes(t *testing.T) {
+	type point struct{ X, Y int }
+	type userID string
+
+	ints := New[int64, string](5 * time.Minute)
+	defer ints.Close()
+	structs := New[point, int](5 * time.Minute)
+	defer structs.Close()
+	named := New[userID, int](5 * time.Minute)
+	defer named.Close()
+	floats := New[float64, int](5 * time.Minute)
+	defer floats.Close()
+
+	for i := 0; i < 1000; i++ {
+		ints.Set(int64(i), fmt.Sprint(i))
+		structs.Set(point{i, -i}, i)
+		named.Set(userID(fmt.Sprint(i)), i)
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := ints.Get(int64(i)); !ok || v != fmt.Sprint(i) {
+			t.Fatalf("int key %d: got %q, %v", i, v, ok)
+		}
+		if v, ok := structs.Get(point{i, -i}); !ok || v != i {
+			t.Fatalf("struct key %d: got %d, %v", i, v, ok)
+		}
+		if v, ok := named.Get(userID(fmt.Sprint(i))); !ok || v != i {
+			t.Fatalf("named key %d: got %d, %v", i, v, ok)
+		}
+	}
+
+	// Equal keys must land on the same shard
+	negZero := 0.0
+	negZero = -negZero
+	floats.Set(0.0, 1)
+	if v, ok := floats.Get(negZero); !ok || v != 1 {
+		t.Error("-0.0 and +0.0 should address the same entry")
+	}
+}
+
+// TestGenericReflectKeys tests keys hashed by walking their value
+func TestGenericReflectKeys(t *testing.T) {
+	// Pointers are keys by address, not by what they point to
+	type node struct{ n int }
+	pointers := New[*node, int](5 * time.Minute)
+	defer pointers.Close()
+	nodes := make([]*node, 100)
+	for i := range nodes {
+		nodes[i] = &node{i}
+		pointers.Set(nodes[i], i)
+	}
+	for i, p := range nodes {
+		p.n += 1000
+		if v, ok := pointers.Get(p); !ok || v != i {
+			t.Fatalf("pointer key %d: got %d, %v after mutating the pointee", i, v, ok)
+		}
+		if _, ok := pointers.Get(&node{p.n}); ok {
+			t.Fatal("A different pointer to an equal value should miss")
+		}
+	}
+
+	// Floats in structs follow == for -0, including unexported fields
+	type coord struct {
+		lat, lon float64
+		_        int
+	}
+	coords := New[coord, int](5 * time.Minute)
+	defer coords.Close()
+	negZero := 0.0
+	negZero = -negZero
+	for i := 0; i < 100; i++ {
+		coords.Set(coord{lat: 0, lon: float64(i)}, i)
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := coords.Get(coord{lat: negZero, lon: float64(i)}); !ok || v != i {
+			t.Fatalf("coord %d: got %d, %v with -0", i, v, ok)
+		}
+	}
+
+	// Interface keys hash their dynamic value
+	anys := New[interface{}, int](5 * time.Minute)
+	defer anys.Close()
+	keys := []interface{}{nil, "s", 1, int64(1), [2]string{"a", "b"}, coord{lon: 1}, nodes[0], struct{ v interface{} }{2.5}}
+	for i, k := range keys {
+		anys.Set(k, i)
+	}
+	for i, k := range keys {
+		if v, ok := anys.Get(k); !ok || v != i {
+			t.Errorf("interface key %#v: got %d, %v", k, v, ok)
+		}
+	}
+}
+
+// TestGenericShardDistribution checks that keys spread across shards
+func TestGenericShardDistribution(t *testing.T) {
+	cache := New[int, int](5 * time.Minute)
+	defer cache.Close()
+
+	used := make(map[*shard[int, int]]bool)
+	for i := 0; i < 10000; i++ {
+		used[cache.getShard(i)] = true
+	}
+	if len(used) < cache.numShards*9/10 {
+		t.Errorf("Expected keys to use most shards, used %d of %d", len(used), cache.numShards)
+	}
+}
+
+// TestGenericExpiration tests TTL functionality
+func TestGenericExpiration(t *testing.T) {
+	cache := New[string, string](5 * time.Minute)
+	defer cache.Close()
+
+	cache.Set("short", "value", 50*time.Millisecond)
+	cache.Set("long", "value")
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, ok := cache.Get("short"); ok {
+		t.Error("Short TTL key should be expired")
+	}
+	if _, ok := cache.Get("long"); !ok {
+		t.Error("Default TTL key should still exist")
+	}
+	if cache.Size() != 1 {
+		t.Errorf("Expected size 1 after lazy expiration, got %d", cache.Size())
+	}
+}
+
+// TestGenericGetWithTTL tests reading the remaining TTL
+func TestGenericGetWithTTL(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, int](Config{Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+
+	cache.Set("ttl", 1, time.Minute)
+	cache.Set("forever", 2)
+	clock.Advance(15 * time.Second)
+
+	if v, ttl, ok := cache.GetWithTTL("ttl"); !ok || v != 1 || ttl != 45*time.Second {
+		t.Errorf("GetWithTTL(ttl) = %d, %v, %v", v, ttl, ok)
+	}
+	if v, ttl, ok := cache.GetWithTTL("forever"); !ok || v != 2 || ttl != 0 {
+		t.Errorf("GetWithTTL(forever) = %d, %v, %v", v, ttl, ok)
+	}
+	clock.Advance(time.Minute)
+	if _, _, ok := cache.GetWithTTL("ttl"); ok {
+		t.Error("Expected expired key to be missing")
+	}
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Expected 2 hits and 1 miss, got %d and %d", stats.Hits, stats.Misses)
+	}
+}
+
+// TestGenericNoExpiration tests NoExpiration and Contains on a cache with
+// a default TTL
+func TestGenericNoExpiration(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, int](Config{DefaultTTL: time.Minute, Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+
+	cache.Set("default", 1)
+	cache.Set("forever", 2, NoExpiration)
+	if _, ttl, _ := cache.GetWithTTL("forever"); ttl != 0 {
+		t.Errorf("Expected no expiration, got TTL %v", ttl)
+	}
+	clock.Advance(2 * time.Minute)
+	if !cache.Contains("forever") || cache.Contains("default") || cache.Contains("missing") {
+		t.Error("Expected only forever to remain")
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 0 {
+		t.Errorf("Expected Contains not to count lookups, got %d hits and %d misses", stats.Hits, stats.Misses)
+	}
+}
+
+// TestGenericTTLArgument tests how the optional TTL of a write combines
+// with the default TTL
+func TestGenericTTLArgument(t *testing.T) {
+	tests := []struct {
+		defaultTTL time.Duration
+		ttl        []time.Duration
+		want       time.Duration // Remaining, 0 for never
+	}{
+		{time.Minute, nil, time.Minute},
+		{time.Minute, []time.Duration{0}, time.Minute},
+		{time.Minute, []time.Duration{time.Second}, time.Second},
+		{time.Minute, []time.Duration{NoExpiration}, 0},
+		{time.Minute, []time.Duration{-time.Hour}, 0},
+		{0, nil, 0},
+		{0, []time.Duration{time.Second}, time.Second},
+		{0, []time.Duration{NoExpiration}, 0},
+	}
+	for _, tt := range tests {
+		cache := NewWithConfig[string, int](Config{DefaultTTL: tt.defaultTTL, Clock: newFakeClock(), CleanupInterval: -1})
+		cache.Set("k", 1, tt.ttl...)
+		if _, remaining, _ := cache.GetWithTTL("k"); remaining != tt.want {
+			t.Errorf("DefaultTTL %v, TTL %v: expected %v remaining, got %v", tt.defaultTTL, tt.ttl, tt.want, remaining)
+		}
+		cache.Close()
+	}
+}
+
+// TestGenericCapacityLimit tests eviction on the generic API
+func TestGenericCapacityLimit(t *testing.T) {
+	cache := NewWithCapacity[int, int](5*time.Minute, 10)
+	defer cache.Close()
+
+	for i := 0; i < 5000; i++ {
+		cache.Set(i, i)
+	}
+
+	stats := cache.Stats()
+	if stats.Evictions == 0 {
+		t.Error("Expected evictions due to capacity limit")
+	}
+	if stats.Size > uint64(10*cache.numShards) {
+		t.Errorf("Size %d exceeds capacity %d", stats.Size, 10*cache.numShards)
+	}
+}
+
+// TestGenericBatchOperations tests SetMulti, GetMulti and Clear
+func TestGenericBatchOperations(t *testing.T) {
+	cache := New[string, []byte](5 * time.Minute)
+	defer cache.Close()
+
+	cache.SetMulti(map[string][]byte{
+		"a": []byte("1"),
+		"b": []byte("2"),
+	})
+
+	results := cache.GetMulti([]string{"a", "b", "c"})
+	if len(results) != 2 || string(results["b"]) != "2" {
+		t.Errorf("Unexpected GetMulti result: %v", results)
+	}
+
+	cache.Clear()
+	if cache.Size() != 0 {
+		t.Errorf("Expected empty cache after Clear, got %d", cache.Size())
+	}
+}
+
+// TestGenericRange tests iterating live entries and stopping early
+func TestGenericRange(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[int, int](Config{NumShards: 4, Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i*10)
+	}
+	cache.Set(1000, 0, time.Second)
+	clock.Advance(2 * time.Second)
+
+	seen := map[int]int{}
+	cache.Range(func(key, value int) bool {
+		seen[key] = value
+		cache.Delete(key) // The shard lock is not held
+		return true
+	})
+	if len(seen) != 100 || seen[42] != 420 {
+		t.Errorf("Expected the 100 live entries, got %d", len(seen))
+	}
+	if stats := cache.Stats(); stats.Hits != 0 {
+		t.Errorf("Range should not count as reads, got %d hits", stats.Hits)
+	}
+
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i)
+	}
+	calls := 0
+	cache.Range(func(key, value int) bool {
+		calls++
+		return calls < 3
+	})
+	if calls != 3 {
+		t.Errorf("Expected Range to stop after 3 calls, got %d", calls)
+	}
+}
+
+// TestGenericConcurrency tests thread safety of the generic API
+func TestGenericConcurrency(t *testing.T) {
+	cache := New[int, int](5 * time.Minute)
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func(n int) {
+			defer wg.Done()
+			cache.Set(n, n*n)
+		}(i)
+		go func(n int) {
+			defer wg.Done()
+			cache.Get(n)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 100; i++ {
+		if v, ok := cache.Get(i); !ok || v != i*i {
+			t.Errorf("Key %d: got %d, %v", i, v, ok)
+		}
+	}
+}
+
+// TestKVCacheWrapsGeneric ensures the legacy API shares the generic implementation
+func TestKVCacheWrapsGeneric(t *testing.T) {
+	legacy := NewKVCache(5 * time.Minute)
+	defer legacy.Close()
+
+	legacy.Set("key", 1)
+	if v, ok := legacy.Cache.Get("key"); !ok || v != 1 {
+		t.Errorf("Expected wrapped cache to hold value, got %v", v)
+	}
+}
+
+// BenchmarkGenericGet measures read performance of the generic API
+func BenchmarkGenericGet(b *testing.B) {
+	cache := New[int, int](5 * time.Minute)
+	defer cache.Close()
+
+	for i := 0; i < 10000; i++ {
+		cache.Set(i, i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Get(i % 10000)
+	}
+}
+
+// BenchmarkGenericConcurrentReads tests parallel read performance
+func BenchmarkGenericConcurrentReads(b *testing.B) {
+	cache := New[int, int](5 * time.Minute)
+	defer cache.Close()
+
+	for i := 0; i < 10000; i++ {
+		cache.Set(i, i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Get(i % 10000)
+			i++
+		}
+	})
+}
+
+// BenchmarkGenericConcurrentWrites tests parallel write performance
+func BenchmarkGenericConcurrentWrites(b *testing.B) {
+	cache := New[int, int](5 * time.Minute)
+	defer cache.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Set(i%10000, i)
+			i++
+		}
+	})
+}
@@ -0,0 +1,531 @@
+package kvcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// A list is a KVCache value holding a sequence, changed in place by the
+// list operations so pushes and pops cost O(1) rather than copying the
+// whole sequence. Operations that change a list hold its key's shard lock;
+// the list's own mutex lets reads copy it without that lock. Snapshots and
+// compaction copy a list while its shard is locked, so they never see an
+// emptied or half-changed list. A list's cost is the sum of its elements'
+// costs; a push taking it over Config.MaxCost drops the list and returns 0.
+// Lists that become empty are deleted, and a list is created by the first
+// push to a missing key, with the default TTL; later operations keep its
+// expiration.
+//
+// Every push, pop and trim that changes a list bumps its version and is
+// published to subscribers as an EventSet carrying the remaining elements,
+// and one that empties it as an EventDelete; an LTrim that removes nothing
+// changes nothing.
+//
+// Get and the other methods returning values, as well as events and
+// removal listeners, see a list as a []interface{} copy of its elements;
+// storing that slice with Set replaces the list with a plain value. Lists
+// are saved by snapshots and the write-ahead log with GobCodec (elements
+// must be registered with gob as usual). The log records each push and pop
+// rather than the whole list, naming the list by a random id and numbering
+// its changes, so replaying the log on top of a snapshot skips those the
+// snapshot already holds. A cache with a Store cannot hold lists.
+
+// ErrWrongType is returned by a list operation on a key holding another
+// kind of value
+var ErrWrongType = errors.New("kvcache: key holds the wrong kind of value")
+
+// ErrUnsupported is returned by LPush and RPush on a cache that cannot hold
+// lists. It matches errors.ErrUnsupported.
+var ErrUnsupported = fmt.Errorf("kvcache: lists are not supported by this cache: %w", errors.ErrUnsupported)
+
+func init() {
+	gob.Register(&listValue{})
+}
+
+// listValue is a list, stored as a ring buffer deque
+type listValue struct {
+	mu    sync.Mutex
+	items []interface{}
+	head  int // Index of the first element in items
+	n     int
+
+	// The write-ahead log's push and pop records name the list by id and
+	// the change they make by seq, see applyWAL
+	id  uint64 // Random, so a list created later under the same key differs
+	seq uint64 // Number of pushes and pops so far
+}
+
+// listState is a list as saved by snapshots and the log
+type listState struct {
+	Items   []interface{}
+	ID, Seq uint64
+}
+
+// at returns the element at logical index i. Must be called with l.mu held.
+func (l *listValue) at(i int) interface{} {
+	if len(l.items) == 0 {
+		return nil
+	}
+	return l.items[(l.head+i)%len(l.items)]
+}
+
+// grow makes room for n more elements. Must be called with l.mu held.
+func (l *listValue) grow(n int) {
+	if l.n+n <= len(l.items) {
+		return
+	}
+	items := make([]interface{}, max(2*len(l.items), l.n+n, 4))
+	for i := 0; i < l.n; i++ {
+		items[i] = l.at(i)
+	}
+	l.items, l.head = items, 0
+}
+
+// push adds v at the front or back. Must be called with l.mu held.
+func (l *listValue) push(front bool, v interface{}) {
+	l.grow(1)
+	if front {
+		l.head = (l.head - 1 + len(l.items)) % len(l.items)
+		l.items[l.head] = v
+	} else {
+		l.items[(l.head+l.n)%len(l.items)] = v
+	}
+	l.n++
+}
+
+// pop removes and returns the front or back element, nil if the list is
+// empty. Must be called with l.mu held.
+func (l *listValue) pop(front bool) interface{} {
+	if l.n == 0 || len(l.items) == 0 {
+		return nil
+	}
+	i := l.head
+	if !front {
+		i = (l.head + l.n - 1) % len(l.items)
+	} else {
+		l.head = (l.head + 1) % len(l.items)
+	}
+	v := l.items[i]
+	l.items[i] = nil
+	l.n--
+	return v
+}
+
+// slice copies the elements from start to stop inclusive, clamped to the
+// list. Must be called with l.mu held.
+func (l *listValue) slice(start, stop int) []interface{} {
+	start, stop = l.bounds(start, stop)
+	if start > stop {
+		return []interface{}{}
+	}
+	out := make([]interface{}, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		out = append(out, l.at(i))
+	}
+	return out
+}
+
+// bounds resolves Redis-style inclusive indexes, where negative ones count
+// from the end, and clamps them to the list. The range is empty if start
+// > stop. Must be called with l.mu held.
+func (l *listValue) bounds(start, stop int) (int, int) {
+	if start < 0 {
+		start = max(l.n+start, 0)
+	}
+	if stop < 0 {
+		stop = l.n + stop
+	}
+	return start, min(stop, l.n-1)
+}
+
+// elements returns a copy of the list's elements
+func (l *listValue) elements() []interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.slice(0, -1)
+}
+
+// clone returns a copy of the list
+func (l *listValue) clone() *listValue {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	items := l.slice(0, -1)
+	return &listValue{items: items, n: len(items), id: l.id, seq: l.seq}
+}
+
+// GobEncode implements gob.GobEncoder for snapshots and the log
+func (l *listValue) GobEncode() ([]byte, error) {
+	l.mu.Lock()
+	state := listState{Items: l.slice(0, -1), ID: l.id, Seq: l.seq}
+	l.mu.Unlock()
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(state)
+	return buf.Bytes(), err
+}
+
+// GobDecode implements gob.GobDecoder
+func (l *listValue) GobDecode(data []byte) error {
+	var state listState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&state); err != nil {
+		return err
+	}
+	if len(state.Items) == 0 {
+		// Empty lists are deleted, so one was never saved
+		return errEmptyList
+	}
+	l.items, l.head, l.n = state.Items, 0, len(state.Items)
+	l.id, l.seq = state.ID, state.Seq
+	return nil
+}
+
+var errEmptyList = errors.New("kvcache: empty list")
+
+// listMapper returns a function that replaces lists with f's result and
+// leaves other values alone, or nil if V cannot hold a list
+func listMapper[V, T any](f func(*listValue) T) func(V) V {
+	var zero V
+	if _, ok := any(&zero).(*interface{}); !ok {
+		return nil
+	}
+	return func(v V) V {
+		if l, ok := any(v).(*listValue); ok {
+			return any(f(l)).(V)
+		}
+		return v
+	}
+}
+
+// LPush inserts values at the head of key's list, creating it if missing,
+// and returns the list's new length. Values are inserted one after the
+// other, so the last one ends up first, as in Redis.
+func (c *KVCache) LPush(key string, values ...interface{}) (int, error) {
+	return c.push(key, true, values)
+}
+
+// RPush appends values to the tail of key's list, creating it if missing,
+// and returns the list's new length
+func (c *KVCache) RPush(key string, values ...interface{}) (int, error) {
+	return c.push(key, false, values)
+}
+
+// LPop removes and returns the first element of key's list. It returns
+// ErrNotFound if the key is missing.
+func (c *KVCache) LPop(key string) (interface{}, error) {
+	return c.pop(key, true)
+}
+
+// RPop removes and returns the last element of key's list. It returns
+// ErrNotFound if the key is missing.
+func (c *KVCache) RPop(key string) (interface{}, error) {
+	return c.pop(key, false)
+}
+
+// BLPop pops the first element of the first non-empty list among keys,
+// waiting for one to be pushed if they are all empty. It returns the key
+// popped from, or ErrCanceled when ctx is done and ErrClosed when the cache
+// is closed. Waiting callers are woken in no particular order.
+func (c *KVCache) BLPop(ctx context.Context, keys ...string) (string, interface{}, error) {
+	return c.blockingPop(ctx, true, keys)
+}
+
+// BRPop is BLPop popping the last element
+func (c *KVCache) BRPop(ctx context.Context, keys ...string) (string, interface{}, error) {
+	return c.blockingPop(ctx, false, keys)
+}
+
+// LLen returns the length of key's list, 0 if the key is missing
+func (c *KVCache) LLen(key string) (int, error) {
+	l, err := c.getList(key)
+	if l == nil {
+		return 0, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.n, nil
+}
+
+// LRange returns the elements of key's list from start to stop inclusive.
+// Negative indexes count from the end, so LRange(key, 0, -1) returns the
+// whole list. Out of range indexes are clamped; a missing key returns an
+// empty slice.
+func (c *KVCache) LRange(key string, start, stop int) ([]interface{}, error) {
+	l, err := c.getList(key)
+	if l == nil {
+		return []interface{}{}, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.slice(start, stop), nil
+}
+
+// LIndex returns the element at index in key's list, counting from the
+// end if negative. It returns ErrNotFound if the key is missing or index
+// is out of range.
+func (c *KVCache) LIndex(key string, index int) (interface{}, error) {
+	l, err := c.getList(key)
+	if l == nil {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if index < 0 {
+		index += l.n
+	}
+	if index < 0 || index >= l.n {
+		return nil, ErrNotFound
+	}
+	return l.at(index), nil
+}
+
+// LTrim keeps only the elements of key's list from start to stop
+// inclusive, with indexes as in LRange. Trimming every element deletes the
+// key.
+func (c *KVCache) LTrim(key string, start, stop int) error {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	defer c.shed()
+	defer c.unlock(shard)
+
+	entry, l, err := c.listLocked(shard, key)
+	if l == nil {
+		return err
+	}
+	cost := entry.cost
+	var front, back int
+	l.mu.Lock()
+	start, stop = l.bounds(start, stop)
+	for ; l.n > 0 && stop < l.n-1; back++ {
+		cost -= c.valueCost(l.pop(false))
+	}
+	for ; l.n > 0 && front < start; front++ {
+		cost -= c.valueCost(l.pop(true))
+	}
+	if front+back > 0 {
+		l.seq++
+	}
+	empty := l.n == 0 || start > stop
+	l.mu.Unlock()
+
+	switch {
+	case empty:
+		c.removeLocked(shard, entry, RemovalDeleted)
+	case front+back == 0:
+		// Nothing was trimmed, so the list is unchanged
+	case c.changedLocked(shard, entry, cost) && c.wal != nil:
+		c.logListPop(shard, key, l, front, back)
+	}
+	return nil
+}
+
+// push implements LPush and RPush
+func (c *KVCache) push(key string, front bool, values []interface{}) (int, error) {
+	if c.backend != nil {
+		return 0, ErrUnsupported
+	}
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	entry, l, err := c.listLocked(shard, key)
+	if err != nil {
+		c.unlock(shard)
+		return 0, err
+	}
+
+	var cost int64
+	if l == nil {
+		l = &listValue{id: rand.Uint64()}
+	} else {
+		cost = entry.cost
+	}
+	if len(values) == 0 {
+		// Nothing to store; report the current length
+		c.unlock(shard)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.n, nil
+	}
+	l.mu.Lock()
+	for _, v := range values {
+		l.push(front, v)
+		cost += c.valueCost(v)
+	}
+	l.seq++
+	n := l.n
+	l.mu.Unlock()
+
+	stored := true
+	if entry == nil {
+		stored = c.setLocked(shard, key, l, c.expiration(c.now(), nil), cost)
+	} else if stored = c.changedLocked(shard, entry, cost); stored && c.wal != nil {
+		c.logListPush(shard, key, l, front, values)
+	}
+	c.unlock(shard)
+	c.shed()
+
+	if !stored {
+		// The list alone is over the cost budget and was dropped
+		return 0, nil
+	}
+	c.lists.wake(key)
+	return n, nil
+}
+
+// pop implements LPop and RPop
+func (c *KVCache) pop(key string, front bool) (interface{}, error) {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	defer c.unlock(shard)
+
+	entry, l, err := c.listLocked(shard, key)
+	if l == nil {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+	l.mu.Lock()
+	v := l.pop(front)
+	l.seq++
+	empty := l.n == 0
+	l.mu.Unlock()
+
+	if empty {
+		c.removeLocked(shard, entry, RemovalDeleted)
+	} else if c.changedLocked(shard, entry, entry.cost-c.valueCost(v)) && c.wal != nil {
+		if front {
+			c.logListPop(shard, key, l, 1, 0)
+		} else {
+			c.logListPop(shard, key, l, 0, 1)
+		}
+	}
+	return v, nil
+}
+
+// blockingPop implements BLPop and BRPop
+func (c *KVCache) blockingPop(ctx context.Context, front bool, keys []string) (string, interface{}, error) {
+	var wake chan struct{}
+	for {
+		if err := c.checkContext(ctx); err != nil {
+			return "", nil, err
+		}
+		for _, key := range keys {
+			v, err := c.pop(key, front)
+			if err == nil {
+				return key, v, nil
+			}
+			if !errors.Is(err, ErrNotFound) {
+				return "", nil, err
+			}
+		}
+
+		if wake == nil {
+			// Register, then look again so a push in between is not missed
+			wake = make(chan struct{}, 1)
+			c.lists.add(keys, wake)
+			defer c.lists.remove(keys, wake)
+			continue
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// listLocked returns key's entry and list, both nil if the key is missing.
+// Must be called with shard.mutex held.
+func (c *KVCache) listLocked(shard *shard[string, interface{}], key string) (*CacheEntry[string, interface{}], *listValue, error) {
+	entry := c.liveLocked(shard, key)
+	if entry == nil {
+		return nil, nil, nil
+	}
+	l, ok := entry.Value.(*listValue)
+	if !ok {
+		return nil, nil, ErrWrongType
+	}
+	return entry, l, nil
+}
+
+// getList returns key's list for reading, nil if the key is missing
+func (c *KVCache) getList(key string) (*listValue, error) {
+	v, _, ok := c.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	l, ok := v.(*listValue)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return l, nil
+}
+
+// listWaiters tracks BLPop and BRPop callers by key
+type listWaiters struct {
+	count   atomic.Int32 // Lets pushes skip the lock when nobody waits
+	mu      sync.Mutex
+	waiting map[string]map[chan struct{}]struct{}
+}
+
+func (w *listWaiters) add(keys []string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.waiting == nil {
+		w.waiting = make(map[string]map[chan struct{}]struct{})
+	}
+	for _, key := range keys {
+		if w.waiting[key] == nil {
+			w.waiting[key] = make(map[chan struct{}]struct{})
+		}
+		w.waiting[key][ch] = struct{}{}
+	}
+	w.count.Add(1)
+}
+
+func (w *listWaiters) remove(keys []string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		delete(w.waiting[key], ch)
+		if len(w.waiting[key]) == 0 {
+			delete(w.waiting, key)
+		}
+	}
+	w.count.Add(-1)
+}
+
+// wake signals the callers waiting for key
+func (w *listWaiters) wake(key string) {
+	if w.count.Load() == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.waiting[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// wakeAll signals every waiting caller, so they notice the cache closing
+func (w *listWaiters) wakeAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, chans := range w.waiting {
+		for ch := range chans {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
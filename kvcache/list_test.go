@@ -0,0 +1,609 @@
+package kvcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestListPushPop tests pushes and pops at both ends
+func TestListPushPop(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+
+	if n, err := cache.RPush("l", "b", "c"); n != 2 || err != nil {
+		t.Errorf("Expected length 2, got %d, %v", n, err)
+	}
+	if n, err := cache.LPush("l", "a", "z"); n != 4 || err != nil {
+		t.Errorf("Expected length 4, got %d, %v", n, err)
+	}
+	want := []interface{}{"z", "a", "b", "c"}
+	if got, _ := cache.LRange("l", 0, -1); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if v, err := cache.LPop("l"); v != "z" || err != nil {
+		t.Errorf("Expected z, got %v, %v", v, err)
+	}
+	if v, err := cache.RPop("l"); v != "c" || err != nil {
+		t.Errorf("Expected c, got %v, %v", v, err)
+	}
+	if n, _ := cache.LLen("l"); n != 2 {
+		t.Errorf("Expected length 2, got %d", n)
+	}
+
+	// Emptied lists are deleted
+	cache.LPop("l")
+	cache.LPop("l")
+	if cache.exists("l") {
+		t.Error("Expected the empty list to be deleted")
+	}
+	if _, err := cache.LPop("l"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if n, err := cache.LPush("l"); n != 0 || err != nil || cache.exists("l") {
+		t.Errorf("Expected an empty push to store nothing, got %d, %v", n, err)
+	}
+}
+
+// TestListRingBuffer tests growth and wraparound of the ring buffer
+func TestListRingBuffer(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+
+	var want []interface{}
+	for i := 0; i < 100; i++ {
+		if i%3 == 0 {
+			cache.LPush("l", i)
+			want = append([]interface{}{i}, want...)
+		} else {
+			cache.RPush("l", i)
+			want = append(want, i)
+		}
+		if i%5 == 0 {
+			cache.LPop("l")
+			want = want[1:]
+		}
+	}
+	if got, _ := cache.LRange("l", 0, -1); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestListRange tests Redis index semantics of LRange, LIndex and LTrim
+func TestListRange(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+	cache.RPush("l", 0, 1, 2, 3, 4)
+
+	ranges := []struct {
+		start, stop int
+		want        []interface{}
+	}{
+		{0, 0, []interface{}{0}},
+		{1, 3, []interface{}{1, 2, 3}},
+		{-2, -1, []interface{}{3, 4}},
+		{-100, 100, []interface{}{0, 1, 2, 3, 4}},
+		{3, 1, []interface{}{}},
+		{5, 10, []interface{}{}},
+	}
+	for _, tt := range ranges {
+		if got, err := cache.LRange("l", tt.start, tt.stop); !reflect.DeepEqual(got, tt.want) || err != nil {
+			t.Errorf("LRange(%d, %d): expected %v, got %v, %v", tt.start, tt.stop, tt.want, got, err)
+		}
+	}
+	if got, err := cache.LRange("missing", 0, -1); len(got) != 0 || err != nil {
+		t.Errorf("Expected an empty range for a missing key, got %v, %v", got, err)
+	}
+
+	if v, err := cache.LIndex("l", -1); v != 4 || err != nil {
+		t.Errorf("Expected 4, got %v, %v", v, err)
+	}
+	if _, err := cache.LIndex("l", 5); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound out of range, got %v", err)
+	}
+
+	if err := cache.LTrim("l", 1, -2); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cache.LRange("l", 0, -1); !reflect.DeepEqual(got, []interface{}{1, 2, 3}) {
+		t.Errorf("Expected [1 2 3] after LTrim, got %v", got)
+	}
+	if err := cache.LTrim("l", 2, 1); err != nil || cache.exists("l") {
+		t.Errorf("Expected an empty trim to delete the list, got %v", err)
+	}
+}
+
+// TestListWrongType tests list operations on other values
+func TestListWrongType(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+	cache.Set("s", "string")
+
+	if _, err := cache.RPush("s", 1); !errors.Is(err, ErrWrongType) {
+		t.Errorf("RPush: expected ErrWrongType, got %v", err)
+	}
+	if _, err := cache.LPop("s"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("LPop: expected ErrWrongType, got %v", err)
+	}
+	if _, err := cache.LRange("s", 0, -1); !errors.Is(err, ErrWrongType) {
+		t.Errorf("LRange: expected ErrWrongType, got %v", err)
+	}
+	if err := cache.LTrim("s", 0, 0); !errors.Is(err, ErrWrongType) {
+		t.Errorf("LTrim: expected ErrWrongType, got %v", err)
+	}
+	if v, _ := cache.Get("s"); v != "string" {
+		t.Errorf("Expected the value untouched, got %v", v)
+	}
+}
+
+// TestListTTL tests that lists get the default TTL and keep their expiration
+func TestListTTL(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewKVCacheWithConfig(Config{DefaultTTL: time.Minute, CleanupInterval: -1, Clock: clock})
+	defer cache.Close()
+
+	cache.RPush("l", 1)
+	clock.Advance(40 * time.Second)
+	cache.RPush("l", 2)
+	clock.Advance(40 * time.Second)
+	if n, _ := cache.LLen("l"); n != 0 {
+		t.Errorf("Expected the list to expire a minute after its creation, got length %d", n)
+	}
+}
+
+// TestListCost tests cost accounting of list elements
+func TestListCost(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{
+		MaxCost:         10,
+		Cost:            func(v interface{}) int64 { return int64(len(v.(string))) },
+		CleanupInterval: -1,
+	})
+	defer cache.Close()
+
+	cache.RPush("l", "ab", "cde")
+	if cost := cache.Stats().Cost; cost != 5 {
+		t.Errorf("Expected cost 5, got %d", cost)
+	}
+	cache.LPop("l")
+	if cost := cache.Stats().Cost; cost != 3 {
+		t.Errorf("Expected cost 3 after LPop, got %d", cost)
+	}
+	cache.RPush("l", "fgh", "ijk")
+	cache.LTrim("l", -1, -1)
+	if cost := cache.Stats().Cost; cost != 3 {
+		t.Errorf("Expected cost 3 after LTrim, got %d", cost)
+	}
+
+	// Growing lists evict to stay within budget
+	cache.RPush("other", "1234567")
+	cache.RPush("l", "lmnop")
+	if cost := cache.Stats().Cost; cost > 10 {
+		t.Errorf("Expected cost within 10, got %d", cost)
+	}
+
+	// A list over the whole budget is dropped
+	cache.Delete("other")
+	cache.Delete("l")
+	cache.RPush("l", "ab", "cd", "ef", "gh")
+	if n, err := cache.RPush("l", "ijk"); n != 0 || err != nil || cache.exists("l") {
+		t.Errorf("Expected the list to be dropped, got %d, %v", n, err)
+	}
+}
+
+// TestListSnapshot tests that lists survive a snapshot
+func TestListSnapshot(t *testing.T) {
+	src := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer src.Close()
+	src.RPush("l", "a", 1, 2.5)
+
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer dst.Close()
+	if err := dst.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := dst.LRange("l", 0, -1); !reflect.DeepEqual(got, []interface{}{"a", 1, 2.5}) {
+		t.Errorf("Expected [a 1 2.5], got %v", got)
+	}
+	dst.RPush("l", "b")
+	if v, _ := dst.LIndex("l", -1); v != "b" {
+		t.Errorf("Expected a loaded list to accept pushes, got %v", v)
+	}
+}
+
+// TestListSnapshotConcurrent tests that a snapshot taken while a list is
+// popped holds the list as it was when its shard was copied
+func TestListSnapshotConcurrent(t *testing.T) {
+	src := NewKVCacheWithConfig(Config{NumShards: 1, CleanupInterval: -1})
+	defer src.Close()
+
+	for i := 0; i < 200; i++ {
+		src.RPush("l", i)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			src.LPop("l")
+		}()
+		var buf bytes.Buffer
+		if err := src.SaveSnapshot(&buf); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+
+		dst := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+		if err := dst.LoadSnapshot(&buf); err != nil {
+			t.Fatalf("Round %d: %v", i, err)
+		}
+		if n, _ := dst.LLen("l"); n > 1 || (n == 0 && dst.exists("l")) {
+			t.Fatalf("Round %d: expected one element or none, got %d", i, n)
+		}
+		dst.Close()
+	}
+}
+
+// TestListEmpty tests that an empty list is never indexed or restored
+func TestListEmpty(t *testing.T) {
+	var l listValue
+	if v := l.pop(true); v != nil {
+		t.Errorf("Expected nil from an empty pop, got %v", v)
+	}
+	if v := l.at(0); v != nil {
+		t.Errorf("Expected nil from an empty list, got %v", v)
+	}
+	data, err := l.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := new(listValue).GobDecode(data); err == nil {
+		t.Error("Expected an empty list to be rejected")
+	}
+}
+
+// TestBLPop tests blocking pops
+func TestBLPop(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+
+	// An element already there is popped at once, from the first non-empty key
+	cache.RPush("b", 1, 2)
+	if key, v, err := cache.BRPop(context.Background(), "a", "b"); key != "b" || v != 2 || err != nil {
+		t.Errorf("Expected b, 2, got %s, %v, %v", key, v, err)
+	}
+
+	// A push wakes a waiter
+	done := make(chan string)
+	go func() {
+		key, v, err := cache.BLPop(context.Background(), "a", "c")
+		done <- fmt.Sprint(key, v, err)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cache.RPush("c", "x")
+	select {
+	case got := <-done:
+		if got != "cx<nil>" {
+			t.Errorf("Expected c, x, got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("BLPop was not woken by the push")
+	}
+
+	// Timeouts return ErrCanceled
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := cache.BLPop(ctx, "a"); !errors.Is(err, ErrCanceled) {
+		t.Errorf("Expected ErrCanceled, got %v", err)
+	}
+}
+
+// TestBLPopConcurrent tests that every pushed element is popped exactly once
+func TestBLPopConcurrent(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+
+	const n = 100
+	var mu sync.Mutex
+	seen := make(map[interface{}]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n/10; j++ {
+				_, v, err := cache.BLPop(context.Background(), "q")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				seen[v]++
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		cache.RPush("q", i)
+	}
+	wg.Wait()
+	if len(seen) != n {
+		t.Errorf("Expected %d distinct elements, got %d", n, len(seen))
+	}
+}
+
+// TestBLPopClose tests that closing the cache releases waiters
+func TestBLPopClose(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+
+	done := make(chan error)
+	go func() {
+		_, _, err := cache.BLPop(context.Background(), "a")
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cache.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("BLPop was not released by Close")
+	}
+}
+
+// exists reports whether key is in the cache
+func (c *KVCache) exists(key string) bool {
+	_, ok := c.Get(key)
+	return ok
+}
+
+// TestListWAL tests that list changes are replayed from the write-ahead
+// log, which records pushes and pops rather than whole lists
+func TestListWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+	cache, err := OpenKVCache(Config{}, WALOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.RPush("l", "a", "b", "c")
+	cache.LPop("l")
+	cache.RPush("gone", 1)
+	cache.RPop("gone")
+	cache.LPush("l", "z")
+	cache.RPush("l", "x", "y")
+	cache.LTrim("l", 1, -2)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{walOpSet, walOpListPop, walOpSet, walOpDelete, walOpListPush, walOpListPush, walOpListPop}
+	if got := walOps(t, path); !bytes.Equal(got, want) {
+		t.Errorf("Expected log operations %v, got %v", want, got)
+	}
+
+	cache, err = OpenKVCache(Config{}, WALOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if got, _ := cache.LRange("l", 0, -1); !reflect.DeepEqual(got, []interface{}{"b", "c", "x"}) {
+		t.Errorf("Expected [b c x], got %v", got)
+	}
+	if cache.exists("gone") {
+		t.Error("Expected the emptied list to stay deleted")
+	}
+}
+
+// walOps returns the operation of each record in the log at path
+func walOps(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []byte
+	for b := data[walHeaderSize:]; len(b) >= walFrameSize; {
+		n := int(binary.BigEndian.Uint32(b))
+		ops = append(ops, b[walFrameSize])
+		b = b[walFrameSize+n:]
+	}
+	return ops
+}
+
+// TestListWALCompaction tests list changes racing a compaction, whose
+// records must not be replayed on top of a copy that already has them
+func TestListWALCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+	opts := WALOptions{Path: path, Sync: WALSyncNever, CompactSize: -1}
+	cache, err := OpenKVCache(Config{NumShards: 8, CleanupInterval: -1}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprint((g + i) % 16)
+				if i%3 == 0 {
+					cache.LPop(key)
+				} else {
+					cache.RPush(key, i)
+				}
+			}
+		}(g)
+	}
+	for i := 0; i < 5; i++ {
+		if err := cache.Compact(); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+	want := make(map[string][]interface{})
+	for i := 0; i < 16; i++ {
+		want[fmt.Sprint(i)], _ = cache.LRange(fmt.Sprint(i), 0, -1)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = OpenKVCache(Config{NumShards: 8, CleanupInterval: -1}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	for key, list := range want {
+		if got, _ := cache.LRange(key, 0, -1); !reflect.DeepEqual(got, list) {
+			t.Fatalf("Key %s: recovered list differs from the list at close", key)
+		}
+	}
+}
+
+// TestListWALCompactionPush tests that a list created and pushed to while
+// a compaction waits to copy its shard is recovered whole
+func TestListWALCompactionPush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+	opts := WALOptions{Path: path, CompactSize: -1}
+	cache, err := OpenKVCache(Config{NumShards: 1, CleanupInterval: -1}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(path + ".compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.wal.startCompaction()
+	cache.RPush("l", "a")
+	cache.RPush("l", "b")
+	if err := cache.writeWALState(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.wal.finishCompaction(f); err != nil {
+		t.Fatal(err)
+	}
+	cache.RPush("l", "c")
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = OpenKVCache(Config{NumShards: 1, CleanupInterval: -1}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if got, _ := cache.LRange("l", 0, -1); !reflect.DeepEqual(got, []interface{}{"a", "b", "c"}) {
+		t.Errorf("Expected [a b c], got %v", got)
+	}
+}
+
+// TestListWALSnapshot tests that replaying the log on top of a snapshot
+// skips the pushes and pops the snapshot already holds
+func TestListWALSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "cache.snap")
+	src := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	src.RPush("l", "a")
+	src.RPush("old", 1)
+	if err := src.SaveSnapshotFile(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	src.Close()
+
+	opts := WALOptions{Path: filepath.Join(dir, "cache.wal"), SnapshotPath: snapshot}
+	cache, err := OpenKVCache(Config{CleanupInterval: -1}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.RPush("l", "b")
+	// Replace "old" with a new list, which the log's pops must not touch
+	cache.RPush("old", 2)
+	cache.LPop("old")
+	cache.LPop("old")
+	cache.RPush("old", "x", "y")
+	if err := cache.SaveSnapshotFile(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = OpenKVCache(Config{CleanupInterval: -1}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if got, _ := cache.LRange("l", 0, -1); !reflect.DeepEqual(got, []interface{}{"a", "b"}) {
+		t.Errorf("Expected [a b], got %v", got)
+	}
+	if got, _ := cache.LRange("old", 0, -1); !reflect.DeepEqual(got, []interface{}{"x", "y"}) {
+		t.Errorf("Expected [x y], got %v", got)
+	}
+}
+
+// TestListEvents tests the events published for list changes
+func TestListEvents(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+	events, cancel := cache.Subscribe(EventFilter{Values: true})
+	defer cancel()
+
+	cache.RPush("l", "a", "b", "c")
+	_, _, version, _ := cache.GetWithVersionAndTTL("l")
+	cache.LTrim("l", 0, -1)
+	if _, _, v, _ := cache.GetWithVersionAndTTL("l"); v != version {
+		t.Errorf("Expected an LTrim removing nothing to keep version %d, got %d", version, v)
+	}
+	cache.LPop("l")
+	cache.LTrim("l", 0, 0)
+	cache.RPop("l")
+
+	want := []string{"set l=[a b c]", "set l=[b c]", "set l=[b]", "delete l=[]"}
+	if got := drain(events); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestListGet tests that reads return a list as a copy of its elements
+func TestListGet(t *testing.T) {
+	cache := NewKVCacheWithConfig(Config{CleanupInterval: -1})
+	defer cache.Close()
+	cache.RPush("l", "a", "b")
+
+	v, ok := cache.Get("l")
+	got, isSlice := v.([]interface{})
+	if !ok || !isSlice || !reflect.DeepEqual(got, []interface{}{"a", "b"}) {
+		t.Fatalf("Expected [a b], got %#v", v)
+	}
+	got[0] = "changed"
+	cache.LPop("l")
+	if !reflect.DeepEqual(got, []interface{}{"changed", "b"}) {
+		t.Errorf("Expected the copy to be unaffected by a pop, got %v", got)
+	}
+	if list, _ := cache.LRange("l", 0, -1); !reflect.DeepEqual(list, []interface{}{"b"}) {
+		t.Errorf("Expected the list to be unaffected by the copy, got %v", list)
+	}
+
+	cache.Range(func(key string, value interface{}) bool {
+		if _, ok := value.([]interface{}); !ok {
+			t.Errorf("Expected Range to see a slice, got %T", value)
+		}
+		return true
+	})
+	if old, _ := cache.GetAndDelete("l"); !reflect.DeepEqual(old, []interface{}{"b"}) {
+		t.Errorf("Expected GetAndDelete to return [b], got %#v", old)
+	}
+}
@@ -0,0 +1,322 @@
+package kvcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Loader loads the value for a key missing from a LoadingCache. A ttl of 0
+// stores the value with the cache's default TTL. Returning ErrNotFound (or
+// an error wrapping it) reports that the key does not exist.
+type Loader[K comparable, V any] func(ctx context.Context, key K) (value V, ttl time.Duration, err error)
+
+// LoadingOptions configures a LoadingCache
+type LoadingOptions struct {
+	// NegativeTTL caches loader errors, including ErrNotFound, for this
+	// long so a missing key or failing backend is not retried on every
+	// Get. 0 disables negative caching.
+	NegativeTTL time.Duration
+
+	// LoadTimeout bounds each loader call, 0 for no limit. Loads are not
+	// canceled when the caller that started them gives up, since other
+	// callers may be waiting on the same load.
+	LoadTimeout time.Duration
+
+	// RefreshAhead reloads a loaded value in the background once less than
+	// this fraction of its TTL remains, so hot keys are replaced before
+	// they expire instead of blocking callers on a load. For example 0.2
+	// refreshes a 1m entry after 48s. Must be in [0, 1); 0 disables.
+	RefreshAhead float64
+
+	// MaxRefreshes bounds concurrent background refreshes, 0 for
+	// DefaultMaxRefreshes. Refreshes beyond the limit are skipped and
+	// retried by a later Get.
+	MaxRefreshes int
+}
+
+// DefaultMaxRefreshes is the refresh concurrency used when
+// LoadingOptions.MaxRefreshes is 0
+const DefaultMaxRefreshes = 16
+
+// LoadingCache is a read-through cache: Get loads missing keys with a
+// Loader and stores the result. Concurrent misses for the same key share a
+// single load. All other Cache methods are available and do not load.
+type LoadingCache[K comparable, V any] struct {
+	*Cache[K, V]
+
+	loader Loader[K, V]
+	opts   LoadingOptions
+
+	// Loader errors kept for NegativeTTL, nil if disabled
+	failures *Cache[K, error]
+
+	mu    sync.Mutex
+	calls map[K]*loadCall[V]
+
+	// Holds a token per running background refresh
+	refreshing chan struct{}
+
+	loads         atomic.Uint64
+	loadErrors    atomic.Uint64
+	loadNanos     atomic.Int64
+	refreshes     atomic.Uint64
+	refreshErrors atomic.Uint64
+}
+
+// loadCall is a load in flight; done is closed once value and err are set
+type loadCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+
+	// Version of the entry being refreshed, 0 for a load after a miss
+	refresh uint64
+}
+
+// NewLoadingCache creates a read-through cache that fills misses with
+// loader. It panics if the configuration or options are invalid or loader
+// is nil.
+func NewLoadingCache[K comparable, V any](cfg Config, loader Loader[K, V], opts LoadingOptions) *LoadingCache[K, V] {
+	if loader == nil {
+		panic("kvcache: nil Loader")
+	}
+	if opts.NegativeTTL < 0 || opts.LoadTimeout < 0 {
+		panic("kvcache: negative LoadingOptions duration")
+	}
+	if opts.RefreshAhead < 0 || opts.RefreshAhead >= 1 {
+		panic("kvcache: LoadingOptions.RefreshAhead must be in [0, 1)")
+	}
+	if opts.MaxRefreshes < 0 {
+		panic("kvcache: negative LoadingOptions.MaxRefreshes")
+	}
+	if opts.MaxRefreshes == 0 {
+		opts.MaxRefreshes = DefaultMaxRefreshes
+	}
+	c := &LoadingCache[K, V]{
+		Cache:      NewWithConfig[K, V](cfg),
+		loader:     loader,
+		opts:       opts,
+		calls:      make(map[K]*loadCall[V]),
+		refreshing: make(chan struct{}, opts.MaxRefreshes),
+	}
+	if opts.NegativeTTL > 0 {
+		c.failures = NewWithConfig[K, error](Config{
+			NumShards:       cfg.NumShards,
+			DefaultTTL:      opts.NegativeTTL,
+			CleanupInterval: cfg.CleanupInterval,
+			Clock:           cfg.Clock,
+		})
+	}
+	return c
+}
+
+// Get returns key's value, loading and storing it on a miss. Callers that
+// miss while a load for the same key is in flight wait for its result
+// instead of loading again. ctx bounds only this caller's wait. A loader
+// error is returned as is (and cached if NegativeTTL is set); nothing is
+// stored for the key. It returns ErrClosed once the cache is closed.
+//
+// With RefreshAhead set, a hit on a loaded value nearing expiry returns
+// the current value and starts a background reload. With
+// Config.GracePeriod set, a stale value is returned the same way while it
+// revalidates, and keeps being served if the loader fails.
+func (c *LoadingCache[K, V]) Get(ctx context.Context, key K) (V, error) {
+	value, _, err := c.GetStale(ctx, key)
+	return value, err
+}
+
+// GetStale is Get, also reporting whether the value returned is stale:
+// expired, within Config.GracePeriod, and being revalidated. While a
+// revalidation failure is cached under NegativeTTL the stale value is
+// served without retrying the loader.
+func (c *LoadingCache[K, V]) GetStale(ctx context.Context, key K) (value V, stale bool, err error) {
+	if err := c.checkContext(ctx); err != nil {
+		return value, false, err
+	}
+	value, meta, stale, ok := c.getStale(key)
+	if ok {
+		switch {
+		case stale:
+			if !c.failing(key) {
+				c.refresh(key, meta.version)
+			}
+		case meta.refreshAt > 0 && c.now() >= meta.refreshAt:
+			c.refresh(key, meta.version)
+		}
+		return value, stale, nil
+	}
+	if c.failures != nil {
+		if err, ok := c.failures.Get(key); ok {
+			return value, false, err
+		}
+	}
+	value, err = c.wait(ctx, c.load(ctx, key))
+	return value, false, err
+}
+
+// failing reports whether a loader error for key is negatively cached
+func (c *LoadingCache[K, V]) failing(key K) bool {
+	if c.failures == nil {
+		return false
+	}
+	_, ok := c.failures.Get(key)
+	return ok
+}
+
+// load returns the in-flight load for key, starting one if there is none
+func (c *LoadingCache[K, V]) load(ctx context.Context, key K) *loadCall[V] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.calls[key]; ok {
+		return call
+	}
+	call := &loadCall[V]{done: make(chan struct{})}
+	c.calls[key] = call
+	go c.run(context.WithoutCancel(ctx), key, call)
+	return call
+}
+
+// refresh starts a background reload of key unless one is already in
+// flight or MaxRefreshes are running. version is the entry's version when
+// the refresh was triggered.
+func (c *LoadingCache[K, V]) refresh(key K, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.calls[key]; ok {
+		return
+	}
+	select {
+	case c.refreshing <- struct{}{}:
+	default:
+		return
+	}
+	c.refreshes.Add(1)
+	call := &loadCall[V]{done: make(chan struct{}), refresh: version}
+	c.calls[key] = call
+	go func() {
+		defer func() { <-c.refreshing }()
+		c.run(context.Background(), key, call)
+	}()
+}
+
+// wait blocks until call finishes or ctx is done
+func (c *LoadingCache[K, V]) wait(ctx context.Context, call *loadCall[V]) (V, error) {
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		var zero V
+		return zero, canceled(ctx.Err())
+	}
+}
+
+// run calls the loader, stores the result and releases the waiters
+func (c *LoadingCache[K, V]) run(ctx context.Context, key K, call *loadCall[V]) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	value, ttl, err := c.callLoader(ctx, key)
+	if err != nil {
+		call.err = err
+		if c.failures != nil {
+			c.failures.Set(key, err)
+		}
+		if call.refresh != 0 {
+			// Keep serving the current value until its grace period ends
+			c.refreshErrors.Add(1)
+			c.stopRefresh(key, call.refresh)
+		}
+		return
+	}
+	call.value = value
+	c.store(key, value, ttl, call.refresh)
+	if c.failures != nil {
+		c.failures.Delete(key)
+	}
+}
+
+// store caches a loaded value, scheduling its refresh if RefreshAhead is
+// set and the value expires. A refreshed value, loaded for the entry
+// version refresh, is dropped if the entry has been written or removed
+// since, as stopRefresh does.
+func (c *LoadingCache[K, V]) store(key K, value V, ttl time.Duration, refresh uint64) {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	if refresh != 0 {
+		if entry, ok := shard.store[key]; !ok || entry.version != refresh {
+			c.unlock(shard)
+			return
+		}
+	}
+	now := c.now()
+	expiration := c.expiration(now, []time.Duration{ttl})
+	stored := c.setLocked(shard, key, value, expiration, c.valueCost(value))
+	if stored && expiration > 0 && c.opts.RefreshAhead > 0 {
+		shard.store[key].refreshAt = expiration - int64(c.opts.RefreshAhead*float64(expiration-now))
+	}
+	c.unlock(shard)
+
+	c.shed()
+}
+
+// stopRefresh clears the refresh time of key's entry after a failed
+// refresh, unless the entry has been written since, so the backend is not
+// retried on every Get until the entry expires
+func (c *LoadingCache[K, V]) stopRefresh(key K, version uint64) {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	if entry, ok := shard.store[key]; ok && entry.version == version {
+		entry.refreshAt = 0
+	}
+	c.unlock(shard)
+}
+
+// callLoader runs the loader with the configured timeout, recording its
+// latency and turning a panic into an error so waiters are not stranded
+func (c *LoadingCache[K, V]) callLoader(ctx context.Context, key K) (value V, ttl time.Duration, err error) {
+	if c.opts.LoadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.LoadTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("kvcache: loader panicked: %v", r)
+		}
+		c.loads.Add(1)
+		c.loadNanos.Add(int64(time.Since(start)))
+		if err != nil {
+			c.loadErrors.Add(1)
+		}
+	}()
+	return c.loader(ctx, key)
+}
+
+// Stats returns cache statistics including loader activity
+func (c *LoadingCache[K, V]) Stats() CacheStats {
+	stats := c.Cache.Stats()
+	stats.Loads = c.loads.Load()
+	stats.LoadErrors = c.loadErrors.Load()
+	stats.LoadTime = time.Duration(c.loadNanos.Load())
+	stats.Refreshes = c.refreshes.Load()
+	stats.RefreshErrors = c.refreshErrors.Load()
+	return stats
+}
+
+// Close stops the cache's background goroutines. Loads already in flight
+// still finish and release their waiters.
+func (c *LoadingCache[K, V]) Close() error {
+	if c.failures != nil {
+		c.failures.Close()
+	}
+	return c.Cache.Close()
+}
@@ -0,0 +1,461 @@
+package kvcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLoadingCacheLoadsOnMiss tests that misses are loaded and stored
+func TestLoadingCacheLoadsOnMiss(t *testing.T) {
+	clock := newFakeClock()
+	var calls atomic.Int32
+	cache := NewLoadingCache[string, int](Config{NumShards: 4, Clock: clock, CleanupInterval: -1},
+		func(ctx context.Context, key string) (int, time.Duration, error) {
+			calls.Add(1)
+			return len(key), time.Minute, nil
+		}, LoadingOptions{})
+	defer cache.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if v, err := cache.Get(ctx, "abc"); err != nil || v != 3 {
+			t.Fatalf("Expected 3, got %d, %v", v, err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected 1 load, got %d", n)
+	}
+	if _, ttl, ok := cache.GetWithTTL("abc"); !ok || ttl != time.Minute {
+		t.Errorf("Expected the loader's TTL, got %v, %v", ttl, ok)
+	}
+
+	// Expired values are loaded again
+	clock.Advance(2 * time.Minute)
+	if _, err := cache.Get(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Expected 2 loads after expiry, got %d", n)
+	}
+
+	stats := cache.Stats()
+	if stats.Loads != 2 || stats.LoadErrors != 0 {
+		t.Errorf("Expected 2 loads and no errors, got %+v", stats)
+	}
+}
+
+// TestLoadingCacheSingleflight tests that concurrent misses share one load
+func TestLoadingCacheSingleflight(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	cache := NewLoadingCache[string, string](Config{NumShards: 4},
+		func(ctx context.Context, key string) (string, time.Duration, error) {
+			calls.Add(1)
+			<-release
+			return "value:" + key, 0, nil
+		}, LoadingOptions{})
+	defer cache.Close()
+
+	const n = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := cache.Get(context.Background(), "k")
+			if err == nil && v != "value:k" {
+				err = errors.New("unexpected value " + v)
+			}
+			errs <- err
+		}()
+	}
+	// Let the callers pile up behind the first load
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected 1 load, got %d", got)
+	}
+}
+
+// TestLoadingCacheErrors tests error propagation and negative caching
+func TestLoadingCacheErrors(t *testing.T) {
+	clock := newFakeClock()
+	var calls atomic.Int32
+	var fail atomic.Bool
+	fail.Store(true)
+	backend := errors.New("backend down")
+	cache := NewLoadingCache[string, int](Config{NumShards: 4, Clock: clock, CleanupInterval: -1},
+		func(ctx context.Context, key string) (int, time.Duration, error) {
+			calls.Add(1)
+			if key == "missing" {
+				return 0, 0, ErrNotFound
+			}
+			if fail.Load() {
+				return 0, 0, backend
+			}
+			return 1, 0, nil
+		}, LoadingOptions{NegativeTTL: time.Second})
+	defer cache.Close()
+
+	ctx := context.Background()
+	if _, err := cache.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := cache.Get(ctx, "k"); !errors.Is(err, backend) {
+		t.Errorf("Expected the loader error, got %v", err)
+	}
+	if cache.Size() != 0 {
+		t.Error("Failed loads should not store a value")
+	}
+
+	// Within NegativeTTL the cached errors are returned without loading
+	fail.Store(false)
+	if _, err := cache.Get(ctx, "k"); !errors.Is(err, backend) {
+		t.Errorf("Expected the cached error, got %v", err)
+	}
+	if _, err := cache.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the cached ErrNotFound, got %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Expected 2 loads, got %d", n)
+	}
+
+	clock.Advance(2 * time.Second)
+	if v, err := cache.Get(ctx, "k"); err != nil || v != 1 {
+		t.Errorf("Expected a fresh load after NegativeTTL, got %d, %v", v, err)
+	}
+
+	stats := cache.Stats()
+	if stats.Loads != 3 || stats.LoadErrors != 2 {
+		t.Errorf("Expected 3 loads with 2 errors, got %+v", stats)
+	}
+}
+
+// TestLoadingCacheContext tests that a caller's context bounds only its wait
+func TestLoadingCacheContext(t *testing.T) {
+	release := make(chan struct{})
+	var loaderCtxErr atomic.Value
+	cache := NewLoadingCache[string, int](Config{NumShards: 4},
+		func(ctx context.Context, key string) (int, time.Duration, error) {
+			<-release
+			if err := ctx.Err(); err != nil {
+				loaderCtxErr.Store(err)
+			}
+			return 7, 0, nil
+		}, LoadingOptions{})
+	defer cache.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.Get(ctx, "k"); !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected ErrCanceled wrapping DeadlineExceeded, got %v", err)
+	}
+
+	// The load continues for other callers
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if v, err := cache.Get(context.Background(), "k"); err != nil || v != 7 {
+			t.Errorf("Expected 7, got %d, %v", v, err)
+		}
+	}()
+	close(release)
+	<-done
+	if err := loaderCtxErr.Load(); err != nil {
+		t.Errorf("Loader context should not be canceled by the caller, got %v", err)
+	}
+}
+
+// TestLoadingCacheTimeout tests LoadTimeout
+func TestLoadingCacheTimeout(t *testing.T) {
+	cache := NewLoadingCache[string, int](Config{NumShards: 4},
+		func(ctx context.Context, key string) (int, time.Duration, error) {
+			<-ctx.Done()
+			return 0, 0, ctx.Err()
+		}, LoadingOptions{LoadTimeout: 10 * time.Millisecond})
+	defer cache.Close()
+
+	if _, err := cache.Get(context.Background(), "k"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+// TestLoadingCachePanic tests that a panicking loader fails the load
+func TestLoadingCachePanic(t *testing.T) {
+	cache := NewLoadingCache[string, int](Config{NumShards: 4},
+		func(ctx context.Context, key string) (int, time.Duration, error) {
+			panic("boom")
+		}, LoadingOptions{})
+	defer cache.Close()
+
+	if _, err := cache.Get(context.Background(), "k"); err == nil {
+		t.Error("Expected an error from a panicking loader")
+	}
+	if stats := cache.Stats(); stats.Loads != 1 || stats.LoadErrors != 1 {
+		t.Errorf("Expected 1 failed load, got %+v", stats)
+	}
+}
+
+// TestLoadingCacheClosed tests Get after Close
+func TestLoadingCacheClosed(t *testing.T) {
+	cache := NewLoadingCache[string, int](Config{NumShards: 4},
+		func(ctx context.Context, key string) (int, time.Duration, error) {
+			return 1, 0, nil
+		}, LoadingOptions{NegativeTTL: time.Second})
+	cache.Close()
+
+	if _, err := cache.Get(context.Background(), "k"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+// TestAverageLoadTime tests the derived load latency
+func TestAverageLoadTime(t *testing.T) {
+	if d := (CacheStats{}).AverageLoadTime(); d != 0 {
+		t.Errorf("Expected 0 with no loads, got %v", d)
+	}
+	if d := (CacheStats{Loads: 4, LoadTime: time.Second}).AverageLoadTime(); d != 250*time.Millisecond {
+		t.Errorf("Expected 250ms, got %v", d)
+	}
+}
+
+// TestLoadingCacheRefreshAhead tests background reloads near expiry
+func TestLoadingCacheRefreshAhead(t *testing.T) {
+	clock := newFakeClock()
+	var version atomic.Int32
+	refreshed := make(chan struct{}, 1)
+	cache := NewLoadingCache[string, int32](Config{NumShards: 4, Clock: clock, CleanupInterval: -1},
+		func(ctx context.Context, key string) (int32, time.Duration, error) {
+			v := version.Add(1)
+			if v > 1 {
+				refreshed <- struct{}{}
+			}
+			return v, 10 * time.Second, nil
+		}, LoadingOptions{RefreshAhead: 0.2})
+	defer cache.Close()
+
+	ctx := context.Background()
+	if v, _ := cache.Get(ctx, "k"); v != 1 {
+		t.Fatalf("Expected 1, got %d", v)
+	}
+
+	// Outside the refresh window nothing is reloaded
+	clock.Advance(7 * time.Second)
+	cache.Get(ctx, "k")
+	if n := cache.Stats().Refreshes; n != 0 {
+		t.Fatalf("Expected no refresh yet, got %d", n)
+	}
+
+	// Inside it the current value is returned and a reload starts
+	clock.Advance(2 * time.Second)
+	if v, err := cache.Get(ctx, "k"); err != nil || v != 1 {
+		t.Fatalf("Expected the current value 1, got %d, %v", v, err)
+	}
+	<-refreshed
+	waitFor(t, func() bool {
+		v, ttl, _ := cache.GetWithTTL("k")
+		return v == 2 && ttl == 10*time.Second
+	})
+
+	stats := cache.Stats()
+	if stats.Refreshes != 1 || stats.Loads != 2 {
+		t.Errorf("Expected 1 refresh and 2 loads, got %+v", stats)
+	}
+}
+
+// TestLoadingCacheRefreshFailure tests that a failed refresh keeps the value
+func TestLoadingCacheRefreshFailure(t *testing.T) {
+	clock := newFakeClock()
+	var calls atomic.Int32
+	cache := NewLoadingCache[string, int](Config{NumShards: 4, Clock: clock, CleanupInterval: -1},
+		func(ctx context.Context, key string) (int, time.Duration, error) {
+			if calls.Add(1) > 1 {
+				return 0, 0, errors.New("backend down")
+			}
+			return 1, 10 * time.Second, nil
+		}, LoadingOptions{RefreshAhead: 0.5})
+	defer cache.Close()
+
+	ctx := context.Background()
+	cache.Get(ctx, "k")
+	clock.Advance(6 * time.Second)
+	cache.Get(ctx, "k")
+	waitFor(t, func() bool { return cache.Stats().RefreshErrors == 1 })
+
+	// The old value is served and the refresh is not retried
+	for i := 0; i < 3; i++ {
+		if v, err := cache.Get(ctx, "k"); err != nil || v != 1 {
+			t.Fatalf("Expected the old value, got %d, %v", v, err)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Expected 2 loader calls, got %d", n)
+	}
+}
+
+// TestLoadingCacheRefreshOverwritten tests that a refresh finishing after
+// the key was written or deleted does not replace the newer state
+func TestLoadingCacheRefreshOverwritten(t *testing.T) {
+	clock := newFakeClock()
+	release := make(chan struct{})
+	var loaded atomic.Bool
+	cache := NewLoadingCache[string, int](Config{NumShards: 4, Clock: clock, CleanupInterval: -1},
+		func(ctx context.Context, key string) (int, time.Duration, error) {
+			if loaded.Load() {
+				<-release
+				return 2, 10 * time.Second, nil
+			}
+			return 1, 10 * time.Second, nil
+		}, LoadingOptions{RefreshAhead: 0.5})
+	defer cache.Close()
+
+	ctx := context.Background()
+	cache.Get(ctx, "set")
+	cache.Get(ctx, "deleted")
+	loaded.Store(true)
+	clock.Advance(6 * time.Second)
+	cache.Get(ctx, "set")
+	cache.Get(ctx, "deleted")
+
+	cache.Set("set", 3)
+	cache.Delete("deleted")
+	close(release)
+	waitFor(t, func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return len(cache.calls) == 0
+	})
+
+	if v, ok := cache.Cache.Get("set"); !ok || v != 3 {
+		t.Errorf("Expected the written value 3, got %d, %v", v, ok)
+	}
+	if v, ok := cache.Cache.Get("deleted"); ok {
+		t.Errorf("Expected the deleted key to stay deleted, got %d", v)
+	}
+}
+
+// TestLoadingCacheMaxRefreshes tests the refresh concurrency bound
+func TestLoadingCacheMaxRefreshes(t *testing.T) {
+	clock := newFakeClock()
+	release := make(chan struct{})
+	var loaded atomic.Bool
+	cache := NewLoadingCache[string, int](Config{NumShards: 4, Clock: clock, CleanupInterval: -1},
+		func(ctx context.Context, key string) (int, time.Duration, error) {
+			if loaded.Load() {
+				<-release
+			}
+			return 1, 10 * time.Second, nil
+		}, LoadingOptions{RefreshAhead: 0.5, MaxRefreshes: 1})
+	defer cache.Close()
+
+	ctx := context.Background()
+	cache.Get(ctx, "a")
+	cache.Get(ctx, "b")
+	loaded.Store(true)
+	clock.Advance(6 * time.Second)
+
+	cache.Get(ctx, "a")
+	cache.Get(ctx, "b") // Skipped while "a" is refreshing
+	if n := cache.Stats().Refreshes; n != 1 {
+		t.Errorf("Expected 1 refresh, got %d", n)
+	}
+	close(release)
+}
+
+// TestNewLoadingCacheInvalid tests option validation
+func TestNewLoadingCacheInvalid(t *testing.T) {
+	loader := func(ctx context.Context, key string) (int, time.Duration, error) { return 0, 0, nil }
+	for _, opts := range []LoadingOptions{
+		{NegativeTTL: -1},
+		{RefreshAhead: 1},
+		{RefreshAhead: -0.1},
+		{MaxRefreshes: -1},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic for %+v", opts)
+				}
+			}()
+			NewLoadingCache[string, int](Config{}, loader, opts).Close()
+		}()
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestLoadingCacheStaleWhileRevalidate tests serving stale values while
+// reloading them, and after the reload fails
+func TestLoadingCacheStaleWhileRevalidate(t *testing.T) {
+	clock := newFakeClock()
+	var calls atomic.Int32
+	var fail atomic.Bool
+	cache := NewLoadingCache[string, int32](Config{NumShards: 4, Clock: clock, GracePeriod: time.Minute, CleanupInterval: -1},
+		func(ctx context.Context, key string) (int32, time.Duration, error) {
+			n := calls.Add(1)
+			if fail.Load() {
+				return 0, 0, errors.New("backend down")
+			}
+			return n, 10 * time.Second, nil
+		}, LoadingOptions{NegativeTTL: 5 * time.Second})
+	defer cache.Close()
+
+	ctx := context.Background()
+	cache.Get(ctx, "k")
+
+	// A stale value is returned at once and revalidated in the background
+	clock.Advance(15 * time.Second)
+	if v, stale, err := cache.GetStale(ctx, "k"); err != nil || !stale || v != 1 {
+		t.Fatalf("Expected a stale 1, got %d, %v, %v", v, stale, err)
+	}
+	waitFor(t, func() bool {
+		v, stale, _ := cache.Cache.GetStale("k")
+		return v == 2 && !stale
+	})
+
+	// If revalidation fails the stale value is still served, and the
+	// failure is not retried within NegativeTTL
+	fail.Store(true)
+	clock.Advance(15 * time.Second)
+	if v, err := cache.Get(ctx, "k"); err != nil || v != 2 {
+		t.Fatalf("Expected the stale 2, got %d, %v", v, err)
+	}
+	waitFor(t, func() bool { return cache.Stats().RefreshErrors == 1 })
+	for i := 0; i < 3; i++ {
+		if v, stale, err := cache.GetStale(ctx, "k"); err != nil || !stale || v != 2 {
+			t.Fatalf("Expected the stale 2, got %d, %v, %v", v, stale, err)
+		}
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("Expected 3 loader calls, got %d", n)
+	}
+
+	// Past the grace period the error is returned
+	clock.Advance(time.Minute)
+	if _, err := cache.Get(ctx, "k"); err == nil {
+		t.Error("Expected the loader error after the grace period")
+	}
+}
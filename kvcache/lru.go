@@ -0,0 +1,109 @@
+package kvcache
+
+// Size of a shard's access buffer. Reads append the key they hit and the
+// buffer is reported to the eviction policy in one batch under the write
+// lock, so a Get only takes the write lock once per accessBufferSize hits.
+const (
+	accessBufferSize = 64
+	maxAccessBuffer  = 16 * accessBufferSize // Readers block to drain past this
+)
+
+// lruList is an intrusive doubly linked list of entries threaded through
+// CacheEntry.prev/next, most recently used at the head. It backs the LRU,
+// FIFO and ARC policies; an entry can be in at most one list at a time.
+// All methods must be called with the owning shard's write lock held.
+type lruList[K comparable, V any] struct {
+	head *CacheEntry[K, V]
+	tail *CacheEntry[K, V]
+	len  int
+}
+
+// pushFront inserts an entry that is not in the list at the head
+func (l *lruList[K, V]) pushFront(e *CacheEntry[K, V]) {
+	e.prev = nil
+	e.next = l.head
+	if l.head != nil {
+		l.head.prev = e
+	} else {
+		l.tail = e
+	}
+	l.head = e
+	l.len++
+}
+
+// remove unlinks an entry from the list
+func (l *lruList[K, V]) remove(e *CacheEntry[K, V]) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		l.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		l.tail = e.prev
+	}
+	e.prev = nil
+	e.next = nil
+	l.len--
+}
+
+// moveToFront marks an entry in the list as most recently used
+func (l *lruList[K, V]) moveToFront(e *CacheEntry[K, V]) {
+	if l.head == e {
+		return
+	}
+	l.remove(e)
+	l.pushFront(e)
+}
+
+// back returns the least recently used entry, or nil if the list is empty
+func (l *lruList[K, V]) back() *CacheEntry[K, V] {
+	return l.tail
+}
+
+// recordAccess queues an access for key after a read hit.
+// Keys rather than entry pointers are buffered because an entry may be
+// removed and recycled through the pool before the buffer is drained.
+// Must be called without the shard lock held.
+func (c *Cache[K, V]) recordAccess(s *shard[K, V], key K) {
+	if !c.trackAccess {
+		return
+	}
+	s.accessMu.Lock()
+	s.accesses = append(s.accesses, key)
+	pending := len(s.accesses)
+	s.accessMu.Unlock()
+
+	switch {
+	case pending >= maxAccessBuffer:
+		// Writers are holding the lock for long stretches; catch up
+		// rather than letting the buffer grow without bound.
+		s.mutex.Lock()
+		c.drainAccessesLocked(s)
+		s.mutex.Unlock()
+	case pending >= accessBufferSize:
+		if s.mutex.TryLock() {
+			c.drainAccessesLocked(s)
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// drainAccessesLocked reports buffered reads to the shard's eviction policy
+// in the order they happened. Must be called with shard.mutex held.
+func (c *Cache[K, V]) drainAccessesLocked(s *shard[K, V]) {
+	s.accessMu.Lock()
+	keys := s.accesses
+	s.accesses = s.spareAccesses[:0]
+	s.accessMu.Unlock()
+
+	for i, key := range keys {
+		if entry, exists := s.store[key]; exists {
+			s.policy.OnAccess(entry)
+		}
+		var zero K
+		keys[i] = zero // Don't pin keys in the spare buffer
+	}
+	s.spareAccesses = keys[:0]
+}
@@ -0,0 +1,302 @@
+package kvcache
+
+import (
+	"container/list"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newSingleShardCache returns a cache whose eviction order is fully observable
+func newSingleShardCache(capacity int) *Cache[int, int] {
+	return NewWithConfig[int, int](Config{
+		NumShards:           1,
+		MaxCapacityPerShard: capacity,
+		CleanupInterval:     -1,
+	})
+}
+
+// lruOf returns the list maintained by a shard's LRU policy
+func lruOf(s *shard[int, int]) *lruList[int, int] {
+	return &s.policy.(*lruPolicy[int, int]).list
+}
+
+// lruOrder returns the keys of a shard from most to least recently used
+func lruOrder(s *shard[int, int]) []int {
+	var keys []int
+	for e := lruOf(s).head; e != nil; e = e.next {
+		keys = append(keys, e.key)
+	}
+	return keys
+}
+
+// TestLRUListOperations tests the intrusive list primitives
+func TestLRUListOperations(t *testing.T) {
+	var l lruList[int, int]
+	entries := make([]*CacheEntry[int, int], 4)
+	for i := range entries {
+		entries[i] = &CacheEntry[int, int]{key: i}
+		l.pushFront(entries[i])
+	}
+
+	check := func(want ...int) {
+		t.Helper()
+		var got []int
+		for e := l.head; e != nil; e = e.next {
+			got = append(got, e.key)
+		}
+		// Walk backwards too so prev pointers are verified
+		var back []int
+		for e := l.tail; e != nil; e = e.prev {
+			back = append([]int{e.key}, back...)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) || fmt.Sprint(back) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, got forward %v backward %v", want, got, back)
+		}
+	}
+
+	check(3, 2, 1, 0)
+	l.moveToFront(entries[1])
+	check(1, 3, 2, 0)
+	l.remove(entries[0])
+	check(1, 3, 2)
+	l.remove(entries[1])
+	check(3, 2)
+	if l.back() != entries[2] || l.len != 2 {
+		t.Errorf("Expected back to be key 2, got %v", l.back().key)
+	}
+	l.remove(entries[3])
+	l.remove(entries[2])
+	check()
+	if l.back() != nil {
+		t.Error("Expected empty list")
+	}
+}
+
+// TestLRUEvictsLeastRecentlyUsed tests that the exact LRU key is evicted
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newSingleShardCache(3)
+	defer cache.Close()
+
+	cache.Set(1, 1)
+	cache.Set(2, 2)
+	cache.Set(3, 3)
+
+	// Touch 1 so 2 becomes the least recently used
+	cache.Get(1)
+	cache.Set(4, 4)
+
+	if _, ok := cache.Get(2); ok {
+		t.Error("Key 2 should have been evicted")
+	}
+	for _, k := range []int{1, 3, 4} {
+		if _, ok := cache.Get(k); !ok {
+			t.Errorf("Key %d should still be cached", k)
+		}
+	}
+
+	// Overwriting counts as use
+	cache.Set(1, 10)
+	cache.Set(5, 5)
+	if _, ok := cache.Get(3); ok {
+		t.Error("Key 3 should have been evicted after key 1 was rewritten")
+	}
+	if cache.Stats().Evictions != 2 {
+		t.Errorf("Expected 2 evictions, got %d", cache.Stats().Evictions)
+	}
+}
+
+// TestLRUHotKeySurvives tests that a frequently read key is never evicted
+func TestLRUHotKeySurvives(t *testing.T) {
+	cache := newSingleShardCache(10)
+	defer cache.Close()
+
+	cache.Set(-1, 0)
+	for i := 0; i < 10000; i++ {
+		cache.Set(i, i)
+		if _, ok := cache.Get(-1); !ok {
+			t.Fatalf("Hot key evicted after %d inserts", i)
+		}
+	}
+}
+
+// TestLRUMatchesReference compares eviction against a reference LRU model
+func TestLRUMatchesReference(t *testing.T) {
+	const capacity = 16
+	cache := newSingleShardCache(capacity)
+	defer cache.Close()
+
+	ref := list.New()
+	index := make(map[int]*list.Element)
+	touch := func(k int) {
+		if e, ok := index[k]; ok {
+			ref.MoveToFront(e)
+			return
+		}
+		index[k] = ref.PushFront(k)
+		if ref.Len() > capacity {
+			oldest := ref.Back()
+			ref.Remove(oldest)
+			delete(index, oldest.Value.(int))
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		k := rng.Intn(40)
+		if rng.Intn(2) == 0 {
+			_, ok := cache.Get(k)
+			if _, want := index[k]; ok != want {
+				t.Fatalf("Step %d: Get(%d) = %v, reference says %v", i, k, ok, want)
+			}
+			if ok {
+				touch(k)
+			}
+		} else {
+			cache.Set(k, k)
+			touch(k)
+		}
+	}
+
+	shard := cache.shards[0]
+	shard.mutex.Lock()
+	cache.drainAccessesLocked(shard)
+	got := lruOrder(shard)
+	shard.mutex.Unlock()
+
+	var want []int
+	for e := ref.Front(); e != nil; e = e.Next() {
+		want = append(want, e.Value.(int))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("LRU order diverged:\n got %v\nwant %v", got, want)
+	}
+}
+
+// TestLRUBufferedPromotions tests that reads are batched, not applied inline
+func TestLRUBufferedPromotions(t *testing.T) {
+	cache := newSingleShardCache(100)
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		cache.Set(i, i)
+	}
+	cache.Get(0)
+
+	shard := cache.shards[0]
+	shard.accessMu.Lock()
+	pending := len(shard.accesses)
+	shard.accessMu.Unlock()
+	if pending != 1 {
+		t.Fatalf("Expected 1 buffered promotion, got %d", pending)
+	}
+
+	// Filling the buffer drains it opportunistically
+	for i := 0; i < accessBufferSize; i++ {
+		cache.Get(i % 10)
+	}
+	shard.accessMu.Lock()
+	pending = len(shard.accesses)
+	shard.accessMu.Unlock()
+	if pending >= accessBufferSize {
+		t.Errorf("Expected buffer to be drained, %d pending", pending)
+	}
+}
+
+// TestLRUUnboundedSkipsTracking tests that unbounded caches skip promotions
+func TestLRUUnboundedSkipsTracking(t *testing.T) {
+	cache := New[int, int](5 * time.Minute)
+	defer cache.Close()
+
+	cache.Set(1, 1)
+	cache.Get(1)
+	shard := cache.getShard(1)
+	shard.accessMu.Lock()
+	defer shard.accessMu.Unlock()
+	if len(shard.accesses) != 0 {
+		t.Error("Unbounded cache should not buffer promotions")
+	}
+}
+
+// TestLRURemovalKeepsListConsistent tests Delete, expiry and Clear unlink entries
+func TestLRURemovalKeepsListConsistent(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[int, int](Config{
+		NumShards:           1,
+		MaxCapacityPerShard: 10,
+		CleanupInterval:     -1,
+		Clock:               clock,
+	})
+	defer cache.Close()
+
+	for i := 0; i < 5; i++ {
+		cache.Set(i, i)
+	}
+	cache.Delete(2)
+	cache.Set(5, 5, time.Second)
+	clock.Advance(2 * time.Second)
+	cache.Get(5) // Lazily expired
+
+	shard := cache.shards[0]
+	if got := fmt.Sprint(lruOrder(shard)); got != "[4 3 1 0]" {
+		t.Errorf("Unexpected LRU order %s", got)
+	}
+
+	cache.Clear()
+	if l := lruOf(shard); l.head != nil || l.tail != nil || l.len != 0 {
+		t.Error("Clear should reset the LRU list")
+	}
+	cache.Set(7, 7)
+	if got := fmt.Sprint(lruOrder(shard)); got != "[7]" {
+		t.Errorf("Unexpected LRU order after Clear %s", got)
+	}
+}
+
+// TestLRUConcurrentAccess tests promotions racing with evictions
+func TestLRUConcurrentAccess(t *testing.T) {
+	cache := NewWithConfig[int, int](Config{
+		NumShards:           4,
+		MaxCapacityPerShard: 8,
+		CleanupInterval:     -1,
+	})
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < 5000; i++ {
+				k := rng.Intn(100)
+				switch rng.Intn(3) {
+				case 0:
+					cache.Set(k, k)
+				case 1:
+					cache.Get(k)
+				default:
+					cache.Delete(k)
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	for _, shard := range cache.shards {
+		shard.mutex.Lock()
+		cache.drainAccessesLocked(shard)
+		n := 0
+		for e := lruOf(shard).head; e != nil; e = e.next {
+			if shard.store[e.key] != e {
+				t.Errorf("List entry %d not in store", e.key)
+			}
+			n++
+		}
+		if n != len(shard.store) || n != shard.size || n > 8 {
+			t.Errorf("List has %d entries, store %d, size %d", n, len(shard.store), shard.size)
+		}
+		shard.mutex.Unlock()
+	}
+}
@@ -0,0 +1,141 @@
+package kvcache
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// EvictionPolicy decides which entry a full shard gives up.
+//
+// Each shard owns its own policy instance and calls it with the shard's
+// write lock held, so implementations need no locking of their own. Every
+// entry passed to OnInsert is later passed to OnRemove exactly once, after
+// which the entry is recycled and must no longer be referenced. Clearing a
+// shard skips OnRemove and replaces the policy with a fresh instance.
+type EvictionPolicy[K comparable, V any] interface {
+	// OnInsert is called after a new entry is added to the shard
+	OnInsert(e *CacheEntry[K, V])
+
+	// OnAccess is called when an entry is read or overwritten. Reads are
+	// buffered and reported in batches, in the order they happened.
+	OnAccess(e *CacheEntry[K, V])
+
+	// OnRemove is called before an entry leaves the shard for any reason
+	OnRemove(e *CacheEntry[K, V])
+
+	// Victim returns the entry to evict, or nil if the policy tracks none.
+	// The shard removes the victim (calling OnRemove) right away.
+	Victim() *CacheEntry[K, V]
+}
+
+// PolicyFactory builds the policy for one shard given its entry capacity
+// (0 means unbounded)
+type PolicyFactory[K comparable, V any] func(capacity int) EvictionPolicy[K, V]
+
+// EvictionKind selects one of the built-in eviction policies
+type EvictionKind int
+
+// Built-in eviction policies
+const (
+	EvictionLRU     EvictionKind = iota // Least recently used (default)
+	EvictionLFU                         // Least frequently used, with periodic aging
+	EvictionFIFO                        // Oldest insertion first
+	EvictionRandom                      // Uniformly random victim
+	EvictionARC                         // Adaptive Replacement Cache
+	EvictionTinyLFU                     // W-TinyLFU: LRU window plus frequency-gated segmented LRU
+)
+
+// String returns the policy name
+func (k EvictionKind) String() string {
+	switch k {
+	case EvictionLRU:
+		return "lru"
+	case EvictionLFU:
+		return "lfu"
+	case EvictionFIFO:
+		return "fifo"
+	case EvictionRandom:
+		return "random"
+	case EvictionARC:
+		return "arc"
+	case EvictionTinyLFU:
+		return "tinylfu"
+	default:
+		return fmt.Sprintf("EvictionKind(%d)", int(k))
+	}
+}
+
+// builtinPolicy returns the factory for a built-in policy of cache c
+func builtinPolicy[K comparable, V any](kind EvictionKind, c *Cache[K, V]) PolicyFactory[K, V] {
+	switch kind {
+	case EvictionLFU:
+		return func(capacity int) EvictionPolicy[K, V] { return newLFUPolicy[K, V](capacity) }
+	case EvictionFIFO:
+		return func(int) EvictionPolicy[K, V] { return &fifoPolicy[K, V]{} }
+	case EvictionRandom:
+		return func(int) EvictionPolicy[K, V] { return newRandomPolicy[K, V]() }
+	case EvictionARC:
+		return func(capacity int) EvictionPolicy[K, V] { return newARCPolicy[K, V](capacity) }
+	case EvictionTinyLFU:
+		return func(capacity int) EvictionPolicy[K, V] {
+			return newTinyLFUPolicy[K, V](capacity, c.hash, &c.rejections)
+		}
+	default:
+		return func(int) EvictionPolicy[K, V] { return &lruPolicy[K, V]{} }
+	}
+}
+
+// lruPolicy evicts the least recently used entry
+type lruPolicy[K comparable, V any] struct {
+	list lruList[K, V]
+}
+
+func (p *lruPolicy[K, V]) OnInsert(e *CacheEntry[K, V]) { p.list.pushFront(e) }
+func (p *lruPolicy[K, V]) OnAccess(e *CacheEntry[K, V]) { p.list.moveToFront(e) }
+func (p *lruPolicy[K, V]) OnRemove(e *CacheEntry[K, V]) { p.list.remove(e) }
+func (p *lruPolicy[K, V]) Victim() *CacheEntry[K, V]    { return p.list.back() }
+
+// fifoPolicy evicts the oldest inserted entry regardless of use
+type fifoPolicy[K comparable, V any] struct {
+	list lruList[K, V]
+}
+
+func (p *fifoPolicy[K, V]) OnInsert(e *CacheEntry[K, V]) { p.list.pushFront(e) }
+func (p *fifoPolicy[K, V]) OnAccess(*CacheEntry[K, V])   {}
+func (p *fifoPolicy[K, V]) OnRemove(e *CacheEntry[K, V]) { p.list.remove(e) }
+func (p *fifoPolicy[K, V]) Victim() *CacheEntry[K, V]    { return p.list.back() }
+
+// randomPolicy evicts a uniformly random entry.
+// Entries are kept in a slice with their position in CacheEntry.index so
+// removal is O(1) by swapping with the last element.
+type randomPolicy[K comparable, V any] struct {
+	entries []*CacheEntry[K, V]
+	rng     *rand.Rand
+}
+
+func newRandomPolicy[K comparable, V any]() *randomPolicy[K, V] {
+	return &randomPolicy[K, V]{rng: rand.New(rand.NewSource(rand.Int63()))}
+}
+
+func (p *randomPolicy[K, V]) OnInsert(e *CacheEntry[K, V]) {
+	e.index = int32(len(p.entries))
+	p.entries = append(p.entries, e)
+}
+
+func (p *randomPolicy[K, V]) OnAccess(*CacheEntry[K, V]) {}
+
+func (p *randomPolicy[K, V]) OnRemove(e *CacheEntry[K, V]) {
+	last := len(p.entries) - 1
+	moved := p.entries[last]
+	p.entries[e.index] = moved
+	moved.index = e.index
+	p.entries[last] = nil
+	p.entries = p.entries[:last]
+}
+
+func (p *randomPolicy[K, V]) Victim() *CacheEntry[K, V] {
+	if len(p.entries) == 0 {
+		return nil
+	}
+	return p.entries[p.rng.Intn(len(p.entries))]
+}
@@ -0,0 +1,142 @@
+package kvcache
+
+import "container/list"
+
+// Lists an ARC-managed entry can live in, stored in CacheEntry.index
+const (
+	arcT1 = iota // Seen once recently
+	arcT2        // Seen at least twice recently
+)
+
+// arcPolicy implements the Adaptive Replacement Cache (Megiddo & Modha).
+//
+// Resident entries are split between T1 (recency) and T2 (frequency). The
+// keys of entries evicted from each side are remembered in the ghost lists
+// B1 and B2; a miss that hits a ghost list shifts the target size p of T1
+// towards whichever side would have kept the key, so the policy adapts
+// between LRU-like and LFU-like behaviour as the workload changes.
+type arcPolicy[K comparable, V any] struct {
+	capacity int
+	p        int // Target size of T1
+	t1, t2   lruList[K, V]
+	b1, b2   ghostList[K]
+}
+
+func newARCPolicy[K comparable, V any](capacity int) *arcPolicy[K, V] {
+	return &arcPolicy[K, V]{
+		capacity: capacity,
+		b1:       newGhostList[K](),
+		b2:       newGhostList[K](),
+	}
+}
+
+func (p *arcPolicy[K, V]) OnInsert(e *CacheEntry[K, V]) {
+	switch {
+	case p.b1.contains(e.key):
+		// Evicted from T1 too early: favour recency
+		p.p = min(p.limit(), p.p+max(1, p.b2.len()/p.b1.len()))
+		p.b1.remove(e.key)
+		e.index = arcT2
+		p.t2.pushFront(e)
+	case p.b2.contains(e.key):
+		// Evicted from T2 too early: favour frequency
+		p.p = max(0, p.p-max(1, p.b1.len()/p.b2.len()))
+		p.b2.remove(e.key)
+		e.index = arcT2
+		p.t2.pushFront(e)
+	default:
+		e.index = arcT1
+		p.t1.pushFront(e)
+	}
+	p.trimGhosts()
+}
+
+func (p *arcPolicy[K, V]) OnAccess(e *CacheEntry[K, V]) {
+	if e.index == arcT1 {
+		p.t1.remove(e)
+		e.index = arcT2
+		p.t2.pushFront(e)
+		return
+	}
+	p.t2.moveToFront(e)
+}
+
+func (p *arcPolicy[K, V]) OnRemove(e *CacheEntry[K, V]) {
+	if e.index == arcT1 {
+		p.t1.remove(e)
+	} else {
+		p.t2.remove(e)
+	}
+	p.trimGhosts()
+}
+
+func (p *arcPolicy[K, V]) Victim() *CacheEntry[K, V] {
+	var victim *CacheEntry[K, V]
+	if p.t1.len > 0 && (p.t1.len > p.p || p.t2.len == 0) {
+		victim = p.t1.back()
+		p.b1.pushFront(victim.key)
+	} else if victim = p.t2.back(); victim != nil {
+		p.b2.pushFront(victim.key)
+	}
+	// Ghosts are trimmed in OnRemove, once the victim has left T1/T2
+	return victim
+}
+
+// limit is the cache size ARC adapts within. Unbounded shards (evicting
+// only under a cost budget) use their current size.
+func (p *arcPolicy[K, V]) limit() int {
+	if p.capacity > 0 {
+		return p.capacity
+	}
+	return p.t1.len + p.t2.len
+}
+
+// trimGhosts keeps |T1|+|B1| <= c and the whole directory within 2c
+func (p *arcPolicy[K, V]) trimGhosts() {
+	c := p.limit()
+	for p.b1.len() > 0 && p.t1.len+p.b1.len() > c {
+		p.b1.removeBack()
+	}
+	for p.b2.len() > 0 && p.t1.len+p.t2.len+p.b1.len()+p.b2.len() > 2*c {
+		p.b2.removeBack()
+	}
+}
+
+// ghostList is an LRU-ordered set of keys whose entries were evicted
+type ghostList[K comparable] struct {
+	order *list.List
+	index map[K]*list.Element
+}
+
+func newGhostList[K comparable]() ghostList[K] {
+	return ghostList[K]{order: list.New(), index: make(map[K]*list.Element)}
+}
+
+func (g *ghostList[K]) len() int { return g.order.Len() }
+
+func (g *ghostList[K]) contains(key K) bool {
+	_, ok := g.index[key]
+	return ok
+}
+
+func (g *ghostList[K]) pushFront(key K) {
+	if el, ok := g.index[key]; ok {
+		g.order.MoveToFront(el)
+		return
+	}
+	g.index[key] = g.order.PushFront(key)
+}
+
+func (g *ghostList[K]) remove(key K) {
+	if el, ok := g.index[key]; ok {
+		g.order.Remove(el)
+		delete(g.index, key)
+	}
+}
+
+func (g *ghostList[K]) removeBack() {
+	if el := g.order.Back(); el != nil {
+		g.order.Remove(el)
+		delete(g.index, el.Value.(K))
+	}
+}
@@ -0,0 +1,133 @@
+package kvcache
+
+import "math"
+
+// Minimum number of operations between LFU aging passes
+const minLFUAgingPeriod = 1024
+
+// lfuPolicy evicts the least frequently used entry, breaking ties in favour
+// of evicting the least recently used. Entries live in a min-heap ordered by
+// (freq, tick) with their heap position in CacheEntry.index.
+//
+// Counts are halved every agingPeriod operations so keys that were hot long
+// ago decay and can be evicted; without aging a burst of early traffic would
+// pin entries forever.
+type lfuPolicy[K comparable, V any] struct {
+	heap        []*CacheEntry[K, V]
+	tick        uint64 // Logical clock for recency tiebreaks
+	ops         int
+	agingPeriod int
+}
+
+func newLFUPolicy[K comparable, V any](capacity int) *lfuPolicy[K, V] {
+	period := 10 * capacity
+	if period < minLFUAgingPeriod {
+		period = minLFUAgingPeriod
+	}
+	return &lfuPolicy[K, V]{agingPeriod: period}
+}
+
+func (p *lfuPolicy[K, V]) OnInsert(e *CacheEntry[K, V]) {
+	p.tick++
+	e.freq = 1
+	e.tick = p.tick
+	e.index = int32(len(p.heap))
+	p.heap = append(p.heap, e)
+	p.up(int(e.index))
+	p.age()
+}
+
+func (p *lfuPolicy[K, V]) OnAccess(e *CacheEntry[K, V]) {
+	p.tick++
+	if e.freq < math.MaxUint32 {
+		e.freq++
+	}
+	e.tick = p.tick
+	p.down(int(e.index)) // Only ever grows, so it can only sink
+	p.age()
+}
+
+func (p *lfuPolicy[K, V]) OnRemove(e *CacheEntry[K, V]) {
+	i := int(e.index)
+	last := len(p.heap) - 1
+	if i != last {
+		p.swap(i, last)
+	}
+	p.heap[last] = nil
+	p.heap = p.heap[:last]
+	if i != last {
+		if !p.up(i) {
+			p.down(i)
+		}
+	}
+}
+
+func (p *lfuPolicy[K, V]) Victim() *CacheEntry[K, V] {
+	if len(p.heap) == 0 {
+		return nil
+	}
+	return p.heap[0]
+}
+
+// age halves every count once per aging period. Halving preserves relative
+// order except for ties, so the heap is rebuilt afterwards.
+func (p *lfuPolicy[K, V]) age() {
+	p.ops++
+	if p.ops < p.agingPeriod {
+		return
+	}
+	p.ops = 0
+	for _, e := range p.heap {
+		e.freq = (e.freq + 1) / 2 // Keep live entries at >= 1
+	}
+	for i := len(p.heap)/2 - 1; i >= 0; i-- {
+		p.down(i)
+	}
+}
+
+func (p *lfuPolicy[K, V]) less(i, j int) bool {
+	a, b := p.heap[i], p.heap[j]
+	if a.freq != b.freq {
+		return a.freq < b.freq
+	}
+	return a.tick < b.tick
+}
+
+func (p *lfuPolicy[K, V]) swap(i, j int) {
+	p.heap[i], p.heap[j] = p.heap[j], p.heap[i]
+	p.heap[i].index = int32(i)
+	p.heap[j].index = int32(j)
+}
+
+// up moves element i towards the root and reports whether it moved
+func (p *lfuPolicy[K, V]) up(i int) bool {
+	start := i
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !p.less(i, parent) {
+			break
+		}
+		p.swap(i, parent)
+		i = parent
+	}
+	return i != start
+}
+
+// down moves element i towards the leaves
+func (p *lfuPolicy[K, V]) down(i int) {
+	n := len(p.heap)
+	for {
+		smallest := i
+		if l := 2*i + 1; l < n && p.less(l, smallest) {
+			smallest = l
+		}
+		if r := 2*i + 2; r < n && p.less(r, smallest) {
+			smallest = r
+		}
+		if smallest == i {
+			return
+		}
+		p.swap(i, smallest)
+		i = smallest
+	}
+}
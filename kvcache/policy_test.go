@@ -0,0 +1,395 @@
+package kvcache
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// newPolicyCache returns a single-shard cache using a built-in policy
+func newPolicyCache(kind EvictionKind, capacity int) *Cache[int, int] {
+	return NewWithConfig[int, int](Config{
+		NumShards:           1,
+		MaxCapacityPerShard: capacity,
+		CleanupInterval:     -1,
+		Eviction:            kind,
+	})
+}
+
+var allPolicies = []EvictionKind{EvictionLRU, EvictionLFU, EvictionFIFO, EvictionRandom, EvictionARC, EvictionTinyLFU}
+
+// TestPolicyCapacityInvariant tests every policy under a random workload
+func TestPolicyCapacityInvariant(t *testing.T) {
+	for _, kind := range allPolicies {
+		t.Run(kind.String(), func(t *testing.T) {
+			cache := newPolicyCache(kind, 32)
+			defer cache.Close()
+
+			rng := rand.New(rand.NewSource(7))
+			for i := 0; i < 50000; i++ {
+				k := rng.Intn(200)
+				switch rng.Intn(4) {
+				case 0, 1:
+					cache.Set(k, k)
+				case 2:
+					cache.Get(k)
+				default:
+					cache.Delete(k)
+				}
+				if i%1000 == 0 {
+					cache.Clear()
+				}
+			}
+
+			if size := cache.Size(); size > 32 {
+				t.Errorf("Size %d exceeds capacity", size)
+			}
+			for i := 0; i < 1000; i++ {
+				cache.Set(1000+i, i)
+			}
+			if size := cache.Size(); size != 32 {
+				t.Errorf("Expected a full shard of 32, got %d", size)
+			}
+			if cache.Stats().Evictions == 0 {
+				t.Error("Expected evictions")
+			}
+		})
+	}
+}
+
+// TestPolicyFIFO tests that reads do not save the oldest entry
+func TestPolicyFIFO(t *testing.T) {
+	cache := newPolicyCache(EvictionFIFO, 3)
+	defer cache.Close()
+
+	cache.Set(1, 1)
+	cache.Set(2, 2)
+	cache.Set(3, 3)
+	cache.Get(1)
+	cache.Set(1, 10) // Overwrites don't reorder either
+	cache.Set(4, 4)
+
+	if _, ok := cache.Get(1); ok {
+		t.Error("FIFO should evict the first inserted key")
+	}
+	if _, ok := cache.Get(2); !ok {
+		t.Error("Key 2 should still be cached")
+	}
+}
+
+// TestPolicyLFU tests that frequently used entries survive
+func TestPolicyLFU(t *testing.T) {
+	cache := newPolicyCache(EvictionLFU, 3)
+	defer cache.Close()
+
+	cache.Set(1, 1)
+	cache.Set(2, 2)
+	cache.Set(3, 3)
+	for i := 0; i < 5; i++ {
+		cache.Get(1)
+		cache.Get(3)
+	}
+	cache.Set(4, 4) // Evicts 2, the only key never read
+
+	if _, ok := cache.Get(2); ok {
+		t.Error("LFU should evict the least frequently used key")
+	}
+	cache.Set(5, 5) // 4 and 5 both have one use; 4 is older
+
+	if _, ok := cache.Get(4); ok {
+		t.Error("LFU should break ties by recency")
+	}
+	for _, k := range []int{1, 3, 5} {
+		if _, ok := cache.Get(k); !ok {
+			t.Errorf("Key %d should still be cached", k)
+		}
+	}
+}
+
+// TestPolicyLFUAging tests that old popularity decays
+func TestPolicyLFUAging(t *testing.T) {
+	p := newLFUPolicy[int, int](4)
+	old := &CacheEntry[int, int]{key: 0}
+	p.OnInsert(old)
+	for i := 0; i < 100; i++ {
+		p.OnAccess(old)
+	}
+
+	// A steadily used newcomer eventually overtakes the stale favourite
+	fresh := &CacheEntry[int, int]{key: 1}
+	p.OnInsert(fresh)
+	for i := 0; i < 4*minLFUAgingPeriod; i++ {
+		p.OnAccess(fresh)
+	}
+	if p.Victim() != old {
+		t.Errorf("Expected stale entry to become the victim, freq old=%d fresh=%d", old.freq, fresh.freq)
+	}
+	if old.freq == 0 {
+		t.Error("Aging must not drop live entries to zero")
+	}
+}
+
+// TestPolicyLFUHeap tests heap bookkeeping through arbitrary removals
+func TestPolicyLFUHeap(t *testing.T) {
+	p := newLFUPolicy[int, int](0)
+	rng := rand.New(rand.NewSource(3))
+	live := make(map[int]*CacheEntry[int, int])
+	for i := 0; i < 5000; i++ {
+		k := rng.Intn(100)
+		e, ok := live[k]
+		switch {
+		case !ok:
+			e = &CacheEntry[int, int]{key: k}
+			live[k] = e
+			p.OnInsert(e)
+		case rng.Intn(3) == 0:
+			p.OnRemove(e)
+			delete(live, k)
+		default:
+			p.OnAccess(e)
+		}
+	}
+	for i, e := range p.heap {
+		if int(e.index) != i {
+			t.Fatalf("Heap index mismatch at %d", i)
+		}
+		if i > 0 && p.less(i, (i-1)/2) {
+			t.Fatalf("Heap property violated at %d", i)
+		}
+	}
+	if len(p.heap) != len(live) {
+		t.Errorf("Heap has %d entries, want %d", len(p.heap), len(live))
+	}
+}
+
+// TestPolicyRandom tests slice bookkeeping in the random policy
+func TestPolicyRandom(t *testing.T) {
+	cache := newPolicyCache(EvictionRandom, 10)
+	defer cache.Close()
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i)
+	}
+	p := cache.shards[0].policy.(*randomPolicy[int, int])
+	if len(p.entries) != 10 {
+		t.Fatalf("Expected 10 tracked entries, got %d", len(p.entries))
+	}
+	for i, e := range p.entries {
+		if int(e.index) != i || cache.shards[0].store[e.key] != e {
+			t.Errorf("Entry %d has stale bookkeeping", i)
+		}
+	}
+}
+
+// TestPolicyARCScanResistance tests that a one-off scan doesn't flush reused keys
+func TestPolicyARCScanResistance(t *testing.T) {
+	for _, tc := range []struct {
+		kind    EvictionKind
+		survive bool
+	}{
+		{EvictionARC, true},
+		{EvictionLRU, false},
+	} {
+		t.Run(tc.kind.String(), func(t *testing.T) {
+			cache := newPolicyCache(tc.kind, 10)
+			defer cache.Close()
+
+			for k := 0; k < 5; k++ {
+				cache.Set(k, k)
+				cache.Get(k)
+			}
+			for k := 100; k < 1100; k++ {
+				cache.Set(k, k)
+			}
+
+			survivors := 0
+			for k := 0; k < 5; k++ {
+				if _, ok := cache.Get(k); ok {
+					survivors++
+				}
+			}
+			if tc.survive && survivors != 5 {
+				t.Errorf("Expected all reused keys to survive, got %d", survivors)
+			}
+			if !tc.survive && survivors != 0 {
+				t.Errorf("Expected scan to flush reused keys, %d survived", survivors)
+			}
+		})
+	}
+}
+
+// TestPolicyARCAdapts tests that ghost hits move the T1 target
+func TestPolicyARCAdapts(t *testing.T) {
+	cache := newPolicyCache(EvictionARC, 4)
+	defer cache.Close()
+
+	cache.Set(0, 0)
+	cache.Set(1, 1)
+	cache.Get(1) // Promote 1 to T2 so T1 is below capacity
+	cache.Set(2, 2)
+	cache.Set(3, 3)
+	cache.Set(4, 4) // Evicts 0 from T1 into B1
+	p := cache.shards[0].policy.(*arcPolicy[int, int])
+	if !p.b1.contains(0) {
+		t.Fatal("Evicted key should be remembered in B1")
+	}
+
+	cache.Set(0, 0) // Ghost hit in B1
+	if p.p == 0 {
+		t.Error("A B1 ghost hit should grow the T1 target")
+	}
+	if p.b1.contains(0) || cache.shards[0].store[0].index != arcT2 {
+		t.Error("Re-admitted key should move from B1 to T2")
+	}
+}
+
+// countingPolicy wraps LRU and checks the EvictionPolicy contract
+type countingPolicy struct {
+	lruPolicy[int, int]
+	live    map[*CacheEntry[int, int]]bool
+	errs    *[]string
+	victims *int
+}
+
+func (p *countingPolicy) OnInsert(e *CacheEntry[int, int]) {
+	if p.live[e] {
+		*p.errs = append(*p.errs, fmt.Sprintf("double insert of %d", e.key))
+	}
+	p.live[e] = true
+	p.lruPolicy.OnInsert(e)
+}
+
+func (p *countingPolicy) OnAccess(e *CacheEntry[int, int]) {
+	if !p.live[e] {
+		*p.errs = append(*p.errs, fmt.Sprintf("access to untracked %d", e.key))
+	}
+	p.lruPolicy.OnAccess(e)
+}
+
+func (p *countingPolicy) OnRemove(e *CacheEntry[int, int]) {
+	if !p.live[e] {
+		*p.errs = append(*p.errs, fmt.Sprintf("remove of untracked %d", e.key))
+	}
+	delete(p.live, e)
+	p.lruPolicy.OnRemove(e)
+}
+
+func (p *countingPolicy) Victim() *CacheEntry[int, int] {
+	*p.victims++
+	return p.lruPolicy.Victim()
+}
+
+// TestNewWithPolicy tests plugging in a custom policy
+func TestNewWithPolicy(t *testing.T) {
+	var errs []string
+	victims := 0
+	cache := NewWithPolicy[int, int](Config{
+		NumShards:           1,
+		MaxCapacityPerShard: 4,
+		CleanupInterval:     -1,
+	}, func(capacity int) EvictionPolicy[int, int] {
+		if capacity != 4 {
+			errs = append(errs, fmt.Sprintf("factory got capacity %d", capacity))
+		}
+		return &countingPolicy{live: make(map[*CacheEntry[int, int]]bool), errs: &errs, victims: &victims}
+	})
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.Set(i%10, i)
+		cache.Get((i + 3) % 10)
+		if i%7 == 0 {
+			cache.Delete(i % 10)
+		}
+	}
+	cache.Clear()
+	cache.Set(1, 1)
+
+	if len(errs) > 0 {
+		t.Errorf("Policy contract violated: %v", errs)
+	}
+	if victims == 0 || uint64(victims) != cache.Stats().Evictions {
+		t.Errorf("Expected every eviction to consult Victim, got %d victims for %d evictions",
+			victims, cache.Stats().Evictions)
+	}
+}
+
+// TestConfigRejectsUnknownEviction tests validation of the policy kind
+func TestConfigRejectsUnknownEviction(t *testing.T) {
+	if err := (Config{Eviction: EvictionKind(99)}).Validate(); err == nil {
+		t.Error("Expected unknown eviction policy to be rejected")
+	}
+}
+
+// policyTraces returns the traces used to compare policy hit rates. They
+// are generated from fixed seeds so every run replays exactly the same
+// request sequence, and only once a benchmark needs them.
+var policyTraces = sync.OnceValue(func() map[string][]int {
+	return map[string][]int{
+		"zipf":      zipfTrace(1, 200000, 10000),
+		"loop":      loopTrace(200000, 1200),
+		"zipf+scan": scanMixTrace(2, 200000, 10000),
+	}
+})
+
+// zipfTrace draws keys from a skewed popularity distribution
+func zipfTrace(seed int64, n int, keys uint64) []int {
+	rng := rand.New(rand.NewSource(seed))
+	z := rand.NewZipf(rng, 1.1, 1, keys-1)
+	trace := make([]int, n)
+	for i := range trace {
+		trace[i] = int(z.Uint64())
+	}
+	return trace
+}
+
+// loopTrace cycles through slightly more keys than the cache holds,
+// the worst case for LRU
+func loopTrace(n, keys int) []int {
+	trace := make([]int, n)
+	for i := range trace {
+		trace[i] = i % keys
+	}
+	return trace
+}
+
+// scanMixTrace interleaves a Zipf workload with long one-off scans
+func scanMixTrace(seed int64, n int, keys uint64) []int {
+	base := zipfTrace(seed, n, keys)
+	next := int(keys)
+	for i := 0; i < n; i += 10000 {
+		for j := i; j < i+2000 && j < n; j++ {
+			base[j] = next
+			next++
+		}
+	}
+	return base
+}
+
+// BenchmarkPolicyHitRate replays each trace against each policy and reports
+// the hit rate alongside the per-operation cost
+func BenchmarkPolicyHitRate(b *testing.B) {
+	for _, name := range []string{"zipf", "loop", "zipf+scan"} {
+		trace := policyTraces()[name]
+		for _, kind := range allPolicies {
+			b.Run(name+"/"+kind.String(), func(b *testing.B) {
+				var hits, total int
+				for i := 0; i < b.N; i++ {
+					cache := newPolicyCache(kind, 1000)
+					hits, total = 0, 0
+					for _, k := range trace {
+						if _, ok := cache.Get(k); ok {
+							hits++
+						} else {
+							cache.Set(k, k)
+						}
+						total++
+					}
+					cache.Close()
+				}
+				b.ReportMetric(float64(hits)/float64(total)*100, "hit%")
+			})
+		}
+	}
+}
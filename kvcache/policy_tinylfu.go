@@ -0,0 +1,144 @@
+package kvcache
+
+import "sync/atomic"
+
+// Segments a W-TinyLFU entry can live in, stored in CacheEntry.index
+const (
+	segWindow    = iota // Admission window, plain LRU
+	segProbation        // Main cache, seen once since admission
+	segProtected        // Main cache, reused since admission
+)
+
+// Share of capacity given to the window and, within the main cache, to the
+// protected segment (as in Caffeine's defaults)
+const (
+	tinyLFUWindowPercent    = 1
+	tinyLFUProtectedPercent = 80
+)
+
+// tinyLFUPolicy implements W-TinyLFU.
+//
+// New entries land in a small LRU window. When the window overflows, its
+// oldest entry becomes a candidate for the main cache, a segmented LRU
+// split into probation and protected segments. The candidate is admitted
+// only if the frequency sketch estimates it is more popular than the main
+// cache's victim; otherwise the candidate itself is evicted and counted as a
+// rejection. A burst of one-off keys therefore churns through the window
+// without displacing the established working set.
+type tinyLFUPolicy[K comparable, V any] struct {
+	capacity   int
+	window     lruList[K, V]
+	probation  lruList[K, V]
+	protected  lruList[K, V]
+	sketch     *countMinSketch
+	hash       func(K) uint32
+	rejections *atomic.Uint64
+}
+
+func newTinyLFUPolicy[K comparable, V any](capacity int, hash func(K) uint32, rejections *atomic.Uint64) *tinyLFUPolicy[K, V] {
+	return &tinyLFUPolicy[K, V]{
+		capacity:   capacity,
+		sketch:     newCountMinSketch(capacity),
+		hash:       hash,
+		rejections: rejections,
+	}
+}
+
+func (p *tinyLFUPolicy[K, V]) OnInsert(e *CacheEntry[K, V]) {
+	p.sketch.increment(spreadHash(p.hash(e.key)))
+	e.index = segWindow
+	p.window.pushFront(e)
+
+	// Until the shard fills up there is nothing to compete with, so window
+	// overflow moves straight into the main cache
+	for p.window.len > p.windowLimit() {
+		p.admit(p.window.back())
+	}
+}
+
+func (p *tinyLFUPolicy[K, V]) OnAccess(e *CacheEntry[K, V]) {
+	p.sketch.increment(spreadHash(p.hash(e.key)))
+	switch e.index {
+	case segWindow:
+		p.window.moveToFront(e)
+	case segProbation:
+		// Reuse promotes to protected, demoting its LRU if it is full
+		p.probation.remove(e)
+		e.index = segProtected
+		p.protected.pushFront(e)
+		if p.protected.len > p.protectedLimit() {
+			demoted := p.protected.back()
+			p.protected.remove(demoted)
+			demoted.index = segProbation
+			p.probation.pushFront(demoted)
+		}
+	case segProtected:
+		p.protected.moveToFront(e)
+	}
+}
+
+func (p *tinyLFUPolicy[K, V]) OnRemove(e *CacheEntry[K, V]) {
+	switch e.index {
+	case segWindow:
+		p.window.remove(e)
+	case segProbation:
+		p.probation.remove(e)
+	case segProtected:
+		p.protected.remove(e)
+	}
+}
+
+// Victim is called before a new entry is inserted into a full shard, which
+// is the moment the window overflows and its candidate must compete
+func (p *tinyLFUPolicy[K, V]) Victim() *CacheEntry[K, V] {
+	mainVictim := p.probation.back()
+	if mainVictim == nil {
+		mainVictim = p.protected.back()
+	}
+
+	var candidate *CacheEntry[K, V]
+	if p.window.len >= p.windowLimit() {
+		candidate = p.window.back()
+	}
+	switch {
+	case candidate == nil && mainVictim == nil:
+		return nil
+	case candidate == nil:
+		return mainVictim
+	case mainVictim == nil:
+		// Everything fits in the window (tiny shards): plain LRU
+		return candidate
+	}
+
+	if p.sketch.estimate(spreadHash(p.hash(candidate.key))) > p.sketch.estimate(spreadHash(p.hash(mainVictim.key))) {
+		p.admit(candidate)
+		return mainVictim
+	}
+	p.rejections.Add(1)
+	return candidate
+}
+
+// admit moves a window entry into the probation segment
+func (p *tinyLFUPolicy[K, V]) admit(e *CacheEntry[K, V]) {
+	p.window.remove(e)
+	e.index = segProbation
+	p.probation.pushFront(e)
+}
+
+// size is the capacity the segments are proportioned against. Shards
+// without an entry limit (evicting only under a cost budget) use their
+// current size.
+func (p *tinyLFUPolicy[K, V]) size() int {
+	if p.capacity > 0 {
+		return p.capacity
+	}
+	return p.window.len + p.probation.len + p.protected.len
+}
+
+func (p *tinyLFUPolicy[K, V]) windowLimit() int {
+	return max(1, p.size()*tinyLFUWindowPercent/100)
+}
+
+func (p *tinyLFUPolicy[K, V]) protectedLimit() int {
+	return max(1, (p.size()-p.windowLimit())*tinyLFUProtectedPercent/100)
+}
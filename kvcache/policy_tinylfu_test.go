@@ -0,0 +1,173 @@
+package kvcache
+
+import (
+	"testing"
+)
+
+// TestSketchEstimates tests frequency estimation and the doorkeeper
+func TestSketchEstimates(t *testing.T) {
+	s := newCountMinSketch(1000)
+	hot, cold, unseen := spreadHash(1), spreadHash(2), spreadHash(3)
+
+	for i := 0; i < 10; i++ {
+		s.increment(hot)
+	}
+	s.increment(cold)
+
+	if got := s.estimate(cold); got != 1 {
+		t.Errorf("One-hit key should only be in the doorkeeper, estimate %d", got)
+	}
+	if got := s.estimate(hot); got < 10 {
+		t.Errorf("Count-min sketch must not underestimate, got %d", got)
+	}
+	if got := s.estimate(unseen); got != 0 {
+		t.Errorf("Unseen key should estimate 0, got %d", got)
+	}
+
+	for i := 0; i < 100; i++ {
+		s.increment(hot)
+	}
+	if got := s.estimate(hot); got > sketchMaxCount+1 {
+		t.Errorf("Counters should saturate, got %d", got)
+	}
+}
+
+// TestSketchAging tests periodic halving
+func TestSketchAging(t *testing.T) {
+	s := newCountMinSketch(16)
+	h := spreadHash(42)
+	for i := 0; i < 12; i++ {
+		s.increment(h)
+	}
+	before := s.estimate(h)
+
+	// Fill the rest of the sample with other keys to trigger a reset
+	for i := s.additions; i < s.sampleSize; i++ {
+		s.increment(spreadHash(uint32(1000 + i)))
+	}
+	after := s.estimate(h)
+	if after >= before || after < (before-1)/2-1 {
+		t.Errorf("Expected estimate to roughly halve, before %d after %d", before, after)
+	}
+	if s.door.test(h) {
+		t.Error("Doorkeeper should be cleared on reset")
+	}
+}
+
+// TestTinyLFUScanResistance tests that a scan of one-off keys is rejected
+func TestTinyLFUScanResistance(t *testing.T) {
+	for _, tc := range []struct {
+		kind    EvictionKind
+		survive bool
+	}{
+		{EvictionTinyLFU, true},
+		{EvictionLRU, false},
+	} {
+		t.Run(tc.kind.String(), func(t *testing.T) {
+			cache := newPolicyCache(tc.kind, 100)
+			defer cache.Close()
+
+			// Establish a working set with repeated use
+			for round := 0; round < 5; round++ {
+				for k := 0; k < 50; k++ {
+					if _, ok := cache.Get(k); !ok {
+						cache.Set(k, k)
+					}
+				}
+			}
+
+			for k := 1000; k < 11000; k++ {
+				cache.Set(k, k)
+			}
+
+			survivors := 0
+			for k := 0; k < 50; k++ {
+				if _, ok := cache.Get(k); ok {
+					survivors++
+				}
+			}
+			if tc.survive && survivors < 49 {
+				t.Errorf("Expected the working set to survive the scan, %d of 50 did", survivors)
+			}
+			if !tc.survive && survivors != 0 {
+				t.Errorf("Expected LRU to be flushed by the scan, %d survived", survivors)
+			}
+
+			stats := cache.Stats()
+			if tc.kind == EvictionTinyLFU && stats.Rejections == 0 {
+				t.Error("Expected admission rejections to be reported")
+			}
+			if tc.kind != EvictionTinyLFU && stats.Rejections != 0 {
+				t.Errorf("Only TinyLFU rejects admissions, got %d", stats.Rejections)
+			}
+			if stats.Rejections > stats.Evictions {
+				t.Error("Rejected candidates are a subset of evictions")
+			}
+		})
+	}
+}
+
+// TestTinyLFUAdmitsPopularNewcomer tests that a frequent new key gets in
+func TestTinyLFUAdmitsPopularNewcomer(t *testing.T) {
+	cache := newPolicyCache(EvictionTinyLFU, 10)
+	defer cache.Close()
+
+	for k := 0; k < 10; k++ {
+		cache.Set(k, k)
+	}
+
+	// Key 100 is requested over and over while one-off keys push it out of
+	// the window; each miss sets it again
+	admitted := false
+	for i := 0; i < 20 && !admitted; i++ {
+		if _, ok := cache.Get(100); !ok {
+			cache.Set(100, 100)
+		}
+		cache.Set(200+i, i)
+
+		shard := cache.shards[0]
+		shard.mutex.Lock()
+		cache.drainAccessesLocked(shard)
+		if e, ok := shard.store[100]; ok && e.index != segWindow {
+			admitted = true
+		}
+		shard.mutex.Unlock()
+	}
+	if !admitted {
+		t.Error("A repeatedly requested key should be admitted to the main cache")
+	}
+}
+
+// TestTinyLFUSegments tests promotion and protected-segment bounds
+func TestTinyLFUSegments(t *testing.T) {
+	cache := newPolicyCache(EvictionTinyLFU, 200)
+	defer cache.Close()
+
+	for k := 0; k < 200; k++ {
+		cache.Set(k, k)
+	}
+	for k := 0; k < 200; k++ {
+		cache.Get(k)
+	}
+
+	shard := cache.shards[0]
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	cache.drainAccessesLocked(shard)
+
+	p := shard.policy.(*tinyLFUPolicy[int, int])
+	if p.window.len != p.windowLimit() {
+		t.Errorf("Window holds %d, limit %d", p.window.len, p.windowLimit())
+	}
+	if p.protected.len > p.protectedLimit() {
+		t.Errorf("Protected holds %d, limit %d", p.protected.len, p.protectedLimit())
+	}
+	if p.window.len+p.probation.len+p.protected.len != shard.size {
+		t.Error("Segments must account for every entry")
+	}
+	for e := p.protected.head; e != nil; e = e.next {
+		if e.index != segProtected {
+			t.Fatalf("Entry %d in protected list tagged %d", e.key, e.index)
+		}
+	}
+}
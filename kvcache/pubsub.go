@@ -0,0 +1,273 @@
+package kvcache
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultMessageBuffer is the subscription buffer used when
+// PubSubOptions.Buffer is 0
+const DefaultMessageBuffer = 256
+
+// Message is a payload published to a channel
+type Message struct {
+	Channel string
+	Pattern string // Matching pattern for PSubscribe deliveries, "" otherwise
+	Payload string
+}
+
+// PubSubOptions configures a PubSub
+type PubSubOptions struct {
+	Buffer   int            // Per-subscription channel capacity, 0 for DefaultMessageBuffer
+	Overflow OverflowPolicy // What to do when a subscription's channel is full
+}
+
+// PubSub is a publish/subscribe broker with Redis semantics: messages go
+// to every subscription to their channel and, once per matching pattern,
+// to every pattern subscription. Messages published to one channel reach
+// each subscription in the order they were published. It is independent
+// of any cache; the Redis-compatible server exposes one over the network.
+type PubSub struct {
+	opts PubSubOptions
+
+	mu       sync.RWMutex
+	channels map[string]map[*Subscription]struct{}
+	patterns map[string]map[*Subscription]struct{}
+	closed   bool
+
+	// Publishes to a channel hold its stripe, keeping them in order
+	order [256]sync.Mutex
+}
+
+// NewPubSub creates a broker. It panics if opts.Buffer is negative.
+func NewPubSub(opts PubSubOptions) *PubSub {
+	if opts.Buffer < 0 {
+		panic("kvcache: negative PubSubOptions.Buffer")
+	}
+	if opts.Buffer == 0 {
+		opts.Buffer = DefaultMessageBuffer
+	}
+	return &PubSub{
+		opts:     opts,
+		channels: make(map[string]map[*Subscription]struct{}),
+		patterns: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends payload to channel's subscribers and returns how many
+// deliveries were made, counting a subscription once per channel or
+// pattern it matched. Subscriptions whose buffer is full are handled by
+// the overflow policy.
+func (p *PubSub) Publish(channel, payload string) int {
+	order := &p.order[fnv32a(channel)%uint32(len(p.order))]
+	order.Lock()
+	defer order.Unlock()
+
+	type delivery struct {
+		sub     *Subscription
+		pattern string
+	}
+	var deliveries []delivery
+	p.mu.RLock()
+	for sub := range p.channels[channel] {
+		deliveries = append(deliveries, delivery{sub: sub})
+	}
+	for pattern, subs := range p.patterns {
+		if !matchGlob(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			deliveries = append(deliveries, delivery{sub: sub, pattern: pattern})
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, d := range deliveries {
+		if !d.sub.send(Message{Channel: channel, Pattern: d.pattern, Payload: payload}) {
+			d.sub.Close()
+		}
+	}
+	return len(deliveries)
+}
+
+// Subscribe returns a new subscription to channels
+func (p *PubSub) Subscribe(channels ...string) *Subscription {
+	s := p.newSubscription()
+	s.Subscribe(channels...)
+	return s
+}
+
+// PSubscribe returns a new subscription to channels matching patterns,
+// which are Redis-style globs
+func (p *PubSub) PSubscribe(patterns ...string) *Subscription {
+	s := p.newSubscription()
+	s.PSubscribe(patterns...)
+	return s
+}
+
+func (p *PubSub) newSubscription() *Subscription {
+	s := &Subscription{
+		mailbox:  newMailbox[Message](p.opts.Buffer, p.opts.Overflow),
+		ps:       p,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+	p.mu.RLock()
+	closed := p.closed
+	p.mu.RUnlock()
+	if closed {
+		s.Close()
+	}
+	return s
+}
+
+// Close closes every subscription. Later subscriptions are returned
+// closed and Publish delivers nothing.
+func (p *PubSub) Close() {
+	p.mu.Lock()
+	p.closed = true
+	subs := make(map[*Subscription]struct{})
+	for _, set := range []map[string]map[*Subscription]struct{}{p.channels, p.patterns} {
+		for _, m := range set {
+			for s := range m {
+				subs[s] = struct{}{}
+			}
+		}
+	}
+	p.mu.Unlock()
+	for s := range subs {
+		s.Close()
+	}
+}
+
+// Subscription receives the messages for a set of channels and patterns,
+// which can change over its life
+type Subscription struct {
+	mailbox[Message]
+	ps *PubSub
+
+	// Guarded by ps.mu
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// Messages returns the channel messages are delivered on. It is closed by
+// Close, by PubSub.Close, and on overflow with OverflowDisconnect.
+func (s *Subscription) Messages() <-chan Message {
+	return s.ch
+}
+
+// Subscribe adds channels to the subscription
+func (s *Subscription) Subscribe(channels ...string) {
+	s.add(s.ps.channels, s.channels, channels)
+}
+
+// PSubscribe adds Redis-style glob patterns to the subscription
+func (s *Subscription) PSubscribe(patterns ...string) {
+	s.add(s.ps.patterns, s.patterns, patterns)
+}
+
+// Unsubscribe removes channels from the subscription, or every channel if
+// none are given. The Messages channel stays open.
+func (s *Subscription) Unsubscribe(channels ...string) {
+	s.remove(s.ps.channels, s.channels, channels)
+}
+
+// PUnsubscribe removes patterns from the subscription, or every pattern if
+// none are given. The Messages channel stays open.
+func (s *Subscription) PUnsubscribe(patterns ...string) {
+	s.remove(s.ps.patterns, s.patterns, patterns)
+}
+
+// Channels returns the subscribed channels in sorted order
+func (s *Subscription) Channels() []string {
+	return s.names(s.channels)
+}
+
+// Patterns returns the subscribed patterns in sorted order
+func (s *Subscription) Patterns() []string {
+	return s.names(s.patterns)
+}
+
+// Count returns the number of channels and patterns subscribed to
+func (s *Subscription) Count() int {
+	s.ps.mu.RLock()
+	defer s.ps.mu.RUnlock()
+	return len(s.channels) + len(s.patterns)
+}
+
+// Close removes every channel and pattern and closes the Messages channel
+func (s *Subscription) Close() {
+	s.ps.mu.Lock()
+	s.dropLocked(s.ps.channels, s.channels, nil)
+	s.dropLocked(s.ps.patterns, s.patterns, nil)
+	s.ps.mu.Unlock()
+	s.close()
+}
+
+// add registers names in the broker's index and the subscription's set
+func (s *Subscription) add(index map[string]map[*Subscription]struct{}, own map[string]struct{}, names []string) {
+	s.ps.mu.Lock()
+	closed := s.ps.closed
+	if !closed && !s.isClosed() {
+		for _, name := range names {
+			subs := index[name]
+			if subs == nil {
+				subs = make(map[*Subscription]struct{})
+				index[name] = subs
+			}
+			subs[s] = struct{}{}
+			own[name] = struct{}{}
+		}
+	}
+	s.ps.mu.Unlock()
+	if closed {
+		s.close()
+	}
+}
+
+func (s *Subscription) remove(index map[string]map[*Subscription]struct{}, own map[string]struct{}, names []string) {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	s.dropLocked(index, own, names)
+}
+
+// dropLocked unregisters names, or all of own if names is empty. Must be
+// called with ps.mu held.
+func (s *Subscription) dropLocked(index map[string]map[*Subscription]struct{}, own map[string]struct{}, names []string) {
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		if subs := index[name]; subs != nil {
+			delete(subs, s)
+			if len(subs) == 0 {
+				delete(index, name)
+			}
+		}
+		delete(own, name)
+	}
+}
+
+func (s *Subscription) names(own map[string]struct{}) []string {
+	s.ps.mu.RLock()
+	names := make([]string, 0, len(own))
+	for name := range own {
+		names = append(names, name)
+	}
+	s.ps.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// isClosed reports whether the Messages channel has been closed
+func (s *Subscription) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
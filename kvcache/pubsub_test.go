@@ -0,0 +1,203 @@
+package kvcache
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receive returns the messages buffered on sub, formatted as
+// "channel[pattern]: payload"
+func receive(sub *Subscription) []string {
+	var got []string
+	for {
+		select {
+		case m, ok := <-sub.Messages():
+			if !ok {
+				return got
+			}
+			got = append(got, fmt.Sprintf("%s[%s]: %s", m.Channel, m.Pattern, m.Payload))
+		default:
+			return got
+		}
+	}
+}
+
+// TestPubSub tests channel and pattern delivery
+func TestPubSub(t *testing.T) {
+	ps := NewPubSub(PubSubOptions{})
+	defer ps.Close()
+	news := ps.Subscribe("news", "sport")
+	all := ps.PSubscribe("n*", "*s")
+
+	if n := ps.Publish("news", "a"); n != 3 {
+		t.Errorf("Expected 3 deliveries, got %d", n)
+	}
+	if n := ps.Publish("weather", "b"); n != 0 {
+		t.Errorf("Expected no deliveries, got %d", n)
+	}
+	ps.Publish("sport", "c")
+
+	if got, want := receive(news), []string{"news[]: a", "sport[]: c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Channels: expected %v, got %v", want, got)
+	}
+	got := receive(all)
+	if len(got) != 2 || got[0] == got[1] {
+		t.Fatalf("Expected one news delivery per pattern, got %v", got)
+	}
+	for _, m := range got {
+		if m != "news[n*]: a" && m != "news[*s]: a" {
+			t.Errorf("Unexpected message %q", m)
+		}
+	}
+}
+
+// TestPubSubUnsubscribe tests changing a subscription's channels
+func TestPubSubUnsubscribe(t *testing.T) {
+	ps := NewPubSub(PubSubOptions{})
+	defer ps.Close()
+	sub := ps.Subscribe("a", "b", "c")
+	sub.PSubscribe("x*")
+
+	sub.Unsubscribe("b")
+	if got := sub.Channels(); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("Expected [a c], got %v", got)
+	}
+	if n := sub.Count(); n != 3 {
+		t.Errorf("Expected 3 subscriptions, got %d", n)
+	}
+	ps.Publish("b", "ignored")
+
+	sub.Unsubscribe()
+	sub.PUnsubscribe()
+	if n := sub.Count(); n != 0 {
+		t.Errorf("Expected no subscriptions, got %d", n)
+	}
+	ps.Publish("a", "ignored")
+	ps.Publish("xy", "ignored")
+
+	sub.Subscribe("b")
+	ps.Publish("b", "ok")
+	if got, want := receive(sub), []string{"b[]: ok"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if len(ps.channels) != 1 || len(ps.patterns) != 0 {
+		t.Errorf("Expected unused channels to be dropped, got %v %v", ps.channels, ps.patterns)
+	}
+}
+
+// TestPubSubOrder tests that concurrent publishers to a channel are seen in
+// the same order by every subscriber
+func TestPubSubOrder(t *testing.T) {
+	ps := NewPubSub(PubSubOptions{Buffer: 1000})
+	defer ps.Close()
+	subs := []*Subscription{ps.Subscribe("ch"), ps.PSubscribe("c?"), ps.Subscribe("ch")}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				ps.Publish("ch", fmt.Sprint(g, ":", i))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	var first []string
+	for i, sub := range subs {
+		payloads := make([]string, 400)
+		for j := range payloads {
+			payloads[j] = (<-sub.Messages()).Payload
+		}
+		if first == nil {
+			first = payloads
+		} else if !reflect.DeepEqual(payloads, first) {
+			t.Errorf("Subscriber %d saw a different order", i)
+		}
+	}
+}
+
+// TestPubSubOverflow tests slow subscriber handling
+func TestPubSubOverflow(t *testing.T) {
+	t.Run("drop", func(t *testing.T) {
+		ps := NewPubSub(PubSubOptions{Buffer: 2})
+		defer ps.Close()
+		sub := ps.Subscribe("ch")
+		for i := 0; i < 5; i++ {
+			ps.Publish("ch", fmt.Sprint(i))
+		}
+		if got, want := receive(sub), []string{"ch[]: 0", "ch[]: 1"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		ps := NewPubSub(PubSubOptions{Buffer: 1, Overflow: OverflowDisconnect})
+		defer ps.Close()
+		slow := ps.Subscribe("ch")
+		fast := ps.Subscribe("ch")
+		ps.Publish("ch", "0")
+		<-fast.Messages()
+		ps.Publish("ch", "1")
+		<-slow.Messages()
+		if _, ok := <-slow.Messages(); ok {
+			t.Error("Expected the slow subscription to be closed")
+		}
+		if got := (<-fast.Messages()).Payload; got != "1" {
+			t.Errorf("Expected the fast subscription to get 1, got %s", got)
+		}
+		if n := ps.Publish("ch", "2"); n != 1 {
+			t.Errorf("Expected 1 delivery after disconnect, got %d", n)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		ps := NewPubSub(PubSubOptions{Buffer: 1, Overflow: OverflowBlock})
+		defer ps.Close()
+		sub := ps.Subscribe("ch")
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ps.Publish("ch", "0")
+			ps.Publish("ch", "1")
+		}()
+		select {
+		case <-done:
+			t.Fatal("Expected the second Publish to block")
+		case <-time.After(10 * time.Millisecond):
+		}
+		<-sub.Messages()
+		<-done
+		if got := (<-sub.Messages()).Payload; got != "1" {
+			t.Errorf("Expected 1, got %s", got)
+		}
+	})
+}
+
+// TestPubSubClose tests closing subscriptions and the broker
+func TestPubSubClose(t *testing.T) {
+	ps := NewPubSub(PubSubOptions{})
+	sub := ps.Subscribe("ch")
+	sub.Close()
+	sub.Close()
+	if _, ok := <-sub.Messages(); ok {
+		t.Error("Expected Close to close the channel")
+	}
+	if n := ps.Publish("ch", "x"); n != 0 {
+		t.Errorf("Expected no deliveries, got %d", n)
+	}
+
+	sub = ps.PSubscribe("*")
+	ps.Close()
+	if _, ok := <-sub.Messages(); ok {
+		t.Error("Expected PubSub.Close to close the channel")
+	}
+	sub = ps.Subscribe("ch")
+	if _, ok := <-sub.Messages(); ok {
+		t.Error("Expected a closed subscription after Close")
+	}
+}
@@ -0,0 +1,192 @@
+package kvcache
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// RemovalReason says why an entry left the cache
+type RemovalReason int
+
+// Removal reasons passed to OnRemoval listeners
+const (
+	RemovalDeleted  RemovalReason = iota // Delete, GetAndDelete, CompareAndDelete or a compute OpDelete
+	RemovalExpired                       // TTL and any grace period passed
+	RemovalEvicted                       // Made room under a capacity or cost limit, or refused admission (including a write over the cost budget)
+	RemovalReplaced                      // Overwritten by a write to the same key
+	RemovalCleared                       // Clear
+)
+
+// String returns the reason's name
+func (r RemovalReason) String() string {
+	switch r {
+	case RemovalDeleted:
+		return "deleted"
+	case RemovalExpired:
+		return "expired"
+	case RemovalEvicted:
+		return "evicted"
+	case RemovalReplaced:
+		return "replaced"
+	case RemovalCleared:
+		return "cleared"
+	default:
+		return fmt.Sprintf("RemovalReason(%d)", int(r))
+	}
+}
+
+// change is a write or removal made under a shard lock, delivered to the
+// removal listeners and the watcher once the lock is released
+type change[K comparable, V any] struct {
+	key    K
+	value  V // New value for a set, removed value otherwise
+	reason RemovalReason
+	set    bool  // A write rather than a removal
+	at     int64 // UnixNano when the change was made
+}
+
+// OnRemoval registers fn to be called once for every value that leaves the
+// cache, including values replaced by a later write. Listeners run after
+// the shard lock is released, so they may use the cache; by default they
+// run on the goroutine that made the change, or on a background goroutine
+// with Config.RemovalQueueSize set, unless its queue is full. Listeners
+// registered later do not see earlier removals.
+func (c *Cache[K, V]) OnRemoval(fn func(key K, value V, reason RemovalReason)) {
+	c.listenersMu.Lock()
+	defer c.listenersMu.Unlock()
+	var listeners []func(K, V, RemovalReason)
+	if old := c.listeners.Load(); old != nil {
+		listeners = append(listeners, *old...)
+	}
+	listeners = append(listeners, fn)
+	c.listeners.Store(&listeners)
+}
+
+// recordRemovalLocked queues a removed value for the listeners and the
+// watcher, if there are any. Must be called with shard.mutex held.
+func (c *Cache[K, V]) recordRemovalLocked(shard *shard[K, V], key K, value V, reason RemovalReason) {
+	if c.listeners.Load() != nil || c.watcher.Load() != nil {
+		shard.changes = append(shard.changes, change[K, V]{key: key, value: c.export(value), reason: reason, at: c.now()})
+	}
+}
+
+// recordSetLocked queues a write for the watcher, if there is one.
+// Must be called with shard.mutex held.
+func (c *Cache[K, V]) recordSetLocked(shard *shard[K, V], key K, value V) {
+	if c.watcher.Load() != nil {
+		shard.changes = append(shard.changes, change[K, V]{key: key, value: c.export(value), set: true, at: c.now()})
+	}
+}
+
+// watch makes fn receive every change, replacing any earlier watcher;
+// nil stops watching. It backs KVCache.Subscribe.
+func (c *Cache[K, V]) watch(fn func(change[K, V])) {
+	if fn == nil {
+		c.watcher.Store(nil)
+		return
+	}
+	c.watcher.Store(&fn)
+}
+
+// replacedReason is the reason reported for an entry overwritten by a
+// write: an expired entry that was not removed yet counts as expired
+func (c *Cache[K, V]) replacedReason(entry *CacheEntry[K, V]) RemovalReason {
+	if exp := atomic.LoadInt64(&entry.Expiration); exp > 0 && c.now() > exp {
+		return RemovalExpired
+	}
+	return RemovalReplaced
+}
+
+// unlock releases shard's write lock and then delivers the changes made
+// while it was held. Writers use it instead of shard.mutex.Unlock.
+//
+// The shard's delivery lock is taken before the shard lock is released, so
+// the changes of successive writers reach the watcher, and the listeners'
+// queue, in the order they were made. Listeners may use the cache, so the
+// removals not queued are passed to them after the delivery lock is
+// released, and are not ordered.
+func (c *Cache[K, V]) unlock(shard *shard[K, V]) {
+	changes := shard.changes
+	shard.changes = nil
+	if len(changes) == 0 {
+		shard.mutex.Unlock()
+		return
+	}
+	shard.delivery.Lock()
+	shard.mutex.Unlock()
+	c.publish(changes)
+	rest := c.enqueue(changes)
+	shard.delivery.Unlock()
+	for _, ch := range rest {
+		c.callListeners(ch)
+	}
+}
+
+// enqueue passes changes to the listeners' queue and returns those left
+// for the caller to deliver: all of them if there is no queue or it is
+// closed, and otherwise those from the first that found it full. The
+// caller delivering them rather than waiting for room means a listener
+// writing to the cache cannot block the goroutine that drains the queue.
+func (c *Cache[K, V]) enqueue(changes []change[K, V]) []change[K, V] {
+	if c.changes == nil || c.listeners.Load() == nil {
+		return changes
+	}
+	c.changesMu.RLock()
+	defer c.changesMu.RUnlock()
+	if c.changesClosed {
+		return changes
+	}
+	for i, ch := range changes {
+		if ch.set {
+			continue // Only the watcher wants writes
+		}
+		select {
+		case c.changes <- ch:
+		default:
+			return changes[i:]
+		}
+	}
+	return nil
+}
+
+// callListeners passes a removal to every listener
+func (c *Cache[K, V]) callListeners(ch change[K, V]) {
+	if ch.set {
+		return
+	}
+	if listeners := c.listeners.Load(); listeners != nil {
+		for _, fn := range *listeners {
+			fn(ch.key, ch.value, ch.reason)
+		}
+	}
+}
+
+// publish passes changes to the watcher, if there is one
+func (c *Cache[K, V]) publish(changes []change[K, V]) {
+	if watcher := c.watcher.Load(); watcher != nil {
+		for _, ch := range changes {
+			(*watcher)(ch)
+		}
+	}
+}
+
+// deliverChanges delivers queued changes until the queue is closed and
+// drained
+func (c *Cache[K, V]) deliverChanges() {
+	defer close(c.changesDone)
+	for ch := range c.changes {
+		c.callListeners(ch)
+	}
+}
+
+// closeChanges stops the delivery goroutine once it has drained the queue
+func (c *Cache[K, V]) closeChanges() {
+	if c.changes == nil {
+		return
+	}
+	c.changesMu.Lock()
+	c.changesClosed = true
+	close(c.changes)
+	c.changesMu.Unlock()
+	<-c.changesDone
+}
@@ -0,0 +1,218 @@
+package kvcache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// removalLog records listener calls
+type removalLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (l *removalLog) listen(key string, value int, reason RemovalReason) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, fmt.Sprintf("%s=%d %v", key, value, reason))
+}
+
+// take returns and resets the recorded calls
+func (l *removalLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	calls := l.calls
+	l.calls = nil
+	return calls
+}
+
+// TestOnRemoval tests that every removal path notifies exactly once
+func TestOnRemoval(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, int](Config{NumShards: 1, MaxCapacity: 3, Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+	var log removalLog
+	cache.OnRemoval(log.listen)
+
+	steps := []struct {
+		name string
+		op   func()
+		want []string
+	}{
+		{"set new", func() { cache.Set("a", 1) }, nil},
+		{"replace", func() { cache.Set("a", 2) }, []string{"a=1 replaced"}},
+		{"delete", func() { cache.Delete("a") }, []string{"a=2 deleted"}},
+		{"delete missing", func() { cache.Delete("a") }, nil},
+		{"lazy expiry", func() {
+			cache.Set("e", 1, time.Second)
+			clock.Advance(2 * time.Second)
+			cache.Get("e")
+			cache.Get("e")
+		}, []string{"e=1 expired"}},
+		{"write over expired", func() {
+			cache.Set("e", 1, time.Second)
+			clock.Advance(2 * time.Second)
+			cache.Set("e", 2)
+		}, []string{"e=1 expired"}},
+		{"evict", func() {
+			cache.Set("b", 1)
+			cache.Set("c", 1)
+			cache.Set("d", 1)
+		}, []string{"e=2 evicted"}},
+		{"get and delete", func() { cache.GetAndDelete("b") }, []string{"b=1 deleted"}},
+		{"compare and delete", func() {
+			_, version, _ := cache.GetWithVersion("c")
+			cache.CompareAndDelete("c", version)
+		}, []string{"c=1 deleted"}},
+		{"compute delete", func() {
+			cache.Compute("d", func(int, bool) (int, Op) { return 0, OpDelete })
+		}, []string{"d=1 deleted"}},
+		{"swap", func() {
+			cache.Set("s", 1)
+			cache.Swap("s", 2)
+		}, []string{"s=1 replaced"}},
+		{"clear", func() { cache.Clear() }, []string{"s=2 cleared"}},
+		{"clear with context", func() {
+			cache.Set("x", 1)
+			cache.ClearWithContext(context.Background())
+		}, []string{"x=1 cleared"}},
+	}
+	for _, step := range steps {
+		step.op()
+		if got := log.take(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: expected %v, got %v", step.name, step.want, got)
+		}
+	}
+}
+
+// TestOnRemovalCost tests removals made to stay within the cost budget
+func TestOnRemovalCost(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 1, MaxCost: 10, CleanupInterval: -1})
+	defer cache.Close()
+	var log removalLog
+	cache.OnRemoval(log.listen)
+
+	cache.SetWithCost("a", 1, 6)
+	cache.SetWithCost("b", 2, 6)
+	if got, want := log.take(), []string{"a=1 evicted"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	cache.SetWithCost("b", 3, 20)
+	if got, want := log.take(), []string{"b=2 evicted"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Over-budget write: expected %v, got %v", want, got)
+	}
+}
+
+// TestOnRemovalCleanup tests removals by the cleanup goroutine
+func TestOnRemovalCleanup(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, int](Config{NumShards: 4, Clock: clock, CleanupInterval: 5 * time.Millisecond})
+	defer cache.Close()
+	var log removalLog
+	cache.OnRemoval(log.listen)
+
+	cache.Set("a", 1, time.Second)
+	clock.Advance(2 * time.Second)
+	waitFor(t, func() bool { return cache.Size() == 0 })
+	if got, want := log.take(), []string{"a=1 expired"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestOnRemovalReentrant tests that listeners may use the cache
+func TestOnRemovalReentrant(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 1, CleanupInterval: -1})
+	defer cache.Close()
+	cache.OnRemoval(func(key string, value int, reason RemovalReason) {
+		if reason == RemovalDeleted {
+			cache.Set("tombstone:"+key, value)
+		}
+	})
+
+	cache.Set("a", 1)
+	cache.Delete("a")
+	if v, ok := cache.Get("tombstone:a"); !ok || v != 1 {
+		t.Errorf("Expected the listener's write, got %d, %v", v, ok)
+	}
+}
+
+// TestOnRemovalAsync tests delivery through the removal queue
+func TestOnRemovalAsync(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 4, RemovalQueueSize: 2, CleanupInterval: -1})
+	var log removalLog
+	release := make(chan struct{})
+	cache.OnRemoval(func(key string, value int, reason RemovalReason) {
+		<-release
+		log.listen(key, value, reason)
+	})
+
+	// Once the queue is full, writers call the listener themselves
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			cache.Set("k", i)
+		}
+	}()
+	close(release)
+	<-done
+	cache.Delete("k")
+
+	// Close delivers everything still queued
+	cache.Close()
+	calls := log.take()
+	sort.Strings(calls) // Listeners called by writers are not ordered
+	if len(calls) != 10 || calls[8] != "k=8 replaced" || calls[9] != "k=9 deleted" {
+		t.Errorf("Expected 9 replacements and a delete, got %v", calls)
+	}
+}
+
+// TestOnRemovalAsyncWrite tests that a queued listener writing to the
+// cache does not deadlock it once the queue is full
+func TestOnRemovalAsyncWrite(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 1, RemovalQueueSize: 1, CleanupInterval: -1})
+	var log removalLog
+	cache.OnRemoval(func(key string, value int, reason RemovalReason) {
+		if key == "a" {
+			for i := 0; i < 10; i++ {
+				cache.Set("b", i) // Each overwrite is another removal
+			}
+		}
+		log.listen(key, value, reason)
+	})
+
+	cache.Set("a", 1)
+	cache.Set("a", 2)
+	cache.Delete("a")
+
+	// a's removals each make nine or ten more
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		log.mu.Lock()
+		n := len(log.calls)
+		log.mu.Unlock()
+		if n == 21 {
+			cache.Close() // Left open on failure, since it would hang too
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("A listener writing to the cache deadlocked it after %d removals", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestRemovalReasonString tests reason names
+func TestRemovalReasonString(t *testing.T) {
+	if s := RemovalEvicted.String(); s != "evicted" {
+		t.Errorf("Expected evicted, got %s", s)
+	}
+	if s := RemovalReason(42).String(); s != "RemovalReason(42)" {
+		t.Errorf("Expected RemovalReason(42), got %s", s)
+	}
+}
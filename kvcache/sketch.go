@@ -0,0 +1,145 @@
+package kvcache
+
+// Count-min sketch parameters
+const (
+	sketchDepth      = 4  // Independent counter rows
+	sketchMaxCount   = 15 // Counters saturate like 4-bit counters
+	sketchSampleMult = 10 // Halve after this many increments per slot of capacity
+)
+
+// countMinSketch estimates key frequencies in fixed memory. Each key maps to
+// one counter per row and the estimate is the smallest of them, so hash
+// collisions can only overestimate.
+//
+// After sampleSize increments every counter is halved and the doorkeeper is
+// reset, so the estimate tracks recent popularity rather than all-time counts.
+type countMinSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+	door       doorkeeper
+}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := nextPowerOfTwo(max(capacity, 16))
+	s := &countMinSketch{
+		mask:       uint64(width - 1),
+		sampleSize: sketchSampleMult * max(capacity, 16),
+		door:       newDoorkeeper(width * 4),
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// increment records one occurrence of a key hash. The first occurrence only
+// sets the doorkeeper, keeping one-hit wonders out of the counters.
+func (s *countMinSketch) increment(h uint64) {
+	if !s.door.testAndSet(h) {
+		s.tick()
+		return
+	}
+	for i := range s.rows {
+		slot := &s.rows[i][s.index(h, i)]
+		if *slot < sketchMaxCount {
+			*slot++
+		}
+	}
+	s.tick()
+}
+
+// estimate returns the approximate recent frequency of a key hash
+func (s *countMinSketch) estimate(h uint64) int {
+	count := uint8(sketchMaxCount)
+	for i := range s.rows {
+		count = min(count, s.rows[i][s.index(h, i)])
+	}
+	if s.door.test(h) {
+		return int(count) + 1
+	}
+	return int(count)
+}
+
+// tick counts an addition and ages the sketch once the sample is full
+func (s *countMinSketch) tick() {
+	s.additions++
+	if s.additions < s.sampleSize {
+		return
+	}
+	s.additions = 0
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.door.reset()
+}
+
+// index derives row i's slot with double hashing
+func (s *countMinSketch) index(h uint64, i int) uint64 {
+	lo, hi := h&0xffffffff, h>>32
+	return (lo + uint64(i)*hi) & s.mask
+}
+
+// doorkeeper is a small bloom filter in front of the sketch
+type doorkeeper struct {
+	bits []uint64
+	mask uint64
+}
+
+func newDoorkeeper(bits int) doorkeeper {
+	bits = nextPowerOfTwo(max(bits, 64))
+	return doorkeeper{bits: make([]uint64, bits/64), mask: uint64(bits - 1)}
+}
+
+// test reports whether the hash may have been seen
+func (d *doorkeeper) test(h uint64) bool {
+	for i := uint64(0); i < 3; i++ {
+		bit := (h + i*(h>>32|1)) & d.mask
+		if d.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// testAndSet sets the hash's bits and reports whether all were already set
+func (d *doorkeeper) testAndSet(h uint64) bool {
+	seen := true
+	for i := uint64(0); i < 3; i++ {
+		bit := (h + i*(h>>32|1)) & d.mask
+		word, flag := &d.bits[bit/64], uint64(1)<<(bit%64)
+		if *word&flag == 0 {
+			seen = false
+			*word |= flag
+		}
+	}
+	return seen
+}
+
+func (d *doorkeeper) reset() {
+	for i := range d.bits {
+		d.bits[i] = 0
+	}
+}
+
+// nextPowerOfTwo rounds n up to a power of two
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// spreadHash widens a 32-bit key hash into 64 well-mixed bits (splitmix64),
+// so keys that share a shard, and therefore their low hash bits, still
+// spread across the sketch
+func spreadHash(h uint32) uint64 {
+	z := uint64(h) + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
@@ -0,0 +1,338 @@
+package kvcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// Snapshot format, version 1. Integers are varints unless noted.
+//
+//	header:  magic "KVCSNAP\x00", version (uint16 big endian)
+//	record:  tag 1, key length, key, value length, value,
+//	         expiration (UnixNano, 0 = never), cost
+//	trailer: tag 0, record count, CRC-32C of all preceding bytes
+//	         (uint32 big endian)
+const (
+	snapshotMagic   = "KVCSNAP\x00"
+	snapshotVersion = 1
+
+	snapshotTagEnd    = 0
+	snapshotTagRecord = 1
+
+	// maxSnapshotField bounds a key or value so a corrupt length cannot
+	// trigger a huge allocation
+	maxSnapshotField = 1 << 30
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// snapshotRecord is one entry copied out of a shard
+type snapshotRecord[K comparable, V any] struct {
+	key        K
+	value      V
+	expiration int64
+	cost       int64
+}
+
+// snapshotLocked returns value as it is now, copying values that are
+// changed in place, so it can be encoded after the shard lock is released.
+// Must be called with the shard lock held.
+func (c *Cache[K, V]) snapshotLocked(value V) V {
+	if c.cloneValue != nil {
+		return c.cloneValue(value)
+	}
+	return value
+}
+
+// export returns value as handed to callers, which get a KVCache list as a
+// []interface{} copy of its elements rather than the list itself
+func (c *Cache[K, V]) export(value V) V {
+	if c.exportValue != nil {
+		return c.exportValue(value)
+	}
+	return value
+}
+
+// SaveSnapshot writes every live entry to w in a versioned, checksummed
+// binary format, encoding keys and values with Config.Codec. Shards are
+// copied one at a time under a read lock and encoded after it is released,
+// so writers are only blocked while their shard is copied. Entries written
+// concurrently may or may not be included.
+func (c *Cache[K, V]) SaveSnapshot(w io.Writer) error {
+	sw := &snapshotWriter{w: bufio.NewWriter(w)}
+	sw.write([]byte(snapshotMagic))
+	sw.write(binary.BigEndian.AppendUint16(nil, snapshotVersion))
+
+	var records []snapshotRecord[K, V]
+	count := 0
+	for _, shard := range c.shards {
+		now := c.now()
+		shard.mutex.RLock()
+		for key, entry := range shard.store {
+			exp := atomic.LoadInt64(&entry.Expiration)
+			if exp > 0 && now > exp {
+				continue
+			}
+			records = append(records, snapshotRecord[K, V]{key, c.snapshotLocked(entry.Value), exp, entry.cost})
+		}
+		shard.mutex.RUnlock()
+
+		for i := range records {
+			if err := c.writeRecord(sw, &records[i]); err != nil {
+				return err
+			}
+			records[i] = snapshotRecord[K, V]{} // Don't pin values
+			count++
+		}
+		records = records[:0]
+	}
+
+	sw.writeByte(snapshotTagEnd)
+	sw.writeUvarint(uint64(count))
+	sw.write(binary.BigEndian.AppendUint32(nil, sw.crc))
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.w.Flush()
+}
+
+// writeRecord encodes one entry
+func (c *Cache[K, V]) writeRecord(sw *snapshotWriter, rec *snapshotRecord[K, V]) error {
+	key, err := c.codec.Encode(&rec.key)
+	if err != nil {
+		return fmt.Errorf("kvcache: encoding key %v: %w", rec.key, err)
+	}
+	value, err := c.codec.Encode(&rec.value)
+	if err != nil {
+		return fmt.Errorf("kvcache: encoding value for key %v: %w", rec.key, err)
+	}
+	sw.writeByte(snapshotTagRecord)
+	sw.writeUvarint(uint64(len(key)))
+	sw.write(key)
+	sw.writeUvarint(uint64(len(value)))
+	sw.write(value)
+	sw.writeVarint(rec.expiration)
+	sw.writeVarint(rec.cost)
+	return sw.err
+}
+
+// LoadSnapshot reads a snapshot written by SaveSnapshot and adds its entries
+// to the cache, replacing existing values for the same keys. Entries keep
+// their original expiration and cost; those that have expired since are
+// skipped. The whole snapshot is verified before anything is stored, so a
+// corrupt snapshot returns an error wrapping ErrInvalidSnapshot and leaves
+// the cache unchanged.
+func (c *Cache[K, V]) LoadSnapshot(r io.Reader) error {
+	records, err := c.readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	for i := range records {
+		rec := &records[i]
+		if rec.expiration > 0 && c.now() > rec.expiration {
+			continue
+		}
+		shard := c.getShard(rec.key)
+		shard.mutex.Lock()
+		c.setLocked(shard, rec.key, rec.value, rec.expiration, rec.cost)
+		c.unlock(shard)
+		c.shed()
+	}
+	return nil
+}
+
+// readSnapshot decodes and verifies a whole snapshot
+func (c *Cache[K, V]) readSnapshot(r io.Reader) ([]snapshotRecord[K, V], error) {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+
+	header := make([]byte, len(snapshotMagic)+2)
+	if err := sr.readFull(header); err != nil {
+		return nil, snapshotError(err)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalidSnapshot)
+	}
+	if v := binary.BigEndian.Uint16(header[len(snapshotMagic):]); v != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, v)
+	}
+
+	var records []snapshotRecord[K, V]
+	for {
+		tag, err := sr.ReadByte()
+		if err != nil {
+			return nil, snapshotError(err)
+		}
+		if tag == snapshotTagEnd {
+			break
+		}
+		if tag != snapshotTagRecord {
+			return nil, fmt.Errorf("%w: unknown record tag %d", ErrInvalidSnapshot, tag)
+		}
+		rec, err := c.readRecord(sr)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+
+	count, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, snapshotError(err)
+	}
+	want := sr.crc
+	sum := make([]byte, 4)
+	if err := sr.readFull(sum); err != nil {
+		return nil, snapshotError(err)
+	}
+	if binary.BigEndian.Uint32(sum) != want {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshot)
+	}
+	if count != uint64(len(records)) {
+		return nil, fmt.Errorf("%w: expected %d records, read %d", ErrInvalidSnapshot, count, len(records))
+	}
+	return records, nil
+}
+
+// readRecord decodes one entry following its tag
+func (c *Cache[K, V]) readRecord(sr *snapshotReader) (snapshotRecord[K, V], error) {
+	var rec snapshotRecord[K, V]
+	key, err := sr.readField()
+	if err != nil {
+		return rec, err
+	}
+	value, err := sr.readField()
+	if err != nil {
+		return rec, err
+	}
+	if rec.expiration, err = binary.ReadVarint(sr); err != nil {
+		return rec, snapshotError(err)
+	}
+	if rec.cost, err = binary.ReadVarint(sr); err != nil {
+		return rec, snapshotError(err)
+	}
+	if err := c.codec.Decode(key, &rec.key); err != nil {
+		return rec, fmt.Errorf("%w: decoding key: %w", ErrInvalidSnapshot, err)
+	}
+	if err := c.codec.Decode(value, &rec.value); err != nil {
+		return rec, fmt.Errorf("%w: decoding value for key %v: %w", ErrInvalidSnapshot, rec.key, err)
+	}
+	return rec, nil
+}
+
+// SaveSnapshotFile writes a snapshot to path atomically: it is written to a
+// temporary file in the same directory, synced, and renamed over path.
+func (c *Cache[K, V]) SaveSnapshotFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	err = c.SaveSnapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadSnapshotFile loads a snapshot written by SaveSnapshotFile
+func (c *Cache[K, V]) LoadSnapshotFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.LoadSnapshot(f)
+}
+
+// snapshotError reports a short read as a truncated snapshot
+func snapshotError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: truncated", ErrInvalidSnapshot)
+	}
+	return err
+}
+
+// snapshotWriter checksums everything it writes and remembers the first error
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc uint32
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	sw.crc = crc32.Update(sw.crc, castagnoli, p)
+	_, sw.err = sw.w.Write(p)
+}
+
+func (sw *snapshotWriter) writeByte(b byte) {
+	sw.buf[0] = b
+	sw.write(sw.buf[:1])
+}
+
+func (sw *snapshotWriter) writeUvarint(x uint64) {
+	sw.write(sw.buf[:binary.PutUvarint(sw.buf[:], x)])
+}
+
+func (sw *snapshotWriter) writeVarint(x int64) {
+	sw.write(sw.buf[:binary.PutVarint(sw.buf[:], x)])
+}
+
+// snapshotReader checksums everything it reads
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc uint32
+	one [1]byte
+}
+
+// ReadByte implements io.ByteReader for the varint decoders
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, err := sr.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	sr.one[0] = b
+	sr.crc = crc32.Update(sr.crc, castagnoli, sr.one[:])
+	return b, nil
+}
+
+func (sr *snapshotReader) readFull(p []byte) error {
+	if _, err := io.ReadFull(sr.r, p); err != nil {
+		return err
+	}
+	sr.crc = crc32.Update(sr.crc, castagnoli, p)
+	return nil
+}
+
+// readField reads a length-prefixed byte string
+func (sr *snapshotReader) readField() ([]byte, error) {
+	n, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, snapshotError(err)
+	}
+	if n > maxSnapshotField {
+		return nil, fmt.Errorf("%w: field length %d too large", ErrInvalidSnapshot, n)
+	}
+	p := make([]byte, n)
+	if err := sr.readFull(p); err != nil {
+		return nil, snapshotError(err)
+	}
+	return p, nil
+}
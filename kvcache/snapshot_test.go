@@ -0,0 +1,282 @@
+package kvcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type snapshotUser struct {
+	Name string
+	Age  int
+}
+
+func init() {
+	gob.Register(snapshotUser{})
+}
+
+// TestSnapshotRoundTrip tests saving and loading keys, values and expirations
+func TestSnapshotRoundTrip(t *testing.T) {
+	clock := newFakeClock()
+	cfg := Config{NumShards: 4, CleanupInterval: -1, Clock: clock}
+	src := NewWithConfig[string, int](cfg)
+	defer src.Close()
+
+	for i := 0; i < 100; i++ {
+		src.Set(fmt.Sprint("key", i), i)
+	}
+	src.Set("short", 1, time.Second)
+	src.Set("long", 2, time.Hour)
+	src.Set("gone", 3, time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewWithConfig[string, int](cfg)
+	defer dst.Close()
+	if err := dst.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Size() != 102 {
+		t.Errorf("Expected 102 entries, got %d", dst.Size())
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := dst.Get(fmt.Sprint("key", i)); !ok || v != i {
+			t.Fatalf("key%d = %v, %v", i, v, ok)
+		}
+	}
+	if _, ok := dst.Get("gone"); ok {
+		t.Error("Expired entries should not be saved")
+	}
+
+	// Expirations are absolute, so time spent on disk counts
+	clock.Advance(2 * time.Second)
+	if _, ok := dst.Get("short"); ok {
+		t.Error("Expected short to expire on schedule")
+	}
+	if _, ok := dst.Get("long"); !ok {
+		t.Error("Expected long to survive")
+	}
+
+	// Loading after more time has passed skips what expired meanwhile
+	late := NewWithConfig[string, int](cfg)
+	defer late.Close()
+	if err := late.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if late.Size() != 101 {
+		t.Errorf("Expected 101 entries, got %d", late.Size())
+	}
+}
+
+// TestSnapshotInterfaceValues tests KVCache values of mixed types
+func TestSnapshotInterfaceValues(t *testing.T) {
+	src := NewKVCache(0)
+	defer src.Close()
+	src.Set("s", "text")
+	src.Set("n", 42)
+	src.Set("b", []byte{1, 2})
+	src.Set("u", snapshotUser{"Ann", 30})
+
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewKVCache(0)
+	defer dst.Close()
+	if err := dst.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]interface{}{
+		"s": "text",
+		"n": 42,
+		"b": []byte{1, 2},
+		"u": snapshotUser{"Ann", 30},
+	} {
+		got, _ := dst.Get(key)
+		if fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", want) {
+			t.Errorf("%s = %#v, want %#v", key, got, want)
+		}
+	}
+}
+
+// TestSnapshotUnregisteredType tests that encoding errors are reported
+func TestSnapshotUnregisteredType(t *testing.T) {
+	type unregistered struct{ X int }
+	cache := NewKVCache(0)
+	defer cache.Close()
+	cache.Set("x", unregistered{1})
+
+	if err := cache.SaveSnapshot(&bytes.Buffer{}); err == nil {
+		t.Error("Expected an error for an unregistered interface type")
+	}
+}
+
+// TestSnapshotJSONCodec tests a non-default codec
+func TestSnapshotJSONCodec(t *testing.T) {
+	cfg := Config{Codec: JSONCodec{}}
+	src := NewWithConfig[int, snapshotUser](cfg)
+	defer src.Close()
+	src.Set(7, snapshotUser{"Bo", 5})
+
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewWithConfig[int, snapshotUser](cfg)
+	defer dst.Close()
+	if err := dst.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.Get(7); v != (snapshotUser{"Bo", 5}) {
+		t.Errorf("Unexpected value %+v", v)
+	}
+}
+
+// TestSnapshotPreservesCost tests that explicit costs survive a round trip
+func TestSnapshotPreservesCost(t *testing.T) {
+	src := New[string, string](0)
+	defer src.Close()
+	src.SetWithCost("a", "x", 100)
+
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewWithConfig[string, string](Config{MaxCost: 1000})
+	defer dst.Close()
+	if err := dst.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if cost := dst.Stats().Cost; cost != 100 {
+		t.Errorf("Expected cost 100, got %d", cost)
+	}
+}
+
+// TestSnapshotCorruption tests that damaged snapshots are rejected whole
+func TestSnapshotCorruption(t *testing.T) {
+	src := New[string, string](0)
+	defer src.Close()
+	for i := 0; i < 20; i++ {
+		src.Set(fmt.Sprint(i), "value")
+	}
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+
+	flipped := append([]byte(nil), good...)
+	flipped[len(flipped)/2] ^= 0xff
+	badVersion := append([]byte(nil), good...)
+	badVersion[len(snapshotMagic)+1] = 99
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"magic":     []byte("not a snapshot at all"),
+		"version":   badVersion,
+		"flipped":   flipped,
+		"truncated": good[:len(good)-3],
+		"no end":    good[:len(good)/2],
+	} {
+		t.Run(name, func(t *testing.T) {
+			dst := New[string, string](0)
+			defer dst.Close()
+			dst.Set("existing", "kept")
+
+			err := dst.LoadSnapshot(bytes.NewReader(data))
+			if !errors.Is(err, ErrInvalidSnapshot) {
+				t.Errorf("Expected ErrInvalidSnapshot, got %v", err)
+			}
+			if dst.Size() != 1 {
+				t.Errorf("Cache should be unchanged, has %d entries", dst.Size())
+			}
+		})
+	}
+}
+
+// TestSnapshotFile tests the file helpers
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+
+	src := New[string, int](0)
+	defer src.Close()
+	src.Set("a", 1)
+	if err := src.SaveSnapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+	src.Set("b", 2)
+	if err := src.SaveSnapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the snapshot file, found %d files", len(entries))
+	}
+
+	dst := New[string, int](0)
+	defer dst.Close()
+	if err := dst.LoadSnapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Size() != 2 {
+		t.Errorf("Expected 2 entries, got %d", dst.Size())
+	}
+	if err := dst.LoadSnapshotFile(path + ".missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+}
+
+// TestSnapshotConcurrentWrites tests saving while the cache is written
+func TestSnapshotConcurrentWrites(t *testing.T) {
+	cache := NewWithConfig[int, int](Config{NumShards: 8, CleanupInterval: -1})
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				cache.Set(1000+i%500, i)
+				cache.Delete(i % 1000)
+				cache.Set(i%1000, i%1000)
+			}
+		}
+	}()
+
+	var buf bytes.Buffer
+	err := cache.SaveSnapshot(&buf)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := New[int, int](0)
+	defer dst.Close()
+	if err := dst.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Size() < 900 {
+		t.Errorf("Expected most entries to be saved, got %d", dst.Size())
+	}
+}
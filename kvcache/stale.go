@@ -0,0 +1,49 @@
+package kvcache
+
+// With Config.GracePeriod set, an entry outlives its expiration by the
+// grace period. During it the entry is stale: Get and the other reads treat
+// it as missing, but GetStale still returns it, and a LoadingCache serves it
+// while it revalidates. Lazy removal and the cleanup goroutine only remove
+// entries once the grace period has also passed.
+
+// pastGrace reports whether an entry with the given expiration is due for
+// removal at now
+func (c *Cache[K, V]) pastGrace(expiration, now int64) bool {
+	return expiration > 0 && now > expiration+c.grace
+}
+
+// GetStale retrieves a value by key like Get, but also returns values that
+// have expired within the grace period, reporting them as stale. Only fresh
+// values count as hits in the metrics.
+func (c *Cache[K, V]) GetStale(key K) (value V, stale bool, ok bool) {
+	value, _, stale, ok = c.getStale(key)
+	return value, stale, ok
+}
+
+// getStale is get for fresh or stale values
+func (c *Cache[K, V]) getStale(key K) (value V, meta entryMeta, stale bool, ok bool) {
+	shard := c.getShard(key)
+	now := c.now()
+	shard.mutex.RLock()
+	entry, exists := shard.store[key]
+	if exists {
+		meta = metaOf(entry)
+		ok = !c.pastGrace(meta.expiration, now)
+		if ok {
+			value = c.export(entry.Value)
+			stale = meta.expiration > 0 && now > meta.expiration
+		}
+	}
+	shard.mutex.RUnlock()
+
+	if exists && !ok {
+		shard.mutex.Lock()
+		c.removeExpiredLocked(shard, key)
+		c.unlock(shard)
+	}
+	if ok {
+		c.recordAccess(shard, key)
+	}
+	c.recordLookup(lookupResultOf(ok && !stale))
+	return value, meta, stale, ok
+}
@@ -0,0 +1,67 @@
+package kvcache
+
+import (
+	"testing"
+	"time"
+)
+
+// TestGetStale tests reading entries within the grace period
+func TestGetStale(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, int](Config{NumShards: 4, Clock: clock, GracePeriod: time.Minute, CleanupInterval: -1})
+	defer cache.Close()
+
+	cache.Set("k", 1, time.Second)
+	if v, stale, ok := cache.GetStale("k"); !ok || stale || v != 1 {
+		t.Errorf("Expected a fresh 1, got %d, %v, %v", v, stale, ok)
+	}
+
+	clock.Advance(30 * time.Second)
+	if _, ok := cache.Get("k"); ok {
+		t.Error("Get should treat a stale entry as missing")
+	}
+	if v, stale, ok := cache.GetStale("k"); !ok || !stale || v != 1 {
+		t.Errorf("Expected a stale 1, got %d, %v, %v", v, stale, ok)
+	}
+	if cache.Size() != 1 {
+		t.Error("Stale entry should be kept during the grace period")
+	}
+
+	// Writes replace a stale entry like a missing one
+	if !cache.SetNX("k", 2, time.Second) {
+		t.Error("SetNX should store over a stale entry")
+	}
+	if v, stale, _ := cache.GetStale("k"); stale || v != 2 {
+		t.Errorf("Expected a fresh 2, got %d, %v", v, stale)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, _, ok := cache.GetStale("k"); ok {
+		t.Error("Entry should be gone after the grace period")
+	}
+	if cache.Size() != 0 {
+		t.Error("Expected the entry to be removed after the grace period")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 3 {
+		t.Errorf("Expected 2 hits and 3 misses, got %+v", stats)
+	}
+}
+
+// TestGracePeriodCleanup tests that the sweep waits for the grace period
+func TestGracePeriodCleanup(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, int](Config{NumShards: 4, Clock: clock, GracePeriod: time.Minute, CleanupInterval: 5 * time.Millisecond})
+	defer cache.Close()
+
+	cache.Set("k", 1, time.Second)
+	clock.Advance(30 * time.Second)
+	time.Sleep(20 * time.Millisecond)
+	if _, _, ok := cache.GetStale("k"); !ok {
+		t.Fatal("Cleanup should keep entries within the grace period")
+	}
+
+	clock.Advance(time.Minute)
+	waitFor(t, func() bool { return cache.Size() == 0 })
+}
@@ -0,0 +1,1019 @@
+package kvcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store is a backing store, such as a database, fronted by a KVCache
+// created with NewKVCacheWithStore. Load and LoadBatch report missing keys
+// with ErrNotFound and by leaving them out of the result, respectively.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	Load(ctx context.Context, key string) (interface{}, error)
+	LoadBatch(ctx context.Context, keys []string) (map[string]interface{}, error)
+	Store(ctx context.Context, key string, value interface{}) error
+	StoreBatch(ctx context.Context, entries map[string]interface{}) error
+	Delete(ctx context.Context, key string) error
+	DeleteBatch(ctx context.Context, keys []string) error
+}
+
+// WriteMode selects how writes reach the backing Store
+type WriteMode int
+
+// Write modes
+const (
+	WriteThrough WriteMode = iota // Write the store before the cache (default)
+	WriteBehind                   // Write the cache and queue the store write
+)
+
+// String returns the mode's name
+func (m WriteMode) String() string {
+	switch m {
+	case WriteThrough:
+		return "write-through"
+	case WriteBehind:
+		return "write-behind"
+	default:
+		return fmt.Sprintf("WriteMode(%d)", int(m))
+	}
+}
+
+// Default write-behind settings
+const (
+	DefaultWriteBehindBatchSize  = 100
+	DefaultWriteBehindInterval   = time.Second
+	DefaultWriteBehindMaxPending = 10000
+	DefaultStoreRetries          = 3
+	DefaultStoreRetryBackoff     = 100 * time.Millisecond
+)
+
+// StoreOptions configures how a KVCache writes to its Store
+type StoreOptions struct {
+	// Mode selects write-through or write-behind. The zero value is
+	// WriteThrough.
+	Mode WriteMode
+
+	// BatchSize is the number of queued keys that triggers a write-behind
+	// flush, and the most written per batch call.
+	// Zero means DefaultWriteBehindBatchSize.
+	BatchSize int
+
+	// FlushInterval is how often queued writes are flushed when fewer than
+	// BatchSize are waiting. Zero means DefaultWriteBehindInterval.
+	FlushInterval time.Duration
+
+	// MaxPending bounds the keys queued for write-behind; writers block
+	// while it is full. Zero means DefaultWriteBehindMaxPending.
+	MaxPending int
+
+	// MaxRetries is how often a failed write-behind batch is retried,
+	// doubling RetryBackoff each time, before it is requeued for the next
+	// flush. Zero means DefaultStoreRetries; negative disables retries.
+	MaxRetries int
+
+	// RetryBackoff is the wait before the first retry.
+	// Zero means DefaultStoreRetryBackoff.
+	RetryBackoff time.Duration
+
+	// OnError is called with a *StoreError for loads and writes that
+	// failed without a caller to return the error to: those of methods
+	// without an error result, such as Get, Set and SetNX, and write-behind
+	// batches that exhausted their retries. It may be called concurrently.
+	OnError func(err error)
+}
+
+// validate reports whether the options can be used
+func (o StoreOptions) validate() error {
+	if o.Mode < WriteThrough || o.Mode > WriteBehind {
+		return fmt.Errorf("%w: unknown write mode %d", ErrInvalidConfig, o.Mode)
+	}
+	if o.BatchSize < 0 || o.MaxPending < 0 {
+		return fmt.Errorf("%w: write-behind sizes must not be negative", ErrInvalidConfig)
+	}
+	if o.FlushInterval < 0 || o.RetryBackoff < 0 {
+		return fmt.Errorf("%w: write-behind intervals must not be negative", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// withDefaults fills in zero-valued settings
+func (o StoreOptions) withDefaults() StoreOptions {
+	if o.BatchSize == 0 {
+		o.BatchSize = DefaultWriteBehindBatchSize
+	}
+	if o.FlushInterval == 0 {
+		o.FlushInterval = DefaultWriteBehindInterval
+	}
+	if o.MaxPending == 0 {
+		o.MaxPending = DefaultWriteBehindMaxPending
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = DefaultStoreRetries
+	}
+	if o.RetryBackoff == 0 {
+		o.RetryBackoff = DefaultStoreRetryBackoff
+	}
+	return o
+}
+
+// StoreError reports a failed load from or write to the backing Store
+type StoreError struct {
+	Op   string   // "load", "store" or "delete"
+	Keys []string // Keys whose load or write failed
+	Err  error    // Error from the Store
+}
+
+// Error implements the error interface
+func (e *StoreError) Error() string {
+	if len(e.Keys) == 1 {
+		return fmt.Sprintf("kvcache: store %s %q: %v", e.Op, e.Keys[0], e.Err)
+	}
+	return fmt.Sprintf("kvcache: store %s of %d keys: %v", e.Op, len(e.Keys), e.Err)
+}
+
+// Unwrap returns the Store's error
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// NewKVCacheWithStore creates a cache in front of store. Every write to a
+// key's value, from Set to Compute, CompareAndSwap and Incr, is written to
+// the store as opts.Mode selects, and reads of a single key or of several
+// keys load missing keys from it. Clear, LoadSnapshot, evictions and
+// expirations change only the cache, as do TTL changes, which the store
+// does not hold. Lists are not supported: LPush and RPush return
+// ErrUnsupported. It panics if the configuration or options are invalid or
+// store is nil.
+func NewKVCacheWithStore(cfg Config, store Store, opts StoreOptions) *KVCache {
+	if store == nil {
+		panic("kvcache: nil Store")
+	}
+	if err := opts.validate(); err != nil {
+		panic(err)
+	}
+	opts = opts.withDefaults()
+
+	b := &backend{store: store, opts: opts}
+	if opts.Mode == WriteBehind {
+		b.behind = newWriteBehind(store, opts)
+	}
+	return &KVCache{Cache: NewWithConfig[string, interface{}](cfg), backend: b}
+}
+
+// backend holds a KVCache's Store. Writes to a key and loads that fill it
+// hold the key's stripe lock, so the cache and the store (or its queue) see
+// them in the same order.
+type backend struct {
+	store  Store
+	opts   StoreOptions
+	behind *writeBehind // Nil for write-through
+	locks  [256]sync.Mutex
+}
+
+// lock acquires the stripe locks for keys in a fixed order and returns a
+// function that releases them
+func (b *backend) lock(keys ...string) func() {
+	stripes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		i := int(fnv32a(key) % uint32(len(b.locks)))
+		if !seen[i] {
+			seen[i] = true
+			stripes = append(stripes, i)
+		}
+	}
+	sort.Ints(stripes)
+	for _, i := range stripes {
+		b.locks[i].Lock()
+	}
+	return func() {
+		for _, i := range stripes {
+			b.locks[i].Unlock()
+		}
+	}
+}
+
+// report passes an error without a caller to OnError
+func (b *backend) report(err error) {
+	if err != nil && b.opts.OnError != nil {
+		b.opts.OnError(err)
+	}
+}
+
+// persistLocked writes key's new value to the Store, or queues it, or
+// deletes key there if deleted is set. A value that could not be written
+// or queued is removed from the cache, so it never holds a value the store
+// lacks. Must be called with the key's stripe lock held.
+func (c *KVCache) persistLocked(ctx context.Context, key string, value interface{}, deleted bool) error {
+	b := c.backend
+	if b.behind != nil {
+		err := b.behind.enqueue(ctx, map[string]pendingWrite{key: {value: value, deleted: deleted}})
+		if err != nil && !deleted {
+			c.Cache.Delete(key)
+		}
+		return err
+	}
+	if deleted {
+		if err := b.store.Delete(ctx, key); err != nil {
+			return &StoreError{Op: "delete", Keys: []string{key}, Err: err}
+		}
+		return nil
+	}
+	if err := b.store.Store(ctx, key, value); err != nil {
+		// The store may or may not hold the new value now
+		c.Cache.Delete(key)
+		return &StoreError{Op: "store", Keys: []string{key}, Err: err}
+	}
+	return nil
+}
+
+// update makes a write to key's cache entry and then to the Store, holding
+// the key's stripe lock so both are atomic with respect to every other
+// write through the cache. A key missing from the cache is loaded first,
+// so conditions such as SetNX's see the store's value. apply writes the
+// cache and returns what the store should now hold, and whether anything
+// changed.
+func (c *KVCache) update(ctx context.Context, key string, apply func() (value interface{}, deleted, changed bool)) error {
+	unlock := c.backend.lock(key)
+	defer unlock()
+	if _, err := c.fillLocked(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	value, deleted, changed := apply()
+	if !changed {
+		return nil
+	}
+	return c.persistLocked(ctx, key, value, deleted)
+}
+
+// fill loads key into the cache from the Store if it is missing, for reads
+// that have no error to return
+func (c *KVCache) fill(key string) {
+	b := c.backend
+	if b == nil || c.Cache.Contains(key) {
+		return
+	}
+	unlock := b.lock(key)
+	defer unlock()
+	if _, err := c.fillLocked(context.Background(), key); err != nil && !errors.Is(err, ErrNotFound) {
+		b.report(err)
+	}
+}
+
+// Set adds or updates a key-value pair with optional custom TTL, writing it
+// to the Store if the cache has one. A failed write-through leaves the key
+// out of the cache and is reported to StoreOptions.OnError.
+func (c *KVCache) Set(key string, value interface{}, ttl ...time.Duration) {
+	if c.backend == nil {
+		c.Cache.Set(key, value, ttl...)
+		return
+	}
+	c.backend.report(c.SetWithContext(context.Background(), key, value, ttl...))
+}
+
+// SetWithContext is Set returning the Store's error, as a *StoreError,
+// instead of reporting it
+func (c *KVCache) SetWithContext(ctx context.Context, key string, value interface{}, ttl ...time.Duration) error {
+	b := c.backend
+	if b == nil {
+		return c.Cache.SetWithContext(ctx, key, value, ttl...)
+	}
+	if err := c.checkContext(ctx); err != nil {
+		return err
+	}
+	unlock := b.lock(key)
+	defer unlock()
+
+	if b.behind != nil {
+		if err := c.Cache.SetWithContext(ctx, key, value, ttl...); err != nil {
+			return err
+		}
+		return c.persistLocked(ctx, key, value, false)
+	}
+	if err := b.store.Store(ctx, key, value); err != nil {
+		// The store may or may not hold the new value now
+		c.Cache.Delete(key)
+		return &StoreError{Op: "store", Keys: []string{key}, Err: err}
+	}
+	// The store has the value now, so the cache must follow even if ctx
+	// is done
+	c.Cache.Set(key, value, ttl...)
+	return nil
+}
+
+// SetMulti sets multiple key-value pairs, writing them to the Store in one
+// batch if the cache has one. Failures are reported as for Set.
+func (c *KVCache) SetMulti(entries map[string]interface{}, ttl ...time.Duration) {
+	if c.backend == nil {
+		c.Cache.SetMulti(entries, ttl...)
+		return
+	}
+	c.backend.report(c.SetMultiWithContext(context.Background(), entries, ttl...))
+}
+
+// SetMultiWithContext is SetMulti returning the Store's error. With a
+// write-through Store nothing is cached unless the whole batch is stored.
+func (c *KVCache) SetMultiWithContext(ctx context.Context, entries map[string]interface{}, ttl ...time.Duration) error {
+	b := c.backend
+	if b == nil {
+		return c.Cache.SetMultiWithContext(ctx, entries, ttl...)
+	}
+	if err := c.checkContext(ctx); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	unlock := b.lock(keys...)
+	defer unlock()
+
+	if b.behind != nil {
+		if err := c.Cache.SetMultiWithContext(ctx, entries, ttl...); err != nil {
+			return err
+		}
+		writes := make(map[string]pendingWrite, len(entries))
+		for key, value := range entries {
+			writes[key] = pendingWrite{value: value}
+		}
+		if err := b.behind.enqueue(ctx, writes); err != nil {
+			for _, key := range keys {
+				c.Cache.Delete(key)
+			}
+			return err
+		}
+		return nil
+	}
+	if err := b.store.StoreBatch(ctx, entries); err != nil {
+		for _, key := range keys {
+			c.Cache.Delete(key)
+		}
+		return &StoreError{Op: "store", Keys: keys, Err: err}
+	}
+	c.Cache.SetMulti(entries, ttl...) // Regardless of ctx, as in SetWithContext
+	return nil
+}
+
+// Delete removes a key-value pair, and deletes it from the Store if the
+// cache has one. Failures are reported as for Set.
+func (c *KVCache) Delete(key string) {
+	if c.backend == nil {
+		c.Cache.Delete(key)
+		return
+	}
+	c.backend.report(c.DeleteWithContext(context.Background(), key))
+}
+
+// DeleteWithContext is Delete returning the Store's error. Without a Store
+// it returns ErrNotFound if the key was not cached; with one the key is
+// deleted from the store either way and only its error is returned.
+func (c *KVCache) DeleteWithContext(ctx context.Context, key string) error {
+	b := c.backend
+	if b == nil {
+		return c.Cache.DeleteWithContext(ctx, key)
+	}
+	if err := c.checkContext(ctx); err != nil {
+		return err
+	}
+	unlock := b.lock(key)
+	defer unlock()
+
+	// The cached value goes first, so a failure cannot leave it cached
+	if err := c.Cache.DeleteWithContext(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return c.persistLocked(ctx, key, nil, true)
+}
+
+// The methods below add a Store to the Cache methods of the same name.
+// The check and the write go through update, so a key held only by the
+// store counts as present. Store errors are reported to OnError, and a
+// write the store failed is reported as not made. Methods that report
+// whether they stored a value write the store only if the cache took it;
+// the others, like Set, write it even if its cost is over budget.
+
+// SetWithCost stores value with an explicit cost, as Cache.SetWithCost
+func (c *KVCache) SetWithCost(key string, value interface{}, cost int64, ttl ...time.Duration) bool {
+	if c.backend == nil {
+		return c.Cache.SetWithCost(key, value, cost, ttl...)
+	}
+	var stored bool
+	err := c.update(context.Background(), key, func() (interface{}, bool, bool) {
+		stored = c.Cache.SetWithCost(key, value, cost, ttl...)
+		return value, false, stored
+	})
+	c.backend.report(err)
+	return stored && err == nil
+}
+
+// SetNX stores value only if key is missing, and reports whether it did
+func (c *KVCache) SetNX(key string, value interface{}, ttl ...time.Duration) bool {
+	if c.backend == nil {
+		return c.Cache.SetNX(key, value, ttl...)
+	}
+	var stored bool
+	err := c.update(context.Background(), key, func() (interface{}, bool, bool) {
+		stored = c.Cache.SetNX(key, value, ttl...)
+		return value, false, stored
+	})
+	c.backend.report(err)
+	return stored && err == nil
+}
+
+// SetXX stores value only if key is present, and reports whether it did
+func (c *KVCache) SetXX(key string, value interface{}, ttl ...time.Duration) bool {
+	if c.backend == nil {
+		return c.Cache.SetXX(key, value, ttl...)
+	}
+	var stored bool
+	err := c.update(context.Background(), key, func() (interface{}, bool, bool) {
+		stored = c.Cache.SetXX(key, value, ttl...)
+		return value, false, stored
+	})
+	c.backend.report(err)
+	return stored && err == nil
+}
+
+// GetOrSet returns key's value if present (loaded is true), or else stores
+// value, as Cache.GetOrSet
+func (c *KVCache) GetOrSet(key string, value interface{}, ttl ...time.Duration) (actual interface{}, loaded bool) {
+	if c.backend == nil {
+		return c.Cache.GetOrSet(key, value, ttl...)
+	}
+	err := c.update(context.Background(), key, func() (interface{}, bool, bool) {
+		actual, loaded = c.Cache.GetOrSet(key, value, ttl...)
+		return value, false, !loaded && c.fits(c.valueCost(value))
+	})
+	if err != nil {
+		c.backend.report(err)
+		return nil, false
+	}
+	return actual, loaded
+}
+
+// GetAndSet stores value and returns the previous value, as
+// Cache.GetAndSet
+func (c *KVCache) GetAndSet(key string, value interface{}, ttl ...time.Duration) (old interface{}, loaded bool) {
+	if c.backend == nil {
+		return c.Cache.GetAndSet(key, value, ttl...)
+	}
+	c.backend.report(c.update(context.Background(), key, func() (interface{}, bool, bool) {
+		old, loaded = c.Cache.GetAndSet(key, value, ttl...)
+		return value, false, true
+	}))
+	return old, loaded
+}
+
+// Swap stores value and returns the previous value, keeping a present
+// key's expiration, as Cache.Swap
+func (c *KVCache) Swap(key string, value interface{}) (old interface{}, loaded bool) {
+	if c.backend == nil {
+		return c.Cache.Swap(key, value)
+	}
+	c.backend.report(c.update(context.Background(), key, func() (interface{}, bool, bool) {
+		old, loaded = c.Cache.Swap(key, value)
+		return value, false, true
+	}))
+	return old, loaded
+}
+
+// GetAndDelete removes key and returns its value, if it was present
+func (c *KVCache) GetAndDelete(key string) (value interface{}, loaded bool) {
+	if c.backend == nil {
+		return c.Cache.GetAndDelete(key)
+	}
+	c.backend.report(c.update(context.Background(), key, func() (interface{}, bool, bool) {
+		value, loaded = c.Cache.GetAndDelete(key)
+		return nil, true, loaded
+	}))
+	return value, loaded
+}
+
+// Expire gives key a new TTL, as Cache.Expire. The Store holds no TTLs, so
+// it is only read.
+func (c *KVCache) Expire(key string, ttl time.Duration) bool {
+	c.fill(key)
+	return c.Cache.Expire(key, ttl)
+}
+
+// Persist removes key's expiration, as Cache.Persist. The Store holds no
+// TTLs, so it is only read.
+func (c *KVCache) Persist(key string) bool {
+	c.fill(key)
+	return c.Cache.Persist(key)
+}
+
+// Compute atomically updates key, as Cache.Compute. With a Store, fn also
+// runs holding the key's stripe lock, and its result is written to the
+// store unless it returns OpKeep.
+func (c *KVCache) Compute(key string, fn func(old interface{}, exists bool) (interface{}, Op), ttl ...time.Duration) (interface{}, bool) {
+	if c.backend == nil {
+		return c.Cache.Compute(key, fn, ttl...)
+	}
+	var value interface{}
+	var present bool
+	err := c.update(context.Background(), key, func() (interface{}, bool, bool) {
+		var result interface{}
+		op := OpKeep
+		value, present = c.Cache.Compute(key, func(old interface{}, exists bool) (interface{}, Op) {
+			result, op = fn(old, exists)
+			return result, op
+		}, ttl...)
+		return result, op == OpDelete, op != OpKeep
+	})
+	if err != nil {
+		c.backend.report(err)
+		return nil, false
+	}
+	return value, present
+}
+
+// ComputeIfAbsent calls fn only if key is missing, as
+// Cache.ComputeIfAbsent
+func (c *KVCache) ComputeIfAbsent(key string, fn func() (interface{}, Op), ttl ...time.Duration) (interface{}, bool) {
+	return c.Compute(key, func(old interface{}, exists bool) (interface{}, Op) {
+		if exists {
+			return old, OpKeep
+		}
+		return fn()
+	}, ttl...)
+}
+
+// ComputeIfPresent calls fn only if key is present, as
+// Cache.ComputeIfPresent
+func (c *KVCache) ComputeIfPresent(key string, fn func(old interface{}) (interface{}, Op), ttl ...time.Duration) (interface{}, bool) {
+	return c.Compute(key, func(old interface{}, exists bool) (interface{}, Op) {
+		if !exists {
+			return old, OpKeep
+		}
+		return fn(old)
+	}, ttl...)
+}
+
+// CompareAndSwap stores value only if key's version is expectedVersion, as
+// Cache.CompareAndSwap. With a Store, a failed store write is returned as
+// a *StoreError.
+func (c *KVCache) CompareAndSwap(key string, expectedVersion uint64, value interface{}, ttl ...time.Duration) (uint64, error) {
+	if c.backend == nil {
+		return c.Cache.CompareAndSwap(key, expectedVersion, value, ttl...)
+	}
+	var version uint64
+	var err error
+	if storeErr := c.update(context.Background(), key, func() (interface{}, bool, bool) {
+		version, err = c.Cache.CompareAndSwap(key, expectedVersion, value, ttl...)
+		return value, false, version != 0
+	}); storeErr != nil {
+		return 0, storeErr
+	}
+	return version, err
+}
+
+// CompareAndDelete deletes key only if its version is expectedVersion, as
+// Cache.CompareAndDelete. With a Store, a failed store delete is returned
+// as a *StoreError.
+func (c *KVCache) CompareAndDelete(key string, expectedVersion uint64) error {
+	if c.backend == nil {
+		return c.Cache.CompareAndDelete(key, expectedVersion)
+	}
+	var err error
+	if storeErr := c.update(context.Background(), key, func() (interface{}, bool, bool) {
+		err = c.Cache.CompareAndDelete(key, expectedVersion)
+		return nil, true, err == nil
+	}); storeErr != nil {
+		return storeErr
+	}
+	return err
+}
+
+// GetWithContext retrieves a value by key, waiting on the shard lock no
+// longer than ctx allows. With a Store, a key missing from the cache is
+// loaded from the store (or its write-behind queue) and cached.
+// It returns ErrNotFound for keys missing from both.
+func (c *KVCache) GetWithContext(ctx context.Context, key string) (interface{}, error) {
+	value, err := c.Cache.GetWithContext(ctx, key)
+	b := c.backend
+	if b == nil || !errors.Is(err, ErrNotFound) {
+		return value, err
+	}
+	unlock := b.lock(key)
+	defer unlock()
+	return c.fillLocked(ctx, key)
+}
+
+// Get retrieves a value by key. With a Store, a key missing from the cache
+// is loaded as by GetWithContext, and load errors are reported to
+// StoreOptions.OnError.
+func (c *KVCache) Get(key string) (interface{}, bool) {
+	value, _, ok := c.read(key)
+	return value, ok
+}
+
+// GetWithTTL is Get also returning the remaining TTL (0 if none)
+func (c *KVCache) GetWithTTL(key string) (interface{}, time.Duration, bool) {
+	value, meta, ok := c.read(key)
+	return value, c.remaining(meta), ok
+}
+
+// GetWithVersion is Get also returning the entry's version
+func (c *KVCache) GetWithVersion(key string) (interface{}, uint64, bool) {
+	value, meta, ok := c.read(key)
+	return value, meta.version, ok
+}
+
+// GetWithVersionAndTTL is Get also returning the entry's version and
+// remaining TTL
+func (c *KVCache) GetWithVersionAndTTL(key string) (interface{}, uint64, time.Duration, bool) {
+	value, meta, ok := c.read(key)
+	return value, meta.version, c.remaining(meta), ok
+}
+
+// read is get loading a key missing from the cache from the Store, if
+// there is one, as fill does. Such a key counts as a single miss, whether
+// or not the Store has it.
+func (c *KVCache) read(key string) (interface{}, entryMeta, bool) {
+	if c.backend == nil || c.Cache.Contains(key) {
+		return c.get(key)
+	}
+	c.fill(key)
+	c.recordLookup(lookupMiss)
+	return c.peek(key)
+}
+
+// Contains reports whether key is present, loading it from the Store if
+// the cache has one, without counting a lookup
+func (c *KVCache) Contains(key string) bool {
+	c.fill(key)
+	return c.Cache.Contains(key)
+}
+
+// fillLocked returns key's value from the cache, or else from the
+// write-behind queue or the Store, caching what it loads. It returns
+// ErrNotFound for keys missing from all of them. Must be called with the
+// key's stripe lock held.
+func (c *KVCache) fillLocked(ctx context.Context, key string) (interface{}, error) {
+	b := c.backend
+	// A concurrent load or write may have filled the key meanwhile. The
+	// caller counts the lookup.
+	if value, _, ok := c.peek(key); ok {
+		return value, nil
+	}
+	if b.behind != nil {
+		if w, ok := b.behind.lookup(key); ok {
+			if w.deleted {
+				return nil, ErrNotFound
+			}
+			return w.value, nil
+		}
+	}
+	value, err := b.store.Load(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			err = &StoreError{Op: "load", Keys: []string{key}, Err: err}
+		}
+		return nil, err
+	}
+	c.Cache.Set(key, value)
+	return value, nil
+}
+
+// GetMultiWithContext retrieves multiple values by keys. With a Store, keys
+// missing from the cache are loaded in one batch and cached. If ctx is
+// canceled part-way, the values found so far are returned with a
+// *BatchError.
+func (c *KVCache) GetMultiWithContext(ctx context.Context, keys []string) (map[string]interface{}, error) {
+	result, err := c.Cache.GetMultiWithContext(ctx, keys)
+	b := c.backend
+	if b == nil || err != nil || len(result) == len(keys) {
+		return result, err
+	}
+
+	var missing []string
+	for _, key := range keys {
+		if _, ok := result[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	unlock := b.lock(missing...)
+	defer unlock()
+
+	toLoad := missing[:0]
+	for _, key := range missing {
+		if value, _, ok := c.peek(key); ok {
+			result[key] = value
+			continue
+		}
+		if b.behind != nil {
+			if w, ok := b.behind.lookup(key); ok {
+				if !w.deleted {
+					result[key] = w.value
+				}
+				continue
+			}
+		}
+		toLoad = append(toLoad, key)
+	}
+	if len(toLoad) == 0 {
+		return result, nil
+	}
+	loaded, err := b.store.LoadBatch(ctx, toLoad)
+	if err != nil {
+		return result, &StoreError{Op: "load", Keys: toLoad, Err: err}
+	}
+	for _, key := range toLoad {
+		if value, ok := loaded[key]; ok {
+			c.Cache.Set(key, value)
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// GetMulti retrieves multiple values by keys. With a Store, missing keys
+// are loaded as by GetMultiWithContext, and load errors are reported to
+// StoreOptions.OnError.
+func (c *KVCache) GetMulti(keys []string) map[string]interface{} {
+	if c.backend == nil {
+		return c.Cache.GetMulti(keys)
+	}
+	result, err := c.GetMultiWithContext(context.Background(), keys)
+	c.backend.report(err)
+	return result
+}
+
+// Flush writes all queued write-behind writes to the Store, returning the
+// *StoreError of each batch that exhausted its retries (joined). Their
+// writes stay queued for the next flush, including one by Flush after
+// Close. It does nothing without a write-behind Store.
+func (c *KVCache) Flush() error {
+	if c.backend == nil || c.backend.behind == nil {
+		return nil
+	}
+	return errors.Join(c.backend.behind.flush()...)
+}
+
+// Close stops the cache's background goroutines and cancels every
+// Subscribe subscription. With a write-behind Store every queued write is
+// flushed first, and failed batches are returned as by Flush; their
+// writes can still be flushed, and read, after Close.
+func (c *KVCache) Close() error {
+	var errs []error
+	if c.backend != nil && c.backend.behind != nil {
+		errs = c.backend.behind.close()
+	}
+	errs = append(errs, c.Cache.Close())
+	c.events.closeAll(c.Cache)
+	c.lists.wakeAll()
+	return errors.Join(errs...)
+}
+
+// pendingWrite is a queued write-behind store or delete
+type pendingWrite struct {
+	value   interface{}
+	deleted bool
+}
+
+// writeBehind queues writes to a Store, keeping only the latest write per
+// key, and flushes them in batches from a background goroutine
+type writeBehind struct {
+	store Store
+	opts  StoreOptions
+
+	mu       sync.Mutex
+	space    *sync.Cond // Signaled when pending shrinks
+	pending  map[string]pendingWrite
+	inflight map[string]pendingWrite // Batch being flushed
+	closed   bool
+
+	flushMu sync.Mutex    // Serializes flushes so writes stay in order
+	kick    chan struct{} // Requests an early flush
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newWriteBehind creates a queue and starts its flusher
+func newWriteBehind(store Store, opts StoreOptions) *writeBehind {
+	w := &writeBehind{
+		store:   store,
+		opts:    opts,
+		pending: make(map[string]pendingWrite),
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	w.space = sync.NewCond(&w.mu)
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// enqueue queues writes, replacing earlier ones for the same keys. It
+// waits while the queue is full, unless the writes only replace queued
+// ones, returning ErrCanceled if ctx is done first.
+func (w *writeBehind) enqueue(ctx context.Context, writes map[string]pendingWrite) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed && len(w.pending) >= w.opts.MaxPending && !w.replacesAll(writes) {
+		// sync.Cond cannot wait on a channel, so wake the waiters instead
+		stop := context.AfterFunc(ctx, func() {
+			w.mu.Lock()
+			w.space.Broadcast()
+			w.mu.Unlock()
+		})
+		defer stop()
+	}
+	for !w.closed && len(w.pending) >= w.opts.MaxPending && !w.replacesAll(writes) {
+		if err := ctx.Err(); err != nil {
+			return canceled(err)
+		}
+		w.kickFlush()
+		w.space.Wait()
+	}
+	if w.closed {
+		return ErrClosed
+	}
+	for key, write := range writes {
+		w.pending[key] = write
+	}
+	if len(w.pending) >= w.opts.BatchSize {
+		w.kickFlush()
+	}
+	return nil
+}
+
+// replacesAll reports whether every key in writes is already queued, so
+// adding them does not grow the queue. Must be called with w.mu held.
+func (w *writeBehind) replacesAll(writes map[string]pendingWrite) bool {
+	for key := range writes {
+		if _, ok := w.pending[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// kickFlush asks the flusher to run without waiting for the interval
+func (w *writeBehind) kickFlush() {
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+// lookup returns the queued or in-flight write for key, if any
+func (w *writeBehind) lookup(key string) (pendingWrite, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if write, ok := w.pending[key]; ok {
+		return write, true
+	}
+	write, ok := w.inflight[key]
+	return write, ok
+}
+
+// run flushes on the interval or when kicked, and drains the queue when
+// closed
+func (w *writeBehind) run() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.report(w.flush())
+		case <-w.kick:
+			w.report(w.flush())
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// report passes flush errors to OnError
+func (w *writeBehind) report(errs []error) {
+	if w.opts.OnError == nil {
+		return
+	}
+	for _, err := range errs {
+		w.opts.OnError(err)
+	}
+}
+
+// close stops accepting writes and flushes everything queued
+func (w *writeBehind) close() []error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.space.Broadcast()
+	w.mu.Unlock()
+
+	close(w.done)
+	w.wg.Wait()
+	return w.flush()
+}
+
+// flush writes the queued writes in batches of BatchSize. Writes in a
+// batch that exhausted its retries are requeued, unless a later write to
+// the same key was queued meanwhile, and its error is returned.
+func (w *writeBehind) flush() []error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	batch := w.pending
+	w.pending = make(map[string]pendingWrite)
+	w.inflight = batch
+	w.space.Broadcast()
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		w.inflight = nil
+		w.mu.Unlock()
+	}()
+
+	stores := make(map[string]interface{})
+	var deletes []string
+	for key, write := range batch {
+		if write.deleted {
+			deletes = append(deletes, key)
+		} else {
+			stores[key] = write.value
+		}
+	}
+
+	// Each key appears once, so stores and deletes can go in any order
+	var errs []error
+	for len(stores) > 0 {
+		chunk := make(map[string]interface{}, min(len(stores), w.opts.BatchSize))
+		for key, value := range stores {
+			if len(chunk) == w.opts.BatchSize {
+				break
+			}
+			chunk[key] = value
+			delete(stores, key)
+		}
+		keys := storeKeys(chunk)
+		if err := w.write("store", keys, func() error {
+			return w.store.StoreBatch(context.Background(), chunk)
+		}); err != nil {
+			errs = append(errs, err)
+			w.requeue(batch, keys)
+		}
+	}
+	for len(deletes) > 0 {
+		chunk := deletes[:min(len(deletes), w.opts.BatchSize)]
+		deletes = deletes[len(chunk):]
+		if err := w.write("delete", chunk, func() error {
+			return w.store.DeleteBatch(context.Background(), chunk)
+		}); err != nil {
+			errs = append(errs, err)
+			w.requeue(batch, chunk)
+		}
+	}
+	return errs
+}
+
+// requeue puts the failed writes to keys from batch back in the queue,
+// keeping any newer write for the same key
+func (w *writeBehind) requeue(batch map[string]pendingWrite, keys []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		if _, newer := w.pending[key]; !newer {
+			w.pending[key] = batch[key]
+		}
+	}
+}
+
+// write calls fn, retrying with exponential backoff, and wraps the final
+// error as a *StoreError
+func (w *writeBehind) write(op string, keys []string, fn func() error) error {
+	backoff := w.opts.RetryBackoff
+	err := fn()
+	for retry := 0; err != nil && retry < w.opts.MaxRetries; retry++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = fn()
+	}
+	if err != nil {
+		return &StoreError{Op: op, Keys: keys, Err: err}
+	}
+	return nil
+}
+
+// storeKeys returns the keys of a batch of stores
+func storeKeys(entries map[string]interface{}) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
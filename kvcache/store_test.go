@@ -0,0 +1,567 @@
+package kvcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mapStore is an in-memory Store that records batch sizes and can fail
+type mapStore struct {
+	mu      sync.Mutex
+	data    map[string]interface{}
+	batches []int // Sizes of StoreBatch and DeleteBatch calls
+	fail    int   // Calls to fail before succeeding, -1 for all
+	writes  atomic.Int32
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{data: make(map[string]interface{})}
+}
+
+var errStoreDown = errors.New("store down")
+
+// failing consumes one injected failure. Must be called with s.mu held.
+func (s *mapStore) failing() bool {
+	switch {
+	case s.fail < 0:
+		return true
+	case s.fail > 0:
+		s.fail--
+		return true
+	}
+	return false
+}
+
+func (s *mapStore) Load(ctx context.Context, key string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return v, nil
+}
+
+func (s *mapStore) LoadBatch(ctx context.Context, keys []string) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[string]interface{})
+	for _, key := range keys {
+		if v, ok := s.data[key]; ok {
+			result[key] = v
+		}
+	}
+	return result, nil
+}
+
+func (s *mapStore) Store(ctx context.Context, key string, value interface{}) error {
+	return s.StoreBatch(ctx, map[string]interface{}{key: value})
+}
+
+func (s *mapStore) StoreBatch(ctx context.Context, entries map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes.Add(1)
+	if s.failing() {
+		return errStoreDown
+	}
+	s.batches = append(s.batches, len(entries))
+	for key, value := range entries {
+		s.data[key] = value
+	}
+	return nil
+}
+
+func (s *mapStore) Delete(ctx context.Context, key string) error {
+	return s.DeleteBatch(ctx, []string{key})
+}
+
+func (s *mapStore) DeleteBatch(ctx context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes.Add(1)
+	if s.failing() {
+		return errStoreDown
+	}
+	s.batches = append(s.batches, len(keys))
+	for _, key := range keys {
+		delete(s.data, key)
+	}
+	return nil
+}
+
+func (s *mapStore) get(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// TestWriteThrough tests synchronous writes to the store
+func TestWriteThrough(t *testing.T) {
+	store := newMapStore()
+	var reported []error
+	cache := NewKVCacheWithStore(Config{NumShards: 4}, store, StoreOptions{
+		OnError: func(err error) { reported = append(reported, err) },
+	})
+	defer cache.Close()
+
+	cache.Set("a", 1)
+	cache.SetMulti(map[string]interface{}{"b": 2, "c": 3})
+	for key, want := range map[string]int{"a": 1, "b": 2, "c": 3} {
+		if v, ok := store.get(key); !ok || v != want {
+			t.Errorf("Store %s: expected %d, got %v", key, want, v)
+		}
+		if v, ok := cache.Get(key); !ok || v != want {
+			t.Errorf("Cache %s: expected %d, got %v", key, want, v)
+		}
+	}
+
+	cache.Delete("a")
+	if _, ok := store.get("a"); ok {
+		t.Error("Delete should remove the key from the store")
+	}
+
+	// A failed write is reported and leaves the key uncached
+	store.fail = 1
+	cache.Set("b", 20)
+	if len(reported) != 1 || !errors.Is(reported[0], errStoreDown) {
+		t.Fatalf("Expected one reported store error, got %v", reported)
+	}
+	var storeErr *StoreError
+	if !errors.As(reported[0], &storeErr) || storeErr.Op != "store" || storeErr.Keys[0] != "b" {
+		t.Errorf("Expected a *StoreError for b, got %v", reported[0])
+	}
+	if _, ok := cache.Cache.Get("b"); ok {
+		t.Error("A failed write-through should not leave the key cached")
+	}
+	if v, _ := cache.Get("b"); v != 2 {
+		t.Errorf("Expected Get to load the store's 2, got %v", v)
+	}
+
+	store.fail = 1
+	if err := cache.SetWithContext(context.Background(), "c", 30); !errors.Is(err, errStoreDown) {
+		t.Errorf("SetWithContext should return the store error, got %v", err)
+	}
+	if v, _ := store.get("c"); v != 3 {
+		t.Errorf("Expected the store to keep 3, got %v", v)
+	}
+}
+
+// cancelingStore cancels a context once it has stored a write
+type cancelingStore struct {
+	*mapStore
+	cancel context.CancelFunc
+}
+
+func (s *cancelingStore) StoreBatch(ctx context.Context, entries map[string]interface{}) error {
+	defer s.cancel()
+	return s.mapStore.StoreBatch(ctx, entries)
+}
+
+// TestWriteThroughContext tests that the cache takes a stored write even
+// if the context is done by the time the store returns
+func TestWriteThroughContext(t *testing.T) {
+	store := &cancelingStore{mapStore: newMapStore()}
+	cache := NewKVCacheWithStore(Config{NumShards: 4}, store, StoreOptions{})
+	defer cache.Close()
+	cache.Set("a", 1)
+	cache.Set("b", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	store.cancel = cancel
+	if err := cache.SetWithContext(ctx, "a", 2); err != nil {
+		t.Errorf("SetWithContext: expected no error once stored, got %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	store.cancel = cancel
+	if err := cache.SetMultiWithContext(ctx, map[string]interface{}{"b": 2}); err != nil {
+		t.Errorf("SetMultiWithContext: expected no error once stored, got %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if v, _ := cache.Cache.Get(key); v != 2 {
+			t.Errorf("Expected the cache to hold the stored %s=2, got %v", key, v)
+		}
+	}
+}
+
+// TestStoreReadThrough tests loading misses from the store
+func TestStoreReadThrough(t *testing.T) {
+	store := newMapStore()
+	store.data["a"] = 1
+	store.data["b"] = 2
+	cache := NewKVCacheWithStore(Config{NumShards: 4}, store, StoreOptions{})
+	defer cache.Close()
+
+	ctx := context.Background()
+	if v, err := cache.GetWithContext(ctx, "a"); err != nil || v != 1 {
+		t.Errorf("Expected 1 from the store, got %v, %v", v, err)
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("Loaded value should be cached")
+	}
+	if _, err := cache.GetWithContext(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	values, err := cache.GetMultiWithContext(ctx, []string{"a", "b", "missing"})
+	if err != nil || len(values) != 2 || values["b"] != 2 {
+		t.Errorf("Expected a and b, got %v, %v", values, err)
+	}
+	if _, ok := cache.Get("b"); !ok {
+		t.Error("Batch-loaded value should be cached")
+	}
+
+	// The methods without a context read through as well
+	store.data["c"] = 3
+	store.data["d"] = 4
+	store.data["e"] = 5
+	if v, ok := cache.Get("c"); !ok || v != 3 {
+		t.Errorf("Expected Get to load 3, got %v, %v", v, ok)
+	}
+	if values := cache.GetMulti([]string{"d", "missing"}); len(values) != 1 || values["d"] != 4 {
+		t.Errorf("Expected GetMulti to load d, got %v", values)
+	}
+	if v, version, _, ok := cache.GetWithVersionAndTTL("e"); !ok || v != 5 || version == 0 {
+		t.Errorf("Expected 5 with a version, got %v, %d, %v", v, version, ok)
+	}
+}
+
+// TestStoreReadStats tests that a read served by the store counts as a
+// single miss
+func TestStoreReadStats(t *testing.T) {
+	store := newMapStore()
+	store.data["a"] = 1
+	store.data["b"] = 2
+	store.data["c"] = 3
+	cache := NewKVCacheWithStore(Config{NumShards: 4}, store, StoreOptions{})
+	defer cache.Close()
+
+	cache.GetWithContext(context.Background(), "a")
+	cache.Get("b")
+	cache.Get("missing")
+	cache.GetMulti([]string{"c", "missing"})
+	cache.Get("b")
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 5 {
+		t.Errorf("Expected 1 hit and 5 misses, got %d and %d", stats.Hits, stats.Misses)
+	}
+}
+
+// TestStoreConditionalWrites tests that every write reaches the store
+func TestStoreConditionalWrites(t *testing.T) {
+	store := newMapStore()
+	var reported []error
+	cache := NewKVCacheWithStore(Config{NumShards: 4}, store, StoreOptions{
+		OnError: func(err error) { reported = append(reported, err) },
+	})
+	defer cache.Close()
+
+	// Keys held only by the store count as present
+	store.data["a"] = 1
+	if cache.SetNX("a", 2) {
+		t.Error("SetNX should see the store's value")
+	}
+	if !cache.SetXX("a", 3) || !cache.SetNX("b", 1) {
+		t.Error("Expected SetXX of a and SetNX of b to store")
+	}
+	store.data["c"] = 1
+	if v, loaded := cache.GetOrSet("c", 2); !loaded || v != 1 {
+		t.Errorf("Expected GetOrSet to load 1, got %v, %v", v, loaded)
+	}
+	cache.GetOrSet("d", 1)
+	cache.GetAndSet("e", 1)
+	cache.Swap("e", 2)
+	cache.Compute("f", func(old interface{}, exists bool) (interface{}, Op) {
+		return 1, OpSet
+	})
+	_, version, _ := cache.GetWithVersion("f")
+	if _, err := cache.CompareAndSwap("f", version, 2); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]int{"a": 3, "b": 1, "c": 1, "d": 1, "e": 2, "f": 2} {
+		if v, _ := store.get(key); v != want {
+			t.Errorf("Store %s: expected %d, got %v", key, want, v)
+		}
+	}
+
+	store.data["g"] = 1
+	if v, loaded := cache.GetAndDelete("g"); !loaded || v != 1 {
+		t.Errorf("Expected GetAndDelete to take the store's 1, got %v, %v", v, loaded)
+	}
+	cache.ComputeIfPresent("a", func(old interface{}) (interface{}, Op) {
+		return nil, OpDelete
+	})
+	_, version, _ = cache.GetWithVersion("b")
+	if err := cache.CompareAndDelete("b", version); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "g"} {
+		if _, ok := store.get(key); ok {
+			t.Errorf("Expected %s to be deleted from the store", key)
+		}
+	}
+
+	// TTL changes load the key but leave the store alone
+	store.data["h"] = 1
+	writes := store.writes.Load()
+	if !cache.Expire("h", time.Minute) || store.writes.Load() != writes {
+		t.Error("Expected Expire to load h without writing the store")
+	}
+
+	// A failed write is reported as not made
+	store.fail = 1
+	if cache.SetNX("i", 1) {
+		t.Error("SetNX should report a failed store write")
+	}
+	store.fail = 1
+	if _, err := cache.CompareAndSwap("i", 0, 1); !errors.Is(err, errStoreDown) {
+		t.Errorf("Expected CompareAndSwap to return the store error, got %v", err)
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], errStoreDown) {
+		t.Errorf("Expected the SetNX failure to be reported, got %v", reported)
+	}
+	if cache.Cache.Contains("i") {
+		t.Error("A failed write should not leave the key cached")
+	}
+
+	if _, err := cache.RPush("list", 1); !errors.Is(err, ErrUnsupported) || !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected lists to be unsupported, got %v", err)
+	}
+}
+
+// TestWriteBehind tests queued, coalesced and batched writes
+func TestWriteBehind(t *testing.T) {
+	store := newMapStore()
+	cache := NewKVCacheWithStore(Config{NumShards: 4}, store, StoreOptions{
+		Mode:          WriteBehind,
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+	})
+	defer cache.Close()
+
+	for i := 0; i < 5; i++ {
+		cache.Set("k", i)
+	}
+	cache.Set("gone", 1)
+	cache.Delete("gone")
+	if store.writes.Load() != 0 {
+		t.Fatal("Writes below BatchSize should wait for a flush")
+	}
+
+	// Queued writes are visible to loads before they are flushed
+	cache.Cache.Delete("k")
+	if v, err := cache.GetWithContext(context.Background(), "k"); err != nil || v != 4 {
+		t.Errorf("Expected the queued 4, got %v, %v", v, err)
+	}
+	if _, err := cache.GetWithContext(context.Background(), "gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a queued delete to hide the key, got %v", err)
+	}
+
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.get("k"); v != 4 {
+		t.Errorf("Expected the last write 4, got %v", v)
+	}
+	if n := store.writes.Load(); n != 2 {
+		t.Errorf("Expected one store and one delete batch, got %d calls", n)
+	}
+
+	// Reaching BatchSize flushes without waiting for the interval
+	for i := 0; i < 25; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), i)
+	}
+	waitFor(t, func() bool { return store.writes.Load() > 2 })
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	store.mu.Lock()
+	if len(store.data) != 26 {
+		t.Errorf("Expected 26 keys in the store, got %d", len(store.data))
+	}
+	for _, n := range store.batches {
+		if n > 10 {
+			t.Errorf("Batch of %d exceeds BatchSize", n)
+		}
+	}
+	store.mu.Unlock()
+}
+
+// TestWriteBehindRetry tests retrying failed batches
+func TestWriteBehindRetry(t *testing.T) {
+	store := newMapStore()
+	store.fail = 2
+	var reported atomic.Int32
+	cache := NewKVCacheWithStore(Config{NumShards: 4}, store, StoreOptions{
+		Mode:          WriteBehind,
+		FlushInterval: time.Hour,
+		MaxRetries:    2,
+		RetryBackoff:  time.Millisecond,
+		OnError:       func(error) { reported.Add(1) },
+	})
+	defer cache.Close()
+
+	cache.Set("k", 1)
+	if err := cache.Flush(); err != nil {
+		t.Fatalf("Expected the write to succeed on the last retry, got %v", err)
+	}
+	if v, _ := store.get("k"); v != 1 {
+		t.Errorf("Expected 1 in the store, got %v", v)
+	}
+
+	// Exhausted retries keep the batch queued and report it
+	store.fail = -1
+	cache.Set("k", 2)
+	err := cache.Flush()
+	var storeErr *StoreError
+	if !errors.As(err, &storeErr) || storeErr.Keys[0] != "k" || !errors.Is(err, errStoreDown) {
+		t.Errorf("Expected a *StoreError for k, got %v", err)
+	}
+	if n := store.writes.Load(); n != 6 {
+		t.Errorf("Expected 3 attempts per flush, got %d calls", n)
+	}
+	if reported.Load() != 0 {
+		t.Error("Flush errors should be returned, not reported")
+	}
+
+	// The next flush writes it, unless a later write replaced it
+	store.mu.Lock()
+	store.fail = 0
+	store.mu.Unlock()
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.get("k"); v != 2 {
+		t.Errorf("Expected the requeued 2 in the store, got %v", v)
+	}
+}
+
+// TestWriteBehindCloseFailure tests that writes that fail at Close can
+// still be flushed
+func TestWriteBehindCloseFailure(t *testing.T) {
+	store := newMapStore()
+	store.fail = -1
+	cache := NewKVCacheWithStore(Config{NumShards: 4}, store, StoreOptions{
+		Mode:          WriteBehind,
+		FlushInterval: time.Hour,
+		MaxRetries:    -1,
+	})
+
+	cache.Set("k", 1)
+	if err := cache.Close(); !errors.Is(err, errStoreDown) {
+		t.Fatalf("Expected Close to return the store error, got %v", err)
+	}
+	store.mu.Lock()
+	store.fail = 0
+	store.mu.Unlock()
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.get("k"); v != 1 {
+		t.Errorf("Expected 1 in the store, got %v", v)
+	}
+}
+
+// gatedStore blocks StoreBatch until release is closed
+type gatedStore struct {
+	*mapStore
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *gatedStore) StoreBatch(ctx context.Context, entries map[string]interface{}) error {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return s.mapStore.StoreBatch(ctx, entries)
+}
+
+// TestWriteBehindEnqueueContext tests that a writer blocked on a full queue
+// gives up when its context is done
+func TestWriteBehindEnqueueContext(t *testing.T) {
+	store := &gatedStore{mapStore: newMapStore(), started: make(chan struct{}, 1), release: make(chan struct{})}
+	cache := NewKVCacheWithStore(Config{NumShards: 4}, store, StoreOptions{
+		Mode:          WriteBehind,
+		FlushInterval: time.Hour,
+		MaxPending:    1,
+	})
+	defer cache.Close()
+
+	// Hold a flush in the store so the queue cannot drain
+	cache.Set("a", 1)
+	go cache.Flush()
+	<-store.started
+	cache.Set("b", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := cache.SetWithContext(ctx, "c", 1)
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected ErrCanceled, got %v", err)
+	}
+	if cache.Cache.Contains("c") {
+		t.Error("A write that was not queued should not stay cached")
+	}
+	close(store.release)
+}
+
+// TestWriteBehindClose tests that Close drains the queue
+func TestWriteBehindClose(t *testing.T) {
+	store := newMapStore()
+	cache := NewKVCacheWithStore(Config{NumShards: 4}, store, StoreOptions{
+		Mode:          WriteBehind,
+		FlushInterval: time.Hour,
+		MaxPending:    4,
+	})
+
+	// Writers block on a full queue until the flusher makes room
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				cache.Set(fmt.Sprintf("%d-%d", i, j), j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.data) != 400 {
+		t.Errorf("Expected all 400 writes in the store after Close, got %d", len(store.data))
+	}
+	if err := cache.SetWithContext(context.Background(), "late", 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
+
+// TestNewKVCacheWithStoreInvalid tests option validation
+func TestNewKVCacheWithStoreInvalid(t *testing.T) {
+	for _, opts := range []StoreOptions{
+		{Mode: WriteMode(5)},
+		{BatchSize: -1},
+		{MaxPending: -1},
+		{FlushInterval: -time.Second},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic for %+v", opts)
+				}
+			}()
+			NewKVCacheWithStore(Config{}, newMapStore(), opts).Close()
+		}()
+	}
+}
@@ -0,0 +1,100 @@
+package kvcache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Every write stores a new version taken from a cache-wide counter, so
+// versions increase monotonically and a key that is deleted and written
+// again never repeats an earlier version. Version 0 means "no entry".
+// Versions are not persisted: entries loaded from a snapshot or log get
+// new ones.
+
+// ErrVersionConflict is matched by a *VersionConflictError
+var ErrVersionConflict = errors.New("kvcache: version conflict")
+
+// VersionConflictError reports a CompareAndSwap or CompareAndDelete whose
+// expected version did not match the entry's
+type VersionConflictError struct {
+	Expected uint64 // Version the caller passed
+	Actual   uint64 // Entry's current version, 0 if missing or expired
+}
+
+// Error implements the error interface
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("kvcache: version conflict: expected %d, found %d", e.Expected, e.Actual)
+}
+
+// Unwrap returns ErrVersionConflict
+func (e *VersionConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+// Version returns the entry's version
+func (e *CacheEntry[K, V]) Version() uint64 {
+	return e.version
+}
+
+// GetWithVersion retrieves a value by key along with its version, to pass
+// to CompareAndSwap or CompareAndDelete
+func (c *Cache[K, V]) GetWithVersion(key K) (V, uint64, bool) {
+	value, meta, ok := c.get(key)
+	return value, meta.version, ok
+}
+
+// GetWithVersionAndTTL is GetWithVersion also returning the remaining TTL,
+// as GetWithTTL does
+func (c *Cache[K, V]) GetWithVersionAndTTL(key K) (V, uint64, time.Duration, bool) {
+	value, meta, ok := c.get(key)
+	return value, meta.version, c.remaining(meta), ok
+}
+
+// CompareAndSwap stores value only if key's current version is
+// expectedVersion, and returns the new version. An expectedVersion of 0
+// stores only if the key is missing. On a mismatch nothing is written and
+// the error is a *VersionConflictError. As with SetWithCost, a value whose
+// cost alone exceeds Config.MaxCost is not stored and the old value is
+// removed; the returned version is then 0.
+func (c *Cache[K, V]) CompareAndSwap(key K, expectedVersion uint64, value V, ttl ...time.Duration) (uint64, error) {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	if actual := c.versionLocked(shard, key); actual != expectedVersion {
+		c.unlock(shard)
+		return 0, &VersionConflictError{Expected: expectedVersion, Actual: actual}
+	}
+	var version uint64
+	if c.setLocked(shard, key, value, c.expiration(c.now(), ttl), c.valueCost(value)) {
+		version = shard.store[key].version
+	}
+	c.unlock(shard)
+
+	c.shed()
+	return version, nil
+}
+
+// CompareAndDelete deletes key only if its current version is
+// expectedVersion. On a mismatch, including a missing key, the error is a
+// *VersionConflictError.
+func (c *Cache[K, V]) CompareAndDelete(key K, expectedVersion uint64) error {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	defer c.unlock(shard)
+
+	actual := c.versionLocked(shard, key)
+	if actual == 0 || actual != expectedVersion {
+		return &VersionConflictError{Expected: expectedVersion, Actual: actual}
+	}
+	c.deleteLocked(shard, key)
+	return nil
+}
+
+// versionLocked returns key's current version, or 0 if it is missing.
+// Must be called with shard.mutex held.
+func (c *Cache[K, V]) versionLocked(shard *shard[K, V], key K) uint64 {
+	if entry := c.liveLocked(shard, key); entry != nil {
+		return entry.version
+	}
+	return 0
+}
@@ -0,0 +1,140 @@
+package kvcache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestVersions tests that every write bumps the version, including after
+// a delete
+func TestVersions(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 4})
+	defer cache.Close()
+
+	if _, v, ok := cache.GetWithVersion("k"); ok || v != 0 {
+		t.Errorf("Expected missing key to have version 0, got %d", v)
+	}
+	cache.Set("k", 1)
+	_, v1, _ := cache.GetWithVersion("k")
+	cache.Set("k", 1)
+	_, v2, _ := cache.GetWithVersion("k")
+	cache.Delete("k")
+	cache.Set("k", 1)
+	value, v3, ok := cache.GetWithVersion("k")
+	if !ok || value != 1 || v1 == 0 || v2 <= v1 || v3 <= v2 {
+		t.Errorf("Expected increasing versions, got %d, %d, %d", v1, v2, v3)
+	}
+
+	cache.Set("k", 2, time.Minute)
+	value, v4, ttl, ok := cache.GetWithVersionAndTTL("k")
+	if !ok || value != 2 || v4 <= v3 || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("GetWithVersionAndTTL = %d, %d, %v, %v", value, v4, ttl, ok)
+	}
+}
+
+// TestCompareAndSwap tests conditional writes and the conflict error
+func TestCompareAndSwap(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewWithConfig[string, string](Config{NumShards: 4, Clock: clock, CleanupInterval: -1})
+	defer cache.Close()
+
+	v1, err := cache.CompareAndSwap("k", 0, "a")
+	if err != nil || v1 == 0 {
+		t.Fatalf("Expected create with version 0 to succeed, got %d, %v", v1, err)
+	}
+	_, err = cache.CompareAndSwap("k", 0, "b")
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) || conflict.Expected != 0 || conflict.Actual != v1 {
+		t.Fatalf("Expected a conflict reporting version %d, got %v", v1, err)
+	}
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Error("Expected the conflict to match ErrVersionConflict")
+	}
+
+	v2, err := cache.CompareAndSwap("k", v1, "b", time.Minute)
+	if err != nil || v2 <= v1 {
+		t.Fatalf("Expected swap to succeed with a newer version, got %d, %v", v2, err)
+	}
+	if value, ttl, _ := cache.GetWithTTL("k"); value != "b" || ttl != time.Minute {
+		t.Errorf("Expected b with a 1m TTL, got %q, %v", value, ttl)
+	}
+	if _, err := cache.CompareAndSwap("k", v1, "c"); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected stale version to conflict, got %v", err)
+	}
+
+	// An expired entry counts as missing
+	clock.Advance(2 * time.Minute)
+	if _, err := cache.CompareAndSwap("k", v2, "d"); !errors.As(err, &conflict) || conflict.Actual != 0 {
+		t.Errorf("Expected expired entry to report version 0, got %v", err)
+	}
+	if _, err := cache.CompareAndSwap("k", 0, "d"); err != nil {
+		t.Errorf("Expected create over an expired entry to succeed, got %v", err)
+	}
+}
+
+// TestCompareAndSwapOverBudget tests that a value over the cost budget is
+// not stored
+func TestCompareAndSwapOverBudget(t *testing.T) {
+	cache := NewWithConfig[string, string](Config{NumShards: 1, MaxCost: 4})
+	defer cache.Close()
+
+	v, _ := cache.CompareAndSwap("k", 0, "ok")
+	if v, err := cache.CompareAndSwap("k", v, "too large"); err != nil || v != 0 {
+		t.Errorf("Expected version 0 without error, got %d, %v", v, err)
+	}
+	if _, ok := cache.Get("k"); ok {
+		t.Error("Expected the old value to be removed")
+	}
+}
+
+// TestCompareAndDelete tests conditional deletes
+func TestCompareAndDelete(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 4})
+	defer cache.Close()
+
+	var conflict *VersionConflictError
+	if err := cache.CompareAndDelete("k", 1); !errors.As(err, &conflict) || conflict.Actual != 0 {
+		t.Errorf("Expected missing key to conflict, got %v", err)
+	}
+	cache.Set("k", 1)
+	_, v, _ := cache.GetWithVersion("k")
+	if err := cache.CompareAndDelete("k", v+1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected wrong version to conflict, got %v", err)
+	}
+	if err := cache.CompareAndDelete("k", v); err != nil {
+		t.Errorf("Expected delete to succeed, got %v", err)
+	}
+	if _, ok := cache.Get("k"); ok {
+		t.Error("Expected key to be deleted")
+	}
+}
+
+// TestCompareAndSwapConcurrent tests that read-modify-write loops built on
+// CompareAndSwap never lose an update
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	cache := NewWithConfig[string, int](Config{NumShards: 4})
+	defer cache.Close()
+	cache.Set("counter", 0)
+
+	const goroutines, increments = 8, 500
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				n, v, _ := cache.GetWithVersion("counter")
+				if _, err := cache.CompareAndSwap("counter", v, n+1); err == nil {
+					i++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, _ := cache.Get("counter"); n != goroutines*increments {
+		t.Errorf("Expected %d, got %d", goroutines*increments, n)
+	}
+}
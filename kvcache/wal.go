@@ -0,0 +1,907 @@
+package kvcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WALSyncPolicy controls when the write-ahead log is flushed to stable
+// storage. Every record is written to the operating system immediately, so
+// a crash of the process alone loses nothing; the policy decides how much a
+// machine crash can lose.
+type WALSyncPolicy int
+
+// Write-ahead log sync policies
+const (
+	WALSyncAlways   WALSyncPolicy = iota // fsync after every record (default)
+	WALSyncPeriodic                      // fsync every WALOptions.SyncInterval
+	WALSyncNever                         // Leave flushing to the operating system
+)
+
+// Default write-ahead log settings
+const (
+	DefaultWALSyncInterval = 100 * time.Millisecond
+	DefaultWALCompactSize  = 64 << 20
+)
+
+// WALOptions configures the write-ahead log of a cache created with Open
+type WALOptions struct {
+	// Path is the log file, created if it does not exist. Required.
+	Path string
+
+	// SnapshotPath is an optional snapshot, written with SaveSnapshotFile,
+	// that is loaded before the log is replayed. A missing file is ignored.
+	SnapshotPath string
+
+	// Sync selects when the log is fsynced. The zero value is WALSyncAlways.
+	Sync WALSyncPolicy
+
+	// SyncInterval is the fsync period for WALSyncPeriodic.
+	// Zero means DefaultWALSyncInterval.
+	SyncInterval time.Duration
+
+	// CompactSize is the log size in bytes that triggers a background
+	// compaction, provided the log has also doubled since the last one.
+	// Zero means DefaultWALCompactSize; negative disables background
+	// compaction (Compact can still be called).
+	CompactSize int64
+}
+
+// validate reports whether the options can be used to open a log
+func (o WALOptions) validate() error {
+	if o.Path == "" {
+		return fmt.Errorf("%w: WALOptions.Path is required", ErrInvalidConfig)
+	}
+	if o.Sync < WALSyncAlways || o.Sync > WALSyncNever {
+		return fmt.Errorf("%w: unknown WAL sync policy %d", ErrInvalidConfig, o.Sync)
+	}
+	if o.SyncInterval < 0 {
+		return fmt.Errorf("%w: SyncInterval must not be negative", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// Open creates a cache backed by a write-ahead log. The snapshot at
+// opts.SnapshotPath, if any, is loaded first and the log is replayed on top
+// of it; an incomplete record at the end of the log, left by a crash
+// mid-write, is discarded. From then on every Set, Delete, Clear, eviction
+// and expiry is appended to the log before the call returns.
+func Open[K comparable, V any](cfg Config, opts WALOptions) (*Cache[K, V], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	cfg = cfg.withDefaults()
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = DefaultWALSyncInterval
+	}
+	if opts.CompactSize == 0 {
+		opts.CompactSize = DefaultWALCompactSize
+	}
+
+	cache := newCache[K, V](cfg, nil)
+	if opts.SnapshotPath != "" {
+		err := cache.LoadSnapshotFile(opts.SnapshotPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	wal, err := cache.recoverWAL(opts)
+	if err != nil {
+		return nil, err
+	}
+	cache.wal = wal
+	cache.start(cfg)
+
+	if opts.Sync == WALSyncPeriodic {
+		cache.wg.Add(1)
+		go cache.syncWAL(opts.SyncInterval)
+	}
+	if opts.CompactSize > 0 {
+		cache.wg.Add(1)
+		go cache.compactWAL()
+	}
+	return cache, nil
+}
+
+// OpenKVCache creates a KVCache backed by a write-ahead log, see Open
+func OpenKVCache(cfg Config, opts WALOptions) (*KVCache, error) {
+	cache, err := Open[string, interface{}](cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &KVCache{Cache: cache}, nil
+}
+
+// Log format, version 1. The file starts with the magic "KVCWAL\x00\x00"
+// and a version (uint16 big endian), followed by records framed as
+//
+//	payload length (uint32 big endian), CRC-32C of payload (uint32 big endian), payload
+//
+// Payloads start with an operation byte; integers are varints and keys and
+// values are length-prefixed Codec encodings:
+//
+//	set:    key, value, expiration, cost
+//	delete: key (also used for evictions)
+//	expire: key
+//	clear:  shard index, shard count (keys whose hash selects that shard)
+//	reset:  (drop everything; starts a compacted log)
+//	push:   key, list id, change, front (1) or back (0), value count, values
+//	pop:    key, list id, change, elements removed from the front, from the back
+//
+// Push and pop records change a KVCache list in place. Each list has a
+// random id and numbers its changes, so a record only applies to the list
+// it was logged for and is skipped if that list, as loaded from a snapshot,
+// already has the change.
+const (
+	walMagic      = "KVCWAL\x00\x00"
+	walVersion    = 1
+	walHeaderSize = len(walMagic) + 2
+	walFrameSize  = 8
+)
+
+// Log operations
+const (
+	walOpSet byte = iota + 1
+	walOpDelete
+	walOpExpire
+	walOpClear
+	walOpReset
+	walOpListPush
+	walOpListPop
+)
+
+// writeAheadLog appends framed records to the log file. Records are
+// appended while the writer holds its shard lock, so the log preserves the
+// order of operations on each key, and a compaction copying that shard
+// under its lock sees either all of a record's effect or none of it.
+type writeAheadLog struct {
+	path        string
+	sync        WALSyncPolicy
+	compactSize int64
+	compact     chan struct{} // Signals the background compactor
+
+	// compactMu serializes compactions
+	compactMu sync.Mutex
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64 // Bytes in file
+	baseSize int64 // Size right after recovery or the last compaction
+	dirty    bool  // Written since the last fsync
+	buf      []byte
+	err      error // First error, reported by Sync and Close
+	broken   bool  // An I/O error stopped further appends
+
+	// While compacting, records are also collected here so they can be
+	// appended to the rewritten log
+	compacting bool
+	pending    []byte
+	copied     int // Shards already written to the rewritten log
+}
+
+// append frames and writes one record about shard i. While compacting,
+// it is collected for the rewritten log only if that shard has been copied
+// already: otherwise the copy includes the change, and a list push or pop
+// replayed on top of it would be applied twice.
+func (w *writeAheadLog) append(i int, payload []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.broken {
+		return
+	}
+
+	w.buf = appendWALFrame(w.buf[:0], payload)
+	if _, err := w.file.Write(w.buf); err != nil {
+		w.fail(err)
+		return
+	}
+	w.size += int64(len(w.buf))
+	if w.compacting && i < w.copied {
+		w.pending = append(w.pending, w.buf...)
+	}
+
+	if w.sync == WALSyncAlways {
+		if err := w.file.Sync(); err != nil {
+			w.fail(err)
+		}
+	} else {
+		w.dirty = true
+	}
+
+	if w.compactSize > 0 && w.size >= w.compactSize && w.size >= 2*w.baseSize {
+		select {
+		case w.compact <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// note records an error without stopping the log.
+// Must be called with w.mu held.
+func (w *writeAheadLog) note(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// fail records an I/O error and stops appending, since the file may now end
+// in a partial record. Must be called with w.mu held.
+func (w *writeAheadLog) fail(err error) {
+	w.note(fmt.Errorf("kvcache: write-ahead log: %w", err))
+	w.broken = true
+}
+
+// flush fsyncs pending writes and returns the first error
+func (w *writeAheadLog) flush() error {
+	w.mu.Lock()
+	f, dirty := w.file, w.dirty && !w.broken
+	w.dirty = false
+	w.mu.Unlock()
+
+	// Sync outside the lock so appends are not held up. A compaction may
+	// swap and close the file meanwhile; it syncs the new file itself.
+	if dirty {
+		if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			w.mu.Lock()
+			w.fail(err)
+			w.mu.Unlock()
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// close syncs and closes the file
+func (w *writeAheadLog) close() error {
+	err := w.flush()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.broken = true // Appends after Close are dropped
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// startCompaction begins collecting appended records
+func (w *writeAheadLog) startCompaction() {
+	w.mu.Lock()
+	w.compacting = true
+	w.pending = w.pending[:0]
+	w.copied = 0
+	w.mu.Unlock()
+}
+
+// copiedShard records that shard i, and every shard before it, has been
+// copied to the rewritten log. Must be called with shard i's lock held.
+func (w *writeAheadLog) copiedShard(i int) {
+	w.mu.Lock()
+	w.copied = i + 1
+	w.mu.Unlock()
+}
+
+// abortCompaction stops collecting records; the current file is untouched
+func (w *writeAheadLog) abortCompaction() {
+	w.mu.Lock()
+	w.compacting = false
+	w.pending = nil
+	w.mu.Unlock()
+}
+
+// finishCompaction appends the records collected since startCompaction to
+// the rewritten log, syncs it and atomically replaces the current file
+func (w *writeAheadLog) finishCompaction(f *os.File) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.broken {
+		if w.err != nil {
+			return w.err
+		}
+		return ErrClosed
+	}
+	if _, err := f.Write(w.pending); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), w.path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(w.path))
+
+	w.file.Close()
+	w.file = f
+	w.size = info.Size()
+	w.baseSize = w.size
+	w.dirty = false
+	w.compacting = false
+	w.pending = nil
+	return nil
+}
+
+// syncDir makes a rename durable. Errors are ignored because not every
+// platform supports syncing directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// appendWALFrame appends a framed record to b
+func appendWALFrame(b, payload []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(payload, castagnoli))
+	return append(b, payload...)
+}
+
+// appendWALField appends a length-prefixed byte string
+func appendWALField(b, field []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(field)))
+	return append(b, field...)
+}
+
+// Sync flushes the write-ahead log to stable storage and returns the first
+// error the log has hit, such as a full disk or a value the codec could not
+// encode. It returns nil for caches without a log.
+func (c *Cache[K, V]) Sync() error {
+	if c.wal == nil {
+		return nil
+	}
+	return c.wal.flush()
+}
+
+// setPayload encodes a set record
+func (c *Cache[K, V]) setPayload(key K, value V, expiration, cost int64) ([]byte, error) {
+	k, err := c.codec.Encode(&key)
+	if err != nil {
+		return nil, fmt.Errorf("kvcache: encoding key %v: %w", key, err)
+	}
+	v, err := c.codec.Encode(&value)
+	if err != nil {
+		return nil, fmt.Errorf("kvcache: encoding value for key %v: %w", key, err)
+	}
+	b := make([]byte, 0, 1+len(k)+len(v)+4*binary.MaxVarintLen64)
+	b = append(b, walOpSet)
+	b = appendWALField(b, k)
+	b = appendWALField(b, v)
+	b = binary.AppendVarint(b, expiration)
+	return binary.AppendVarint(b, cost), nil
+}
+
+// logSet appends a set record. A value the codec cannot encode is logged as
+// a delete, so recovery drops the key rather than restoring an older value.
+// Must be called with shard.mutex held.
+func (c *Cache[K, V]) logSet(shard *shard[K, V], key K, value V, expiration, cost int64) {
+	payload, err := c.setPayload(key, value, expiration, cost)
+	if err != nil {
+		c.wal.mu.Lock()
+		c.wal.note(err)
+		c.wal.mu.Unlock()
+		c.logRemove(shard, key, RemovalDeleted)
+		return
+	}
+	c.wal.append(shard.index, payload)
+}
+
+// logRemove appends a delete or expire record.
+// Must be called with shard.mutex held.
+func (c *Cache[K, V]) logRemove(shard *shard[K, V], key K, reason RemovalReason) {
+	k, err := c.codec.Encode(&key)
+	if err != nil {
+		c.wal.mu.Lock()
+		c.wal.fail(fmt.Errorf("encoding key %v: %w", key, err))
+		c.wal.mu.Unlock()
+		return
+	}
+	op := walOpDelete
+	if reason == RemovalExpired {
+		op = walOpExpire
+	}
+	c.wal.append(shard.index, appendWALField([]byte{op}, k))
+}
+
+// logListPush appends a push record for the change l just numbered. A
+// value the codec cannot encode makes it log a delete instead, as logSet
+// does. Must be called with shard.mutex held.
+func (c *Cache[K, V]) logListPush(shard *shard[K, V], key K, l *listValue, front bool, values []interface{}) {
+	payload, err := c.listPushPayload(key, l, front, values)
+	if err != nil {
+		c.wal.mu.Lock()
+		c.wal.note(err)
+		c.wal.mu.Unlock()
+		c.logRemove(shard, key, RemovalDeleted)
+		return
+	}
+	c.wal.append(shard.index, payload)
+}
+
+// listPushPayload encodes a push record
+func (c *Cache[K, V]) listPushPayload(key K, l *listValue, front bool, values []interface{}) ([]byte, error) {
+	b, err := c.listPayload(walOpListPush, key, l)
+	if err != nil {
+		return nil, err
+	}
+	var end uint64
+	if front {
+		end = 1
+	}
+	b = binary.AppendUvarint(b, end)
+	b = binary.AppendUvarint(b, uint64(len(values)))
+	for i := range values {
+		v, err := c.codec.Encode(&values[i])
+		if err != nil {
+			return nil, fmt.Errorf("kvcache: encoding list element for key %v: %w", key, err)
+		}
+		b = appendWALField(b, v)
+	}
+	return b, nil
+}
+
+// logListPop appends a pop record for the change l just numbered, which
+// removed front elements from the front of the list and back from the
+// back. Must be called with shard.mutex held.
+func (c *Cache[K, V]) logListPop(shard *shard[K, V], key K, l *listValue, front, back int) {
+	b, err := c.listPayload(walOpListPop, key, l)
+	if err != nil {
+		c.wal.mu.Lock()
+		c.wal.fail(err)
+		c.wal.mu.Unlock()
+		return
+	}
+	b = binary.AppendUvarint(b, uint64(front))
+	c.wal.append(shard.index, binary.AppendUvarint(b, uint64(back)))
+}
+
+// listPayload starts a push or pop record with key and l's id and change
+// number, which the shard lock guards. Must be called with shard.mutex held.
+func (c *Cache[K, V]) listPayload(op byte, key K, l *listValue) ([]byte, error) {
+	k, err := c.codec.Encode(&key)
+	if err != nil {
+		return nil, fmt.Errorf("kvcache: encoding key %v: %w", key, err)
+	}
+	b := appendWALField([]byte{op}, k)
+	b = binary.AppendUvarint(b, l.id)
+	return binary.AppendUvarint(b, l.seq), nil
+}
+
+// logClear appends a clear record for shard i.
+// Must be called with the shard lock held.
+func (c *Cache[K, V]) logClear(i int) {
+	b := binary.AppendUvarint([]byte{walOpClear}, uint64(i))
+	c.wal.append(i, binary.AppendUvarint(b, uint64(c.numShards)))
+}
+
+// Compact rewrites the write-ahead log from the cache's current state, so
+// it holds one record per live entry. Writes continue while the state is
+// copied shard by shard; the new log replaces the old one atomically, so a
+// crash part-way leaves the old log intact. Open runs Compact in the
+// background as the log grows (see WALOptions.CompactSize). It does nothing
+// for caches without a log.
+func (c *Cache[K, V]) Compact() error {
+	w := c.wal
+	if w == nil {
+		return nil
+	}
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	w.compactMu.Lock()
+	defer w.compactMu.Unlock()
+
+	f, err := os.Create(w.path + ".compact")
+	if err != nil {
+		return err
+	}
+	w.startCompaction()
+	err = c.writeWALState(f)
+	if err == nil {
+		err = w.finishCompaction(f)
+	}
+	if err != nil {
+		w.abortCompaction()
+		f.Close()
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// writeWALState writes a log header, a reset record and a set record for
+// every live entry
+func (c *Cache[K, V]) writeWALState(f *os.File) error {
+	bw := bufio.NewWriter(f)
+	buf := binary.BigEndian.AppendUint16([]byte(walMagic), walVersion)
+	buf = appendWALFrame(buf, []byte{walOpReset})
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+
+	var records []snapshotRecord[K, V]
+	for _, shard := range c.shards {
+		now := c.now()
+		shard.mutex.RLock()
+		for key, entry := range shard.store {
+			exp := atomic.LoadInt64(&entry.Expiration)
+			if exp > 0 && now > exp {
+				continue
+			}
+			records = append(records, snapshotRecord[K, V]{key, c.snapshotLocked(entry.Value), exp, entry.cost})
+		}
+		c.wal.copiedShard(shard.index)
+		shard.mutex.RUnlock()
+
+		for i := range records {
+			rec := &records[i]
+			// Values that cannot be encoded were logged as deletes
+			if payload, err := c.setPayload(rec.key, rec.value, rec.expiration, rec.cost); err == nil {
+				buf = appendWALFrame(buf[:0], payload)
+				if _, err := bw.Write(buf); err != nil {
+					return err
+				}
+			}
+			*rec = snapshotRecord[K, V]{} // Don't pin values
+		}
+		records = records[:0]
+	}
+	return bw.Flush()
+}
+
+// syncWAL fsyncs the log periodically
+func (c *Cache[K, V]) syncWAL(interval time.Duration) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.wal.flush()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// compactWAL compacts the log whenever it signals that it has grown
+func (c *Cache[K, V]) compactWAL() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.wal.compact:
+			if err := c.Compact(); err != nil {
+				c.wal.mu.Lock()
+				c.wal.note(fmt.Errorf("kvcache: compacting write-ahead log: %w", err))
+				c.wal.mu.Unlock()
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// recoverWAL replays the log at opts.Path into the cache, discards a torn
+// final record, and returns the log ready for appending
+func (c *Cache[K, V]) recoverWAL(opts WALOptions) (*writeAheadLog, error) {
+	os.Remove(opts.Path + ".compact") // Left by an interrupted compaction
+
+	f, err := os.OpenFile(opts.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	end, err := c.replayWAL(f)
+	if err == nil && end == 0 {
+		// New file, or one that never got a complete header
+		_, err = f.WriteAt(binary.BigEndian.AppendUint16([]byte(walMagic), walVersion), 0)
+		end = int64(walHeaderSize)
+	}
+	if err == nil {
+		err = f.Truncate(end)
+	}
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &writeAheadLog{
+		path:        opts.Path,
+		sync:        opts.Sync,
+		compactSize: opts.CompactSize,
+		compact:     make(chan struct{}, 1),
+		file:        f,
+		size:        end,
+		baseSize:    end,
+	}, nil
+}
+
+// replayWAL applies every intact record in f and returns the offset just
+// past the last one, or 0 if f has no complete header. A damaged record is
+// tolerated only at the very end of the file, where a crash mid-append
+// leaves it; damage followed by more data is reported as corruption.
+func (c *Cache[K, V]) replayWAL(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if size < int64(walHeaderSize) {
+		return 0, nil
+	}
+
+	r := bufio.NewReader(f)
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	if string(header[:len(walMagic)]) != walMagic {
+		return 0, fmt.Errorf("%w: %s is not a write-ahead log", ErrInvalidSnapshot, f.Name())
+	}
+	if v := binary.BigEndian.Uint16(header[len(walMagic):]); v != walVersion {
+		return 0, fmt.Errorf("%w: unsupported log version %d", ErrInvalidSnapshot, v)
+	}
+
+	off := int64(walHeaderSize)
+	var frame [walFrameSize]byte
+	for {
+		if _, err := io.ReadFull(r, frame[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return off, nil // Clean end, or a torn frame header
+			}
+			return 0, err
+		}
+		n := binary.BigEndian.Uint32(frame[:4])
+		end := off + walFrameSize + int64(n)
+		if n == 0 || end > size {
+			// Records are never empty; zeros or a short record are what a
+			// crash leaves behind
+			return off, nil
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, err
+		}
+		if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(frame[4:]) {
+			if end == size {
+				return off, nil
+			}
+			return 0, fmt.Errorf("%w: log checksum mismatch at offset %d", ErrInvalidSnapshot, off)
+		}
+		if err := c.applyWAL(payload); err != nil {
+			return 0, fmt.Errorf("%w: log record at offset %d: %w", ErrInvalidSnapshot, off, err)
+		}
+		off = end
+	}
+}
+
+// walDecoder reads the fields of a record payload
+type walDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *walDecoder) uvarint() uint64 {
+	x, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("bad varint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+func (d *walDecoder) varint() int64 {
+	x, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("bad varint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+func (d *walDecoder) field() []byte {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.err = errors.New("field overruns record")
+		return nil
+	}
+	field := d.buf[:n]
+	d.buf = d.buf[n:]
+	return field
+}
+
+// applyWAL replays one record
+func (c *Cache[K, V]) applyWAL(payload []byte) error {
+	d := &walDecoder{buf: payload[1:]}
+	switch payload[0] {
+	case walOpSet:
+		k, v := d.field(), d.field()
+		expiration, cost := d.varint(), d.varint()
+		if d.err != nil {
+			return d.err
+		}
+		var key K
+		var value V
+		if err := c.codec.Decode(k, &key); err != nil {
+			return err
+		}
+		if err := c.codec.Decode(v, &value); err != nil {
+			return err
+		}
+		shard := c.getShard(key)
+		shard.mutex.Lock()
+		if expiration > 0 && c.now() > expiration {
+			c.deleteLocked(shard, key)
+		} else {
+			c.setLocked(shard, key, value, expiration, cost)
+		}
+		c.unlock(shard)
+		c.shed()
+
+	case walOpDelete, walOpExpire:
+		k := d.field()
+		if d.err != nil {
+			return d.err
+		}
+		var key K
+		if err := c.codec.Decode(k, &key); err != nil {
+			return err
+		}
+		shard := c.getShard(key)
+		shard.mutex.Lock()
+		c.deleteLocked(shard, key)
+		c.unlock(shard)
+
+	case walOpClear:
+		i, n := d.uvarint(), d.uvarint()
+		if d.err != nil {
+			return d.err
+		}
+		if n == 0 || n&(n-1) != 0 || i >= n {
+			return fmt.Errorf("bad clear of shard %d of %d", i, n)
+		}
+		c.replayClear(uint32(i), uint32(n))
+
+	case walOpReset:
+		for _, shard := range c.shards {
+			shard.mutex.Lock()
+			c.clearLocked(shard)
+			c.unlock(shard)
+		}
+
+	case walOpListPush:
+		k, id, seq := d.field(), d.uvarint(), d.uvarint()
+		end, n := d.uvarint(), d.uvarint()
+		if d.err == nil && n > uint64(len(d.buf)) {
+			d.err = errors.New("value count overruns record")
+		}
+		if d.err != nil {
+			return d.err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			v := d.field()
+			if d.err != nil {
+				return d.err
+			}
+			if err := c.codec.Decode(v, &values[i]); err != nil {
+				return err
+			}
+		}
+		var key K
+		if err := c.codec.Decode(k, &key); err != nil {
+			return err
+		}
+		err := c.replayList(key, id, seq, func(l *listValue) (cost int64) {
+			for _, v := range values {
+				l.push(end == 1, v)
+				cost += c.valueCost(any(v).(V))
+			}
+			return cost
+		})
+		if err != nil {
+			return err
+		}
+		c.shed()
+
+	case walOpListPop:
+		k, id, seq := d.field(), d.uvarint(), d.uvarint()
+		front, back := d.uvarint(), d.uvarint()
+		if d.err != nil {
+			return d.err
+		}
+		var key K
+		if err := c.codec.Decode(k, &key); err != nil {
+			return err
+		}
+		return c.replayList(key, id, seq, func(l *listValue) (cost int64) {
+			for ; back > 0 && l.n > 0; back-- {
+				cost -= c.valueCost(any(l.pop(false)).(V))
+			}
+			for ; front > 0 && l.n > 0; front-- {
+				cost -= c.valueCost(any(l.pop(true)).(V))
+			}
+			return cost
+		})
+
+	default:
+		return fmt.Errorf("unknown operation %d", payload[0])
+	}
+	return nil
+}
+
+// replayList applies a logged push or pop to key's list: change changes
+// the list in place and returns the change in its cost. The record is
+// skipped unless key holds the list it was logged for, without the change
+// yet; otherwise the list expired or was deleted, or the snapshot loaded
+// before the log already has a later state of key.
+func (c *Cache[K, V]) replayList(key K, id, seq uint64, change func(l *listValue) int64) error {
+	shard := c.getShard(key)
+	shard.mutex.Lock()
+	defer c.unlock(shard)
+
+	entry := c.liveLocked(shard, key)
+	if entry == nil {
+		return nil
+	}
+	l, ok := any(entry.Value).(*listValue)
+	if !ok || l.id != id || l.seq >= seq {
+		return nil
+	}
+	l.mu.Lock()
+	cost := entry.cost + change(l)
+	l.seq = seq
+	empty := l.n == 0
+	l.mu.Unlock()
+
+	if empty {
+		c.removeLocked(shard, entry, RemovalDeleted)
+	} else {
+		c.changedLocked(shard, entry, cost)
+	}
+	return nil
+}
+
+// replayClear removes the keys that shard i of n held when the log was
+// written, which is a single shard unless the shard count has changed
+func (c *Cache[K, V]) replayClear(i, n uint32) {
+	if int(n) == c.numShards {
+		shard := c.shards[i]
+		shard.mutex.Lock()
+		c.clearLocked(shard)
+		c.unlock(shard)
+		return
+	}
+	for _, shard := range c.shards {
+		shard.mutex.Lock()
+		for key, entry := range shard.store {
+			if c.hash(key)&(n-1) == i {
+				c.removeLocked(shard, entry, RemovalDeleted)
+			}
+		}
+		c.unlock(shard)
+	}
+}
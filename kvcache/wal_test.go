@@ -0,0 +1,376 @@
+package kvcache
+
+import (
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openWAL opens a logged cache without the background cleanup goroutine
+func openWAL(t *testing.T, cfg Config, opts WALOptions) *Cache[string, int] {
+	t.Helper()
+	if cfg.CleanupInterval == 0 {
+		cfg.CleanupInterval = -1
+	}
+	cache, err := Open[string, int](cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+// contents returns a cache's live entries in a comparable form
+func contents(c *Cache[string, int]) string {
+	var items []string
+	for _, shard := range c.shards {
+		shard.mutex.RLock()
+		for k, e := range shard.store {
+			items = append(items, fmt.Sprintf("%s=%d", k, e.Value))
+		}
+		shard.mutex.RUnlock()
+	}
+	sort.Strings(items)
+	return fmt.Sprint(items)
+}
+
+// TestWALRecovery tests that sets, deletes, expiry and clears are replayed
+func TestWALRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+	clock := newFakeClock()
+	cfg := Config{NumShards: 4, Clock: clock}
+
+	cache := openWAL(t, cfg, WALOptions{Path: path})
+	for i := 0; i < 20; i++ {
+		cache.Set(fmt.Sprint("k", i), i)
+	}
+	cache.Set("k1", 100)
+	cache.Delete("k2")
+	cache.Set("ttl", 1, time.Second)
+	cache.Set("lazy", 1, time.Second)
+	clock.Advance(2 * time.Second)
+	cache.Get("lazy") // Expired and removed, which is logged
+
+	// Clear a single shard
+	shard := cache.getShard("k3")
+	shard.mutex.Lock()
+	cache.clearLocked(shard)
+	shard.mutex.Unlock()
+	want := contents(cache)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openWAL(t, cfg, WALOptions{Path: path})
+	defer reopened.Close()
+	if got := contents(reopened); got != want {
+		t.Errorf("Recovered state differs:\n got %s\nwant %s", got, want)
+	}
+
+	// Replaying into a different shard count still clears the right keys
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path+".copy", data, 0o644)
+	other := openWAL(t, Config{NumShards: 16, Clock: clock}, WALOptions{Path: path + ".copy"})
+	defer other.Close()
+	if got := contents(other); got != want {
+		t.Errorf("Recovered state with 16 shards differs:\n got %s\nwant %s", got, want)
+	}
+}
+
+// TestWALClearReflectKeys tests replaying a shard clear for keys whose
+// hash differs between processes
+func TestWALClearReflectKeys(t *testing.T) {
+	type key struct{ A, B int }
+	path := filepath.Join(t.TempDir(), "cache.wal")
+	cfg := Config{NumShards: 4, CleanupInterval: -1}
+	opts := WALOptions{Path: path}
+
+	cache, err := Open[key, int](cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		cache.Set(key{i, i}, i)
+	}
+	shard := cache.getShard(key{0, 0})
+	shard.mutex.Lock()
+	cache.clearLocked(shard)
+	cache.unlock(shard)
+	want := cache.Size()
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay as another process would, with keys on other shards
+	defer func(seed maphash.Seed) { hashSeed = seed }(hashSeed)
+	hashSeed = maphash.MakeSeed()
+	cache, err = Open[key, int](cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if cache.Size() != want {
+		t.Errorf("Expected %d entries after replay, got %d", want, cache.Size())
+	}
+	if _, ok := cache.Get(key{0, 0}); ok {
+		t.Error("Expected the cleared key to stay cleared")
+	}
+}
+
+// TestWALTornTail tests recovery from a crash in the middle of an append
+func TestWALTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+	cache := openWAL(t, Config{}, WALOptions{Path: path})
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Close()
+	info, _ := os.Stat(path)
+	full := info.Size()
+
+	for _, tail := range [][]byte{
+		{0, 0, 0},                   // Torn frame header
+		{0, 0, 0, 9, 1, 2, 3, 4, 1}, // Payload shorter than its length
+		{0, 0, 0, 0, 0, 0, 0, 0},    // Zeroed blocks
+		{0, 0, 0, 1, 1, 2, 3, 4, 1}, // Complete but bad checksum
+	} {
+		f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		f.Write(tail)
+		f.Close()
+
+		cache = openWAL(t, Config{}, WALOptions{Path: path})
+		if got := contents(cache); got != "[a=1 b=2]" {
+			t.Errorf("Tail %v: recovered %s", tail, got)
+		}
+		if info, _ := os.Stat(path); info.Size() != full {
+			t.Errorf("Tail %v: expected log truncated to %d, got %d", tail, full, info.Size())
+		}
+		cache.Close()
+	}
+
+	// Appending after a truncated tail keeps the log readable
+	cache = openWAL(t, Config{}, WALOptions{Path: path})
+	cache.Set("c", 3)
+	cache.Close()
+	cache = openWAL(t, Config{}, WALOptions{Path: path})
+	defer cache.Close()
+	if got := contents(cache); got != "[a=1 b=2 c=3]" {
+		t.Errorf("Recovered %s", got)
+	}
+}
+
+// TestWALCorruption tests that damage before the tail is an error
+func TestWALCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+	cache := openWAL(t, Config{}, WALOptions{Path: path})
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprint(i), i)
+	}
+	cache.Close()
+
+	data, _ := os.ReadFile(path)
+	data[walHeaderSize+walFrameSize+2] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+	if _, err := Open[string, int](Config{}, WALOptions{Path: path}); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("Expected ErrInvalidSnapshot, got %v", err)
+	}
+
+	os.WriteFile(path, []byte("definitely not a log file"), 0o644)
+	if _, err := Open[string, int](Config{}, WALOptions{Path: path}); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("Expected ErrInvalidSnapshot for foreign file, got %v", err)
+	}
+}
+
+// TestWALWithSnapshot tests replaying the log on top of a snapshot
+func TestWALWithSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	opts := WALOptions{Path: filepath.Join(dir, "cache.wal"), SnapshotPath: filepath.Join(dir, "cache.snap")}
+
+	cache := openWAL(t, Config{}, opts) // Missing snapshot is fine
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	if err := cache.SaveSnapshotFile(opts.SnapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 10)
+	cache.Delete("b")
+	cache.Set("c", 3)
+	cache.Close()
+
+	cache = openWAL(t, Config{}, opts)
+	defer cache.Close()
+	if got := contents(cache); got != "[a=10 c=3]" {
+		t.Errorf("Recovered %s", got)
+	}
+}
+
+// TestWALCompaction tests rewriting the log from current state
+func TestWALCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := WALOptions{Path: filepath.Join(dir, "cache.wal"), SnapshotPath: filepath.Join(dir, "cache.snap"), CompactSize: -1}
+
+	cache := openWAL(t, Config{}, opts)
+	cache.Set("stale", 1)
+	cache.SaveSnapshotFile(opts.SnapshotPath)
+	cache.Delete("stale")
+	for i := 0; i < 1000; i++ {
+		cache.Set(fmt.Sprint(i%10), i)
+	}
+	before, _ := os.Stat(opts.Path)
+	if err := cache.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(opts.Path)
+	if after.Size() >= before.Size()/10 {
+		t.Errorf("Expected compaction to shrink the log, %d -> %d bytes", before.Size(), after.Size())
+	}
+	cache.Set("later", 1)
+	want := contents(cache)
+	cache.Close()
+
+	// The compacted log resets state, so the older snapshot cannot
+	// resurrect deleted keys
+	cache = openWAL(t, Config{}, opts)
+	defer cache.Close()
+	if got := contents(cache); got != want {
+		t.Errorf("Recovered state differs:\n got %s\nwant %s", got, want)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Expected only the log and snapshot, found %d files", len(entries))
+	}
+}
+
+// TestWALCompactionConcurrentWrites tests writes racing a compaction
+func TestWALCompactionConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+	cache := openWAL(t, Config{NumShards: 8}, WALOptions{Path: path, Sync: WALSyncNever, CompactSize: -1})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < 2000; i++ {
+				k := fmt.Sprint(rng.Intn(200))
+				if rng.Intn(4) == 0 {
+					cache.Delete(k)
+				} else {
+					cache.Set(k, i)
+				}
+			}
+		}(int64(g))
+	}
+	for i := 0; i < 5; i++ {
+		if err := cache.Compact(); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+	want := contents(cache)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache = openWAL(t, Config{NumShards: 8}, WALOptions{Path: path})
+	defer cache.Close()
+	if got := contents(cache); got != want {
+		t.Error("Recovered state differs from the state at close")
+	}
+}
+
+// TestWALBackgroundCompaction tests compaction triggered by log growth
+func TestWALBackgroundCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+	cache := openWAL(t, Config{}, WALOptions{Path: path, Sync: WALSyncPeriodic, SyncInterval: time.Millisecond, CompactSize: 4096})
+	defer cache.Close()
+
+	for i := 0; i < 2000; i++ {
+		cache.Set("key", i)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, _ := os.Stat(path)
+		if info.Size() < 4096 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Log was not compacted, %d bytes", info.Size())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := cache.Sync(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestWALLogsEvictions tests that evicted keys stay evicted after recovery
+func TestWALLogsEvictions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+	cache := openWAL(t, Config{NumShards: 1, MaxCapacity: 3}, WALOptions{Path: path})
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprint(i), i)
+	}
+	cache.Close()
+
+	cache = openWAL(t, Config{NumShards: 1}, WALOptions{Path: path})
+	defer cache.Close()
+	if got := contents(cache); got != "[7=7 8=8 9=9]" {
+		t.Errorf("Recovered %s", got)
+	}
+}
+
+// TestWALEncodeError tests values the codec cannot encode
+func TestWALEncodeError(t *testing.T) {
+	type unregistered struct{ X int }
+	path := filepath.Join(t.TempDir(), "cache.wal")
+
+	cache, err := OpenKVCache(Config{}, WALOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("k", "old")
+	cache.Set("k", unregistered{1})
+	cache.Set("other", "fine")
+	if err := cache.Sync(); err == nil {
+		t.Error("Expected Sync to report the encoding error")
+	}
+	cache.Close()
+
+	cache, err = OpenKVCache(Config{}, WALOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if _, ok := cache.Get("k"); ok {
+		t.Error("A value that could not be logged should not recover an older one")
+	}
+	if v, _ := cache.Get("other"); v != "fine" {
+		t.Errorf("Expected later writes to be logged, got %v", v)
+	}
+}
+
+// TestWALOptionsValidate tests option validation
+func TestWALOptionsValidate(t *testing.T) {
+	for _, opts := range []WALOptions{
+		{},
+		{Path: "x", Sync: WALSyncNever + 1},
+		{Path: "x", SyncInterval: -1},
+	} {
+		if _, err := Open[string, int](Config{}, opts); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Open(%+v) = %v, want ErrInvalidConfig", opts, err)
+		}
+	}
+
+	// Caches without a log accept the log methods
+	cache := New[string, int](0)
+	defer cache.Close()
+	if cache.Sync() != nil || cache.Compact() != nil {
+		t.Error("Expected no-op log methods without a log")
+	}
+}
@@ -0,0 +1,391 @@
+package memcached
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Binary protocol magic bytes
+const (
+	magicRequest  = 0x80
+	magicResponse = 0x81
+)
+
+const headerSize = 24
+
+// Binary protocol opcodes
+const (
+	opGet       = 0x00
+	opSet       = 0x01
+	opAdd       = 0x02
+	opReplace   = 0x03
+	opDelete    = 0x04
+	opIncrement = 0x05
+	opDecrement = 0x06
+	opQuit      = 0x07
+	opFlush     = 0x08
+	opGetQ      = 0x09
+	opNoop      = 0x0a
+	opVersion   = 0x0b
+	opGetK      = 0x0c
+	opGetKQ     = 0x0d
+	opAppend    = 0x0e
+	opPrepend   = 0x0f
+	opStat      = 0x10
+	opSetQ      = 0x11
+	opAddQ      = 0x12
+	opReplaceQ  = 0x13
+	opDeleteQ   = 0x14
+	opIncrQ     = 0x15
+	opDecrQ     = 0x16
+	opQuitQ     = 0x17
+	opFlushQ    = 0x18
+	opAppendQ   = 0x19
+	opPrependQ  = 0x1a
+	opTouch     = 0x1c
+	opGAT       = 0x1d
+	opGATQ      = 0x1e
+	opGATK      = 0x23
+	opGATKQ     = 0x24
+)
+
+// Binary protocol response statuses
+const (
+	statusOK             = 0x00
+	statusKeyNotFound    = 0x01
+	statusKeyExists      = 0x02
+	statusTooLarge       = 0x03
+	statusInvalidArgs    = 0x04
+	statusNotStored      = 0x05
+	statusNonNumeric     = 0x06
+	statusUnknownCommand = 0x81
+)
+
+var statusMessages = map[uint16]string{
+	statusKeyNotFound:    "Not found",
+	statusKeyExists:      "Data exists for key.",
+	statusTooLarge:       "Too large.",
+	statusInvalidArgs:    "Invalid arguments",
+	statusNotStored:      "Not stored.",
+	statusNonNumeric:     "Non-numeric server-side value for incr or decr",
+	statusUnknownCommand: "Unknown command",
+}
+
+var storeStatuses = [...]uint16{
+	stored:     statusOK,
+	notStored:  statusNotStored,
+	exists:     statusKeyExists,
+	notFound:   statusKeyNotFound,
+	nonNumeric: statusNonNumeric,
+}
+
+// noExpiry in an incr/decr request's expiration means fail if the key is
+// missing rather than create it
+const noExpiry = math.MaxUint32
+
+// request is a decoded binary request
+type request struct {
+	opcode uint8
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+// binaryConn is one binary protocol connection's state
+type binaryConn struct {
+	s    *Server
+	r    *bufio.Reader
+	w    *bufio.Writer
+	hdr  [headerSize]byte
+	quit bool
+}
+
+// serveBinary runs the binary protocol loop. Responses are buffered and
+// flushed once no pipelined requests are waiting.
+func (s *Server) serveBinary(r *bufio.Reader, w *bufio.Writer) {
+	c := &binaryConn{s: s, r: r, w: w}
+	for !c.quit {
+		req, status, err := c.readRequest()
+		if err != nil {
+			return
+		}
+		if status != statusOK {
+			c.error(req, status)
+		} else {
+			c.dispatch(req)
+		}
+		if c.quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readRequest reads one request. A non-OK status reports a request that
+// was read but cannot be served; an error means the stream is unusable.
+func (c *binaryConn) readRequest() (*request, uint16, error) {
+	hdr := c.hdr[:]
+	if _, err := io.ReadFull(c.r, hdr); err != nil {
+		return nil, 0, err
+	}
+	if hdr[0] != magicRequest {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	req := &request{
+		opcode: hdr[1],
+		opaque: binary.BigEndian.Uint32(hdr[12:]),
+		cas:    binary.BigEndian.Uint64(hdr[16:]),
+	}
+	keyLen := int(binary.BigEndian.Uint16(hdr[2:]))
+	extLen := int(hdr[4])
+	bodyLen := int(binary.BigEndian.Uint32(hdr[8:]))
+
+	if bodyLen > maxValueSize+maxKeySize+64 {
+		if _, err := c.r.Discard(bodyLen); err != nil {
+			return nil, 0, err
+		}
+		return req, statusTooLarge, nil
+	}
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, 0, err
+	}
+	if keyLen+extLen > bodyLen || keyLen > maxKeySize {
+		return req, statusInvalidArgs, nil
+	}
+	req.extras = body[:extLen]
+	req.key = body[extLen : extLen+keyLen]
+	req.value = body[extLen+keyLen:]
+	return req, statusOK, nil
+}
+
+// respond writes one response
+func (c *binaryConn) respond(req *request, status uint16, cas uint64, extras, key, value []byte) {
+	hdr := c.hdr[:]
+	hdr[0] = magicResponse
+	hdr[1] = req.opcode
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(key)))
+	hdr[4] = uint8(len(extras))
+	hdr[5] = 0
+	binary.BigEndian.PutUint16(hdr[6:], status)
+	binary.BigEndian.PutUint32(hdr[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(hdr[12:], req.opaque)
+	binary.BigEndian.PutUint64(hdr[16:], cas)
+	c.w.Write(hdr)
+	c.w.Write(extras)
+	c.w.Write(key)
+	c.w.Write(value)
+}
+
+// error writes a failure response with the status's message as the body
+func (c *binaryConn) error(req *request, status uint16) {
+	c.respond(req, status, 0, nil, nil, []byte(statusMessages[status]))
+}
+
+// quiet reports whether an opcode is a quiet variant, which sends no
+// response on success (or, for gets, on a miss)
+func quiet(opcode uint8) bool {
+	switch opcode {
+	case opGetQ, opGetKQ, opSetQ, opAddQ, opReplaceQ, opDeleteQ, opIncrQ,
+		opDecrQ, opQuitQ, opFlushQ, opAppendQ, opPrependQ, opGATQ, opGATKQ:
+		return true
+	}
+	return false
+}
+
+func (c *binaryConn) dispatch(req *request) {
+	switch req.opcode {
+	case opGet, opGetQ, opGetK, opGetKQ:
+		c.cmdGet(req)
+	case opSet, opSetQ:
+		c.cmdStore(req, modeSet)
+	case opAdd, opAddQ:
+		c.cmdStore(req, modeAdd)
+	case opReplace, opReplaceQ:
+		c.cmdStore(req, modeReplace)
+	case opAppend, opAppendQ:
+		c.cmdStore(req, modeAppend)
+	case opPrepend, opPrependQ:
+		c.cmdStore(req, modePrepend)
+	case opDelete, opDeleteQ:
+		c.cmdDelete(req)
+	case opIncrement, opIncrQ, opDecrement, opDecrQ:
+		c.cmdDelta(req)
+	case opTouch, opGAT, opGATQ, opGATK, opGATKQ:
+		c.cmdTouch(req)
+	case opFlush, opFlushQ:
+		c.cmdFlush(req)
+	case opStat:
+		c.cmdStat(req)
+	case opNoop:
+		c.respond(req, statusOK, 0, nil, nil, nil)
+	case opVersion:
+		c.respond(req, statusOK, 0, nil, nil, []byte(Version))
+	case opQuit, opQuitQ:
+		if !quiet(req.opcode) {
+			c.respond(req, statusOK, 0, nil, nil, nil)
+		}
+		c.quit = true
+	default:
+		c.error(req, statusUnknownCommand)
+	}
+}
+
+// result writes the response to a write, which quiet variants skip on
+// success
+func (c *binaryConn) result(req *request, result storeResult, cas uint64, value []byte) {
+	if result != stored {
+		c.error(req, storeStatuses[result])
+		return
+	}
+	if !quiet(req.opcode) {
+		c.respond(req, statusOK, cas, nil, nil, value)
+	}
+}
+
+// item writes a get response carrying the item's flags, and its key for
+// the K variants
+func (c *binaryConn) item(req *request, it Item) {
+	var extras [4]byte
+	binary.BigEndian.PutUint32(extras[:], it.Flags)
+	var key []byte
+	switch req.opcode {
+	case opGetK, opGetKQ, opGATK, opGATKQ:
+		key = req.key
+	}
+	c.respond(req, statusOK, it.CAS, extras[:], key, it.Data)
+}
+
+func (c *binaryConn) cmdGet(req *request) {
+	if len(req.extras) != 0 || len(req.key) == 0 || len(req.value) != 0 {
+		c.error(req, statusInvalidArgs)
+		return
+	}
+	c.s.gets.Add(1)
+	it, _, ok := c.s.get(string(req.key))
+	switch {
+	case ok:
+		c.item(req, it)
+	case !quiet(req.opcode):
+		c.error(req, statusKeyNotFound)
+	}
+}
+
+// cmdStore implements set, add, replace (extras: flags, expiration) and
+// append, prepend (no extras). A non-zero CAS makes the write conditional.
+func (c *binaryConn) cmdStore(req *request, mode storeMode) {
+	var flags uint32
+	var exptime int64
+	switch {
+	case len(req.key) == 0:
+		c.error(req, statusInvalidArgs)
+		return
+	case mode == modeAppend || mode == modePrepend:
+		if len(req.extras) != 0 {
+			c.error(req, statusInvalidArgs)
+			return
+		}
+	case len(req.extras) != 8:
+		c.error(req, statusInvalidArgs)
+		return
+	default:
+		flags = binary.BigEndian.Uint32(req.extras)
+		exptime = int64(binary.BigEndian.Uint32(req.extras[4:]))
+	}
+	if len(req.value) > maxValueSize {
+		c.error(req, statusTooLarge)
+		return
+	}
+	if mode == modeAdd && req.cas != 0 {
+		c.error(req, statusInvalidArgs)
+		return
+	}
+
+	c.s.sets.Add(1)
+	result, cas := c.s.store(mode, string(req.key), flags, exptime, req.value, req.cas)
+	c.result(req, result, cas, nil)
+}
+
+func (c *binaryConn) cmdDelete(req *request) {
+	if len(req.extras) != 0 || len(req.key) == 0 || len(req.value) != 0 {
+		c.error(req, statusInvalidArgs)
+		return
+	}
+	c.result(req, c.s.remove(string(req.key), req.cas), 0, nil)
+}
+
+// cmdDelta implements increment and decrement. Extras are the delta, the
+// initial value stored if the key is missing, and that item's expiration.
+func (c *binaryConn) cmdDelta(req *request) {
+	if len(req.extras) != 20 || len(req.key) == 0 || len(req.value) != 0 {
+		c.error(req, statusInvalidArgs)
+		return
+	}
+	amount := binary.BigEndian.Uint64(req.extras)
+	initial := binary.BigEndian.Uint64(req.extras[8:])
+	exptime := binary.BigEndian.Uint32(req.extras[16:])
+	incr := req.opcode == opIncrement || req.opcode == opIncrQ
+	key := string(req.key)
+
+	n, cas, result := c.s.deltaOrCreate(key, incr, amount, initial, exptime, req.cas)
+	var value [8]byte
+	binary.BigEndian.PutUint64(value[:], n)
+	c.result(req, result, cas, value[:])
+}
+
+// cmdTouch implements touch and the get-and-touch variants, whose extras
+// hold the new expiration
+func (c *binaryConn) cmdTouch(req *request) {
+	if len(req.extras) != 4 || len(req.key) == 0 || len(req.value) != 0 {
+		c.error(req, statusInvalidArgs)
+		return
+	}
+	exptime := int64(binary.BigEndian.Uint32(req.extras))
+	c.s.touches.Add(1)
+	if req.opcode != opTouch {
+		c.s.gets.Add(1)
+	}
+	it, ok := c.s.touch(string(req.key), exptime)
+	switch {
+	case !ok && req.opcode != opGATQ && req.opcode != opGATKQ:
+		c.error(req, statusKeyNotFound)
+	case !ok:
+	case req.opcode == opTouch:
+		c.respond(req, statusOK, it.CAS, nil, nil, nil)
+	default:
+		c.item(req, it)
+	}
+}
+
+// cmdFlush implements flush with an optional delay in the extras
+func (c *binaryConn) cmdFlush(req *request) {
+	var delay int64
+	switch len(req.extras) {
+	case 0:
+	case 4:
+		delay = int64(binary.BigEndian.Uint32(req.extras))
+	default:
+		c.error(req, statusInvalidArgs)
+		return
+	}
+	c.s.flush(delay)
+	c.result(req, stored, 0, nil)
+}
+
+// cmdStat sends one response per statistic and an empty one to finish.
+// Only the general group (an empty key) is supported.
+func (c *binaryConn) cmdStat(req *request) {
+	if len(req.key) != 0 {
+		c.error(req, statusKeyNotFound)
+		return
+	}
+	for _, stat := range c.s.stats() {
+		c.respond(req, statusOK, 0, nil, []byte(stat[0]), []byte(stat[1]))
+	}
+	c.respond(req, statusOK, 0, nil, nil, nil)
+}
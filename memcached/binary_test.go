@@ -0,0 +1,267 @@
+package memcached
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// response is a decoded binary response
+type response struct {
+	opcode uint8
+	status uint16
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+// encodeRequest formats a binary request
+func encodeRequest(req request) []byte {
+	b := make([]byte, headerSize, headerSize+len(req.extras)+len(req.key)+len(req.value))
+	b[0] = magicRequest
+	b[1] = req.opcode
+	binary.BigEndian.PutUint16(b[2:], uint16(len(req.key)))
+	b[4] = uint8(len(req.extras))
+	binary.BigEndian.PutUint32(b[8:], uint32(len(req.extras)+len(req.key)+len(req.value)))
+	binary.BigEndian.PutUint32(b[12:], req.opaque)
+	binary.BigEndian.PutUint64(b[16:], req.cas)
+	b = append(b, req.extras...)
+	b = append(b, req.key...)
+	return append(b, req.value...)
+}
+
+// send writes requests without reading responses
+func (c *testConn) send(reqs ...request) {
+	c.t.Helper()
+	for _, req := range reqs {
+		if _, err := c.conn.Write(encodeRequest(req)); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+// do sends one request and reads its response
+func (c *testConn) do(req request) response {
+	c.t.Helper()
+	c.send(req)
+	return c.response()
+}
+
+func (c *testConn) response() response {
+	c.t.Helper()
+	hdr := make([]byte, headerSize)
+	if _, err := io.ReadFull(c.r, hdr); err != nil {
+		c.t.Fatal(err)
+	}
+	if hdr[0] != magicResponse {
+		c.t.Fatalf("Bad response magic %#x", hdr[0])
+	}
+	body := make([]byte, binary.BigEndian.Uint32(hdr[8:]))
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatal(err)
+	}
+	keyLen, extLen := int(binary.BigEndian.Uint16(hdr[2:])), int(hdr[4])
+	return response{
+		opcode: hdr[1],
+		status: binary.BigEndian.Uint16(hdr[6:]),
+		opaque: binary.BigEndian.Uint32(hdr[12:]),
+		cas:    binary.BigEndian.Uint64(hdr[16:]),
+		extras: body[:extLen],
+		key:    body[extLen : extLen+keyLen],
+		value:  body[extLen+keyLen:],
+	}
+}
+
+func flagsOf(res response) uint32 {
+	if len(res.extras) != 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(res.extras)
+}
+
+// storeExtras builds set/add/replace extras
+func storeExtras(flags, exptime uint32) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b, flags)
+	binary.BigEndian.PutUint32(b[4:], exptime)
+	return b
+}
+
+// deltaExtras builds incr/decr extras
+func deltaExtras(delta, initial uint64, exptime uint32) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint64(b, delta)
+	binary.BigEndian.PutUint64(b[8:], initial)
+	binary.BigEndian.PutUint32(b[16:], exptime)
+	return b
+}
+
+func expiryExtras(exptime uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, exptime)
+}
+
+// TestBinaryStorage tests get, set, add, replace, append, prepend, delete and CAS
+func TestBinaryStorage(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+	key := []byte("k")
+
+	if res := c.do(request{opcode: opGet, key: key, opaque: 7}); res.status != statusKeyNotFound || res.opaque != 7 || string(res.value) != "Not found" {
+		t.Errorf("Unexpected miss %+v", res)
+	}
+	set := c.do(request{opcode: opSet, extras: storeExtras(3, 0), key: key, value: []byte("abc")})
+	if set.status != statusOK || set.cas == 0 {
+		t.Fatalf("Unexpected set %+v", set)
+	}
+	res := c.do(request{opcode: opGetK, key: key})
+	if res.status != statusOK || string(res.key) != "k" || string(res.value) != "abc" || flagsOf(res) != 3 || res.cas != set.cas {
+		t.Errorf("Unexpected getk %+v", res)
+	}
+
+	if res := c.do(request{opcode: opAdd, extras: storeExtras(0, 0), key: key, value: []byte("x")}); res.status != statusNotStored {
+		t.Errorf("Expected add of existing key to fail, got %+v", res)
+	}
+	if res := c.do(request{opcode: opReplace, extras: storeExtras(0, 0), key: []byte("nope"), value: []byte("x")}); res.status != statusNotStored {
+		t.Errorf("Expected replace of missing key to fail, got %+v", res)
+	}
+	if res := c.do(request{opcode: opSet, extras: storeExtras(0, 0), key: key, value: []byte("x"), cas: set.cas + 1}); res.status != statusKeyExists {
+		t.Errorf("Expected CAS mismatch, got %+v", res)
+	}
+	if res := c.do(request{opcode: opAppend, key: key, value: []byte("de"), cas: set.cas}); res.status != statusOK {
+		t.Errorf("Expected CAS append to succeed, got %+v", res)
+	}
+	c.do(request{opcode: opPrepend, key: key, value: []byte("0")})
+	if res := c.do(request{opcode: opGet, key: key}); string(res.value) != "0abcde" || flagsOf(res) != 3 || len(res.key) != 0 {
+		t.Errorf("Unexpected get after append %+v", res)
+	}
+	if res := c.do(request{opcode: opAppend, extras: storeExtras(0, 0), key: key, value: []byte("x")}); res.status != statusInvalidArgs {
+		t.Errorf("Expected append with extras to fail, got %+v", res)
+	}
+
+	if res := c.do(request{opcode: opDelete, key: key}); res.status != statusOK {
+		t.Errorf("Unexpected delete %+v", res)
+	}
+	if res := c.do(request{opcode: opDelete, key: key}); res.status != statusKeyNotFound {
+		t.Errorf("Expected second delete to miss, got %+v", res)
+	}
+}
+
+// TestBinaryQuiet tests that quiet requests only answer failures and that
+// pipelined responses keep their order
+func TestBinaryQuiet(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	c.send(
+		request{opcode: opSetQ, extras: storeExtras(0, 0), key: []byte("a"), value: []byte("1"), opaque: 1},
+		request{opcode: opAddQ, extras: storeExtras(0, 0), key: []byte("a"), value: []byte("2"), opaque: 2},
+		request{opcode: opGetKQ, key: []byte("missing"), opaque: 3},
+		request{opcode: opGetKQ, key: []byte("a"), opaque: 4},
+		request{opcode: opDeleteQ, key: []byte("a"), opaque: 5},
+		request{opcode: opNoop, opaque: 6},
+	)
+	for _, want := range []struct {
+		opaque uint32
+		status uint16
+	}{{2, statusNotStored}, {4, statusOK}, {6, statusOK}} {
+		res := c.response()
+		if res.opaque != want.opaque || res.status != want.status {
+			t.Errorf("Expected opaque %d status %d, got %+v", want.opaque, want.status, res)
+		}
+	}
+}
+
+// TestBinaryCounters tests increment and decrement with initial values
+func TestBinaryCounters(t *testing.T) {
+	_, cache, addr := newTestServer(t)
+	c := dial(t, addr)
+	key := []byte("n")
+	value := func(res response) uint64 {
+		t.Helper()
+		if res.status != statusOK || len(res.value) != 8 {
+			t.Fatalf("Unexpected counter response %+v", res)
+		}
+		return binary.BigEndian.Uint64(res.value)
+	}
+
+	if res := c.do(request{opcode: opIncrement, extras: deltaExtras(1, 0, noExpiry), key: key}); res.status != statusKeyNotFound {
+		t.Errorf("Expected miss with no-create expiration, got %+v", res)
+	}
+	if n := value(c.do(request{opcode: opIncrement, extras: deltaExtras(1, 10, 60), key: key})); n != 10 {
+		t.Errorf("Expected initial value 10, got %d", n)
+	}
+	if _, ttl, _ := cache.GetWithTTL("n"); ttl == 0 {
+		t.Error("Expected created counter to expire")
+	}
+	if n := value(c.do(request{opcode: opIncrement, extras: deltaExtras(5, 0, 0), key: key})); n != 15 {
+		t.Errorf("Expected 15, got %d", n)
+	}
+	if n := value(c.do(request{opcode: opDecrement, extras: deltaExtras(20, 0, 0), key: key})); n != 0 {
+		t.Errorf("Expected decrement to stop at 0, got %d", n)
+	}
+
+	c.do(request{opcode: opSet, extras: storeExtras(0, 0), key: []byte("s"), value: []byte("abc")})
+	if res := c.do(request{opcode: opIncrement, extras: deltaExtras(1, 0, 0), key: []byte("s")}); res.status != statusNonNumeric {
+		t.Errorf("Expected non-numeric error, got %+v", res)
+	}
+	if res := c.do(request{opcode: opIncrement, extras: deltaExtras(1, 0, 0)[:8], key: key}); res.status != statusInvalidArgs {
+		t.Errorf("Expected invalid arguments, got %+v", res)
+	}
+}
+
+// TestBinaryServerCommands tests touch, gat, flush, stat, version, unknown
+// opcodes and quit
+func TestBinaryServerCommands(t *testing.T) {
+	_, cache, addr := newTestServer(t)
+	c := dial(t, addr)
+	key := []byte("k")
+
+	c.do(request{opcode: opSet, extras: storeExtras(1, 0), key: key, value: []byte("v")})
+	if res := c.do(request{opcode: opTouch, extras: expiryExtras(100), key: key}); res.status != statusOK {
+		t.Errorf("Unexpected touch %+v", res)
+	}
+	if _, ttl, _ := cache.GetWithTTL("k"); ttl == 0 {
+		t.Error("Expected touch to set an expiry")
+	}
+	if res := c.do(request{opcode: opGAT, extras: expiryExtras(0), key: key}); string(res.value) != "v" || flagsOf(res) != 1 {
+		t.Errorf("Unexpected gat %+v", res)
+	}
+	if _, ttl, _ := cache.GetWithTTL("k"); ttl != 0 {
+		t.Errorf("Expected gat 0 to remove the expiry, got %v", ttl)
+	}
+	if res := c.do(request{opcode: opTouch, extras: expiryExtras(1), key: []byte("missing")}); res.status != statusKeyNotFound {
+		t.Errorf("Expected touch of missing key to fail, got %+v", res)
+	}
+
+	c.send(request{opcode: opStat})
+	stats := map[string]string{}
+	for {
+		res := c.response()
+		if len(res.key) == 0 {
+			break
+		}
+		stats[string(res.key)] = string(res.value)
+	}
+	if stats["curr_items"] != "1" || stats["version"] != Version {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+
+	if res := c.do(request{opcode: opVersion}); string(res.value) != Version {
+		t.Errorf("Unexpected version %+v", res)
+	}
+	if res := c.do(request{opcode: 0x7f}); res.status != statusUnknownCommand {
+		t.Errorf("Expected unknown command, got %+v", res)
+	}
+	if res := c.do(request{opcode: opFlush}); res.status != statusOK || cache.Size() != 0 {
+		t.Errorf("Expected flush to clear the cache, got %+v with %d items", res, cache.Size())
+	}
+
+	if res := c.do(request{opcode: opQuit}); res.status != statusOK {
+		t.Errorf("Unexpected quit %+v", res)
+	}
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Error("Expected quit to close the connection")
+	}
+}
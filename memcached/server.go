@@ -0,0 +1,190 @@
+// Package memcached exposes a kvcache.KVCache over TCP using the memcached
+// text and binary protocols. Each connection's protocol is detected from
+// its first byte.
+//
+// Items are stored as Item values holding their flags, CAS unique and
+// data. kvcache.DefaultCost charges them their data's size plus 12 bytes
+// against a memory budget (Config.MaxCost). Other values read as items
+// with flags 0. Items stored with an exptime of 0 never expire, as in
+// memcached, even on a cache with a DefaultTTL.
+//
+// Commands that read and then write a key, such as cas, append and incr,
+// do so in one step under the key's shard lock, so they are atomic with
+// respect to every other frontend sharing the cache.
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// Version is reported by the version command and stats
+const Version = "1.6.0-kvdb"
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close
+var ErrServerClosed = errors.New("memcached: server closed")
+
+// Limits on what a client may send in one command
+const (
+	maxLineSize  = 2048
+	maxKeySize   = 250
+	maxValueSize = 64 * 1024 * 1024
+)
+
+// Server serves one KVCache to any number of connections.
+// The cache is owned by the caller and is not closed by Close.
+type Server struct {
+	cache   *kvcache.KVCache
+	started time.Time
+	pid     int
+
+	casCounter atomic.Uint64
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]struct{}
+	flushTimer *time.Timer
+	closed     bool
+	wg         sync.WaitGroup
+
+	// Metrics reported by stats
+	connections atomic.Uint64
+	gets        atomic.Uint64
+	sets        atomic.Uint64
+	touches     atomic.Uint64
+}
+
+// New creates a server for cache
+func New(cache *kvcache.KVCache) *Server {
+	return &Server{
+		cache:     cache,
+		started:   time.Now(),
+		pid:       os.Getpid(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called, then returns
+// ErrServerClosed. Each connection is handled on its own goroutine.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// Out of file descriptors and the like; back off and retry
+				delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		if !s.track(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.connections.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// track registers a connection unless the server is closed
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Close stops all listeners, cancels a pending delayed flush_all, closes
+// every connection and waits for their handlers to return
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); err == nil {
+			err = cerr
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// serveConn detects the connection's protocol from its first byte and runs
+// the matching read-execute loop
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReaderSize(conn, 16*1024)
+	w := bufio.NewWriterSize(conn, 16*1024)
+	first, err := r.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] == magicRequest {
+		s.serveBinary(r, w)
+	} else {
+		s.serveText(r, w)
+	}
+}
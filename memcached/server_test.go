@@ -0,0 +1,135 @@
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+	"github.com/HueCodes/Fast-Cache/server"
+)
+
+// newTestServer serves a fresh cache on a loopback port
+func newTestServer(t *testing.T) (*Server, *kvcache.KVCache, string) {
+	t.Helper()
+	cache := kvcache.NewKVCacheWithConfig(kvcache.Config{NumShards: 16})
+	srv := New(cache)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(l) }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-done; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Serve returned %v", err)
+		}
+		cache.Close()
+	})
+	return srv, cache, l.Addr().String()
+}
+
+// testConn is a raw connection to the server
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *testConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &testConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *testConn) write(s string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, s); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// TestServerClose tests that Close stops Serve and drops connections
+func TestServerClose(t *testing.T) {
+	cache := kvcache.NewKVCacheWithConfig(kvcache.Config{NumShards: 16})
+	defer cache.Close()
+	srv := New(cache)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(l) }()
+
+	c := dial(t, l.Addr().String())
+	c.write("version\r\n")
+	if line, _ := c.r.ReadString('\n'); line != "VERSION "+Version+"\r\n" {
+		t.Fatalf("Unexpected version reply %q", line)
+	}
+
+	srv.flush(3600) // Pending delayed flush is canceled
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected ErrServerClosed, got %v", err)
+	}
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Error("Expected connection to be closed")
+	}
+	if err := srv.Serve(l); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected ErrServerClosed after Close, got %v", err)
+	}
+}
+
+// TestServerSharedCache tests that both protocols see the same items
+func TestServerSharedCache(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	text, bin := dial(t, addr), dial(t, addr)
+
+	text.write("set k 7 0 5\r\nhello\r\n")
+	if line, _ := text.r.ReadString('\n'); line != "STORED\r\n" {
+		t.Fatalf("Unexpected set reply %q", line)
+	}
+	res := bin.do(request{opcode: opGet, key: []byte("k")})
+	if res.status != statusOK || string(res.value) != "hello" || flagsOf(res) != 7 {
+		t.Errorf("Unexpected binary get %+v", res)
+	}
+}
+
+// TestServerCrossProtocol tests keys shared with the Redis server on one
+// cache
+func TestServerCrossProtocol(t *testing.T) {
+	_, cache, addr := newTestServer(t)
+	redis := server.New(cache)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go redis.Serve(l)
+	defer redis.Close()
+	mc, rc := dial(t, addr), dial(t, l.Addr().String())
+
+	// Set over memcached, read over Redis: the data only
+	mc.expect("set k 7 0 5\r\nhello\r\n", "STORED\r\n")
+	rc.expect("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", "$5\r\nhello\r\n")
+
+	// Set over Redis, read over memcached: the whole value, flags 0
+	value := "a value longer than twelve bytes"
+	rc.expect(fmt.Sprintf("*3\r\n$3\r\nSET\r\n$1\r\nr\r\n$%d\r\n%s\r\n", len(value), value), "+OK\r\n")
+	mc.expect("get r\r\n", fmt.Sprintf("VALUE r 0 %d\r\n%s\r\nEND\r\n", len(value), value))
+
+	// Appending keeps it readable by both
+	mc.expect("append r 0 0 1\r\n!\r\n", "STORED\r\n")
+	rc.expect("*2\r\n$3\r\nGET\r\n$1\r\nr\r\n", fmt.Sprintf("$%d\r\n%s!\r\n", len(value)+1, value))
+}
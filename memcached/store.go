@@ -0,0 +1,368 @@
+package memcached
+
+import (
+	"encoding/gob"
+	"strconv"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// maxRelativeExptime is the largest exptime taken as seconds from now;
+// larger values are absolute Unix times, as in memcached
+const maxRelativeExptime = 60 * 60 * 24 * 30
+
+// itemOverhead is the cost charged for an Item's flags and CAS unique, on
+// top of its data
+const itemOverhead = 12
+
+// foreignCAS marks the CAS uniques made up for values not stored by this
+// package, which are their entry versions, so they never equal an Item's
+const foreignCAS = 1 << 63
+
+// storeMode selects the condition a storage command writes under
+type storeMode int
+
+const (
+	modeSet storeMode = iota
+	modeAdd
+	modeReplace
+	modeAppend
+	modePrepend
+	modeCAS
+)
+
+// storeResult is the outcome of a write, named after the text protocol reply
+type storeResult int
+
+const (
+	stored storeResult = iota
+	notStored
+	exists
+	notFound
+	nonNumeric
+)
+
+// Item is the value stored in the cache for a key written over the
+// memcached protocols. It is registered with gob, so it survives snapshots
+// and the write-ahead log.
+type Item struct {
+	Flags uint32
+	CAS   uint64
+	Data  []byte
+}
+
+// Bytes returns the item's data, which the Redis server and HTTP API serve
+// as the key's value
+func (it Item) Bytes() []byte {
+	return it.Data
+}
+
+// Cost returns what the item is charged against Config.MaxCost by
+// kvcache.DefaultCost: its data's size plus itemOverhead
+func (it Item) Cost() int64 {
+	return int64(itemOverhead + len(it.Data))
+}
+
+func init() {
+	gob.Register(Item{})
+}
+
+// ttl converts a memcached exptime to a TTL: 0 never expires, whatever the
+// cache's DefaultTTL, up to 30 days is relative, anything larger an
+// absolute Unix time. expired is set for negative and past times, which
+// memcached treats as already expired.
+func ttl(exptime int64, now time.Time) (d time.Duration, expired bool) {
+	switch {
+	case exptime == 0:
+		return kvcache.NoExpiration, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= maxRelativeExptime:
+		return time.Duration(exptime) * time.Second, false
+	default:
+		d = time.Unix(exptime, 0).Sub(now)
+		return d, d <= 0
+	}
+}
+
+// get reads an item and its remaining TTL (0 if it never expires)
+func (s *Server) get(key string) (Item, time.Duration, bool) {
+	v, version, remaining, ok := s.cache.GetWithVersionAndTTL(key)
+	if !ok {
+		return Item{}, 0, false
+	}
+	return itemOf(v, version), remaining, true
+}
+
+// itemOf reads a cached value as an item. Values stored by something other
+// than this server read as items with flags 0, their entry version as CAS
+// unique and, for strings and byte slices, their contents as data.
+func itemOf(v interface{}, version uint64) Item {
+	switch v := v.(type) {
+	case Item:
+		return v
+	case []byte:
+		return Item{Data: v, CAS: version | foreignCAS}
+	case string:
+		return Item{Data: []byte(v), CAS: version | foreignCAS}
+	default:
+		return Item{CAS: version | foreignCAS}
+	}
+}
+
+// apply runs a command that reads and then writes key as one step, so it
+// is atomic with respect to every other write to the cache, whichever
+// frontend makes it. fn receives key's item and whether it is present, and
+// returns the new item, what to do with it as for KVCache.Compute, and the
+// command's result. A stored item takes ttl if given and otherwise keeps
+// its expiry.
+//
+// fn runs under the shard lock of KVCache.Compute, where the entry version
+// of a value not stored by this server is not visible, so its CAS unique
+// reads as foreignCAS alone and matches no casUnique. A casUnique made up
+// by get is checked instead by applyVersion.
+func (s *Server) apply(key string, casUnique uint64, fn func(current Item, ok bool) (Item, kvcache.Op, storeResult), ttl ...time.Duration) storeResult {
+	if casUnique&foreignCAS != 0 {
+		return s.applyVersion(key, fn, ttl)
+	}
+	var result storeResult
+	s.cache.Compute(key, func(old interface{}, exists bool) (interface{}, kvcache.Op) {
+		var current Item
+		if exists {
+			current = itemOf(old, 0)
+		}
+		item, op, r := fn(current, exists)
+		result = r
+		if op == kvcache.OpKeep {
+			return old, op
+		}
+		return item, op
+	}, ttl...)
+	return result
+}
+
+// applyVersion is apply for a value read with its entry version, which is
+// written back with CompareAndSwap or CompareAndDelete at that version. A
+// value changed in between no longer has the CAS unique fn checked, so the
+// command reports exists.
+func (s *Server) applyVersion(key string, fn func(current Item, ok bool) (Item, kvcache.Op, storeResult), ttl []time.Duration) storeResult {
+	v, version, remaining, ok := s.cache.GetWithVersionAndTTL(key)
+	var current Item
+	if ok {
+		current = itemOf(v, version)
+	}
+	item, op, result := fn(current, ok)
+
+	var err error
+	switch op {
+	case kvcache.OpSet:
+		if len(ttl) == 0 {
+			ttl = []time.Duration{keepTTL(remaining)}
+		}
+		_, err = s.cache.CompareAndSwap(key, version, item, ttl...)
+	case kvcache.OpDelete:
+		err = s.cache.CompareAndDelete(key, version)
+	}
+	if err != nil {
+		return exists
+	}
+	return result
+}
+
+// store runs a storage command (set, add, replace, append, prepend, cas),
+// returning the new item's CAS unique. casUnique is checked by modeCAS, and
+// by the binary protocol whenever it is non-zero. Append and prepend keep
+// the item's flags and expiry.
+func (s *Server) store(mode storeMode, key string, flags uint32, exptime int64, data []byte, casUnique uint64) (storeResult, uint64) {
+	d, expired := ttl(exptime, time.Now())
+	var keep []time.Duration
+	if mode != modeAppend && mode != modePrepend {
+		keep = []time.Duration{d}
+	}
+
+	var cas uint64
+	result := s.apply(key, casUnique, func(current Item, ok bool) (Item, kvcache.Op, storeResult) {
+		switch {
+		case mode == modeAdd && ok:
+			return current, kvcache.OpKeep, notStored
+		case (mode == modeReplace || mode == modeAppend || mode == modePrepend) && !ok:
+			return current, kvcache.OpKeep, notStored
+		case mode == modeCAS && !ok:
+			return current, kvcache.OpKeep, notFound
+		case (mode == modeCAS || casUnique != 0) && ok && current.CAS != casUnique:
+			return current, kvcache.OpKeep, exists
+		case casUnique != 0 && !ok:
+			return current, kvcache.OpKeep, notFound
+		}
+
+		cas = s.casCounter.Add(1)
+		switch {
+		case mode == modeAppend:
+			return Item{Flags: current.Flags, CAS: cas, Data: concat(current.Data, data)}, kvcache.OpSet, stored
+		case mode == modePrepend:
+			return Item{Flags: current.Flags, CAS: cas, Data: concat(data, current.Data)}, kvcache.OpSet, stored
+		case expired:
+			// Stored and immediately expired
+			return current, kvcache.OpDelete, stored
+		}
+		return Item{Flags: flags, CAS: cas, Data: data}, kvcache.OpSet, stored
+	}, keep...)
+	if result != stored {
+		return result, 0
+	}
+	return result, cas
+}
+
+// keepTTL converts a remaining TTL read by get back to one for a write, so
+// an item that never expires stays that way
+func keepTTL(remaining time.Duration) time.Duration {
+	if remaining == 0 {
+		return kvcache.NoExpiration
+	}
+	return remaining
+}
+
+func concat(a, b []byte) []byte {
+	return append(append(make([]byte, 0, len(a)+len(b)), a...), b...)
+}
+
+// delta runs incr or decr, returning the new value and CAS unique. The
+// value must be an unsigned decimal integer; incr wraps at 2^64 and decr
+// stops at 0. The item keeps its flags and expiry. A non-zero casUnique,
+// sent by the binary protocol, must match the item's.
+func (s *Server) delta(key string, incr bool, amount, casUnique uint64) (uint64, uint64, storeResult) {
+	var n, cas uint64
+	result := s.apply(key, casUnique, func(current Item, ok bool) (Item, kvcache.Op, storeResult) {
+		switch {
+		case !ok:
+			return current, kvcache.OpKeep, notFound
+		case casUnique != 0 && current.CAS != casUnique:
+			return current, kvcache.OpKeep, exists
+		}
+		var err error
+		if n, err = strconv.ParseUint(string(current.Data), 10, 64); err != nil {
+			return current, kvcache.OpKeep, nonNumeric
+		}
+		switch {
+		case incr:
+			n += amount
+		case amount > n:
+			n = 0
+		default:
+			n -= amount
+		}
+		cas = s.casCounter.Add(1)
+		return Item{Flags: current.Flags, CAS: cas, Data: strconv.AppendUint(nil, n, 10)}, kvcache.OpSet, stored
+	})
+	if result != stored {
+		return 0, 0, result
+	}
+	return n, cas, result
+}
+
+// deltaOrCreate is delta for the binary protocol, which stores initial
+// when the key is missing unless exptime is noExpiry or casUnique is set
+func (s *Server) deltaOrCreate(key string, incr bool, amount, initial uint64, exptime uint32, casUnique uint64) (uint64, uint64, storeResult) {
+	for {
+		n, cas, result := s.delta(key, incr, amount, casUnique)
+		if result != notFound || exptime == noExpiry || casUnique != 0 {
+			return n, cas, result
+		}
+		d, expired := ttl(int64(exptime), time.Now())
+		if expired {
+			return initial, s.casCounter.Add(1), stored
+		}
+		created := false
+		s.cache.ComputeIfAbsent(key, func() (interface{}, kvcache.Op) {
+			created = true
+			cas = s.casCounter.Add(1)
+			return Item{CAS: cas, Data: strconv.AppendUint(nil, initial, 10)}, kvcache.OpSet
+		}, d)
+		if created {
+			return initial, cas, stored
+		}
+		// Created since delta looked, so apply the delta to that value
+	}
+}
+
+// touch updates an item's expiry, returning the item
+func (s *Server) touch(key string, exptime int64) (Item, bool) {
+	d, expired := ttl(exptime, time.Now())
+	var touched Item
+	result := s.apply(key, 0, func(current Item, ok bool) (Item, kvcache.Op, storeResult) {
+		if !ok {
+			return current, kvcache.OpKeep, notFound
+		}
+		touched = current
+		if expired {
+			return current, kvcache.OpDelete, stored
+		}
+		touched.CAS = s.casCounter.Add(1)
+		return touched, kvcache.OpSet, stored
+	}, d)
+	return touched, result == stored
+}
+
+// remove deletes a key, reporting whether it existed. A non-zero
+// casUnique must match the item's.
+func (s *Server) remove(key string, casUnique uint64) storeResult {
+	return s.apply(key, casUnique, func(current Item, ok bool) (Item, kvcache.Op, storeResult) {
+		switch {
+		case !ok:
+			return current, kvcache.OpKeep, notFound
+		case casUnique != 0 && current.CAS != casUnique:
+			return current, kvcache.OpKeep, exists
+		}
+		return current, kvcache.OpDelete, stored
+	})
+}
+
+// flush clears the cache now, or after delay seconds
+func (s *Server) flush(delay int64) {
+	if delay <= 0 {
+		s.cache.Clear()
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+	}
+	s.flushTimer = time.AfterFunc(time.Duration(delay)*time.Second, s.cache.Clear)
+}
+
+// stats returns the stats reply fields. Hits, misses, items, bytes and
+// evictions come from CacheStats, so lookups made by other frontends
+// sharing the cache are counted too.
+func (s *Server) stats() [][2]string {
+	cs := s.cache.Stats()
+	now := time.Now()
+	s.mu.Lock()
+	conns := len(s.conns)
+	s.mu.Unlock()
+
+	u := func(n uint64) string { return strconv.FormatUint(n, 10) }
+	return [][2]string{
+		{"pid", strconv.Itoa(s.pid)},
+		{"uptime", strconv.FormatInt(int64(now.Sub(s.started)/time.Second), 10)},
+		{"time", strconv.FormatInt(now.Unix(), 10)},
+		{"version", Version},
+		{"pointer_size", "64"},
+		{"curr_connections", strconv.Itoa(conns)},
+		{"total_connections", u(s.connections.Load())},
+		{"cmd_get", u(s.gets.Load())},
+		{"cmd_set", u(s.sets.Load())},
+		{"cmd_touch", u(s.touches.Load())},
+		{"get_hits", u(cs.Hits)},
+		{"get_misses", u(cs.Misses)},
+		{"curr_items", u(cs.Size)},
+		{"bytes", strconv.FormatInt(cs.Cost, 10)},
+		{"limit_maxbytes", strconv.FormatInt(cs.MaxCost, 10)},
+		{"evictions", u(cs.Evictions)},
+		{"rejections", u(cs.Rejections)},
+	}
+}
@@ -0,0 +1,143 @@
+package memcached
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// TestTTL tests the mapping of exptime onto a TTL
+func TestTTL(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		exptime int64
+		want    time.Duration
+		expired bool
+	}{
+		{0, kvcache.NoExpiration, false},
+		{-1, 0, true},
+		{60, time.Minute, false},
+		{maxRelativeExptime, maxRelativeExptime * time.Second, false},
+		{now.Unix() + 90, 90 * time.Second, false}, // Absolute
+		{now.Unix(), 0, true},
+		{maxRelativeExptime + 1, time.Unix(maxRelativeExptime+1, 0).Sub(now), true}, // Absolute, in 1970
+	}
+	for _, tt := range tests {
+		got, expired := ttl(tt.exptime, now)
+		if got != tt.want || expired != tt.expired {
+			t.Errorf("ttl(%d) = %v, %v; want %v, %v", tt.exptime, got, expired, tt.want, tt.expired)
+		}
+	}
+}
+
+// TestItemEncoding tests that items round-trip and foreign values are
+// served as data
+func TestItemEncoding(t *testing.T) {
+	srv, cache, _ := newTestServer(t)
+
+	_, cas := srv.store(modeSet, "k", 42, 0, []byte("data"), 0)
+	it, _, ok := srv.get("k")
+	if !ok || string(it.Data) != "data" || it.Flags != 42 || it.CAS != cas {
+		t.Errorf("Unexpected item %+v", it)
+	}
+	if cost := cache.Stats(); cost.Size != 1 {
+		t.Errorf("Expected 1 item, got %d", cost.Size)
+	}
+
+	// Foreign values are served whole, with a CAS unique that changes
+	// only when they are written
+	cache.Set("foreign", "from elsewhere")
+	it, _, ok = srv.get("foreign")
+	if !ok || string(it.Data) != "from elsewhere" || it.Flags != 0 || it.CAS&foreignCAS == 0 {
+		t.Errorf("Unexpected foreign item %+v", it)
+	}
+	if again, _, _ := srv.get("foreign"); again.CAS != it.CAS {
+		t.Errorf("Expected a stable CAS unique, got %d then %d", it.CAS, again.CAS)
+	}
+	cache.Set("foreign", "changed")
+	if changed, _, _ := srv.get("foreign"); changed.CAS == it.CAS {
+		t.Error("Expected the CAS unique to change with the value")
+	}
+
+	long := []byte("more than twelve bytes of binary data")
+	cache.Set("bytes", long)
+	if it, _, _ := srv.get("bytes"); string(it.Data) != string(long) || it.Flags != 0 {
+		t.Errorf("Expected a byte slice to be served whole, got %+v", it)
+	}
+}
+
+// TestNoExpiry tests that an exptime of 0 overrides the cache's DefaultTTL
+func TestNoExpiry(t *testing.T) {
+	cache := kvcache.NewKVCacheWithConfig(kvcache.Config{NumShards: 4, DefaultTTL: time.Minute})
+	defer cache.Close()
+	srv := New(cache)
+
+	srv.store(modeSet, "set", 0, 0, []byte("1"), 0)
+	srv.deltaOrCreate("counter", true, 1, 5, 0, 0)
+	srv.store(modeSet, "touched", 0, 60, []byte("1"), 0)
+	srv.touch("touched", 0)
+	for _, key := range []string{"set", "counter", "touched"} {
+		if _, remaining, ok := cache.GetWithTTL(key); !ok || remaining != 0 {
+			t.Errorf("Expected %s never to expire, got %v, %v", key, remaining, ok)
+		}
+	}
+}
+
+// TestStoreAtomic tests that read-modify-write commands are atomic with
+// respect to writes made to the cache by other frontends
+func TestStoreAtomic(t *testing.T) {
+	srv, cache, _ := newTestServer(t)
+	srv.store(modeSet, "n", 0, 0, []byte("0"), 0)
+
+	const rounds = 1000
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			srv.delta("n", true, 1, 0)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			cache.Compute("n", func(old interface{}, exists bool) (interface{}, kvcache.Op) {
+				it := old.(Item)
+				n, _ := strconv.Atoi(string(it.Data))
+				it.Data = strconv.AppendInt(nil, int64(n+1), 10)
+				return it, kvcache.OpSet
+			})
+		}
+	}()
+	wg.Wait()
+
+	if it, _, _ := srv.get("n"); string(it.Data) != strconv.Itoa(2*rounds) {
+		t.Errorf("Expected %d, got %s", 2*rounds, it.Data)
+	}
+}
+
+// TestStoreForeignCAS tests CAS uniques made up for values not stored by
+// this server
+func TestStoreForeignCAS(t *testing.T) {
+	srv, cache, _ := newTestServer(t)
+	cache.Set("k", "abc")
+	it, _, _ := srv.get("k")
+
+	cache.Set("k", "changed")
+	if result, _ := srv.store(modeAppend, "k", 0, 0, []byte("!"), it.CAS); result != exists {
+		t.Errorf("Expected exists after a write, got %d", result)
+	}
+	it, _, _ = srv.get("k")
+	if result, _ := srv.store(modeAppend, "k", 0, 0, []byte("!"), it.CAS); result != stored {
+		t.Errorf("Expected stored, got %d", result)
+	}
+	if it, _, _ := srv.get("k"); string(it.Data) != "changed!" {
+		t.Errorf("Expected changed!, got %s", it.Data)
+	}
+	if result := srv.remove("k", it.CAS); result != exists {
+		t.Errorf("Expected a stale CAS unique to be refused, got %d", result)
+	}
+}
@@ -0,0 +1,340 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// Text protocol error replies
+const (
+	errUnknown     = "ERROR"
+	errFormat      = "CLIENT_ERROR bad command line format"
+	errDataChunk   = "CLIENT_ERROR bad data chunk"
+	errDelta       = "CLIENT_ERROR invalid numeric delta argument"
+	errNonNumeric  = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+	errTooLarge    = "SERVER_ERROR object too large for cache"
+	errLineTooLong = "CLIENT_ERROR line too long"
+)
+
+var storeModes = map[string]storeMode{
+	"set":     modeSet,
+	"add":     modeAdd,
+	"replace": modeReplace,
+	"append":  modeAppend,
+	"prepend": modePrepend,
+	"cas":     modeCAS,
+}
+
+var storeReplies = [...]string{
+	stored:    "STORED",
+	notStored: "NOT_STORED",
+	exists:    "EXISTS",
+	notFound:  "NOT_FOUND",
+}
+
+// textConn is one text protocol connection's state
+type textConn struct {
+	s    *Server
+	r    *bufio.Reader
+	w    *bufio.Writer
+	quit bool
+}
+
+// serveText runs the text protocol loop. Replies are buffered and flushed
+// once no pipelined commands are waiting.
+func (s *Server) serveText(r *bufio.Reader, w *bufio.Writer) {
+	c := &textConn{s: s, r: r, w: w}
+	for !c.quit {
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) || len(line) > maxLineSize {
+			c.reply(errLineTooLong)
+			w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if err := c.dispatch(bytes.Fields(line)); err != nil {
+			return
+		}
+		if c.quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// dispatch runs one command. An error means the connection is unusable.
+func (c *textConn) dispatch(args [][]byte) error {
+	if len(args) == 0 {
+		c.reply(errUnknown)
+		return nil
+	}
+	name := string(args[0])
+	if mode, ok := storeModes[name]; ok {
+		return c.cmdStore(mode, args)
+	}
+	switch name {
+	case "get", "gets":
+		c.cmdGet(args[1:], name == "gets")
+	case "gat", "gats":
+		c.cmdGat(args, name == "gats")
+	case "delete":
+		c.cmdDelete(args)
+	case "incr", "decr":
+		c.cmdDelta(args)
+	case "touch":
+		c.cmdTouch(args)
+	case "flush_all":
+		c.cmdFlush(args)
+	case "stats":
+		c.cmdStats(args)
+	case "version":
+		c.reply("VERSION " + Version)
+	case "verbosity":
+		c.replyUnless(noreply(args), "OK")
+	case "quit":
+		c.quit = true
+	default:
+		c.reply(errUnknown)
+	}
+	return nil
+}
+
+func (c *textConn) reply(line string) {
+	c.w.WriteString(line)
+	c.w.WriteString("\r\n")
+}
+
+// replyUnless sends line unless the command asked for noreply
+func (c *textConn) replyUnless(quiet bool, line string) {
+	if !quiet {
+		c.reply(line)
+	}
+}
+
+// noreply reports whether a command's last argument is "noreply"
+func noreply(args [][]byte) bool {
+	return len(args) > 1 && string(args[len(args)-1]) == "noreply"
+}
+
+// cmdStore implements set, add, replace, append, prepend and cas:
+//
+//	<command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+func (c *textConn) cmdStore(mode storeMode, args [][]byte) error {
+	want := 5
+	if mode == modeCAS {
+		want = 6
+	}
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) != want || len(args[1]) > maxKeySize {
+		c.reply(errFormat)
+		return nil
+	}
+	flags, err1 := strconv.ParseUint(string(args[2]), 10, 32)
+	exptime, err2 := strconv.ParseInt(string(args[3]), 10, 64)
+	size, err3 := strconv.Atoi(string(args[4]))
+	var casUnique uint64
+	var err4 error
+	if mode == modeCAS {
+		casUnique, err4 = strconv.ParseUint(string(args[5]), 10, 64)
+	}
+	if err := errors.Join(err1, err2, err3, err4); err != nil || size < 0 {
+		c.reply(errFormat)
+		return nil
+	}
+
+	if size > maxValueSize {
+		// Swallow the data so the stream stays in sync
+		if _, err := c.r.Discard(size + 2); err != nil {
+			return err
+		}
+		c.reply(errTooLarge)
+		return nil
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		// Skip the rest of the oversized line rather than run it
+		if data[size+1] != '\n' {
+			if _, err := c.r.ReadSlice('\n'); err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+				return err
+			}
+		}
+		c.reply(errDataChunk)
+		return nil
+	}
+
+	c.s.sets.Add(1)
+	result, _ := c.s.store(mode, string(args[1]), uint32(flags), exptime, data[:size], casUnique)
+	c.replyUnless(quiet, storeReplies[result])
+	return nil
+}
+
+// cmdGet implements get and gets
+func (c *textConn) cmdGet(keys [][]byte, withCAS bool) {
+	if len(keys) == 0 {
+		c.reply(errUnknown)
+		return
+	}
+	for _, key := range keys {
+		c.s.gets.Add(1)
+		if it, _, ok := c.s.get(string(key)); ok {
+			c.value(key, it, withCAS)
+		}
+	}
+	c.reply("END")
+}
+
+// cmdGat implements gat and gats: gat <exptime> <key>*
+func (c *textConn) cmdGat(args [][]byte, withCAS bool) {
+	if len(args) < 3 {
+		c.reply(errUnknown)
+		return
+	}
+	exptime, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		c.reply(errFormat)
+		return
+	}
+	for _, key := range args[2:] {
+		c.s.gets.Add(1)
+		c.s.touches.Add(1)
+		if it, ok := c.s.touch(string(key), exptime); ok {
+			c.value(key, it, withCAS)
+		}
+	}
+	c.reply("END")
+}
+
+// value writes one VALUE block of a get reply
+func (c *textConn) value(key []byte, it Item, withCAS bool) {
+	c.w.WriteString("VALUE ")
+	c.w.Write(key)
+	c.w.WriteByte(' ')
+	c.w.WriteString(strconv.FormatUint(uint64(it.Flags), 10))
+	c.w.WriteByte(' ')
+	c.w.WriteString(strconv.Itoa(len(it.Data)))
+	if withCAS {
+		c.w.WriteByte(' ')
+		c.w.WriteString(strconv.FormatUint(it.CAS, 10))
+	}
+	c.w.WriteString("\r\n")
+	c.w.Write(it.Data)
+	c.w.WriteString("\r\n")
+}
+
+// cmdDelete implements delete <key> [noreply]. A legacy time argument of
+// 0 is accepted.
+func (c *textConn) cmdDelete(args [][]byte) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) == 3 && string(args[2]) == "0" {
+		args = args[:2]
+	}
+	if len(args) != 2 {
+		c.reply("CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]")
+		return
+	}
+	if c.s.remove(string(args[1]), 0) == stored {
+		c.replyUnless(quiet, "DELETED")
+	} else {
+		c.replyUnless(quiet, "NOT_FOUND")
+	}
+}
+
+// cmdDelta implements incr and decr <key> <value> [noreply]
+func (c *textConn) cmdDelta(args [][]byte) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 3 {
+		c.reply(errUnknown)
+		return
+	}
+	amount, err := strconv.ParseUint(string(args[2]), 10, 64)
+	if err != nil {
+		c.reply(errDelta)
+		return
+	}
+	n, _, result := c.s.delta(string(args[1]), string(args[0]) == "incr", amount, 0)
+	switch result {
+	case stored:
+		c.replyUnless(quiet, strconv.FormatUint(n, 10))
+	case nonNumeric:
+		c.reply(errNonNumeric)
+	default:
+		c.replyUnless(quiet, "NOT_FOUND")
+	}
+}
+
+// cmdTouch implements touch <key> <exptime> [noreply]
+func (c *textConn) cmdTouch(args [][]byte) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 3 {
+		c.reply(errUnknown)
+		return
+	}
+	exptime, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid exptime argument")
+		return
+	}
+	c.s.touches.Add(1)
+	if _, ok := c.s.touch(string(args[1]), exptime); ok {
+		c.replyUnless(quiet, "TOUCHED")
+	} else {
+		c.replyUnless(quiet, "NOT_FOUND")
+	}
+}
+
+// cmdFlush implements flush_all [delay] [noreply]
+func (c *textConn) cmdFlush(args [][]byte) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	var delay int64
+	switch len(args) {
+	case 1:
+	case 2:
+		var err error
+		if delay, err = strconv.ParseInt(string(args[1]), 10, 64); err != nil {
+			c.reply(errFormat)
+			return
+		}
+	default:
+		c.reply(errUnknown)
+		return
+	}
+	c.s.flush(delay)
+	c.replyUnless(quiet, "OK")
+}
+
+// cmdStats implements stats with no arguments; groups such as "stats
+// slabs" have no equivalent here
+func (c *textConn) cmdStats(args [][]byte) {
+	if len(args) > 1 {
+		c.reply(errUnknown)
+		return
+	}
+	for _, stat := range c.s.stats() {
+		c.reply("STAT " + stat[0] + " " + stat[1])
+	}
+	c.reply("END")
+}
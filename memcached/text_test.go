@@ -0,0 +1,184 @@
+package memcached
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expect sends raw input and checks the exact reply
+func (c *testConn) expect(input, want string) {
+	c.t.Helper()
+	c.write(input)
+	got := make([]byte, 0, len(want))
+	for len(got) < len(want) {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			c.t.Fatalf("%q: expected %q, got %q (%v)", input, want, got, err)
+		}
+		got = append(got, b)
+	}
+	if string(got) != want {
+		c.t.Fatalf("%q: expected %q, got %q", input, want, got)
+	}
+}
+
+// TestTextStorage tests set, add, replace, append, prepend and get
+func TestTextStorage(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	c.expect("get k\r\n", "END\r\n")
+	c.expect("set k 5 0 3\r\nabc\r\n", "STORED\r\n")
+	c.expect("get k\r\n", "VALUE k 5 3\r\nabc\r\nEND\r\n")
+	c.expect("add k 0 0 1\r\nx\r\n", "NOT_STORED\r\n")
+	c.expect("add n 0 0 1\r\nx\r\n", "STORED\r\n")
+	c.expect("replace missing 0 0 1\r\nx\r\n", "NOT_STORED\r\n")
+	c.expect("replace n 1 0 1\r\ny\r\n", "STORED\r\n")
+	c.expect("append k 9 0 2\r\nde\r\n", "STORED\r\n")
+	c.expect("prepend k 9 0 2\r\n01\r\n", "STORED\r\n")
+	c.expect("append missing 0 0 1\r\nx\r\n", "NOT_STORED\r\n")
+	// Append and prepend keep the original flags
+	c.expect("get k n missing\r\n", "VALUE k 5 7\r\n01abcde\r\nVALUE n 1 1\r\ny\r\nEND\r\n")
+	c.expect("set empty 0 0 0\r\n\r\n", "STORED\r\n")
+	c.expect("get empty\r\n", "VALUE empty 0 0\r\n\r\nEND\r\n")
+
+	// noreply suppresses the reply; the get confirms it was processed
+	c.expect("set q 0 0 1 noreply\r\nz\r\nget q\r\n", "VALUE q 0 1\r\nz\r\nEND\r\n")
+
+	c.expect("set k 0 0 3\r\nabcd\r\n", "CLIENT_ERROR bad data chunk\r\n")
+	c.expect("set k x 0 1\r\n", "CLIENT_ERROR bad command line format\r\n")
+	c.expect("set k 0 0\r\n", "CLIENT_ERROR bad command line format\r\n")
+	c.expect("set "+strings.Repeat("a", 251)+" 0 0 1\r\n", "CLIENT_ERROR bad command line format\r\n")
+	c.expect("bogus\r\n", "ERROR\r\n")
+	c.expect("get\r\n", "ERROR\r\n")
+}
+
+// TestTextCAS tests gets and cas
+func TestTextCAS(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	c.expect("cas k 0 0 1 1\r\na\r\n", "NOT_FOUND\r\n")
+	c.expect("set k 0 0 1\r\na\r\n", "STORED\r\n")
+	c.write("gets k\r\n")
+	line, _ := c.r.ReadString('\n')
+	fields := strings.Fields(line)
+	if len(fields) != 5 || fields[0] != "VALUE" {
+		t.Fatalf("Unexpected gets reply %q", line)
+	}
+	c.expect("", "a\r\nEND\r\n")
+	unique := fields[4]
+
+	next, _ := strconv.ParseUint(unique, 10, 64)
+	c.expect("cas k 0 0 1 "+strconv.FormatUint(next+100, 10)+"\r\nb\r\n", "EXISTS\r\n")
+	c.expect("cas k 0 0 1 "+unique+"\r\nb\r\n", "STORED\r\n")
+	c.expect("cas k 0 0 1 "+unique+"\r\nc\r\n", "EXISTS\r\n")
+	c.expect("get k\r\n", "VALUE k 0 1\r\nb\r\nEND\r\n")
+}
+
+// TestTextCounters tests incr and decr
+func TestTextCounters(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	c.expect("incr n 1\r\n", "NOT_FOUND\r\n")
+	c.expect("set n 3 100 2\r\n10\r\n", "STORED\r\n")
+	c.expect("incr n 5\r\n", "15\r\n")
+	c.expect("decr n 100\r\n", "0\r\n") // Stops at 0
+	c.expect("set n 3 0 20\r\n18446744073709551615\r\n", "STORED\r\n")
+	c.expect("incr n 2\r\n", "1\r\n") // Wraps
+	c.expect("get n\r\n", "VALUE n 3 1\r\n1\r\nEND\r\n")
+	c.expect("incr n x\r\n", "CLIENT_ERROR invalid numeric delta argument\r\n")
+	c.expect("set s 0 0 3\r\nabc\r\n", "STORED\r\n")
+	c.expect("incr s 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	c.expect("decr n 1 noreply\r\nget n\r\n", "VALUE n 3 1\r\n0\r\nEND\r\n")
+}
+
+// TestTextExpiry tests exptime, touch, gat and delete
+func TestTextExpiry(t *testing.T) {
+	_, cache, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	c.expect("set rel 0 100 1\r\na\r\n", "STORED\r\n")
+	if _, ttl, _ := cache.GetWithTTL("rel"); ttl <= 99*time.Second || ttl > 100*time.Second {
+		t.Errorf("Expected relative TTL of 100s, got %v", ttl)
+	}
+	abs := strconv.FormatInt(time.Now().Unix()+3600, 10)
+	c.expect("set abs 0 "+abs+" 1\r\na\r\n", "STORED\r\n")
+	if _, ttl, _ := cache.GetWithTTL("abs"); ttl <= 3590*time.Second || ttl > 3600*time.Second {
+		t.Errorf("Expected absolute exptime an hour away, got %v", ttl)
+	}
+	c.expect("set gone 0 -1 1\r\na\r\n", "STORED\r\n")
+	c.expect("set past 0 1000000000 1\r\na\r\n", "STORED\r\n")
+	c.expect("get gone past\r\n", "END\r\n")
+
+	c.expect("touch rel 0\r\n", "TOUCHED\r\n")
+	if _, ttl, ok := cache.GetWithTTL("rel"); !ok || ttl != 0 {
+		t.Errorf("Expected touch 0 to remove the expiry, got %v", ttl)
+	}
+	c.expect("touch missing 10\r\n", "NOT_FOUND\r\n")
+	c.expect("gat 50 rel missing\r\n", "VALUE rel 0 1\r\na\r\nEND\r\n")
+	if _, ttl, _ := cache.GetWithTTL("rel"); ttl <= 49*time.Second || ttl > 50*time.Second {
+		t.Errorf("Expected gat to set a 50s TTL, got %v", ttl)
+	}
+	c.expect("touch rel -1\r\n", "TOUCHED\r\n")
+	c.expect("get rel\r\n", "END\r\n")
+
+	c.expect("delete abs\r\n", "DELETED\r\n")
+	c.expect("delete abs\r\n", "NOT_FOUND\r\n")
+	c.expect("delete abs 0 noreply\r\nget abs\r\n", "END\r\n")
+	c.expect("delete\r\n", "CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]\r\n")
+}
+
+// TestTextServerCommands tests flush_all, stats, version, verbosity and quit
+func TestTextServerCommands(t *testing.T) {
+	_, cache, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	c.expect("set a 0 0 1\r\n1\r\nset b 0 0 1\r\n2\r\n", "STORED\r\nSTORED\r\n")
+	c.expect("get a missing\r\n", "VALUE a 0 1\r\n1\r\nEND\r\n")
+
+	c.write("stats\r\n")
+	stats := map[string]string{}
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "END\r\n" {
+			break
+		}
+		f := strings.Fields(line)
+		if len(f) != 3 || f[0] != "STAT" {
+			t.Fatalf("Unexpected stats line %q", line)
+		}
+		stats[f[1]] = f[2]
+	}
+	for name, want := range map[string]string{
+		"curr_items":       "2",
+		"cmd_get":          "2",
+		"cmd_set":          "2",
+		"curr_connections": "1",
+		"version":          Version,
+	} {
+		if stats[name] != want {
+			t.Errorf("Expected stat %s = %s, got %q", name, want, stats[name])
+		}
+	}
+	c.expect("stats slabs\r\n", "ERROR\r\n")
+
+	c.expect("version\r\n", "VERSION "+Version+"\r\n")
+	c.expect("verbosity 1\r\n", "OK\r\n")
+	c.expect("flush_all\r\n", "OK\r\n")
+	if cache.Size() != 0 {
+		t.Errorf("Expected flush_all to clear the cache, %d left", cache.Size())
+	}
+	c.expect("flush_all x\r\n", "CLIENT_ERROR bad command line format\r\n")
+
+	c.write("quit\r\n")
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Error("Expected quit to close the connection")
+	}
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// FixedWindow allows limit requests per key in each window, with windows
+// aligned to multiples of the window length since the Unix epoch
+type FixedWindow struct {
+	limiter
+	limit  int
+	window time.Duration
+}
+
+// fixedState is one key's count in its current window
+type fixedState struct {
+	start int64 // Window start, UnixNano
+	count int
+}
+
+// NewFixedWindow creates a fixed window limiter. It panics unless limit
+// and window are positive.
+func NewFixedWindow(cache *kvcache.KVCache, limit int, window time.Duration) *FixedWindow {
+	if limit <= 0 || window <= 0 {
+		panic("ratelimit: FixedWindow needs a positive limit and window")
+	}
+	return &FixedWindow{limiter: newLimiter(cache), limit: limit, window: window}
+}
+
+// Allow reports whether one request for key may proceed
+func (l *FixedWindow) Allow(key string) Result {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n requests for key may proceed
+func (l *FixedWindow) AllowN(key string, n int) Result {
+	checkN(n)
+	now := l.clock.Now().UnixNano()
+	start := now - now%int64(l.window)
+	end := start + int64(l.window)
+
+	res := Result{Limit: l.limit}
+	update(&l.limiter, key, time.Duration(end-now), func(s *fixedState) *fixedState {
+		count := 0
+		if s != nil && s.start == start {
+			count = s.count
+		}
+		res.Allowed = count+n <= l.limit
+		res.Remaining = l.limit - count
+		switch {
+		case res.Allowed:
+			res.Remaining -= n
+		case n > l.limit:
+			res.RetryAfter = InfDuration
+		default:
+			res.RetryAfter = time.Duration(end - now)
+		}
+		if !res.Allowed || n == 0 {
+			return nil
+		}
+		return &fixedState{start: start, count: count + n}
+	})
+	return res
+}
@@ -0,0 +1,24 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFixedWindow tests counting in aligned windows
+func TestFixedWindow(t *testing.T) {
+	cache, clock := newTestCache(t)
+	l := NewFixedWindow(cache, 2, time.Second)
+
+	clock.Advance(300 * time.Millisecond)
+	expect(t, "first", l.Allow("k"), true, 1, 0)
+	expect(t, "second", l.Allow("k"), true, 0, 0)
+	expect(t, "over", l.Allow("k"), false, 0, 700*time.Millisecond)
+
+	// A new window starts at the next whole second
+	clock.Advance(700 * time.Millisecond)
+	expect(t, "next window", l.AllowN("k", 2), true, 0, 0)
+	if _, ttl, _ := cache.GetWithTTL("k"); ttl != time.Second {
+		t.Errorf("Expected state to expire with the window, got %v", ttl)
+	}
+}
@@ -0,0 +1,106 @@
+// Package ratelimit provides rate limiters keyed by arbitrary strings
+// (client IPs, API keys, user IDs) that keep their state in a
+// kvcache.KVCache:
+//
+//   - FixedWindow counts requests in aligned windows; cheapest, but allows
+//     up to twice the limit across a window boundary.
+//   - SlidingLog keeps each request's time; exact, at one timestamp per
+//     allowed request.
+//   - SlidingWindow weights the previous window's count by its overlap
+//     with a window ending now; a close approximation of SlidingLog in
+//     constant space.
+//   - TokenBucket refills tokens at a steady rate up to a burst size.
+//
+// Each key's state is updated atomically under its cache shard's lock and
+// stored with a TTL covering only the time it still matters, so idle keys
+// expire and cost nothing. Time is taken from the cache's Clock.
+//
+// State values are unexported types: give limiters a cache without a Store
+// or write-ahead log. Limiters sharing a cache need different Prefixes.
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// InfDuration is the RetryAfter of a request that can never be allowed,
+// because it asks for more than the limit
+const InfDuration = time.Duration(math.MaxInt64)
+
+// Result is a limiter's decision about one request
+type Result struct {
+	Allowed    bool
+	Limit      int           // Requests allowed per window, or the bucket size
+	Remaining  int           // Requests that would still be allowed now
+	RetryAfter time.Duration // Wait before the request would be allowed, 0 if it was
+}
+
+// Limiter is implemented by every limiter in this package
+type Limiter interface {
+	// Allow reports whether one request for key may proceed, and counts it
+	// if so
+	Allow(key string) Result
+
+	// AllowN is Allow for n requests at once, which are all allowed or all
+	// denied. With n of 0 it reports the state without counting anything.
+	AllowN(key string, n int) Result
+
+	// Reset forgets key's requests
+	Reset(key string)
+}
+
+// Compile-time interface checks
+var (
+	_ Limiter = (*FixedWindow)(nil)
+	_ Limiter = (*SlidingLog)(nil)
+	_ Limiter = (*SlidingWindow)(nil)
+	_ Limiter = (*TokenBucket)(nil)
+)
+
+// limiter holds what every limiter shares
+type limiter struct {
+	// Prefix is prepended to keys in the cache. Set it before first use.
+	Prefix string
+
+	cache *kvcache.KVCache
+	clock kvcache.Clock
+}
+
+func newLimiter(cache *kvcache.KVCache) limiter {
+	return limiter{cache: cache, clock: cache.Clock()}
+}
+
+// Reset forgets key's requests
+func (l *limiter) Reset(key string) {
+	l.cache.Delete(l.Prefix + key)
+}
+
+// update runs fn on key's state under the shard lock. fn receives the
+// current state (nil if missing or of another type) and returns the new
+// one, or nil to leave the state unchanged. A new state is stored with
+// ttl.
+func update[S any](l *limiter, key string, ttl time.Duration, fn func(state *S) *S) {
+	l.cache.Compute(l.Prefix+key, func(old interface{}, exists bool) (interface{}, kvcache.Op) {
+		state, _ := old.(*S)
+		if state = fn(state); state == nil {
+			return old, kvcache.OpKeep
+		}
+		return state, kvcache.OpSet
+	}, ttl)
+}
+
+// checkN panics on a negative request count
+func checkN(n int) {
+	if n < 0 {
+		panic("ratelimit: negative request count")
+	}
+}
+
+// ceil rounds a computed number of nanoseconds up, so a RetryAfter is
+// never too short
+func ceil(ns float64) time.Duration {
+	return time.Duration(math.Ceil(ns))
+}
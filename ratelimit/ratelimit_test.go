@@ -0,0 +1,163 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// fakeClock is a manually advanced kvcache.Clock
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	f.mu.Unlock()
+}
+
+// newTestCache returns a cache on a fake clock set to the start of a
+// minute, so windows of a second or a minute begin now
+func newTestCache(t *testing.T) (*kvcache.KVCache, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{now: time.Unix(1700000040, 0)}
+	cache := kvcache.NewKVCacheWithConfig(kvcache.Config{Clock: clock, CleanupInterval: -1})
+	t.Cleanup(func() { cache.Close() })
+	return cache, clock
+}
+
+// expect checks a Result
+func expect(t *testing.T, name string, got Result, allowed bool, remaining int, retryAfter time.Duration) {
+	t.Helper()
+	if got.Allowed != allowed || got.Remaining != remaining || got.RetryAfter != retryAfter {
+		t.Errorf("%s: expected allowed=%v remaining=%d retryAfter=%v, got %+v", name, allowed, remaining, retryAfter, got)
+	}
+}
+
+// TestLimiters tests behaviour every limiter shares
+func TestLimiters(t *testing.T) {
+	limiters := map[string]func(*kvcache.KVCache) Limiter{
+		"FixedWindow":   func(c *kvcache.KVCache) Limiter { return NewFixedWindow(c, 3, time.Second) },
+		"SlidingLog":    func(c *kvcache.KVCache) Limiter { return NewSlidingLog(c, 3, time.Second) },
+		"SlidingWindow": func(c *kvcache.KVCache) Limiter { return NewSlidingWindow(c, 3, time.Second) },
+		"TokenBucket":   func(c *kvcache.KVCache) Limiter { return NewTokenBucket(c, 3, 3) },
+	}
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			cache, clock := newTestCache(t)
+			l := newLimiter(cache)
+
+			expect(t, "peek", l.AllowN("a", 0), true, 3, 0)
+			if cache.Size() != 0 {
+				t.Error("Expected a peek to store nothing")
+			}
+			for i := 0; i < 3; i++ {
+				if !l.Allow("a").Allowed {
+					t.Fatalf("Request %d denied", i)
+				}
+			}
+			if res := l.Allow("a"); res.Allowed || res.Remaining != 0 || res.RetryAfter <= 0 {
+				t.Errorf("Expected the fourth request to be denied, got %+v", res)
+			}
+			if !l.Allow("b").Allowed {
+				t.Error("Expected keys to be limited separately")
+			}
+			if res := l.AllowN("c", 4); res.Allowed || res.RetryAfter != InfDuration {
+				t.Errorf("Expected more than the limit never to be allowed, got %+v", res)
+			}
+
+			l.Reset("a")
+			if !l.Allow("a").Allowed {
+				t.Error("Expected Reset to forget requests")
+			}
+
+			// Idle keys expire, after two windows at most
+			clock.Advance(3 * time.Second)
+			cache.Get("a")
+			cache.Get("b")
+			if n := cache.Size(); n != 0 {
+				t.Errorf("Expected idle keys to expire, %d left", n)
+			}
+		})
+	}
+}
+
+// TestLimiterPrefix tests that prefixed limiters can share a cache
+func TestLimiterPrefix(t *testing.T) {
+	cache, _ := newTestCache(t)
+	a := NewFixedWindow(cache, 1, time.Second)
+	a.Prefix = "a:"
+	b := NewTokenBucket(cache, 1, 1)
+	b.Prefix = "b:"
+
+	if !a.Allow("k").Allowed || !b.Allow("k").Allowed {
+		t.Error("Expected both limiters to allow their first request")
+	}
+	if _, ok := cache.Get("a:k"); !ok {
+		t.Error("Expected state under the prefix")
+	}
+}
+
+// TestLimiterConcurrent tests that concurrent requests are counted exactly
+func TestLimiterConcurrent(t *testing.T) {
+	cache, _ := newTestCache(t)
+	limiters := []Limiter{
+		NewFixedWindow(cache, 100, time.Minute),
+		NewSlidingLog(cache, 100, time.Minute),
+		NewSlidingWindow(cache, 100, time.Minute),
+		NewTokenBucket(cache, 0.001, 100),
+	}
+	for i, l := range limiters {
+		key := fmt.Sprint(i)
+		var allowed atomic.Int32
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					if l.Allow(key).Allowed {
+						allowed.Add(1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if n := allowed.Load(); n != 100 {
+			t.Errorf("%T: expected 100 requests allowed, got %d", l, n)
+		}
+	}
+}
+
+// TestLimiterPanics tests constructor validation
+func TestLimiterPanics(t *testing.T) {
+	cache, _ := newTestCache(t)
+	for name, fn := range map[string]func(){
+		"FixedWindow":   func() { NewFixedWindow(cache, 0, time.Second) },
+		"SlidingLog":    func() { NewSlidingLog(cache, 1, 0) },
+		"SlidingWindow": func() { NewSlidingWindow(cache, -1, time.Second) },
+		"TokenBucket":   func() { NewTokenBucket(cache, 0, 1) },
+		"negative n":    func() { NewTokenBucket(cache, 1, 1).AllowN("k", -1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
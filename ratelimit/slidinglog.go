@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// SlidingLog allows limit requests per key in any window-long period,
+// recording the time of every allowed request
+type SlidingLog struct {
+	limiter
+	limit  int
+	window time.Duration
+}
+
+// logState is one key's allowed requests, oldest first. It is changed in
+// place, which is safe because only Compute callbacks touch it.
+type logState struct {
+	times []int64 // UnixNano
+}
+
+// NewSlidingLog creates a sliding log limiter. It panics unless limit and
+// window are positive.
+func NewSlidingLog(cache *kvcache.KVCache, limit int, window time.Duration) *SlidingLog {
+	if limit <= 0 || window <= 0 {
+		panic("ratelimit: SlidingLog needs a positive limit and window")
+	}
+	return &SlidingLog{limiter: newLimiter(cache), limit: limit, window: window}
+}
+
+// Allow reports whether one request for key may proceed
+func (l *SlidingLog) Allow(key string) Result {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n requests for key may proceed
+func (l *SlidingLog) AllowN(key string, n int) Result {
+	checkN(n)
+	now := l.clock.Now().UnixNano()
+	cutoff := now - int64(l.window)
+
+	res := Result{Limit: l.limit}
+	update(&l.limiter, key, l.window, func(s *logState) *logState {
+		var times []int64
+		if s != nil {
+			// Drop requests that left the window
+			i := 0
+			for i < len(s.times) && s.times[i] <= cutoff {
+				i++
+			}
+			s.times = s.times[i:]
+			times = s.times
+		}
+		res.Allowed = len(times)+n <= l.limit
+		res.Remaining = l.limit - len(times)
+		switch {
+		case res.Allowed:
+			res.Remaining -= n
+		case n > l.limit:
+			res.RetryAfter = InfDuration
+		default:
+			// Wait until enough of the oldest requests leave the window
+			res.RetryAfter = time.Duration(times[len(times)+n-l.limit-1] - cutoff)
+		}
+		if !res.Allowed || n == 0 {
+			return nil
+		}
+		if s == nil {
+			s = &logState{}
+		}
+		for i := 0; i < n; i++ {
+			s.times = append(s.times, now)
+		}
+		return s
+	})
+	return res
+}
@@ -0,0 +1,26 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSlidingLog tests that requests leave the window one by one
+func TestSlidingLog(t *testing.T) {
+	cache, clock := newTestCache(t)
+	l := NewSlidingLog(cache, 3, time.Second)
+
+	l.Allow("k")
+	clock.Advance(200 * time.Millisecond)
+	l.AllowN("k", 2)
+	clock.Advance(500 * time.Millisecond)
+	expect(t, "full", l.Allow("k"), false, 0, 300*time.Millisecond)
+	expect(t, "two more", l.AllowN("k", 2), false, 0, 500*time.Millisecond)
+
+	clock.Advance(300 * time.Millisecond)
+	expect(t, "oldest left", l.Allow("k"), true, 0, 0)
+	expect(t, "full again", l.Allow("k"), false, 0, 200*time.Millisecond)
+
+	clock.Advance(200 * time.Millisecond)
+	expect(t, "two left", l.AllowN("k", 0), true, 2, 0)
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// SlidingWindow allows about limit requests per key in any window-long
+// period. It counts requests in aligned windows like FixedWindow, but
+// also counts the previous window's requests weighted by how much of it
+// falls within a window ending now, which assumes they were evenly spread.
+type SlidingWindow struct {
+	limiter
+	limit  int
+	window time.Duration
+}
+
+// windowState is one key's counts in its current and previous windows
+type windowState struct {
+	start      int64 // Current window start, UnixNano
+	prev, curr int
+}
+
+// NewSlidingWindow creates a sliding window limiter. It panics unless
+// limit and window are positive.
+func NewSlidingWindow(cache *kvcache.KVCache, limit int, window time.Duration) *SlidingWindow {
+	if limit <= 0 || window <= 0 {
+		panic("ratelimit: SlidingWindow needs a positive limit and window")
+	}
+	return &SlidingWindow{limiter: newLimiter(cache), limit: limit, window: window}
+}
+
+// Allow reports whether one request for key may proceed
+func (l *SlidingWindow) Allow(key string) Result {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n requests for key may proceed
+func (l *SlidingWindow) AllowN(key string, n int) Result {
+	checkN(n)
+	w := int64(l.window)
+	now := l.clock.Now().UnixNano()
+	start := now - now%w
+	elapsed := now - start
+
+	res := Result{Limit: l.limit}
+	// The counts matter until the current window has slid out entirely
+	ttl := time.Duration(2*w - elapsed)
+	update(&l.limiter, key, ttl, func(s *windowState) *windowState {
+		var prev, curr int
+		if s != nil {
+			switch s.start {
+			case start:
+				prev, curr = s.prev, s.curr
+			case start - w:
+				prev = s.curr
+			}
+		}
+		used := float64(prev)*float64(w-elapsed)/float64(w) + float64(curr)
+		res.Allowed = used+float64(n) <= float64(l.limit)
+		res.Remaining = max(int(math.Floor(float64(l.limit)-used)), 0)
+		switch {
+		case res.Allowed:
+			res.Remaining -= n
+		case n > l.limit:
+			res.RetryAfter = InfDuration
+		case curr+n <= l.limit:
+			// Wait for enough of the previous window to slide out
+			free := float64(l.limit - n - curr)
+			res.RetryAfter = ceil(float64(w)*(1-free/float64(prev))) - time.Duration(elapsed)
+		default:
+			// Wait for the next window, and then for enough of this one to
+			// slide out
+			free := float64(l.limit - n)
+			res.RetryAfter = time.Duration(w-elapsed) + ceil(float64(w)*(1-free/float64(curr)))
+		}
+		if !res.Allowed || n == 0 {
+			return nil
+		}
+		return &windowState{start: start, prev: prev, curr: curr + n}
+	})
+	return res
+}
@@ -0,0 +1,31 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSlidingWindow tests weighting of the previous window
+func TestSlidingWindow(t *testing.T) {
+	cache, clock := newTestCache(t)
+	l := NewSlidingWindow(cache, 10, time.Second)
+
+	expect(t, "fill", l.AllowN("k", 8), true, 2, 0)
+	// Over the limit within the window: wait for the next window and for
+	// 37.5% of it, when 8 * 0.625 + 5 <= 10
+	clock.Advance(500 * time.Millisecond)
+	expect(t, "over", l.AllowN("k", 5), false, 2, 875*time.Millisecond)
+
+	// A quarter into the next window 75% of the previous 8 still count
+	clock.Advance(750 * time.Millisecond)
+	expect(t, "weighted", l.AllowN("k", 4), true, 0, 0)
+	// 6 + 4 = 10 used; one more waits for 1/8 of the window more
+	expect(t, "weighted over", l.Allow("k"), false, 0, 125*time.Millisecond)
+
+	clock.Advance(125 * time.Millisecond)
+	expect(t, "slid", l.Allow("k"), true, 0, 0)
+
+	// Two windows later nothing counts
+	clock.Advance(2 * time.Second)
+	expect(t, "expired", l.AllowN("k", 0), true, 10, 0)
+}
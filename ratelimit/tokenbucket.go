@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// TokenBucket gives each key a bucket of burst tokens that refills at rate
+// tokens per second. A request takes one token, and is denied if none is
+// left. Keys start with a full bucket.
+type TokenBucket struct {
+	limiter
+	rate  float64 // Tokens per nanosecond
+	burst int
+}
+
+// bucketState is one key's tokens as of its last allowed request
+type bucketState struct {
+	tokens float64
+	last   int64 // UnixNano
+}
+
+// NewTokenBucket creates a token bucket limiter refilling rate tokens per
+// second up to burst. It panics unless rate and burst are positive.
+func NewTokenBucket(cache *kvcache.KVCache, rate float64, burst int) *TokenBucket {
+	if !(rate > 0) || math.IsInf(rate, 0) || burst <= 0 {
+		panic("ratelimit: TokenBucket needs a positive rate and burst")
+	}
+	return &TokenBucket{limiter: newLimiter(cache), rate: rate / float64(time.Second), burst: burst}
+}
+
+// Allow reports whether one request for key may proceed
+func (l *TokenBucket) Allow(key string) Result {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n requests for key may proceed, taking n tokens
+func (l *TokenBucket) AllowN(key string, n int) Result {
+	checkN(n)
+	now := l.clock.Now().UnixNano()
+
+	res := Result{Limit: l.burst}
+	// Once the bucket has had time to refill, a missing key is equivalent
+	ttl := ceil(float64(l.burst) / l.rate)
+	update(&l.limiter, key, ttl, func(s *bucketState) *bucketState {
+		tokens := float64(l.burst)
+		if s != nil {
+			tokens = min(tokens, s.tokens+float64(max(now-s.last, 0))*l.rate)
+		}
+		res.Allowed = tokens >= float64(n)
+		res.Remaining = int(math.Floor(tokens))
+		switch {
+		case res.Allowed:
+			res.Remaining -= n
+		case n > l.burst:
+			res.RetryAfter = InfDuration
+		default:
+			res.RetryAfter = ceil((float64(n) - tokens) / l.rate)
+		}
+		if !res.Allowed || n == 0 {
+			return nil
+		}
+		return &bucketState{tokens: tokens - float64(n), last: now}
+	})
+	return res
+}
@@ -0,0 +1,27 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTokenBucket tests bursts and refilling
+func TestTokenBucket(t *testing.T) {
+	cache, clock := newTestCache(t)
+	l := NewTokenBucket(cache, 2, 4) // 2 tokens per second, bursts of 4
+
+	expect(t, "burst", l.AllowN("k", 4), true, 0, 0)
+	expect(t, "empty", l.Allow("k"), false, 0, 500*time.Millisecond)
+	expect(t, "empty, two", l.AllowN("k", 2), false, 0, time.Second)
+
+	clock.Advance(750 * time.Millisecond)
+	expect(t, "refilled", l.Allow("k"), true, 0, 0)
+	expect(t, "partial token", l.Allow("k"), false, 0, 250*time.Millisecond)
+
+	// The bucket never holds more than burst
+	clock.Advance(time.Hour)
+	expect(t, "full", l.AllowN("k", 0), true, 4, 0)
+	if _, _, ok := cache.GetWithTTL("k"); ok {
+		t.Error("Expected the state of a full bucket to have expired")
+	}
+}
@@ -0,0 +1,546 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// Error replies shared by several commands
+const (
+	errSyntax     = "ERR syntax error"
+	errNotInteger = "ERR value is not an integer or out of range"
+	errWrongType  = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errOverflow   = "ERR increment or decrement would overflow"
+)
+
+// command is one entry in the command table. Arity follows Redis: a
+// positive value is the exact argument count including the command name,
+// a negative one the minimum.
+type command struct {
+	arity   int
+	handler func(s *Server, c *client, args [][]byte)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"get":      {2, cmdGet},
+		"set":      {-3, cmdSet},
+		"del":      {-2, cmdDel},
+		"exists":   {-2, cmdExists},
+		"expire":   {3, cmdExpire},
+		"pexpire":  {3, cmdExpire},
+		"ttl":      {2, cmdTTL},
+		"pttl":     {2, cmdTTL},
+		"persist":  {2, cmdPersist},
+		"mget":     {-2, cmdMGet},
+		"mset":     {-3, cmdMSet},
+		"incr":     {2, cmdIncr},
+		"decr":     {2, cmdIncr},
+		"incrby":   {3, cmdIncr},
+		"decrby":   {3, cmdIncr},
+		"flushall": {-1, cmdFlush},
+		"flushdb":  {-1, cmdFlush},
+		"dbsize":   {1, cmdDBSize},
+		"info":     {-1, cmdInfo},
+		"ping":     {-1, cmdPing},
+		"echo":     {2, cmdEcho},
+		"quit":     {1, cmdQuit},
+		"hello":    {-1, cmdHello},
+		"select":   {2, cmdSelect},
+		"command":  {-1, cmdCommand},
+		"client":   {-2, cmdClient},
+
+		"publish":      {3, cmdPublish},
+		"subscribe":    {-2, cmdSubscribe},
+		"psubscribe":   {-2, cmdSubscribe},
+		"unsubscribe":  {-1, cmdUnsubscribe},
+		"punsubscribe": {-1, cmdUnsubscribe},
+	}
+}
+
+// dispatch looks up and runs a command
+func (s *Server) dispatch(c *client, args [][]byte) {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.error(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", args[0], quoteArgs(args[1:])))
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return
+	}
+	if !subscribedCommands[name] && c.subscribed() {
+		c.w.error(errSubscribed(name))
+		return
+	}
+	cmd.handler(s, c, args)
+}
+
+func quoteArgs(args [][]byte) string {
+	var b strings.Builder
+	for _, a := range args {
+		fmt.Fprintf(&b, "'%s' ", a)
+	}
+	return b.String()
+}
+
+// getString reads a key's value as a string. ok is false if the key is
+// missing; wrongType is set for values stored in-process that are neither
+// strings, byte slices nor numbers. Numbers, such as the int64 counters
+// INCR creates, read as decimal strings.
+func (s *Server) getString(key string) (value string, ok, wrongType bool) {
+	v, ok := s.cache.Get(key)
+	if !ok {
+		return "", false, false
+	}
+	value, isString := asString(v)
+	return value, true, !isString
+}
+
+// asString formats a value as a Redis string, reporting false for values
+// that are not strings, byte slices, numbers or values with a Bytes
+// method, such as memcached.Item
+func asString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	case interface{ Bytes() []byte }:
+		return string(v.Bytes()), true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, rv.Type().Bits()), true
+	}
+	return "", false
+}
+
+// set stores a string value with an optional TTL (0 means none)
+func (s *Server) set(key, value string, ttl time.Duration) {
+	if ttl > 0 {
+		s.cache.Set(key, value, ttl)
+	} else {
+		s.cache.Set(key, value)
+	}
+}
+
+func cmdGet(s *Server, c *client, args [][]byte) {
+	value, ok, wrongType := s.getString(string(args[1]))
+	switch {
+	case wrongType:
+		c.w.error(errWrongType)
+	case !ok:
+		c.w.null()
+	default:
+		c.w.bulkString(value)
+	}
+}
+
+// cmdSet implements SET key value [EX seconds | PX milliseconds] [NX | XX] [KEEPTTL]
+func cmdSet(s *Server, c *client, args [][]byte) {
+	key, value := string(args[1]), string(args[2])
+	var ttl time.Duration
+	var nx, xx, keepTTL, expire bool
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); {
+		case opt == "NX" && !xx:
+			nx = true
+		case opt == "XX" && !nx:
+			xx = true
+		case opt == "KEEPTTL" && !expire:
+			keepTTL = true
+		case (opt == "EX" || opt == "PX") && !expire && !keepTTL && i+1 < len(args):
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				c.w.error(errNotInteger)
+				return
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				c.w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			ttl, expire = time.Duration(n)*unit, true
+			i++
+		default:
+			c.w.error(errSyntax)
+			return
+		}
+	}
+
+	if !nx && !xx && !keepTTL {
+		s.set(key, value, ttl)
+		c.w.simple("OK")
+		return
+	}
+
+	// Without a TTL argument Compute keeps an existing key's expiration;
+	// a TTL of 0 applies the default
+	var ttls []time.Duration
+	if !keepTTL {
+		ttls = []time.Duration{ttl}
+	}
+	written := false
+	s.cache.Compute(key, func(old interface{}, exists bool) (interface{}, kvcache.Op) {
+		if (nx && exists) || (xx && !exists) {
+			return old, kvcache.OpKeep
+		}
+		written = true
+		return value, kvcache.OpSet
+	}, ttls...)
+	if !written {
+		c.w.null()
+		return
+	}
+	c.w.simple("OK")
+}
+
+// cmdDel deletes keys and counts those that existed. Neither it nor
+// EXISTS counts as a lookup in the hit and miss metrics.
+func cmdDel(s *Server, c *client, args [][]byte) {
+	var n int64
+	for _, arg := range args[1:] {
+		s.cache.Compute(string(arg), func(old interface{}, exists bool) (interface{}, kvcache.Op) {
+			if exists {
+				n++
+			}
+			return nil, kvcache.OpDelete
+		})
+	}
+	c.w.int(n)
+}
+
+func cmdExists(s *Server, c *client, args [][]byte) {
+	var n int64
+	for _, arg := range args[1:] {
+		if s.cache.Contains(string(arg)) {
+			n++
+		}
+	}
+	c.w.int(n)
+}
+
+// cmdExpire implements EXPIRE and PEXPIRE. A non-positive timeout deletes
+// the key, as in Redis.
+func cmdExpire(s *Server, c *client, args [][]byte) {
+	key := string(args[1])
+	n, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.w.error(errNotInteger)
+		return
+	}
+	unit := time.Second
+	if strings.EqualFold(string(args[0]), "pexpire") {
+		unit = time.Millisecond
+	}
+	if n > math.MaxInt64/int64(unit) {
+		c.w.error(fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(string(args[0]))))
+		return
+	}
+
+	var ok bool
+	if n <= 0 {
+		_, ok = s.cache.GetAndDelete(key)
+	} else {
+		ok = s.cache.Expire(key, time.Duration(n)*unit)
+	}
+	if !ok {
+		c.w.int(0)
+		return
+	}
+	c.w.int(1)
+}
+
+// cmdTTL implements TTL and PTTL: -2 for a missing key, -1 for a key
+// without an expiry
+func cmdTTL(s *Server, c *client, args [][]byte) {
+	_, ttl, ok := s.cache.GetWithTTL(string(args[1]))
+	switch {
+	case !ok:
+		c.w.int(-2)
+	case ttl == 0:
+		c.w.int(-1)
+	case strings.EqualFold(string(args[0]), "pttl"):
+		c.w.int(int64((ttl + time.Millisecond/2) / time.Millisecond))
+	default:
+		c.w.int(int64((ttl + time.Second/2) / time.Second))
+	}
+}
+
+func cmdPersist(s *Server, c *client, args [][]byte) {
+	if !s.cache.Persist(string(args[1])) {
+		c.w.int(0)
+		return
+	}
+	c.w.int(1)
+}
+
+func cmdMGet(s *Server, c *client, args [][]byte) {
+	c.w.array(len(args) - 1)
+	for _, arg := range args[1:] {
+		// Keys of other types read as nil, as in Redis
+		if value, ok, wrongType := s.getString(string(arg)); ok && !wrongType {
+			c.w.bulkString(value)
+		} else {
+			c.w.null()
+		}
+	}
+}
+
+func cmdMSet(s *Server, c *client, args [][]byte) {
+	if len(args)%2 != 1 {
+		c.w.error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	entries := make(map[string]interface{}, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		entries[string(args[i])] = string(args[i+1])
+	}
+	s.cache.SetMulti(entries)
+	c.w.simple("OK")
+}
+
+// cmdIncr implements INCR, DECR, INCRBY and DECRBY with KVCache.Incr, so
+// they are atomic with increments made in-process. The key's TTL is kept.
+func cmdIncr(s *Server, c *client, args [][]byte) {
+	key := string(args[1])
+	name := strings.ToLower(string(args[0]))
+	delta := int64(1)
+	if len(args) == 3 {
+		var err error
+		if delta, err = strconv.ParseInt(string(args[2]), 10, 64); err != nil {
+			c.w.error(errNotInteger)
+			return
+		}
+	}
+	if name == "decr" || name == "decrby" {
+		if delta == math.MinInt64 {
+			c.w.error("ERR decrement would overflow")
+			return
+		}
+		delta = -delta
+	}
+
+	n, err := s.cache.Incr(key, delta)
+	var notNumeric *kvcache.NotNumericError
+	switch {
+	case errors.Is(err, kvcache.ErrOverflow):
+		c.w.error(errOverflow)
+	case errors.As(err, &notNumeric):
+		if _, isString := asString(notNumeric.Value); isString {
+			c.w.error(errNotInteger)
+		} else {
+			c.w.error(errWrongType)
+		}
+	case err != nil:
+		c.w.error("ERR " + err.Error())
+	default:
+		c.w.int(n)
+	}
+}
+
+// cmdFlush implements FLUSHALL and FLUSHDB. The ASYNC and SYNC modifiers
+// are accepted; clearing is always synchronous.
+func cmdFlush(s *Server, c *client, args [][]byte) {
+	if len(args) > 2 || (len(args) == 2 && !strings.EqualFold(string(args[1]), "async") && !strings.EqualFold(string(args[1]), "sync")) {
+		c.w.error(errSyntax)
+		return
+	}
+	s.cache.Clear()
+	c.w.simple("OK")
+}
+
+func cmdDBSize(s *Server, c *client, args [][]byte) {
+	c.w.int(int64(s.cache.Size()))
+}
+
+// cmdInfo reports server and cache statistics in Redis INFO format
+func cmdInfo(s *Server, c *client, args [][]byte) {
+	section := "all"
+	if len(args) > 1 {
+		section = strings.ToLower(string(args[1]))
+	}
+	c.w.bulkString(s.info(section))
+}
+
+// info renders the requested INFO section ("all" or "default" for every one)
+func (s *Server) info(section string) string {
+	stats := s.cache.Stats()
+	s.mu.Lock()
+	clients := len(s.clients)
+	s.mu.Unlock()
+
+	sections := []struct {
+		name   string
+		fields [][2]string
+	}{
+		{"server", [][2]string{
+			{"redis_version", "7.0.0"}, // What clients should assume we speak
+			{"redis_mode", "standalone"},
+			{"uptime_in_seconds", strconv.FormatInt(int64(time.Since(s.started)/time.Second), 10)},
+		}},
+		{"clients", [][2]string{
+			{"connected_clients", strconv.Itoa(clients)},
+		}},
+		{"memory", [][2]string{
+			{"used_memory", strconv.FormatInt(stats.Cost, 10)},
+			{"maxmemory", strconv.FormatInt(stats.MaxCost, 10)},
+		}},
+		{"stats", [][2]string{
+			{"total_connections_received", strconv.FormatUint(s.connections.Load(), 10)},
+			{"total_commands_processed", strconv.FormatUint(s.commands.Load(), 10)},
+			{"keyspace_hits", strconv.FormatUint(stats.Hits, 10)},
+			{"keyspace_misses", strconv.FormatUint(stats.Misses, 10)},
+			{"evicted_keys", strconv.FormatUint(stats.Evictions, 10)},
+			{"rejected_keys", strconv.FormatUint(stats.Rejections, 10)},
+			{"hit_rate", strconv.FormatFloat(stats.HitRate(), 'f', 2, 64)},
+		}},
+		{"keyspace", [][2]string{
+			{"db0", "keys=" + strconv.FormatUint(stats.Size, 10)},
+		}},
+	}
+
+	var b strings.Builder
+	for _, sec := range sections {
+		if section != "all" && section != "default" && section != sec.name {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(sec.name[:1])+sec.name[1:])
+		for _, f := range sec.fields {
+			fmt.Fprintf(&b, "%s:%s\r\n", f[0], f[1])
+		}
+	}
+	return b.String()
+}
+
+// cmdPing replies PONG, or echoes its argument. In RESP2 subscribed mode
+// the reply is a ["pong", argument] array, as in Redis.
+func cmdPing(s *Server, c *client, args [][]byte) {
+	if len(args) <= 2 && c.subscribed() {
+		c.w.array(2)
+		c.w.bulkString("pong")
+		if len(args) == 2 {
+			c.w.bulk(args[1])
+		} else {
+			c.w.bulkString("")
+		}
+		return
+	}
+	switch len(args) {
+	case 1:
+		c.w.simple("PONG")
+	case 2:
+		c.w.bulk(args[1])
+	default:
+		c.w.error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func cmdEcho(s *Server, c *client, args [][]byte) {
+	c.w.bulk(args[1])
+}
+
+func cmdQuit(s *Server, c *client, args [][]byte) {
+	c.w.simple("OK")
+	c.quit = true
+}
+
+// cmdHello implements HELLO [protover [AUTH username password] [SETNAME name]],
+// switching the connection between RESP2 and RESP3
+func cmdHello(s *Server, c *client, args [][]byte) {
+	if len(args) > 1 {
+		proto, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			c.w.error("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if proto != 2 && proto != 3 {
+			c.w.error("NOPROTO unsupported protocol version")
+			return
+		}
+		for i := 2; i < len(args); i++ {
+			switch opt := strings.ToUpper(string(args[i])); {
+			case opt == "AUTH" && i+2 < len(args):
+				i += 2 // No authentication; any credentials are accepted
+			case opt == "SETNAME" && i+1 < len(args):
+				i++
+			default:
+				c.w.error(errSyntax)
+				return
+			}
+		}
+		c.w.proto = proto
+	}
+
+	c.w.mapHeader(7)
+	c.w.bulkString("server")
+	c.w.bulkString("kvdb")
+	c.w.bulkString("version")
+	c.w.bulkString("7.0.0")
+	c.w.bulkString("proto")
+	c.w.int(int64(c.w.proto))
+	c.w.bulkString("id")
+	c.w.int(c.id)
+	c.w.bulkString("mode")
+	c.w.bulkString("standalone")
+	c.w.bulkString("role")
+	c.w.bulkString("master")
+	c.w.bulkString("modules")
+	c.w.array(0)
+}
+
+// cmdSelect accepts only database 0; there is a single keyspace
+func cmdSelect(s *Server, c *client, args [][]byte) {
+	if string(args[1]) != "0" {
+		c.w.error("ERR DB index is out of range")
+		return
+	}
+	c.w.simple("OK")
+}
+
+// cmdCommand answers COMMAND and its subcommands with an empty list, which
+// is enough for clients that ask for command metadata on connect
+func cmdCommand(s *Server, c *client, args [][]byte) {
+	if len(args) == 2 && strings.EqualFold(string(args[1]), "count") {
+		c.w.int(int64(len(commands)))
+		return
+	}
+	c.w.array(0)
+}
+
+// cmdClient implements the CLIENT subcommands client libraries send on
+// connect
+func cmdClient(s *Server, c *client, args [][]byte) {
+	switch strings.ToUpper(string(args[1])) {
+	case "ID":
+		c.w.int(c.id)
+	case "SETNAME", "SETINFO":
+		c.w.simple("OK")
+	case "GETNAME":
+		c.w.null()
+	default:
+		c.w.error(fmt.Sprintf("ERR unknown subcommand '%s'", args[1]))
+	}
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// script runs commands and checks each reply. A want of errPrefix("X")
+// matches any error starting with X.
+type step struct {
+	args []string
+	want interface{}
+}
+
+type errPrefix string
+
+func run(t *testing.T, c *testConn, steps []step) {
+	t.Helper()
+	for _, s := range steps {
+		got := c.do(s.args...)
+		switch want := s.want.(type) {
+		case errPrefix:
+			if e, ok := got.(replyError); !ok || !strings.HasPrefix(string(e), string(want)) {
+				t.Errorf("%v: expected error %q, got %#v", s.args, want, got)
+			}
+		default:
+			if fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", want) {
+				t.Errorf("%v: expected %#v, got %#v", s.args, want, got)
+			}
+		}
+	}
+}
+
+func args(a ...string) []string { return a }
+
+// TestCommandsStrings tests GET, SET and its options, DEL, EXISTS, MGET, MSET
+func TestCommandsStrings(t *testing.T) {
+	_, cache, addr := newTestServer(t)
+	c := dial(t, addr)
+	cache.Set("object", struct{}{}) // Stored in-process, not a string
+
+	run(t, c, []step{
+		{args("GET", "k"), nil},
+		{args("SET", "k", "v"), "OK"},
+		{args("GET", "k"), "v"},
+		{args("SET", "k", "v2", "NX"), nil},
+		{args("SET", "new", "1", "nx"), "OK"},
+		{args("SET", "missing", "1", "XX"), nil},
+		{args("SET", "k", "v3", "XX"), "OK"},
+		{args("GET", "k"), "v3"},
+		{args("SET", "k", "v", "NX", "XX"), errPrefix("ERR syntax")},
+		{args("SET", "k", "v", "EX"), errPrefix("ERR syntax")},
+		{args("SET", "k", "v", "EX", "ten"), errPrefix("ERR value is not an integer")},
+		{args("SET", "k", "v", "EX", "0"), errPrefix("ERR invalid expire time")},
+		{args("SET", "k", "v", "EX", "10", "KEEPTTL"), errPrefix("ERR syntax")},
+		{args("SET", "k", "v", "BOGUS"), errPrefix("ERR syntax")},
+		{args("GET", "object"), errPrefix("WRONGTYPE")},
+		{args("EXISTS", "k", "k", "nope", "object"), int64(3)},
+		{args("MSET", "a", "1", "b", "2"), "OK"},
+		{args("MSET", "a", "1", "b"), errPrefix("ERR wrong number")},
+		{args("MGET", "a", "nope", "b", "object"), []interface{}{"1", nil, "2", nil}},
+		{args("DEL", "a", "b", "nope"), int64(2)},
+		{args("EXISTS", "a"), int64(0)},
+		{args("GET"), errPrefix("ERR wrong number of arguments for 'get'")},
+		{args("NOSUCH", "x"), errPrefix("ERR unknown command 'NOSUCH'")},
+	})
+}
+
+// TestCommandsExpiry tests EX/PX, EXPIRE, TTL, PERSIST and KEEPTTL
+func TestCommandsExpiry(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	run(t, c, []step{
+		{args("TTL", "k"), int64(-2)},
+		{args("SET", "k", "v"), "OK"},
+		{args("TTL", "k"), int64(-1)},
+		{args("EXPIRE", "k", "100"), int64(1)},
+		{args("TTL", "k"), int64(100)},
+		{args("SET", "k", "v2", "KEEPTTL"), "OK"},
+		{args("TTL", "k"), int64(100)},
+		{args("SET", "k", "v3"), "OK"}, // Plain SET clears the TTL
+		{args("TTL", "k"), int64(-1)},
+		{args("SET", "k", "v", "PX", "5000"), "OK"},
+		{args("TTL", "k"), int64(5)},
+		{args("PERSIST", "k"), int64(1)},
+		{args("PERSIST", "k"), int64(0)},
+		{args("TTL", "k"), int64(-1)},
+		{args("PEXPIRE", "k", "1500"), int64(1)},
+		{args("EXPIRE", "nope", "10"), int64(0)},
+		{args("EXPIRE", "k", "x"), errPrefix("ERR value is not an integer")},
+	})
+	if ms := c.do("PTTL", "k").(int64); ms <= 1000 || ms > 1500 {
+		t.Errorf("Expected PTTL around 1500, got %d", ms)
+	}
+
+	run(t, c, []step{
+		{args("SET", "short", "v", "PX", "30"), "OK"},
+		{args("EXPIRE", "k", "0"), int64(1)}, // Deletes
+		{args("EXISTS", "k"), int64(0)},
+	})
+	time.Sleep(50 * time.Millisecond)
+	run(t, c, []step{{args("GET", "short"), nil}})
+}
+
+// TestCommandsIncr tests INCR, DECR, INCRBY and DECRBY
+func TestCommandsIncr(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	run(t, c, []step{
+		{args("INCR", "n"), int64(1)},
+		{args("INCRBY", "n", "41"), int64(42)},
+		{args("DECR", "n"), int64(41)},
+		{args("DECRBY", "n", "50"), int64(-9)},
+		{args("GET", "n"), "-9"},
+		{args("SET", "n", "9223372036854775807", "EX", "100"), "OK"},
+		{args("INCR", "n"), errPrefix("ERR increment or decrement would overflow")},
+		{args("DECR", "n"), int64(9223372036854775806)},
+		{args("TTL", "n"), int64(100)}, // INCR keeps the TTL
+		{args("SET", "s", "abc"), "OK"},
+		{args("INCR", "s"), errPrefix("ERR value is not an integer")},
+		{args("INCRBY", "n", "x"), errPrefix("ERR value is not an integer")},
+		{args("DECRBY", "n", "-9223372036854775808"), errPrefix("ERR decrement would overflow")},
+	})
+}
+
+// TestCommandsSharedCache tests commands against values and TTLs set
+// in-process on a cache with a default TTL
+func TestCommandsSharedCache(t *testing.T) {
+	cache := kvcache.NewKVCacheWithConfig(kvcache.Config{NumShards: 16, DefaultTTL: time.Hour})
+	_, addr := serveCache(t, cache)
+	c := dial(t, addr)
+
+	cache.Incr("hits", 5)
+	cache.Set("forever", "v", kvcache.NoExpiration)
+	cache.Set("float", 1.5)
+
+	run(t, c, []step{
+		// PERSIST and KEEPTTL keep keys permanent despite the default TTL
+		{args("SET", "k", "v", "EX", "100"), "OK"},
+		{args("PERSIST", "k"), int64(1)},
+		{args("TTL", "k"), int64(-1)},
+		{args("SET", "forever", "v2", "KEEPTTL"), "OK"},
+		{args("TTL", "forever"), int64(-1)},
+		{args("SET", "new", "v", "KEEPTTL"), "OK"},
+		{args("TTL", "new"), int64(3600)},
+
+		// In-process numbers are strings to the server
+		{args("INCR", "hits"), int64(6)},
+		{args("GET", "hits"), "6"},
+		{args("GET", "float"), "1.5"},
+		{args("INCR", "float"), errPrefix("ERR value is not an integer")},
+	})
+	if v, _ := cache.Get("hits"); v != int64(6) {
+		t.Errorf("Expected INCR to keep the int64 counter, got %#v", v)
+	}
+
+	// DEL and EXISTS are not lookups
+	before := cache.Stats()
+	run(t, c, []step{
+		{args("EXISTS", "k", "nope"), int64(1)},
+		{args("DEL", "k", "nope"), int64(1)},
+	})
+	if after := cache.Stats(); after.Hits != before.Hits || after.Misses != before.Misses {
+		t.Errorf("Expected DEL and EXISTS not to count, hits %d -> %d, misses %d -> %d",
+			before.Hits, after.Hits, before.Misses, after.Misses)
+	}
+}
+
+// TestCommandsAtomicWithCache tests that INCR and SET NX are atomic with
+// in-process writers
+func TestCommandsAtomicWithCache(t *testing.T) {
+	_, cache, addr := newTestServer(t)
+
+	var wg sync.WaitGroup
+	var created atomic.Int64
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			c := dial(t, addr)
+			for i := 0; i < 100; i++ {
+				c.do("INCR", "n")
+				if c.do("SET", fmt.Sprint("nx", i), "server", "NX") == "OK" {
+					created.Add(1)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				cache.Incr("n", 1)
+				if cache.SetNX(fmt.Sprint("nx", i), "local") {
+					created.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := cache.Get("n"); v != int64(800) {
+		t.Errorf("Expected 800, got %#v", v)
+	}
+	if n := created.Load(); n != 100 {
+		t.Errorf("Expected each NX key created once, got %d creations", n)
+	}
+}
+
+// TestCommandsServer tests FLUSHALL, DBSIZE, INFO, PING, ECHO and SELECT
+func TestCommandsServer(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	run(t, c, []step{
+		{args("PING"), "PONG"},
+		{args("PING", "hi"), "hi"},
+		{args("ECHO", "hello"), "hello"},
+		{args("MSET", "a", "1", "b", "2", "c", "3"), "OK"},
+		{args("GET", "a"), "1"},
+		{args("GET", "zzz"), nil},
+		{args("DBSIZE"), int64(3)},
+		{args("SELECT", "0"), "OK"},
+		{args("SELECT", "1"), errPrefix("ERR DB index is out of range")},
+		{args("CLIENT", "SETINFO", "lib-name", "test"), "OK"},
+		{args("FLUSHALL", "BOGUS"), errPrefix("ERR syntax")},
+		{args("FLUSHALL"), "OK"},
+		{args("DBSIZE"), int64(0)},
+	})
+
+	info, _ := c.do("INFO").(string)
+	for _, want := range []string{"# Server\r\n", "redis_version:", "keyspace_hits:1\r\n", "keyspace_misses:1\r\n", "db0:keys=0\r\n", "connected_clients:1\r\n"} {
+		if !strings.Contains(info, want) {
+			t.Errorf("INFO missing %q:\n%s", want, info)
+		}
+	}
+	stats, _ := c.do("INFO", "stats").(string)
+	if !strings.HasPrefix(stats, "# Stats\r\n") || strings.Contains(stats, "# Server") {
+		t.Errorf("INFO stats should only return that section:\n%s", stats)
+	}
+}
+
+// TestCommandsHello tests switching to RESP3
+func TestCommandsHello(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	reply, ok := c.do("HELLO", "3", "SETNAME", "test").([]interface{})
+	if !ok || len(reply) != 14 || reply[4] != "proto" || reply[5] != int64(3) {
+		t.Fatalf("Unexpected HELLO reply %#v", reply)
+	}
+
+	// RESP3 null is "_"
+	fmt.Fprint(c.conn, encode("GET", "missing"))
+	line, _ := c.r.ReadString('\n')
+	if line != "_\r\n" {
+		t.Errorf("Expected RESP3 null, got %q", line)
+	}
+
+	run(t, c, []step{
+		{args("HELLO", "4"), errPrefix("NOPROTO")},
+		{args("HELLO", "x"), errPrefix("ERR Protocol version")},
+	})
+	if reply, _ := c.do("HELLO", "2").([]interface{}); len(reply) != 14 || reply[5] != int64(2) {
+		t.Errorf("Unexpected HELLO 2 reply %#v", reply)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// subscribedCommands are the commands a RESP2 connection may send while it
+// has subscriptions, as in Redis
+var subscribedCommands = map[string]bool{
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+}
+
+// subscribed reports whether c is in RESP2 subscribed mode, where only
+// subscribedCommands are accepted. RESP3 connections may mix commands and
+// pushes freely.
+func (c *client) subscribed() bool {
+	return c.w.proto == 2 && c.sub != nil && c.sub.Count() > 0
+}
+
+func cmdPublish(s *Server, c *client, args [][]byte) {
+	c.w.int(int64(s.pubsub.Publish(string(args[1]), string(args[2]))))
+}
+
+// cmdSubscribe implements SUBSCRIBE and PSUBSCRIBE, confirming each channel
+// or pattern with a push that carries the subscription count
+func cmdSubscribe(s *Server, c *client, args [][]byte) {
+	if c.sub == nil {
+		c.sub = s.pubsub.Subscribe()
+		c.forwarded = make(chan struct{})
+		go s.forward(c, c.sub)
+	}
+	name := strings.ToLower(string(args[0]))
+	for _, arg := range args[1:] {
+		if name == "psubscribe" {
+			c.sub.PSubscribe(string(arg))
+		} else {
+			c.sub.Subscribe(string(arg))
+		}
+		c.w.push(3)
+		c.w.bulkString(name)
+		c.w.bulk(arg)
+		c.w.int(int64(c.sub.Count()))
+	}
+}
+
+// cmdUnsubscribe implements UNSUBSCRIBE and PUNSUBSCRIBE. Without
+// arguments every channel (or pattern) is removed, each confirmed with a
+// push; with none to remove a single push with a null name is sent.
+func cmdUnsubscribe(s *Server, c *client, args [][]byte) {
+	name := strings.ToLower(string(args[0]))
+	var names []string
+	for _, arg := range args[1:] {
+		names = append(names, string(arg))
+	}
+	if len(names) == 0 && c.sub != nil {
+		if name == "punsubscribe" {
+			names = c.sub.Patterns()
+		} else {
+			names = c.sub.Channels()
+		}
+	}
+	if len(names) == 0 {
+		count := 0
+		if c.sub != nil {
+			count = c.sub.Count()
+		}
+		c.w.push(3)
+		c.w.bulkString(name)
+		c.w.null()
+		c.w.int(int64(count))
+		return
+	}
+
+	for _, n := range names {
+		count := 0
+		if c.sub != nil {
+			if name == "punsubscribe" {
+				c.sub.PUnsubscribe(n)
+			} else {
+				c.sub.Unsubscribe(n)
+			}
+			count = c.sub.Count()
+		}
+		c.w.push(3)
+		c.w.bulkString(name)
+		c.w.bulkString(n)
+		c.w.int(int64(count))
+	}
+}
+
+// forward writes sub's messages to the connection until sub is closed,
+// either when the connection ends or because the client fell too far
+// behind; in the latter case the connection is closed too
+func (s *Server) forward(c *client, sub *kvcache.Subscription) {
+	defer close(c.forwarded)
+	messages := sub.Messages()
+	for m := range messages {
+		c.wmu.Lock()
+		if m.Pattern != "" {
+			c.w.push(4)
+			c.w.bulkString("pmessage")
+			c.w.bulkString(m.Pattern)
+		} else {
+			c.w.push(3)
+			c.w.bulkString("message")
+		}
+		c.w.bulkString(m.Channel)
+		c.w.bulkString(m.Payload)
+		var err error
+		if len(messages) == 0 {
+			err = c.w.flush()
+		}
+		c.wmu.Unlock()
+		if err != nil {
+			sub.Close()
+			break
+		}
+	}
+	c.conn.Close()
+}
+
+// errSubscribed is the reply to other commands in RESP2 subscribed mode
+func errSubscribed(name string) string {
+	return fmt.Sprintf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", name)
+}
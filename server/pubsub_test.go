@@ -0,0 +1,146 @@
+package server
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// list builds the reply expected for a RESP array
+func list(items ...interface{}) []interface{} {
+	return items
+}
+
+// TestServerPubSub tests SUBSCRIBE, PSUBSCRIBE and PUBLISH across
+// connections
+func TestServerPubSub(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	sub := dial(t, addr)
+	pub := dial(t, addr)
+
+	if got, want := sub.do("SUBSCRIBE", "news", "sport"), list("subscribe", "news", int64(1)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got, want := sub.read(), list("subscribe", "sport", int64(2)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got, want := sub.do("PSUBSCRIBE", "n*"), list("psubscribe", "n*", int64(3)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if got := pub.do("PUBLISH", "news", "hello"); got != int64(2) {
+		t.Errorf("Expected 2 receivers, got %v", got)
+	}
+	if got := pub.do("PUBLISH", "weather", "rain"); got != int64(0) {
+		t.Errorf("Expected no receivers, got %v", got)
+	}
+	pub.do("PUBLISH", "sport", "goal")
+
+	// The channel and pattern deliveries of one message may come in
+	// either order
+	first, second := sub.read(), sub.read()
+	if reflect.DeepEqual(first, list("pmessage", "n*", "news", "hello")) {
+		first, second = second, first
+	}
+	if want := list("message", "news", "hello"); !reflect.DeepEqual(first, want) {
+		t.Errorf("Expected %v, got %v", want, first)
+	}
+	if want := list("pmessage", "n*", "news", "hello"); !reflect.DeepEqual(second, want) {
+		t.Errorf("Expected %v, got %v", want, second)
+	}
+	if got, want := sub.read(), list("message", "sport", "goal"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestServerUnsubscribe tests UNSUBSCRIBE and PUNSUBSCRIBE replies
+func TestServerUnsubscribe(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	if got, want := c.do("UNSUBSCRIBE"), list("unsubscribe", nil, int64(0)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Without subscriptions: expected %v, got %v", want, got)
+	}
+	c.do("SUBSCRIBE", "a")
+	c.do("PSUBSCRIBE", "x*")
+	if got, want := c.do("UNSUBSCRIBE", "a", "b"), list("unsubscribe", "a", int64(1)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got, want := c.read(), list("unsubscribe", "b", int64(1)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got, want := c.do("PUNSUBSCRIBE"), list("punsubscribe", "x*", int64(0)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := c.do("SET", "k", "v"); got != "OK" {
+		t.Errorf("Expected commands after unsubscribing, got %v", got)
+	}
+}
+
+// TestServerSubscribedMode tests the commands allowed while subscribed
+func TestServerSubscribedMode(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+	c.do("SUBSCRIBE", "a")
+
+	if got, ok := c.do("GET", "k").(replyError); !ok || !strings.Contains(string(got), "only (P)SUBSCRIBE") {
+		t.Errorf("Expected a subscribed mode error, got %v", got)
+	}
+	if got, want := c.do("PING"), list("pong", ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got, want := c.do("PING", "hi"), list("pong", "hi"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestServerPubSubRESP3 tests that RESP3 connections get pushes and may
+// run other commands while subscribed
+func TestServerPubSubRESP3(t *testing.T) {
+	srv, _, addr := newTestServer(t)
+	c := dial(t, addr)
+	c.do("HELLO", "3")
+
+	io.WriteString(c.conn, encode("SUBSCRIBE", "a"))
+	if line, _ := c.r.ReadString('\n'); line != ">3\r\n" {
+		t.Errorf("Expected a push, got %q", line)
+	}
+	c.read()
+	c.read()
+	c.read()
+	if got := c.do("SET", "k", "v"); got != "OK" {
+		t.Errorf("Expected SET to work, got %v", got)
+	}
+	if got := c.do("PING"); got != "PONG" {
+		t.Errorf("Expected PONG, got %v", got)
+	}
+
+	// Messages published in-process reach network subscribers
+	srv.PubSub().Publish("a", "local")
+	if got, want := c.read(), list("message", "a", "local"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestServerPubSubInProcess tests in-process subscribers to network
+// publishes
+func TestServerPubSubInProcess(t *testing.T) {
+	srv, _, addr := newTestServer(t)
+	sub := srv.PubSub().PSubscribe("events:*")
+	c := dial(t, addr)
+
+	if got := c.do("PUBLISH", "events:1", "x"); got != int64(1) {
+		t.Errorf("Expected 1 receiver, got %v", got)
+	}
+	if m := <-sub.Messages(); m != (kvcache.Message{Channel: "events:1", Pattern: "events:*", Payload: "x"}) {
+		t.Errorf("Unexpected message %+v", m)
+	}
+
+	srv.Close()
+	if _, ok := <-sub.Messages(); ok {
+		t.Error("Expected Close to close in-process subscriptions")
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Limits on what a client may send in one command
+const (
+	maxInlineSize = 64 * 1024
+	maxBulkSize   = 512 * 1024 * 1024
+	maxArrayLen   = 1024 * 1024
+)
+
+// protocolError is a malformed request; the connection is closed after
+// reporting it because the stream can no longer be framed
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// respReader parses client commands: RESP arrays of bulk strings as sent
+// by client libraries, or space-separated inline commands as typed into
+// telnet.
+type respReader struct {
+	r *bufio.Reader
+}
+
+// newRESPReader buffers enough for the longest inline command allowed and
+// its CRLF, since readLine needs a whole line in the buffer
+func newRESPReader(r io.Reader) *respReader {
+	return &respReader{r: bufio.NewReaderSize(r, maxInlineSize+2)}
+}
+
+// buffered reports how many bytes of pipelined commands are already read
+func (r *respReader) buffered() int {
+	return r.r.Buffered()
+}
+
+// readCommand returns the next command's arguments. An empty command
+// (a blank inline line) returns no arguments and no error.
+func (r *respReader) readCommand() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return r.parseInline(line)
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArrayLen {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	args := make([][]byte, n)
+	for i := range args {
+		if args[i], err = r.readBulk(); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// readBulk reads one $-prefixed bulk string
+func (r *respReader) readBulk() ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, protocolError(fmt.Sprintf("expected '$', got '%s'", firstByte(line)))
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxBulkSize {
+		return nil, protocolError("invalid bulk length")
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, protocolError("bulk string not terminated by CRLF")
+	}
+	return buf[:n], nil
+}
+
+// readLine reads a line without its CRLF (a bare LF is accepted)
+func (r *respReader) readLine() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, protocolError("too big inline request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	if len(line) > maxInlineSize {
+		return nil, protocolError("too big inline request")
+	}
+	return line, nil
+}
+
+// parseInline splits an inline command on whitespace, honouring double
+// and single quotes the way redis-cli does
+func (r *respReader) parseInline(line []byte) ([][]byte, error) {
+	var args [][]byte
+	for {
+		line = bytes.TrimLeft(line, " \t")
+		if len(line) == 0 {
+			return args, nil
+		}
+		var arg []byte
+		switch quote := line[0]; quote {
+		case '"', '\'':
+			end := bytes.IndexByte(line[1:], quote)
+			if end < 0 {
+				return nil, protocolError("unbalanced quotes in request")
+			}
+			arg, line = line[1:end+1], line[end+2:]
+		default:
+			end := bytes.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			arg, line = line[:end], line[end:]
+		}
+		// The line is reused by the next read
+		args = append(args, append([]byte(nil), arg...))
+	}
+}
+
+func firstByte(line []byte) string {
+	if len(line) == 0 {
+		return ""
+	}
+	return string(line[:1])
+}
+
+// respWriter encodes replies in the connection's protocol version.
+// RESP3 differs only in the types used for null, maps and pushes here.
+type respWriter struct {
+	w     *bufio.Writer
+	proto int
+	buf   []byte
+}
+
+func newRESPWriter(w io.Writer) *respWriter {
+	return &respWriter{w: bufio.NewWriterSize(w, 16*1024), proto: 2}
+}
+
+func (w *respWriter) flush() error {
+	return w.w.Flush()
+}
+
+// simple writes a status reply such as +OK
+func (w *respWriter) simple(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// error writes an error reply. msg should start with an error code such as
+// ERR or WRONGTYPE.
+func (w *respWriter) error(msg string) {
+	w.w.WriteByte('-')
+	w.w.WriteString(msg)
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) int(n int64) {
+	w.header(':', n)
+}
+
+func (w *respWriter) bulk(b []byte) {
+	w.header('$', int64(len(b)))
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) bulkString(s string) {
+	w.header('$', int64(len(s)))
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// null writes a missing value: a null bulk string in RESP2
+func (w *respWriter) null() {
+	if w.proto == 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("$-1\r\n")
+}
+
+// array starts an array of n elements
+func (w *respWriter) array(n int) {
+	w.header('*', int64(n))
+}
+
+// push starts an out-of-band push of n elements, such as a pub/sub
+// message, sent as an array in RESP2
+func (w *respWriter) push(n int) {
+	if w.proto == 3 {
+		w.header('>', int64(n))
+		return
+	}
+	w.array(n)
+}
+
+// mapHeader starts a map of n pairs, sent as a flat array in RESP2
+func (w *respWriter) mapHeader(n int) {
+	if w.proto == 3 {
+		w.header('%', int64(n))
+		return
+	}
+	w.array(2 * n)
+}
+
+func (w *respWriter) header(prefix byte, n int64) {
+	w.buf = append(w.buf[:0], prefix)
+	w.buf = strconv.AppendInt(w.buf, n, 10)
+	w.buf = append(w.buf, '\r', '\n')
+	w.w.Write(w.buf)
+}
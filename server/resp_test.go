@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestReadCommand tests parsing multibulk and inline commands
+func TestReadCommand(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nva\r\nl\r\n" + // Binary-safe bulk
+		"PING\r\n" +
+		"set \"two words\" 'it''s'\n" +
+		"\r\n" +
+		"*0\r\n"
+	r := newRESPReader(strings.NewReader(input))
+
+	want := []string{
+		`["SET" "key" "va\r\nl"]`,
+		`["PING"]`,
+		`["set" "two words" "it" "s"]`,
+		`[]`,
+		`[]`,
+	}
+	for _, w := range want {
+		args, err := r.readCommand()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(args))
+		for i, a := range args {
+			got[i] = string(a)
+		}
+		if s := fmt.Sprintf("%q", got); s != w {
+			t.Errorf("Expected %s, got %s", w, s)
+		}
+	}
+}
+
+// TestReadCommandErrors tests malformed requests
+func TestReadCommandErrors(t *testing.T) {
+	for name, input := range map[string]string{
+		"bad count":      "*x\r\n",
+		"huge count":     "*99999999\r\n",
+		"not bulk":       "*1\r\n:1\r\n",
+		"bad length":     "*1\r\n$-5\r\n",
+		"no terminator":  "*1\r\n$3\r\nabcde\r\n",
+		"open quote":     "set \"key\r\n",
+		"inline too big": strings.Repeat("x", maxInlineSize+10) + "\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newRESPReader(strings.NewReader(input)).readCommand()
+			var perr protocolError
+			if !errors.As(err, &perr) {
+				t.Errorf("Expected protocol error, got %v", err)
+			}
+		})
+	}
+}
+
+// TestReadCommandInlineLimit tests that inline commands up to
+// maxInlineSize are accepted and longer ones refused
+func TestReadCommandInlineLimit(t *testing.T) {
+	arg := strings.Repeat("x", maxInlineSize-len("echo "))
+	args, err := newRESPReader(strings.NewReader("echo " + arg + "\r\n")).readCommand()
+	if err != nil || len(args) != 2 || string(args[1]) != arg {
+		t.Errorf("Expected a %d-byte inline command to be read, got %d args, %v", maxInlineSize, len(args), err)
+	}
+	_, err = newRESPReader(strings.NewReader("echo " + arg + "x\n")).readCommand()
+	var perr protocolError
+	if !errors.As(err, &perr) {
+		t.Errorf("Expected a protocol error one byte over the limit, got %v", err)
+	}
+}
+
+// TestRESPWriter tests reply encoding in both protocol versions
+func TestRESPWriter(t *testing.T) {
+	for proto, want := range map[int]string{
+		2: "+OK\r\n-ERR bad\r\n:-7\r\n$3\r\nabc\r\n$-1\r\n*2\r\n$1\r\nk\r\n:1\r\n",
+		3: "+OK\r\n-ERR bad\r\n:-7\r\n$3\r\nabc\r\n_\r\n%1\r\n$1\r\nk\r\n:1\r\n",
+	} {
+		var buf bytes.Buffer
+		w := newRESPWriter(&buf)
+		w.proto = proto
+		w.simple("OK")
+		w.error("ERR bad")
+		w.int(-7)
+		w.bulkString("abc")
+		w.null()
+		w.mapHeader(1)
+		w.bulk([]byte("k"))
+		w.int(1)
+		w.flush()
+		if buf.String() != want {
+			t.Errorf("RESP%d: expected %q, got %q", proto, want, buf.String())
+		}
+	}
+}
+
+// readReply parses one reply for tests: simple strings and bulk strings
+// become string, integers int64, nulls nil, arrays and maps []interface{},
+// and errors a replyError
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply line")
+	}
+	body := line[1:]
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return replyError(body), nil
+	case ':':
+		var n int64
+		_, err := fmt.Sscan(body, &n)
+		return n, err
+	case '_':
+		return nil, nil
+	case '$':
+		var n int
+		fmt.Sscan(body, &n)
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*', '%', '>':
+		var n int
+		fmt.Sscan(body, &n)
+		if line[0] == '%' {
+			n *= 2
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
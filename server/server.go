@@ -0,0 +1,221 @@
+// Package server exposes a kvcache.KVCache over TCP using the Redis
+// serialization protocol (RESP2 and RESP3), so redis-cli and Redis client
+// libraries can be used as clients. It also serves Redis pub/sub through
+// a kvcache.PubSub, which in-process code can share, see Server.PubSub.
+//
+// Values written through the server are stored as strings, except that
+// INCR and friends go through KVCache.Incr and create int64 counters.
+// Numbers stored in-process read as decimal strings. Keys set without an
+// expiry use the cache's DefaultTTL, so serve a cache without one for Redis
+// semantics; PERSIST and KEEPTTL keep a key permanent regardless.
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close
+var ErrServerClosed = errors.New("server: closed")
+
+// Server serves one KVCache to any number of connections.
+// The cache is owned by the caller and is not closed by Close.
+type Server struct {
+	cache   *kvcache.KVCache
+	pubsub  *kvcache.PubSub
+	started time.Time
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	clients   map[*client]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+
+	// Metrics reported by INFO
+	nextID      atomic.Int64
+	connections atomic.Uint64
+	commands    atomic.Uint64
+}
+
+// client is one connection's state
+type client struct {
+	id   int64
+	conn net.Conn
+	r    *respReader
+	quit bool
+
+	// wmu guards w, which the pub/sub forwarder also writes to
+	wmu sync.Mutex
+	w   *respWriter
+
+	// Nil until the first SUBSCRIBE or PSUBSCRIBE; forwarded is closed
+	// once the goroutine writing its messages has returned
+	sub       *kvcache.Subscription
+	forwarded chan struct{}
+}
+
+// New creates a server for cache with its own PubSub. Subscribers that
+// fall more than kvcache.DefaultMessageBuffer messages behind are
+// disconnected, as with Redis's pub/sub output buffer limit.
+func New(cache *kvcache.KVCache) *Server {
+	return &Server{
+		cache:     cache,
+		pubsub:    kvcache.NewPubSub(kvcache.PubSubOptions{Overflow: kvcache.OverflowDisconnect}),
+		started:   time.Now(),
+		listeners: make(map[net.Listener]struct{}),
+		clients:   make(map[*client]struct{}),
+	}
+}
+
+// PubSub returns the broker behind PUBLISH and SUBSCRIBE, so in-process
+// code can publish to and subscribe alongside network clients. It is
+// closed by Close.
+func (s *Server) PubSub() *kvcache.PubSub {
+	return s.pubsub
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called, then returns
+// ErrServerClosed. Each connection is handled on its own goroutine.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// Out of file descriptors and the like; back off and retry
+				delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		c := &client{
+			id:   s.nextID.Add(1),
+			conn: conn,
+			r:    newRESPReader(conn),
+			w:    newRESPWriter(conn),
+		}
+		if !s.track(c) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.connections.Add(1)
+		go s.serveClient(c)
+	}
+}
+
+// track registers a client unless the server is closed
+func (s *Server) track(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.clients[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Close stops all listeners, closes every connection, waits for their
+// handlers to return and closes the PubSub
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); err == nil {
+			err = cerr
+		}
+	}
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	s.pubsub.Close()
+	return err
+}
+
+// serveClient runs one connection's read-execute loop. Replies are
+// buffered and flushed once no pipelined commands are waiting.
+func (s *Server) serveClient(c *client) {
+	defer func() {
+		c.conn.Close()
+		if c.sub != nil {
+			c.sub.Close()
+			<-c.forwarded
+		}
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	for !c.quit {
+		args, err := c.r.readCommand()
+		if err != nil {
+			var perr protocolError
+			if errors.As(err, &perr) {
+				c.wmu.Lock()
+				c.w.error("ERR " + perr.Error())
+				c.w.flush()
+				c.wmu.Unlock()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.commands.Add(1)
+		c.wmu.Lock()
+		s.dispatch(c, args)
+		if c.quit || c.r.buffered() == 0 {
+			err = c.w.flush()
+		}
+		c.wmu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
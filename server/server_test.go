@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/Fast-Cache/kvcache"
+)
+
+// newTestServer serves a fresh cache on a loopback port
+func newTestServer(t *testing.T) (*Server, *kvcache.KVCache, string) {
+	t.Helper()
+	cache := kvcache.NewKVCacheWithConfig(kvcache.Config{NumShards: 16})
+	srv, addr := serveCache(t, cache)
+	return srv, cache, addr
+}
+
+// serveCache serves cache on a local port until the test ends, then closes
+// the server and the cache
+func serveCache(t *testing.T, cache *kvcache.KVCache) (*Server, string) {
+	t.Helper()
+	srv := New(cache)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(l) }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-done; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Serve returned %v", err)
+		}
+		cache.Close()
+	})
+	return srv, l.Addr().String()
+}
+
+// testConn is a minimal RESP client
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *testConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &testConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// encode formats a command as a RESP array
+func encode(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	return b.String()
+}
+
+// do sends one command and returns its reply
+func (c *testConn) do(args ...string) interface{} {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, encode(args...)); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.read()
+}
+
+func (c *testConn) read() interface{} {
+	c.t.Helper()
+	reply, err := readReply(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return reply
+}
+
+// TestServerPipelining tests many commands sent in a single write
+func TestServerPipelining(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	var batch strings.Builder
+	for i := 0; i < 1000; i++ {
+		batch.WriteString(encode("SET", fmt.Sprint("k", i), fmt.Sprint(i)))
+		batch.WriteString(encode("GET", fmt.Sprint("k", i)))
+	}
+	go io.WriteString(c.conn, batch.String())
+
+	for i := 0; i < 1000; i++ {
+		if got := c.read(); got != "OK" {
+			t.Fatalf("Reply %d to SET: %v", i, got)
+		}
+		if got := c.read(); got != fmt.Sprint(i) {
+			t.Fatalf("Reply %d to GET: %v", i, got)
+		}
+	}
+}
+
+// TestServerInline tests inline commands as typed into telnet
+func TestServerInline(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	io.WriteString(c.conn, "SET greeting \"hello world\"\r\nGET greeting\r\n\r\nPING\r\n")
+	for _, want := range []interface{}{"OK", "hello world", "PONG"} {
+		if got := c.read(); got != want {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	}
+}
+
+// TestServerQuit tests that QUIT replies and closes the connection
+func TestServerQuit(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	if got := c.do("QUIT"); got != "OK" {
+		t.Errorf("Expected OK, got %v", got)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("Expected connection to be closed, got %v", err)
+	}
+}
+
+// TestServerProtocolError tests that malformed input closes the connection
+func TestServerProtocolError(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	io.WriteString(c.conn, "*1\r\n:5\r\n")
+	if got, ok := c.read().(replyError); !ok || !strings.HasPrefix(string(got), "ERR Protocol error") {
+		t.Errorf("Expected protocol error, got %v", got)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("Expected connection to be closed, got %v", err)
+	}
+}
+
+// TestServerClose tests that Close disconnects clients
+func TestServerClose(t *testing.T) {
+	srv, _, addr := newTestServer(t)
+	c := dial(t, addr)
+	c.do("PING")
+
+	srv.Close()
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Error("Expected the connection to be closed")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("Expected the listener to be closed")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Serve(l); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected ErrServerClosed, got %v", err)
+	}
+}
+
+// TestServerConcurrentIncr tests that INCR is atomic across connections
+func TestServerConcurrentIncr(t *testing.T) {
+	_, cache, addr := newTestServer(t)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := dial(t, addr)
+			for i := 0; i < 200; i++ {
+				c.do("INCR", "counter")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Counters created by INCR are int64, as with KVCache.Incr
+	if v, _ := cache.Get("counter"); v != int64(1600) {
+		t.Errorf("Expected int64(1600), got %#v", v)
+	}
+}